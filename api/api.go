@@ -0,0 +1,109 @@
+// Package api holds the JSON bodies of the /v1 API. The server and the
+// client package both use these types, so the two can't drift apart; it
+// imports nothing outside the standard library, keeping the client free
+// of the server's dependencies.
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	OrderStatusOpen      = "open"
+	OrderStatusCancelled = "cancelled"
+)
+
+type Order struct {
+	ID              int64     `json:"id"`
+	CustomerName    string    `json:"customerName"`
+	ProductName     string    `json:"productName"`
+	Quantity        int       `json:"quantity"`
+	ShippingAddress string    `json:"shippingAddress"`
+	Priority        string    `json:"priority"`
+	Tag             string    `json:"tag,omitempty"`
+	Status          string    `json:"status"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// PriorityChange is a request to move an order to a new priority.
+type PriorityChange struct {
+	OrderID  int64  `json:"orderId"`
+	Priority string `json:"priority"`
+}
+
+// AuditEntry is one change in an order's audit trail.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	OrderID    int64     `json:"orderId"`
+	ChangeType string    `json:"changeType"`
+	Priority   string    `json:"priority"`
+	Processed  bool      `json:"processed"`
+	CreatedAt  time.Time `json:"createdAt"`
+
+	// Actor identifies who made the change; empty when the request wasn't
+	// authenticated.
+	Actor string `json:"actor,omitempty"`
+
+	// Patch is the change as an RFC 6902 JSON Patch against the order's
+	// JSON form; empty on entries recorded before patches were.
+	Patch json.RawMessage `json:"patch,omitempty"`
+
+	// Tenant owns the order; empty where the reader is scoped to one
+	// tenant already.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+type Link struct {
+	Href   string `json:"href"`
+	Method string `json:"method"`
+}
+
+// OrderResponse is an order with links to the actions it still allows.
+type OrderResponse struct {
+	Order
+	Links map[string]Link `json:"_links"`
+}
+
+// OrderList is a page of orders, oldest first; Links has "next" unless it
+// is the last page.
+type OrderList struct {
+	Links  map[string]Link `json:"_links"`
+	Orders []OrderResponse `json:"orders"`
+}
+
+// AuditTrail is an order's audit trail, or a page of audit entries with a
+// "next" link when more match.
+type AuditTrail struct {
+	Links   map[string]Link `json:"_links"`
+	Entries []AuditEntry    `json:"entries"`
+}
+
+// OrderState is an order as it was right after one of its changes.
+type OrderState struct {
+	Links    map[string]Link `json:"_links"`
+	ChangeID int64           `json:"changeId"`
+	Order    Order           `json:"order"`
+}
+
+// Problem is an RFC 7807 problem details body, which every error response
+// carries. Code and Fields are extension members; Reference ties a
+// response to the server-side log line.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code,omitempty"`
+	Reference string       `json:"reference,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is one failed validation rule in a Problem.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
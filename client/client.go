@@ -0,0 +1,388 @@
+// Package client is the Go SDK for the orders service: orders and their
+// audit trails over /v1, the admin audit query, and the change feed over
+// gRPC. Requests and responses use the api package's types, the same ones
+// the server encodes.
+//
+// Calls retry connection errors and 429, 502, 503 and 504 responses with
+// jittered backoff, see RetryPolicy, and give up at the context's deadline
+// or after 30 seconds when it has none. Writes carry an Idempotency-Key,
+// the same on every attempt, so a retried write never applies twice.
+//
+//	c, err := client.New("https://orders.example.com", client.Credentials{APIKey: key})
+//	order, err := c.CreateOrder(ctx, client.Order{CustomerName: "Ada", ...})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"test/api"
+)
+
+// The types requests and responses are made of.
+type (
+	Order          = api.Order
+	PriorityChange = api.PriorityChange
+	AuditEntry     = api.AuditEntry
+	Problem        = api.Problem
+)
+
+// Credentials are the keys the server was started with that the client
+// may use.
+type Credentials struct {
+	// APIKey authenticates /v1 calls; leave it empty against a server
+	// without -api-keys.
+	APIKey string
+
+	// AdminKey authenticates QueryAudit and StreamChanges, which the
+	// server guards with -admin-keys.
+	AdminKey string
+}
+
+type Client struct {
+	base    *url.URL
+	creds   Credentials
+	http    *http.Client
+	retry   RetryPolicy
+	timeout time.Duration
+
+	feedAddr string
+	dialOpts []grpc.DialOption
+	feed     *grpc.ClientConn
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sends requests through h instead of a plain
+// http.Client.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.http = h }
+}
+
+// WithRetry replaces DefaultRetryPolicy.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithTimeout bounds calls whose context has no deadline, retries
+// included; 0 leaves them unbounded. The default is 30 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithChangeFeed sets the server's -grpc-addr, which StreamChanges needs.
+// Without dial options the connection is plaintext.
+func WithChangeFeed(addr string, opts ...grpc.DialOption) Option {
+	return func(c *Client) {
+		c.feedAddr = addr
+		c.dialOpts = opts
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// "https://orders.example.com".
+func New(baseURL string, creds Credentials, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("base URL %q must be http or https", baseURL)
+	}
+	c := &Client{base: u, creds: creds, http: &http.Client{}, retry: DefaultRetryPolicy, timeout: 30 * time.Second}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.feedAddr != "" {
+		c.feed, err = dialFeed(c.feedAddr, c.creds.AdminKey, c.dialOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close closes the change feed connection, if there is one.
+func (c *Client) Close() error {
+	if c.feed == nil {
+		return nil
+	}
+	return c.feed.Close()
+}
+
+// Error is a response the server refused, with the problem details it
+// gave.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Problem    Problem
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s %s: %d", e.Method, e.Path, e.StatusCode)
+	if e.Problem.Code != "" {
+		msg += " " + e.Problem.Code
+	}
+	if e.Problem.Detail != "" {
+		msg += ": " + e.Problem.Detail
+	}
+	for _, f := range e.Problem.Fields {
+		msg += "; " + f.Field + ": " + f.Message
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is a 404 from the server, such as for
+// an order that doesn't exist or belongs to another tenant.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+func (c *Client) CreateOrder(ctx context.Context, o Order) (Order, error) {
+	var resp api.OrderResponse
+	err := c.do(ctx, http.MethodPost, "/v1/orders", c.creds.APIKey, o, &resp)
+	return resp.Order, err
+}
+
+func (c *Client) GetOrder(ctx context.Context, id int64) (Order, error) {
+	var resp api.OrderResponse
+	err := c.do(ctx, http.MethodGet, orderPath(id), c.creds.APIKey, nil, &resp)
+	return resp.Order, err
+}
+
+// ListOrders returns up to limit orders with ids above after, oldest
+// first, and the after to pass for the next page; 0 when this is the last
+// one. A limit of 0 takes the server's default page size.
+func (c *Client) ListOrders(ctx context.Context, after int64, limit int) ([]Order, int64, error) {
+	q := url.Values{}
+	if after > 0 {
+		q.Set("after", strconv.FormatInt(after, 10))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var resp api.OrderList
+	err := c.do(ctx, http.MethodGet, withQuery("/v1/orders", q), c.creds.APIKey, nil, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	orders := make([]Order, len(resp.Orders))
+	for i, o := range resp.Orders {
+		orders[i] = o.Order
+	}
+	return orders, nextCursor(resp.Links), nil
+}
+
+// ChangePriority moves an order to a new priority and returns it updated.
+func (c *Client) ChangePriority(ctx context.Context, change PriorityChange) (Order, error) {
+	var resp api.OrderResponse
+	err := c.do(ctx, http.MethodPatch, "/v1/orders/priority", c.creds.APIKey, change, &resp)
+	return resp.Order, err
+}
+
+// AuditTrail returns every change to an order, oldest first.
+func (c *Client) AuditTrail(ctx context.Context, orderID int64) ([]AuditEntry, error) {
+	var resp api.AuditTrail
+	err := c.do(ctx, http.MethodGet, orderPath(orderID)+"/audit", c.creds.APIKey, nil, &resp)
+	return resp.Entries, err
+}
+
+// OrderAt returns an order as it was right after the change with
+// changeID in its audit trail.
+func (c *Client) OrderAt(ctx context.Context, orderID, changeID int64) (Order, error) {
+	var resp api.OrderState
+	path := orderPath(orderID) + "/audit/" + strconv.FormatInt(changeID, 10) + "/state"
+	err := c.do(ctx, http.MethodGet, path, c.creds.APIKey, nil, &resp)
+	return resp.Order, err
+}
+
+// AuditQuery narrows QueryAudit; zero fields match everything.
+type AuditQuery struct {
+	OrderID   int64
+	Tenant    string
+	Actor     string
+	Operation string
+	Since     time.Time
+	Until     time.Time
+	Processed *bool
+
+	// After is the cursor from the previous page.
+	After int64
+
+	// Limit is the page size; 0 takes the server's default.
+	Limit int
+}
+
+// QueryAudit searches every tenant's audit entries through the admin API,
+// oldest first, returning the After for the next page; 0 when this is the
+// last one.
+func (c *Client) QueryAudit(ctx context.Context, aq AuditQuery) ([]AuditEntry, int64, error) {
+	q := url.Values{}
+	setID := func(name string, v int64) {
+		if v > 0 {
+			q.Set(name, strconv.FormatInt(v, 10))
+		}
+	}
+	setID("orderId", aq.OrderID)
+	setID("after", aq.After)
+	for name, v := range map[string]string{"tenant": aq.Tenant, "actor": aq.Actor, "operation": aq.Operation} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	if !aq.Since.IsZero() {
+		q.Set("since", aq.Since.Format(time.RFC3339))
+	}
+	if !aq.Until.IsZero() {
+		q.Set("until", aq.Until.Format(time.RFC3339))
+	}
+	if aq.Processed != nil {
+		q.Set("processed", strconv.FormatBool(*aq.Processed))
+	}
+	if aq.Limit > 0 {
+		q.Set("limit", strconv.Itoa(aq.Limit))
+	}
+
+	var resp api.AuditTrail
+	err := c.do(ctx, http.MethodGet, withQuery("/admin/audit", q), c.creds.AdminKey, nil, &resp)
+	return resp.Entries, nextCursor(resp.Links), err
+}
+
+func orderPath(id int64) string {
+	return "/v1/orders/" + strconv.FormatInt(id, 10)
+}
+
+func withQuery(path string, q url.Values) string {
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
+
+// nextCursor is the after parameter of a page's "next" link.
+func nextCursor(links map[string]api.Link) int64 {
+	next, ok := links["next"]
+	if !ok {
+		return 0
+	}
+	u, err := url.Parse(next.Href)
+	if err != nil {
+		return 0
+	}
+	after, _ := strconv.ParseInt(u.Query().Get("after"), 10, 64)
+	return after
+}
+
+func (c *Client) do(ctx context.Context, method, path, key string, body, out any) error {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var buf []byte
+	if body != nil {
+		var err error
+		buf, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	var idempotencyKey string
+	if method != http.MethodGet {
+		idempotencyKey, _ = ctx.Value(idempotencyKeyKey{}).(string)
+		if idempotencyKey == "" {
+			var err error
+			idempotencyKey, err = newIdempotencyKey()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := c.attempt(ctx, method, path, key, idempotencyKey, buf, out)
+		if err == nil || retryAfter < 0 || retryAfter > c.retry.MaxDelay || attempt >= c.retry.MaxAttempts {
+			return err
+		}
+		delay := max(c.retry.backoff(attempt), retryAfter)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt sends the request once. Its error is retryable unless retryAfter
+// is negative; otherwise retryAfter is how long the server asked for.
+func (c *Client) attempt(ctx context.Context, method, path, key, idempotencyKey string, body []byte, out any) (time.Duration, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base.String()+path, rd)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		e := &Error{Method: method, Path: path, StatusCode: resp.StatusCode}
+		err = json.NewDecoder(resp.Body).Decode(&e.Problem)
+		if err != nil {
+			e.Problem = Problem{Status: resp.StatusCode, Title: http.StatusText(resp.StatusCode)}
+		}
+		if !e.temporary() {
+			return -1, e
+		}
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(max(seconds, 0)) * time.Second, e
+	}
+	return -1, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// temporary reports whether the same request may succeed later.
+func (e *Error) temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusConflict:
+		return e.Problem.Code == "idempotency_key_in_flight"
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"test/internal/events"
+	"test/internal/grpcapi"
+	"test/internal/httpapi"
+	"test/internal/store"
+)
+
+// serve runs the HTTP API and the gRPC feed on a fresh database and
+// returns a client for both.
+func serve(t *testing.T) (*Client, *sql.DB) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "orders.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := store.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = store.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(httpapi.New(db, httpapi.Config{
+		MinQuantity: 1,
+		MaxQuantity: 1000,
+		APIKeys:     []string{"api-key"},
+		AdminKeys:   []string{"admin-key"},
+	}, nil).Handler())
+	t.Cleanup(srv.Close)
+
+	orders := store.New(db, nil, nil)
+	t.Cleanup(func() { orders.Close() })
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := grpcapi.New(db, orders, grpcapi.Config{Keys: []string{"admin-key"}, PollInterval: 10 * time.Millisecond}).GRPCServer()
+	go feed.Serve(lis)
+	t.Cleanup(feed.Stop)
+
+	c, err := New(srv.URL, Credentials{APIKey: "api-key", AdminKey: "admin-key"}, WithChangeFeed(lis.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, db
+}
+
+func TestOrdersAndAudit(t *testing.T) {
+	c, _ := serve(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var ids []int64
+	for range 3 {
+		o, err := c.CreateOrder(ctx, Order{
+			CustomerName: "Ada", ProductName: "ninja", Quantity: 2, ShippingAddress: "1 Test Street", Priority: "low",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, o.ID)
+	}
+
+	_, err := c.CreateOrder(ctx, Order{CustomerName: "Ada", ProductName: "ninja"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity || len(apiErr.Problem.Fields) == 0 {
+		t.Errorf("invalid order: %v, want a 422 naming the fields", err)
+	}
+
+	page, next, err := c.ListOrders(ctx, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].ID != ids[0] || next != ids[1] {
+		t.Fatalf("first page = %v next %d, want orders %v then a cursor at %d", page, next, ids[:2], ids[1])
+	}
+	page, next, err = c.ListOrders(ctx, next, 2)
+	if err != nil || len(page) != 1 || page[0].ID != ids[2] || next != 0 {
+		t.Fatalf("last page = %v next %d (%v), want order %d and no cursor", page, next, err, ids[2])
+	}
+
+	updated, err := c.ChangePriority(ctx, PriorityChange{OrderID: ids[0], Priority: "high"})
+	if err != nil || updated.Priority != "high" {
+		t.Fatalf("ChangePriority = %+v, %v; want it at high", updated, err)
+	}
+	trail, err := c.AuditTrail(ctx, ids[0])
+	if err != nil || len(trail) != 1 || trail[0].Priority != "high" {
+		t.Fatalf("AuditTrail = %+v, %v; want the one change", trail, err)
+	}
+	before, err := c.OrderAt(ctx, ids[0], trail[0].ID)
+	if err != nil || before.Priority != "high" {
+		t.Errorf("OrderAt = %+v, %v; want the order after the change", before, err)
+	}
+
+	entries, _, err := c.QueryAudit(ctx, AuditQuery{OrderID: ids[0]})
+	if err != nil || len(entries) != 1 || entries[0].Tenant == "" {
+		t.Errorf("QueryAudit = %+v, %v; want the change with its tenant", entries, err)
+	}
+
+	_, err = c.GetOrder(ctx, 999)
+	if !IsNotFound(err) {
+		t.Errorf("GetOrder(999): %v, want not found", err)
+	}
+	anon, err := New(c.base.String(), Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = anon.GetOrder(ctx, ids[0])
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GetOrder without a key: %v, want 401", err)
+	}
+}
+
+func TestStreamChanges(t *testing.T) {
+	c, db := serve(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	o, err := c.CreateOrder(ctx, Order{
+		CustomerName: "Ada", ProductName: "ninja", Quantity: 2, ShippingAddress: "1 Test Street", Priority: "low",
+	})
+	if err == nil {
+		_, err = c.ChangePriority(ctx, PriorityChange{OrderID: o.ID, Priority: "high"})
+	}
+	// No pollers run here, so the change is marked processed by hand.
+	if err == nil {
+		_, err = db.Exec(`UPDATE priority_changes SET processed = TRUE`)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := c.StreamChanges(ctx, StreamOptions{SchemaVersion: events.V1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p events.PayloadV1
+	err = json.Unmarshal(change.Payload, &p)
+	if err != nil || change.SchemaVersion != events.V1 || p.OrderID != o.ID || p.Priority != "high" {
+		t.Errorf("got %+v (%s), want the escalation of order %d in v1", change, change.Payload, o.ID)
+	}
+	if stream.Position() != change.ID {
+		t.Errorf("Position = %d, want %d", stream.Position(), change.ID)
+	}
+}
+
+func TestRetriesReuseTheIdempotencyKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"id":7}`))
+			return
+		}
+		switch len(keys) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"status":409,"code":"idempotency_key_in_flight"}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":7,"priority":"low"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, Credentials{}, WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := c.CreateOrder(context.Background(), Order{ProductName: "ninja"})
+	if err != nil || o.ID != 7 {
+		t.Fatalf("CreateOrder = %+v, %v; want order 7 on the third attempt", o, err)
+	}
+	if len(keys) != 3 || keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("Idempotency-Key per attempt = %q, want one key sent three times", keys)
+	}
+
+	// Reads carry no key.
+	keys = nil
+	_, err = c.GetOrder(WithIdempotencyKey(context.Background(), "ignored"), 7)
+	if err != nil || len(keys) != 1 || keys[0] != "" {
+		t.Errorf("GetOrder sent %q (%v), want one attempt without a key", keys, err)
+	}
+}
+
+func TestRetriesStopAtTheDeadline(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, _, err = c.ListOrders(ctx, 0, 0)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || attempts != 1 {
+		t.Errorf("ListOrders: %v after %d attempts, want the 429 without waiting past the deadline", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %s, want at once", elapsed)
+	}
+}
+
+func TestIdempotencyKeyAgainstTheServer(t *testing.T) {
+	c, _ := serve(t)
+	ctx := WithIdempotencyKey(context.Background(), "import-42")
+
+	o := Order{CustomerName: "Ada", ProductName: "ninja", Quantity: 2, ShippingAddress: "1 Test Street", Priority: "low"}
+	first, err := c.CreateOrder(ctx, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := c.CreateOrder(ctx, o)
+	if err != nil || again.ID != first.ID {
+		t.Errorf("repeat with the same key = %+v, %v; want order %d again", again, err, first.ID)
+	}
+	orders, _, err := c.ListOrders(context.Background(), 0, 0)
+	if err != nil || len(orders) != 1 {
+		t.Errorf("%d orders (%v), want 1", len(orders), err)
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy says how calls retry connection errors and 429, 502, 503 and
+// 504 responses. Each wait is random between zero and a cap that starts at
+// BaseDelay and doubles per retry up to MaxDelay, so clients that failed
+// together don't retry together. A Retry-After from the server is waited
+// out in full, unless it is longer than MaxDelay, when the call gives up.
+type RetryPolicy struct {
+	// MaxAttempts counts the first try; 1 turns retries off.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// backoff is how long to wait after the attempt-th try failed.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 32 {
+		ceiling = min(ceiling, p.BaseDelay<<(attempt-1))
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+type idempotencyKeyKey struct{}
+
+// WithIdempotencyKey returns ctx making write calls send key as their
+// Idempotency-Key instead of a fresh one, for callers that retry a write
+// themselves, across a restart say. The server keeps a key's response
+// for 24 hours.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := crand.Read(b)
+	return hex.EncodeToString(b), err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"test/internal/maintenance"
+)
+
+// backend is where adminctl gets its answers: straight from the database or
+// through a running server's /admin API.
+type backend interface {
+	offsets() ([]maintenance.Offset, error)
+	changes(state string, limit int) ([]maintenance.Change, error)
+	requeue(id int64) (int64, error)
+	retention(olderThan time.Duration, dryRun bool) (maintenance.RetentionResult, error)
+	verifyAudit() (maintenance.ChainReport, error)
+}
+
+type dbBackend struct {
+	db *sql.DB
+}
+
+func (b dbBackend) offsets() ([]maintenance.Offset, error) {
+	return maintenance.ListOffsets(b.db)
+}
+
+func (b dbBackend) changes(state string, limit int) ([]maintenance.Change, error) {
+	return maintenance.ListChanges(b.db, state, limit)
+}
+
+func (b dbBackend) requeue(id int64) (int64, error) {
+	return maintenance.Requeue(b.db, id)
+}
+
+func (b dbBackend) retention(olderThan time.Duration, dryRun bool) (maintenance.RetentionResult, error) {
+	return maintenance.Retention(b.db, olderThan, dryRun)
+}
+
+func (b dbBackend) verifyAudit() (maintenance.ChainReport, error) {
+	return maintenance.VerifyChain(context.Background(), b.db)
+}
+
+type apiBackend struct {
+	http    *http.Client
+	baseURL string
+	key     string
+}
+
+func (b apiBackend) offsets() ([]maintenance.Offset, error) {
+	var resp struct {
+		Offsets []maintenance.Offset `json:"offsets"`
+	}
+	err := b.do(http.MethodGet, "/admin/offsets", nil, &resp)
+	return resp.Offsets, err
+}
+
+func (b apiBackend) changes(state string, limit int) ([]maintenance.Change, error) {
+	q := url.Values{"state": {state}, "limit": {strconv.Itoa(limit)}}
+	var resp struct {
+		Changes []maintenance.Change `json:"changes"`
+	}
+	err := b.do(http.MethodGet, "/admin/changes?"+q.Encode(), nil, &resp)
+	return resp.Changes, err
+}
+
+func (b apiBackend) requeue(id int64) (int64, error) {
+	var resp struct {
+		OffsetsRewound int64 `json:"offsetsRewound"`
+	}
+	err := b.do(http.MethodPost, fmt.Sprintf("/admin/changes/%d/requeue", id), nil, &resp)
+	return resp.OffsetsRewound, err
+}
+
+func (b apiBackend) retention(olderThan time.Duration, dryRun bool) (maintenance.RetentionResult, error) {
+	body := map[string]any{"olderThan": olderThan.String(), "dryRun": dryRun}
+	var res maintenance.RetentionResult
+	err := b.do(http.MethodPost, "/admin/retention", body, &res)
+	return res, err
+}
+
+func (b apiBackend) verifyAudit() (maintenance.ChainReport, error) {
+	var report maintenance.ChainReport
+	err := b.do(http.MethodGet, "/admin/audit/verify", nil, &report)
+	return report, err
+}
+
+func (b apiBackend) do(method, path string, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, b.baseURL+path, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.key != "" {
+		req.Header.Set("X-API-Key", b.key)
+	}
+
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var p struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&p)
+		if err != nil || p.Detail == "" {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, p.Detail)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"google.golang.org/protobuf/proto"
+
+	"test/internal/events"
+	"test/internal/grpcapi/pb"
+	"test/internal/maintenance"
+	"test/internal/webhook"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file when the
+// test runs with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.MkdirAll("testdata", 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, got, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed.\n got: %s\nwant: %s", path, got, want)
+	}
+}
+
+func goldenEvents() []replayEvent {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []replayEvent{
+		{
+			Change: maintenance.Change{
+				ID:          7,
+				OrderID:     3,
+				ChangeType:  "priority.changed",
+				Priority:    "high",
+				ProductName: "ninja",
+				Quantity:    2,
+				Tenant:      "acme",
+				Processed:   true,
+				CreatedAt:   at,
+			},
+			Replay: true,
+		},
+		{
+			Change: maintenance.Change{
+				ID:          8,
+				OrderID:     3,
+				ChangeType:  "order.cancelled",
+				Priority:    "high",
+				ProductName: "ninja",
+				Processed:   true,
+				CreatedAt:   at.Add(time.Minute),
+			},
+			Replay: true,
+		},
+	}
+}
+
+func TestGoldenReplayJSONL(t *testing.T) {
+	var out bytes.Buffer
+	s := jsonlSink{enc: json.NewEncoder(&out)}
+	err := s.send(context.Background(), goldenEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "replay.jsonl.golden", out.Bytes())
+}
+
+// webhookRequests sends the golden events through a webhook sink writing
+// out, and returns the requests as the receiver saw them.
+func webhookRequests(t *testing.T, out output) []byte {
+	t.Helper()
+	var got bytes.Buffer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(&got, "%s %s\n", r.Method, r.URL.Path)
+		for _, h := range []string{"Content-Type", "X-Change-ID", "X-Replay"} {
+			fmt.Fprintf(&got, "%s: %s\n", h, r.Header.Get(h))
+		}
+		fmt.Fprintf(&got, "\n%s\n\n", body)
+	}))
+	defer srv.Close()
+
+	s := webhookSink{http: srv.Client(), url: srv.URL + "/hooks/orders", out: out}
+	err := s.send(context.Background(), goldenEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got.Bytes()
+}
+
+func TestGoldenWebhookPayload(t *testing.T) {
+	checkGolden(t, "webhook.golden", webhookRequests(t, output{}))
+}
+
+func TestGoldenWebhookCloudEvents(t *testing.T) {
+	got := webhookRequests(t, output{version: events.Current, format: events.FormatCloudEvents})
+	checkGolden(t, "webhook.cloudevents.golden", got)
+}
+
+func TestWebhookSinkSignsBodies(t *testing.T) {
+	secret := []byte("replay-secret")
+	var verified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := webhook.VerifyRequest(r, secret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		verified++
+	}))
+	defer srv.Close()
+
+	s := webhookSink{http: srv.Client(), url: srv.URL, secret: secret}
+	err := s.send(context.Background(), goldenEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != len(goldenEvents()) {
+		t.Errorf("receiver verified %d bodies, want %d", verified, len(goldenEvents()))
+	}
+
+	s.secret = []byte("wrong")
+	err = s.send(context.Background(), goldenEvents())
+	if err == nil {
+		t.Error("bodies signed with the wrong secret were accepted")
+	}
+}
+
+func TestGoldenReplayEnvelopes(t *testing.T) {
+	var out bytes.Buffer
+	outputs := []output{
+		{version: events.V1, format: events.FormatEnvelope},
+		{version: events.V2, format: events.FormatEnvelope},
+		{version: events.V2, format: events.FormatCloudEvents},
+	}
+	for _, o := range outputs {
+		s := jsonlSink{enc: json.NewEncoder(&out), out: o}
+		err := s.send(context.Background(), goldenEvents())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkGolden(t, "replay.envelope.golden", out.Bytes())
+}
+
+func TestProtobufOutput(t *testing.T) {
+	e := goldenEvents()[0]
+	body, contentType, err := output{format: formatProtobuf}.encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got pb.PriorityChange
+	err = proto.Unmarshal(body, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != contentTypeProtobuf || got.Id != e.ID || got.Quantity != int32(e.Quantity) || got.Tenant != e.Tenant ||
+		!got.CreatedAt.AsTime().Equal(e.CreatedAt) {
+		t.Errorf("%s %v, want change %d as a PriorityChange", contentType, &got, e.ID)
+	}
+}
+
+func TestAvroOutputIsFramed(t *testing.T) {
+	e := goldenEvents()[0]
+	body, contentType, err := output{format: formatAvro, schemaID: 42}.encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != events.ContentTypeAvro || len(body) < 5 || !bytes.Equal(body[:5], []byte{0, 0, 0, 0, 42}) {
+		t.Fatalf("%s %x, want Avro behind the wire format header for schema 42", contentType, body)
+	}
+	var got map[string]any
+	err = avro.Unmarshal(avro.MustParse(events.AvroSchema), body[5:], &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["change_id"] != e.ID || got["event_type"] != e.ChangeType {
+		t.Errorf("decoded %v, want change %d", got, e.ID)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+
+	"test/internal/events"
+	"test/internal/grpcapi"
+	"test/internal/maintenance"
+	"test/internal/schemaregistry"
+	"test/internal/webhook"
+)
+
+// replayEvent is what every sink receives: the change as stored, flagged so
+// consumers can tell a backfill from live traffic.
+type replayEvent struct {
+	maintenance.Change
+	Replay bool `json:"replay"`
+}
+
+// output is how sinks write events: with no version, the stored change
+// as before; otherwise an events envelope in that schema version, written
+// in format. The protobuf format writes the change as an orders.v1
+// PriorityChange instead, and avro as events.AvroSchema framed with the
+// schema's registry id; neither has schema versions. Envelopes, protobuf
+// and Avro leave out the replay flag; the webhook and Kafka sinks still
+// say so in a header.
+type output struct {
+	version  int
+	format   string
+	schemaID int
+}
+
+const (
+	formatProtobuf      = "protobuf"
+	contentTypeProtobuf = "application/x-protobuf"
+	formatAvro          = "avro"
+)
+
+// encode returns e as o writes it and its content type.
+func (o output) encode(e replayEvent) ([]byte, string, error) {
+	if o.format == formatProtobuf {
+		b, err := proto.Marshal(grpcapi.ProtoChange(e.Change))
+		return b, contentTypeProtobuf, err
+	}
+	if o.format == formatAvro {
+		env, err := e.Event()
+		if err != nil {
+			return nil, "", err
+		}
+		b, err := events.EncodeAvro(env)
+		return schemaregistry.Frame(o.schemaID, b), events.ContentTypeAvro, err
+	}
+	if o.version == 0 {
+		b, err := json.Marshal(e)
+		return b, events.ContentTypeJSON, err
+	}
+	env, err := e.Event()
+	if err != nil {
+		return nil, "", err
+	}
+	return events.Encode(env, o.version, o.format)
+}
+
+type sink interface {
+	send(ctx context.Context, events []replayEvent) error
+	close() error
+}
+
+type jsonlSink struct {
+	enc *json.Encoder
+	out output
+}
+
+func (s jsonlSink) send(ctx context.Context, events []replayEvent) error {
+	for _, e := range events {
+		body, _, err := s.out.encode(e)
+		if err != nil {
+			return err
+		}
+		err = s.enc.Encode(json.RawMessage(body))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s jsonlSink) close() error { return nil }
+
+// webhookSink POSTs one event per request and stops at the first failure so
+// the replay can be resumed from the last delivered id. With a secret each
+// body is signed; see package webhook.
+type webhookSink struct {
+	http   *http.Client
+	url    string
+	secret []byte
+	out    output
+}
+
+func (s webhookSink) send(ctx context.Context, events []replayEvent) error {
+	for _, e := range events {
+		body, contentType, err := s.out.encode(e)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Change-ID", strconv.FormatInt(e.ID, 10))
+		req.Header.Set("X-Replay", "true")
+		if s.secret != nil {
+			webhook.SignRequest(req, s.secret, body)
+		}
+
+		resp, err := s.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("change %d: %w", e.ID, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("change %d: webhook returned %s", e.ID, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (s webhookSink) close() error { return nil }
+
+// kafkaSink keys messages by order id so one order's changes stay in order
+// within a partition.
+type kafkaSink struct {
+	w   *kafka.Writer
+	out output
+}
+
+func (s kafkaSink) send(ctx context.Context, events []replayEvent) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, e := range events {
+		value, contentType, err := s.out.encode(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(strconv.FormatInt(e.OrderID, 10)),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "change-type", Value: []byte(e.ChangeType)},
+				{Key: "content-type", Value: []byte(contentType)},
+				{Key: "replay", Value: []byte("true")},
+			},
+		})
+	}
+	return s.w.WriteMessages(ctx, msgs...)
+}
+
+func (s kafkaSink) close() error { return s.w.Close() }
+
+type replayOptions struct {
+	sink            string
+	url             string
+	secretEnv       string
+	out             output
+	brokers         string
+	topic           string
+	registry        string
+	subjectStrategy string
+	compatibility   string
+	since           string
+	until           string
+	batch           int
+	rng             maintenance.Range
+}
+
+func newReplayCmd(g *globalFlags, b func() backend) *cobra.Command {
+	var opts replayOptions
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Send processed changes to a sink without touching processed flags",
+		Long: "Replay reads processed changes in id order, optionally limited by id range\n" +
+			"or creation time, and delivers them to stdout as JSON lines, a webhook or a\n" +
+			"Kafka topic. Useful for backfilling a downstream system added later.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, ok := b().(dbBackend)
+			if !ok {
+				return errors.New("replay reads the database directly; drop --api")
+			}
+			err := opts.parseWindow()
+			if err != nil {
+				return err
+			}
+			if opts.out.format == formatAvro {
+				opts.out.schemaID, err = opts.registerSchema(cmd.Context())
+				if err != nil {
+					return err
+				}
+			}
+			s, err := opts.newSink(cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			defer s.close()
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+			return replay(ctx, db, s, opts, cmd.ErrOrStderr())
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&opts.sink, "sink", "stdout", "where to send changes: stdout, webhook or kafka")
+	f.StringVar(&opts.url, "url", "", "webhook URL for --sink webhook")
+	f.StringVar(&opts.secretEnv, "secret-env", "", "environment variable holding the secret --sink webhook signs each body with, in X-Signature")
+	f.IntVar(&opts.out.version, "schema-version", 0, fmt.Sprintf("send events enveloped in this schema version (%d to %d); 0 sends stored changes as before", events.V1, events.Current))
+	f.StringVar(&opts.out.format, "format", events.FormatEnvelope, "how events are written: envelope or cloudevents (structured mode), protobuf for --sink webhook and kafka, or avro for --sink kafka; cloudevents without --schema-version uses the current one")
+	f.StringVar(&opts.brokers, "brokers", "localhost:9092", "comma-separated Kafka brokers for --sink kafka")
+	f.StringVar(&opts.topic, "topic", "order-changes", "Kafka topic for --sink kafka")
+	f.StringVar(&opts.registry, "schema-registry", "", "schema registry URL --format avro registers its schema with; credentials in the URL are sent as basic auth")
+	f.StringVar(&opts.subjectStrategy, "subject-strategy", schemaregistry.TopicName, "registry subject naming for --format avro: topic (<topic>-value), record (the record name) or topic-record (<topic>-<record>)")
+	f.StringVar(&opts.compatibility, "compatibility", "", "compatibility level to set on the subject before registering, e.g. BACKWARD or FULL; empty leaves the registry's setting")
+	f.Int64Var(&opts.rng.FromID, "from-id", 0, "first change id to replay")
+	f.Int64Var(&opts.rng.ToID, "to-id", 0, "last change id to replay; 0 means no limit")
+	f.StringVar(&opts.since, "since", "", "only changes created at or after this RFC 3339 time")
+	f.StringVar(&opts.until, "until", "", "only changes created before this RFC 3339 time")
+	f.IntVar(&opts.batch, "batch", 500, "changes read and sent per round trip")
+	return cmd
+}
+
+func (o *replayOptions) parseWindow() error {
+	var err error
+	if o.since != "" {
+		o.rng.Since, err = time.Parse(time.RFC3339, o.since)
+		if err != nil {
+			return fmt.Errorf("--since: %w", err)
+		}
+	}
+	if o.until != "" {
+		o.rng.Until, err = time.Parse(time.RFC3339, o.until)
+		if err != nil {
+			return fmt.Errorf("--until: %w", err)
+		}
+	}
+	if o.batch < 1 {
+		return errors.New("--batch must be positive")
+	}
+	if o.out.version != 0 && !events.Supported(o.out.version) {
+		return fmt.Errorf("--schema-version must be between %d and %d", events.V1, events.Current)
+	}
+	switch o.out.format {
+	case events.FormatEnvelope:
+	case events.FormatCloudEvents:
+		if o.out.version == 0 {
+			o.out.version = events.Current
+		}
+	case formatProtobuf:
+		if o.out.version != 0 {
+			return errors.New("--format protobuf has no schema versions; leave out --schema-version")
+		}
+		if o.sink == "stdout" {
+			return errors.New("--format protobuf needs --sink webhook or kafka")
+		}
+	case formatAvro:
+		if o.out.version != 0 {
+			return errors.New("--format avro has no schema versions; leave out --schema-version")
+		}
+		if o.sink != "kafka" {
+			return errors.New("--format avro needs --sink kafka")
+		}
+		if o.registry == "" {
+			return errors.New("--format avro needs --schema-registry")
+		}
+	default:
+		return fmt.Errorf("--format must be %s, %s, %s or %s", events.FormatEnvelope, events.FormatCloudEvents, formatProtobuf, formatAvro)
+	}
+	return nil
+}
+
+// registerSchema registers events.AvroSchema under the subject the options
+// name, setting the subject's compatibility first if asked, and returns the
+// schema's id.
+func (o replayOptions) registerSchema(ctx context.Context) (int, error) {
+	subject, err := schemaregistry.Subject(o.subjectStrategy, o.topic, events.AvroRecordName)
+	if err != nil {
+		return 0, fmt.Errorf("--subject-strategy: %w", err)
+	}
+	registry, err := schemaregistry.New(o.registry)
+	if err != nil {
+		return 0, fmt.Errorf("--schema-registry: %w", err)
+	}
+	if o.compatibility != "" {
+		err = registry.SetCompatibility(ctx, subject, strings.ToUpper(o.compatibility))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return registry.Register(ctx, subject, events.AvroSchema)
+}
+
+func (o replayOptions) newSink(stdout io.Writer) (sink, error) {
+	switch o.sink {
+	case "stdout":
+		return jsonlSink{enc: json.NewEncoder(stdout), out: o.out}, nil
+	case "webhook":
+		if o.url == "" {
+			return nil, errors.New("--sink webhook needs --url")
+		}
+		sink := webhookSink{http: &http.Client{Timeout: 10 * time.Second}, url: o.url, out: o.out}
+		if o.secretEnv != "" {
+			secret := os.Getenv(o.secretEnv)
+			if secret == "" {
+				return nil, fmt.Errorf("--secret-env: %s is empty", o.secretEnv)
+			}
+			sink.secret = []byte(secret)
+		}
+		return sink, nil
+	case "kafka":
+		brokers := strings.Split(o.brokers, ",")
+		return kafkaSink{
+			w: &kafka.Writer{
+				Addr:         kafka.TCP(brokers...),
+				Topic:        o.topic,
+				Balancer:     &kafka.Hash{},
+				RequiredAcks: kafka.RequireAll,
+			},
+			out: o.out,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink %q (want stdout, webhook or kafka)", o.sink)
+	}
+}
+
+func replay(ctx context.Context, b dbBackend, s sink, opts replayOptions, progress io.Writer) error {
+	var lastID int64
+	var sent int
+	for {
+		changes, err := maintenance.ListProcessed(b.db, opts.rng, lastID, opts.batch)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			break
+		}
+
+		events := make([]replayEvent, len(changes))
+		for i, c := range changes {
+			events[i] = replayEvent{Change: c, Replay: true}
+		}
+		err = s.send(ctx, events)
+		if err != nil {
+			if lastID > 0 {
+				return fmt.Errorf("%w (delivered through change %d; resume with --from-id %d)", err, lastID, lastID+1)
+			}
+			return err
+		}
+
+		lastID = changes[len(changes)-1].ID
+		sent += len(changes)
+		fmt.Fprintf(progress, "replayed %d changes, through id %d\n", sent, lastID)
+	}
+
+	fmt.Fprintf(progress, "replay finished: %d changes\n", sent)
+	return nil
+}
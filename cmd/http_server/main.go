@@ -1,293 +1,209 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"test/internal/alert"
+	"test/internal/grpcapi"
+	"test/internal/httpapi"
+	"test/internal/maintenance"
+	"test/internal/poller"
+	"test/internal/store"
+	"test/internal/webhook"
 )
 
-type Order struct {
-	CustomerName    string
-	ProductName     string
-	Quantity        int
-	ShippingAddress string
-	Priority        string
-}
-
-func initDB(db *sql.DB) error {
-	createTable := `
-    CREATE TABLE IF NOT EXISTS orders (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        customer_name TEXT NOT NULL,
-        product_name TEXT NOT NULL,
-        quantity INTEGER NOT NULL,
-        shipping_address TEXT NOT NULL,
-        priority TEXT NOT NULL,
-        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-    );`
-
-	_, err := db.Exec(createTable)
-	if err != nil {
-		return err
-	}
-
-	_, err = db.Exec(`
-        CREATE TABLE IF NOT EXISTS priority_changes (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            order_id INTEGER NOT NULL,
-            priority TEXT NOT NULL,
-            processed BOOLEAN DEFAULT FALSE,
-            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY(order_id) REFERENCES orders(id)
-        )`)
+func main() {
+	cfg, err := loadConfig()
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
+	defer cfg.logFile.Close()
 
-	_, err = db.Exec(`
-        CREATE TABLE IF NOT EXISTS polling_state (
-            id INTEGER PRIMARY KEY CHECK (id = 1),
-            last_processed_id INTEGER NOT NULL DEFAULT 0
-        )`)
+	db, err := store.Open(cfg.dbPath)
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
+	defer db.Close()
+	cfg.pool.Apply(db)
+	store.PublishPoolStats(db)
 
-	_, err = db.Exec(`
-        INSERT OR IGNORE INTO polling_state (id, last_processed_id) 
-        VALUES (1, 0)`)
-	return err
-}
-
-// only ninja product will be affected
-func pollForPriorityChanges(db *sql.DB) {
-	for {
-		tx, err := db.Begin()
-		if err != nil {
-			log.Printf("Error starting transaction: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		defer tx.Rollback()
-
-		var lastID int64
-		err = tx.QueryRow(`
-            SELECT last_processed_id FROM polling_state WHERE id = 1
-        `).Scan(&lastID)
-		if err != nil {
-			log.Printf("Error getting last processed ID: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		rows, err := tx.Query(`
-			SELECT pc.id, pc.order_id, pc.priority 
-			FROM priority_changes pc
-			JOIN orders o ON pc.order_id = o.id 
-			WHERE o.product_name = 'ninja'
-			AND pc.id > ? 
-			AND pc.processed = FALSE
-			ORDER BY pc.id ASC`,
-			lastID)
+	if cfg.migrate {
+		err = store.Migrate(db)
 		if err != nil {
-			log.Printf("Polling error: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		var maxID int64
-		for rows.Next() {
-			var id, orderID int64
-			var priority string
-			err := rows.Scan(&id, &orderID, &priority)
-			if err != nil {
-				log.Printf("Scan error: %v", err)
-				continue
-			}
-
-			_, err = tx.Exec(`
-                UPDATE priority_changes SET processed = TRUE WHERE id = ?
-            `, id)
-			if err != nil {
-				log.Printf("Error marking change as processed: %v", err)
-				continue
-			}
-
-			maxID = id
-			log.Printf(
-				"Polling worker processed priority change for ninja order #%d",
-				orderID,
-			)
+			log.Fatal(err)
 		}
-		rows.Close()
+	}
+	err = store.CheckSchema(db)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		if maxID > lastID {
-			_, err = tx.Exec(`
-                UPDATE polling_state SET last_processed_id = ? WHERE id = 1
-            `, maxID)
-			if err != nil {
-				log.Printf("Error updating last processed ID: %v", err)
-			}
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		err = tx.Commit()
+	pollerDone := make(chan struct{})
+	sup := poller.NewSupervisor(db, cfg.pollers, poller.NewChaos(cfg.chaos))
+	sup.SetDryRun(cfg.dryRun)
+	if cfg.effectsDB != "" {
+		effects, err := poller.OpenEffects(cfg.effectsDB)
 		if err != nil {
-			log.Printf("Error committing transaction: %v", err)
+			log.Fatal(err)
 		}
-
-		time.Sleep(5 * time.Second)
+		defer effects.Close()
+		sup.SetEffects(effects)
 	}
-}
-
-func main() {
-	db, err := sql.Open("sqlite3", "./orders.db")
-	if err != nil {
-		log.Fatal(err)
+	if cfg.runPollers {
+		go sup.Run(ctx, pollerDone)
+		go webhook.NewDispatcher(db, cfg.webhooks).Run(ctx)
+	} else {
+		log.Println("Polling workers disabled; run cmd/poller against this database")
+		close(pollerDone)
 	}
-	defer db.Close()
 
-	err = initDB(db)
-	if err != nil {
-		log.Fatal(err)
+	if cfg.api.StaleAfter > 0 {
+		go poller.WatchHeartbeats(ctx, db, cfg.api.StaleAfter)
 	}
 
-	go pollForPriorityChanges(db)
-
-	fs := http.FileServer(http.Dir("static"))
-	http.Handle("/", fs)
-
-	http.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+	if cfg.maintenance.Interval > 0 {
+		cfg.maintenance.Holder = poller.InstanceID
+		go maintenance.NewScheduler(db, cfg.maintenance).Run(ctx)
+	}
 
-		err := r.ParseForm()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+	if cfg.alerts.Interval > 0 {
+		cfg.alerts.Holder = poller.InstanceID
+		go alert.NewEvaluator(db, cfg.alerts, cfg.alertChannels).Run(ctx)
+	}
 
-		stmt, err := db.Prepare(`
-            INSERT INTO orders (
-                customer_name, 
-                product_name, 
-                quantity, 
-                shipping_address, 
-                priority
-            ) VALUES (?, ?, ?, ?, ?)
-        `)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer stmt.Close()
+	if cfg.backupInterval > 0 {
+		go maintenance.RunBackups(ctx, db, cfg.api.BackupDir, cfg.backupInterval, poller.InstanceID)
+	}
 
-		quantity := r.FormValue("quantity")
-		result, err := stmt.Exec(
-			r.FormValue("customerName"),
-			r.FormValue("productName"),
-			quantity,
-			r.FormValue("shippingAddress"),
-			r.FormValue("priority"),
-		)
+	api := httpapi.New(db, cfg.api, sup)
+	defer api.Close()
+	if cfg.readDB != "" {
+		readDB, err := store.Open(cfg.readDB)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			log.Fatal(err)
 		}
+		defer readDB.Close()
+		cfg.pool.Apply(readDB)
+		api.SetReadDB(readDB)
+	}
 
-		lastID, err := result.LastInsertId()
+	httpServer := &http.Server{
+		Addr:    cfg.addr,
+		Handler: api.Handler(),
+	}
+	servers := []*http.Server{httpServer}
+	if cfg.adminAddr != "" {
+		adminServer, err := newAdminServer(cfg, api.AdminHandler())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			log.Fatal(err)
 		}
-
-		log.Printf(
-			"Inserted order #%d with quantity: %s, customer name: %s, product name: %s, shipping address: %s, priority: %s",
-			lastID,
-			quantity,
-			r.FormValue("customerName"),
-			r.FormValue("productName"),
-			r.FormValue("shippingAddress"),
-			r.FormValue("priority"),
-		)
-
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-	})
-
-	http.HandleFunc(
-		"/orders/priority",
-		func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodPatch {
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-				return
-			}
-
-			err := r.ParseForm()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+		servers = append(servers, adminServer)
+		go func() {
+			log.Printf("Admin server starting on %s...", cfg.adminAddr)
+			var err error
+			if adminServer.TLSConfig != nil {
+				err = adminServer.ListenAndServeTLS(cfg.adminTLSCert, cfg.adminTLSKey)
+			} else {
+				err = adminServer.ListenAndServe()
 			}
-
-			orderID := r.FormValue("id")
-
-			tx, err := db.Begin()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			defer tx.Rollback()
-
-			updateStmt, err := tx.Prepare(`
-				UPDATE orders 
-				SET priority = 'high'
-				WHERE id = ?
-			`)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
 			}
-			defer updateStmt.Close()
+		}()
+	}
 
-			_, err = updateStmt.Exec(orderID)
+	if cfg.grpcAddr != "" {
+		lis, err := net.Listen("tcp", cfg.grpcAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		orders := store.New(db, nil, cfg.api.Cipher)
+		defer orders.Close()
+		grpcServer := grpcapi.New(db, orders, cfg.grpc).GRPCServer()
+		go func() {
+			log.Printf("gRPC change feed starting on %s...", cfg.grpcAddr)
+			err := grpcServer.Serve(lis)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+				log.Fatal(err)
 			}
+		}()
+		go func() {
+			<-ctx.Done()
+			// Streams never finish on their own; Stop ends them.
+			grpcServer.Stop()
+		}()
+	}
 
-			insertStmt, err := tx.Prepare(`
-				INSERT INTO priority_changes (order_id, priority)
-				VALUES (?, 'high')
-			`)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		for _, srv := range servers {
+			err := srv.Shutdown(shutdownCtx)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+				log.Printf("Error shutting down HTTP server on %s: %v", srv.Addr, err)
 			}
-			defer insertStmt.Close()
+		}
+	}()
 
-			_, err = insertStmt.Exec(orderID)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	log.Printf("Server starting on %s...", cfg.addr)
+	err = httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 
-			err = tx.Commit()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	<-pollerDone
+	log.Println("Shutdown complete")
+}
 
-			log.Printf(
-				"Updated order #%s priority to high and logged change",
-				orderID,
-			)
-			w.WriteHeader(http.StatusOK)
-		},
-	)
+// newAdminServer builds the -admin-addr listener's server. With a client CA
+// it only completes the handshake for clients with a certificate it signed.
+func newAdminServer(cfg config, h http.Handler) (*http.Server, error) {
+	srv := &http.Server{Addr: cfg.adminAddr, Handler: h}
+	if cfg.adminTLSCert == "" {
+		if len(cfg.api.AdminUsers) > 0 && !isLoopbackAddr(cfg.adminAddr) {
+			log.Printf("WARNING: -admin-addr %s takes basic auth without TLS; passwords cross the network in the clear", cfg.adminAddr)
+		}
+		return srv, nil
+	}
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.adminClientCA != "" {
+		pem, err := os.ReadFile(cfg.adminClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("admin-client-ca %s: no certificates", cfg.adminClientCA)
+		}
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return srv, nil
+}
 
-	log.Println("Server starting on :8080...")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
@@ -0,0 +1,325 @@
+// Command loadgen drives order creations and priority escalations against a
+// running server and reports request latency percentiles plus the end-to-end
+// lag from an escalation's PATCH until the poller has processed it.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type options struct {
+	target          string
+	apiKey          string
+	ordersPerSec    float64
+	escalationsPerS float64
+	duration        time.Duration
+	product         string
+	lagPoll         time.Duration
+	lagTimeout      time.Duration
+}
+
+// recorder collects latency samples and error counts per operation.
+type recorder struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+	errors  map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		samples: make(map[string][]time.Duration),
+		errors:  make(map[string]int),
+	}
+}
+
+func (r *recorder) observe(op string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[op] = append(r.samples[op], d)
+}
+
+func (r *recorder) fail(op string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[op]++
+	if r.errors[op] <= 3 {
+		log.Printf("%s error: %v", op, err)
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func (r *recorder) report(w io.Writer, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fmt.Fprintf(w, "\n%-12s %8s %8s %10s %10s %10s %10s\n",
+		"operation", "ok", "errors", "p50", "p95", "p99", "max")
+	for _, op := range []string{"create", "escalate", "lag"} {
+		s := slices.Clone(r.samples[op])
+		slices.Sort(s)
+		var maxD time.Duration
+		if len(s) > 0 {
+			maxD = s[len(s)-1]
+		}
+		fmt.Fprintf(w, "%-12s %8d %8d %10s %10s %10s %10s\n",
+			op,
+			len(s),
+			r.errors[op],
+			percentile(s, 0.50).Round(time.Microsecond),
+			percentile(s, 0.95).Round(time.Microsecond),
+			percentile(s, 0.99).Round(time.Microsecond),
+			maxD.Round(time.Microsecond),
+		)
+	}
+	fmt.Fprintf(w, "\nelapsed %s, achieved %.1f creates/s, %.1f escalations/s\n",
+		elapsed.Round(time.Millisecond),
+		float64(len(r.samples["create"]))/elapsed.Seconds(),
+		float64(len(r.samples["escalate"]))/elapsed.Seconds(),
+	)
+}
+
+type client struct {
+	http   *http.Client
+	target string
+	apiKey string
+}
+
+func (c *client) do(ctx context.Context, method, path string, body any, out any) error {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.target+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s %s: %d %s", method, path, resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type orderIDs struct {
+	mu  sync.Mutex
+	ids []int64
+}
+
+func (o *orderIDs) add(id int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ids = append(o.ids, id)
+}
+
+func (o *orderIDs) random() (int64, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.ids) == 0 {
+		return 0, false
+	}
+	return o.ids[rand.IntN(len(o.ids))], true
+}
+
+var priorities = []string{"low", "medium", "high"}
+
+func createOrder(ctx context.Context, c *client, opts options, rec *recorder, ids *orderIDs) {
+	body := map[string]any{
+		"customerName":    fmt.Sprintf("load-%d", rand.IntN(1_000_000)),
+		"productName":     opts.product,
+		"quantity":        1 + rand.IntN(10),
+		"shippingAddress": "1 Load Test Way",
+		"priority":        "low",
+	}
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	start := time.Now()
+	err := c.do(ctx, http.MethodPost, "/v1/orders", body, &created)
+	if err != nil && ctx.Err() == nil {
+		rec.fail("create", err)
+		return
+	}
+	if err != nil {
+		return
+	}
+	rec.observe("create", time.Since(start))
+	ids.add(created.ID)
+}
+
+func escalate(ctx, lagCtx context.Context, c *client, opts options, rec *recorder, ids *orderIDs, wg *sync.WaitGroup) {
+	id, ok := ids.random()
+	if !ok {
+		return
+	}
+
+	path := fmt.Sprintf("/v1/orders/%d/priority", id)
+	body := map[string]string{"priority": priorities[rand.IntN(len(priorities))]}
+
+	start := time.Now()
+	err := c.do(ctx, http.MethodPatch, path, body, nil)
+	if err != nil && ctx.Err() == nil {
+		rec.fail("escalate", err)
+		return
+	}
+	if err != nil {
+		return
+	}
+	patched := time.Now()
+	rec.observe("escalate", patched.Sub(start))
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		awaitProcessed(lagCtx, c, opts, rec, id, patched)
+	}()
+}
+
+// awaitProcessed polls the order's audit trail until its newest change is
+// processed. Changes for one order are processed in id order, so that also
+// covers the change this escalation created.
+func awaitProcessed(ctx context.Context, c *client, opts options, rec *recorder, id int64, patched time.Time) {
+	deadline := patched.Add(opts.lagTimeout)
+	path := fmt.Sprintf("/v1/orders/%d/audit", id)
+
+	for time.Now().Before(deadline) {
+		var audit struct {
+			Entries []struct {
+				Processed bool `json:"processed"`
+			} `json:"entries"`
+		}
+		err := c.do(ctx, http.MethodGet, path, nil, &audit)
+		if err == nil && len(audit.Entries) > 0 && audit.Entries[len(audit.Entries)-1].Processed {
+			rec.observe("lag", time.Since(patched))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(opts.lagPoll):
+		}
+	}
+	rec.fail("lag", fmt.Errorf("order %d: not processed within %s", id, opts.lagTimeout))
+}
+
+// every calls fn at the given rate until ctx ends. Each call runs in its own
+// goroutine so a slow server lowers throughput only through client limits.
+func every(ctx context.Context, perSec float64, wg *sync.WaitGroup, fn func()) {
+	defer wg.Done()
+	if perSec <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / perSec))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fn()
+			}()
+		}
+	}
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.target, "target", "http://localhost:8080", "server base URL")
+	flag.StringVar(&opts.apiKey, "api-key", "", "API key for /v1, if the server requires one")
+	flag.Float64Var(&opts.ordersPerSec, "orders-per-sec", 20, "order creations per second")
+	flag.Float64Var(&opts.escalationsPerS, "escalations-per-sec", 10, "priority changes per second")
+	flag.DurationVar(&opts.duration, "duration", 30*time.Second, "how long to generate load")
+	flag.StringVar(&opts.product, "product", "ninja", "product name for created orders; must match a poller to measure lag")
+	flag.DurationVar(&opts.lagPoll, "lag-poll", 100*time.Millisecond, "how often to check whether an escalation was processed")
+	flag.DurationVar(&opts.lagTimeout, "lag-timeout", time.Minute, "give up measuring lag for an escalation after this long")
+	flag.Parse()
+
+	opts.target = strings.TrimRight(opts.target, "/")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := &client{
+		http:   &http.Client{Timeout: 10 * time.Second},
+		target: opts.target,
+		apiKey: opts.apiKey,
+	}
+	rec := newRecorder()
+	ids := &orderIDs{}
+
+	log.Printf(
+		"Generating %.1f creates/s and %.1f escalations/s against %s for %s",
+		opts.ordersPerSec,
+		opts.escalationsPerS,
+		opts.target,
+		opts.duration,
+	)
+
+	// Lag checks outlive the load window, so they get the signal context
+	// rather than the one that ends generation.
+	genCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	var genWG, lagWG sync.WaitGroup
+	start := time.Now()
+	genWG.Add(2)
+	go every(genCtx, opts.ordersPerSec, &genWG, func() {
+		createOrder(genCtx, c, opts, rec, ids)
+	})
+	go every(genCtx, opts.escalationsPerS, &genWG, func() {
+		escalate(genCtx, ctx, c, opts, rec, ids, &lagWG)
+	})
+	genWG.Wait()
+	elapsed := time.Since(start)
+
+	log.Println("Load finished, waiting for outstanding escalations to be processed...")
+	lagWG.Wait()
+
+	rec.report(os.Stdout, elapsed)
+}
@@ -0,0 +1,200 @@
+// Package alert checks the deployment's health against thresholds and
+// notifies channels when an alert starts or stops firing, so a small
+// deployment gets alerting without a monitoring stack of its own.
+package alert
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"test/internal/lease"
+	"test/internal/logdedup"
+	"test/internal/poller"
+)
+
+// Alert is one check's state as of At. Channels get it when Firing flips.
+type Alert struct {
+	Name    string    `json:"name"`
+	Firing  bool      `json:"firing"`
+	Summary string    `json:"summary"`
+	At      time.Time `json:"at"`
+}
+
+// Thresholds turn on the checks; a zero value leaves its check off.
+type Thresholds struct {
+	// Backlog fires above this many changes waiting to be processed.
+	Backlog int64
+	// OldestUnprocessed fires when a waiting change is older than this.
+	OldestUnprocessed time.Duration
+	// DeadLetters fires above this many quarantined changes.
+	DeadLetters int64
+	// StaleAfter fires for every poller with no cycle for this long.
+	StaleAfter time.Duration
+}
+
+type Config struct {
+	Thresholds
+	Interval time.Duration
+
+	// Holder names this process for the "alerts" lease, so replicas
+	// sharing the database don't each send the same alerts. Empty
+	// evaluates without it.
+	Holder string
+}
+
+// Evaluator runs the checks every Interval and notifies its channels of
+// the alerts that changed state since the last run.
+type Evaluator struct {
+	db       *sql.DB
+	cfg      Config
+	channels []Channel
+	leader   *lease.Elector
+
+	firing map[string]bool
+}
+
+func NewEvaluator(db *sql.DB, cfg Config, channels []Channel) *Evaluator {
+	e := &Evaluator{db: db, cfg: cfg, channels: channels, firing: make(map[string]bool)}
+	if cfg.Holder != "" {
+		e.leader = lease.NewElector(db, "alerts", cfg.Holder, max(2*cfg.Interval, lease.MinTTL))
+	}
+	return e
+}
+
+// Run evaluates until ctx is cancelled.
+func (e *Evaluator) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if e.leader != nil && !e.leader.Lead(ctx) {
+			continue
+		}
+		_, err := e.Evaluate(ctx)
+		if err != nil && ctx.Err() == nil {
+			logdedup.Printf("Error evaluating alerts: %v", err)
+		}
+	}
+}
+
+// Evaluate runs every enabled check, notifies the channels of alerts that
+// started or stopped firing, and returns the ones firing now.
+func (e *Evaluator) Evaluate(ctx context.Context) ([]Alert, error) {
+	alerts, err := e.check(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var firing []Alert
+	seen := make(map[string]bool)
+	for _, a := range alerts {
+		seen[a.Name] = true
+		if a.Firing {
+			firing = append(firing, a)
+		}
+		if a.Firing != e.firing[a.Name] {
+			e.notify(ctx, a)
+		}
+		e.firing[a.Name] = a.Firing
+	}
+	// A stalled poller that went away entirely resolves too.
+	for name, was := range e.firing {
+		if was && !seen[name] {
+			e.notify(ctx, Alert{Name: name, Summary: "no longer reported", At: time.Now()})
+			delete(e.firing, name)
+		}
+	}
+	return firing, nil
+}
+
+func (e *Evaluator) notify(ctx context.Context, a Alert) {
+	for _, ch := range e.channels {
+		err := ch.Notify(ctx, a)
+		if err != nil {
+			log.Printf("Error sending alert %s to %s: %v", a.Name, ch, err)
+		}
+	}
+}
+
+func (e *Evaluator) check(ctx context.Context) ([]Alert, error) {
+	now := time.Now()
+	th := e.cfg.Thresholds
+	var alerts []Alert
+
+	if th.Backlog > 0 || th.OldestUnprocessed > 0 {
+		var backlog int64
+		var oldest sql.NullString
+		err := e.db.QueryRowContext(ctx, `
+            SELECT COUNT(*), MIN(created_at)
+            FROM priority_changes
+            WHERE processed = FALSE
+            AND id NOT IN (SELECT change_id FROM change_quarantine)
+        `).Scan(&backlog, &oldest)
+		if err != nil {
+			return nil, err
+		}
+		if th.Backlog > 0 {
+			alerts = append(alerts, Alert{
+				Name:    "backlog",
+				Firing:  backlog > th.Backlog,
+				Summary: fmt.Sprintf("%d changes waiting, threshold %d", backlog, th.Backlog),
+				At:      now,
+			})
+		}
+		if th.OldestUnprocessed > 0 {
+			var age time.Duration
+			if oldest.Valid {
+				created, err := time.Parse(time.DateTime, oldest.String)
+				if err != nil {
+					return nil, fmt.Errorf("oldest unprocessed change: %w", err)
+				}
+				age = now.Sub(created).Truncate(time.Second)
+			}
+			alerts = append(alerts, Alert{
+				Name:    "oldest_unprocessed",
+				Firing:  age > th.OldestUnprocessed,
+				Summary: fmt.Sprintf("oldest waiting change is %s old, threshold %s", age, th.OldestUnprocessed),
+				At:      now,
+			})
+		}
+	}
+
+	if th.DeadLetters > 0 {
+		var dead int64
+		err := e.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM change_quarantine`).Scan(&dead)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, Alert{
+			Name:    "dead_letters",
+			Firing:  dead > th.DeadLetters,
+			Summary: fmt.Sprintf("%d changes quarantined, threshold %d", dead, th.DeadLetters),
+			At:      now,
+		})
+	}
+
+	if th.StaleAfter > 0 {
+		heartbeats, err := poller.ListHeartbeats(ctx, e.db, th.StaleAfter)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range heartbeats {
+			alerts = append(alerts, Alert{
+				Name:   "poller_stalled:" + h.Instance + "/" + h.Poller,
+				Firing: h.Stale,
+				Summary: fmt.Sprintf("poller %s on %s last completed a cycle at %s",
+					h.Poller, h.Instance, h.LastCycleAt.Format(time.RFC3339)),
+				At: now,
+			})
+		}
+	}
+	return alerts, nil
+}
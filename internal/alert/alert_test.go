@@ -0,0 +1,95 @@
+package alert
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"test/internal/store"
+)
+
+type recorder struct {
+	got []string
+}
+
+func (r *recorder) Notify(ctx context.Context, a Alert) error {
+	state := "resolved"
+	if a.Firing {
+		state = "firing"
+	}
+	r.got = append(r.got, a.Name+" "+state)
+	return nil
+}
+
+func (r *recorder) String() string { return "recorder" }
+
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	db, err := store.Open("file:" + filepath.Join(tb.TempDir(), "orders.db") + "?_busy_timeout=5000")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	err = store.Migrate(db)
+	if err != nil {
+		tb.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestEvaluatorNotifiesOnStateChanges(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.Exec(`
+        INSERT INTO orders (customer_name, product_name, quantity, shipping_address, priority)
+        VALUES ('Test', 'ninja', 1, '1 Test Way', 'low')
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		_, err = db.Exec(`
+            INSERT INTO priority_changes (order_id, priority, created_at)
+            VALUES (1, 'high', datetime('now', '-2 hours'))
+        `)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := &recorder{}
+	e := NewEvaluator(db, Config{Thresholds: Thresholds{
+		Backlog:           2,
+		OldestUnprocessed: time.Hour,
+		DeadLetters:       5,
+	}}, []Channel{rec})
+	for range 2 {
+		firing, err := e.Evaluate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(firing) != 2 {
+			t.Errorf("firing = %+v, want backlog and oldest_unprocessed", firing)
+		}
+	}
+
+	_, err = db.Exec(`UPDATE priority_changes SET processed = TRUE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.Evaluate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"backlog firing", "oldest_unprocessed firing",
+		"backlog resolved", "oldest_unprocessed resolved",
+	}
+	if !slices.Equal(rec.got, want) {
+		t.Errorf("notifications = %v, want %v", rec.got, want)
+	}
+}
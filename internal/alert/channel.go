@@ -0,0 +1,118 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Channel delivers alerts to where someone will see them.
+type Channel interface {
+	Notify(ctx context.Context, a Alert) error
+	String() string
+}
+
+// channelRegistry maps channel kinds to the constructors that open them
+// from the text after "=" in a -alert-channels entry.
+type channelRegistry struct {
+	mu    sync.RWMutex
+	kinds map[string]func(target string) (Channel, error)
+}
+
+var channels = &channelRegistry{kinds: make(map[string]func(string) (Channel, error))}
+
+// RegisterChannel makes kind usable in OpenChannels. Call it from init.
+func RegisterChannel(kind string, open func(target string) (Channel, error)) {
+	channels.mu.Lock()
+	defer channels.mu.Unlock()
+	channels.kinds[kind] = open
+}
+
+// OpenChannels opens channels from specs such as "log" or
+// "webhook=https://hooks.example.com/alerts".
+func OpenChannels(specs []string) ([]Channel, error) {
+	channels.mu.RLock()
+	defer channels.mu.RUnlock()
+
+	var out []Channel
+	for _, spec := range specs {
+		kind, target, _ := strings.Cut(spec, "=")
+		open, ok := channels.kinds[kind]
+		if !ok {
+			kinds := make([]string, 0, len(channels.kinds))
+			for k := range channels.kinds {
+				kinds = append(kinds, k)
+			}
+			slices.Sort(kinds)
+			return nil, fmt.Errorf("unknown alert channel %q (registered: %s)", kind, strings.Join(kinds, ", "))
+		}
+		ch, err := open(target)
+		if err != nil {
+			return nil, fmt.Errorf("alert channel %s: %w", spec, err)
+		}
+		out = append(out, ch)
+	}
+	return out, nil
+}
+
+func init() {
+	RegisterChannel("log", func(string) (Channel, error) { return logChannel{}, nil })
+	RegisterChannel("webhook", openWebhook)
+}
+
+// logChannel writes alerts to the log in the ALERT: form the pollers use.
+type logChannel struct{}
+
+func (logChannel) Notify(ctx context.Context, a Alert) error {
+	if a.Firing {
+		log.Printf("ALERT: %s: %s", a.Name, a.Summary)
+	} else {
+		log.Printf("RESOLVED: %s: %s", a.Name, a.Summary)
+	}
+	return nil
+}
+
+func (logChannel) String() string { return "log" }
+
+// webhookChannel POSTs each alert as JSON.
+type webhookChannel struct {
+	http *http.Client
+	url  string
+}
+
+func openWebhook(url string) (Channel, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("webhook needs an http(s) URL, got %q", url)
+	}
+	return webhookChannel{http: &http.Client{Timeout: 10 * time.Second}, url: url}, nil
+}
+
+func (w webhookChannel) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (w webhookChannel) String() string { return "webhook" }
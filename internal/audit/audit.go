@@ -0,0 +1,130 @@
+// Package audit defines the change feed recorded in priority_changes: the
+// change types pollers dispatch on and the entries of the per-order audit
+// trail read back from it.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"test/api"
+)
+
+const (
+	PriorityChanged = "priority.changed"
+	OrderCancelled  = "order.cancelled"
+
+	// CustomerErased records that an order's personal data was anonymized.
+	CustomerErased = "customer.erased"
+)
+
+// Entry is an audit trail entry as the API shows it; its Actor is the
+// one WithActor recorded.
+type Entry = api.AuditEntry
+
+type actorKey struct{}
+
+// WithActor returns ctx carrying the actor recorded on changes made with
+// it, such as the fingerprint of the API key a request authenticated with.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// DefaultTenant owns the orders of requests that resolve to no tenant,
+// and every order recorded before tenants existed.
+const DefaultTenant = "default"
+
+type tenantKey struct{}
+
+// WithTenant returns ctx scoped to tenant: orders and changes read with it
+// are that tenant's only, and those written with it belong to the tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant ctx is scoped to, DefaultTenant when none.
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	if tenant == "" {
+		return DefaultTenant
+	}
+	return tenant
+}
+
+// PatchOp is one operation of a JSON Patch.
+type PatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// Replace patches path from old to new. The leading test op records the old
+// value, which is what lets a patch be undone.
+func Replace(path string, old, new any) []PatchOp {
+	return []PatchOp{
+		{Op: "test", Path: path, Value: old},
+		{Op: "replace", Path: path, Value: new},
+	}
+}
+
+// Undo reverts patch on doc, an order in its JSON form, by restoring every
+// value the patch's test ops recorded. Values replaced without a test op,
+// like erased personal data, stay as they are.
+func Undo(doc map[string]any, patch json.RawMessage) error {
+	var ops []PatchOp
+	err := json.Unmarshal(patch, &ops)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.Op != "test" {
+			continue
+		}
+		doc[pointerKey(op.Path)] = op.Value
+	}
+	return nil
+}
+
+// pointerKey unescapes a top-level JSON Pointer; order documents are flat.
+func pointerKey(path string) string {
+	key := strings.TrimPrefix(path, "/")
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(key)
+}
+
+// Hash chains e to the entry before it: a hex SHA-256 over prev, that
+// entry's hash, and e's recorded fields, including the patch as stored,
+// the actor and tenant when set. processed is not covered because it
+// changes after the entry is written.
+func Hash(prev string, e Entry) string {
+	fields := []string{
+		prev,
+		strconv.FormatInt(e.ID, 10),
+		strconv.FormatInt(e.OrderID, 10),
+		e.ChangeType,
+		e.Priority,
+		e.CreatedAt.UTC().Format(time.DateTime),
+	}
+	if len(e.Patch) > 0 {
+		fields = append(fields, string(e.Patch))
+	}
+	if e.Actor != "" {
+		fields = append(fields, "actor="+e.Actor)
+	}
+	// Left out for the default tenant so changes chained before tenants
+	// existed keep their hashes.
+	if e.Tenant != "" && e.Tenant != DefaultTenant {
+		fields = append(fields, "tenant="+e.Tenant)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,168 @@
+// Package events defines the envelope change events go out in, to webhook
+// subscriptions and to queues alike:
+//
+//	{"schema_version": 2, "event_type": "priority.changed", "payload": {...}}
+//
+// The payload's shape is fixed per schema version. Consumers pin the
+// version they were written against and Convert steps a payload up or down
+// one version at a time, so changing the shape means adding a version and
+// its converters, never editing an old one. Encode can also write the
+// event as a CloudEvent, for consumers built around that spec, and
+// EncodeAvro as Avro for the data platform's Kafka tooling.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Schema versions.
+const (
+	// V1 is the flat shape webhooks first went out in.
+	V1 = 1
+
+	// V2 gathers the order's fields under "order" and leaves the change
+	// type to the envelope.
+	V2 = 2
+
+	// Current is what events are built in.
+	Current = V2
+)
+
+var ErrUnknownVersion = errors.New("unknown event schema version")
+
+type Envelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+
+	// ID, Subject and Time only appear in CloudEvents output, as the
+	// attributes of the same names.
+	ID      string    `json:"-"`
+	Subject string    `json:"-"`
+	Time    time.Time `json:"-"`
+}
+
+// Payload is the current payload shape.
+type Payload = PayloadV2
+
+type PayloadV2 struct {
+	ChangeID  int64     `json:"changeId"`
+	Tenant    string    `json:"tenant"`
+	CreatedAt time.Time `json:"createdAt"`
+	Order     Order     `json:"order"`
+}
+
+// Order is the changed order as of the change.
+type Order struct {
+	ID       int64  `json:"id"`
+	Product  string `json:"product"`
+	Quantity int    `json:"quantity"`
+	Priority string `json:"priority,omitempty"`
+}
+
+type PayloadV1 struct {
+	ChangeID   int64     `json:"changeId"`
+	OrderID    int64     `json:"orderId"`
+	ChangeType string    `json:"changeType"`
+	Priority   string    `json:"priority,omitempty"`
+	Product    string    `json:"product"`
+	Quantity   int       `json:"quantity"`
+	Tenant     string    `json:"tenant"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// New wraps p in an envelope of the current version.
+func New(eventType string, p Payload) (Envelope, error) {
+	b, err := json.Marshal(p)
+	return Envelope{
+		SchemaVersion: Current,
+		EventType:     eventType,
+		Payload:       b,
+		ID:            strconv.FormatInt(p.ChangeID, 10),
+		Subject:       "orders/" + strconv.FormatInt(p.Order.ID, 10),
+		Time:          p.CreatedAt,
+	}, err
+}
+
+// Supported reports whether version is one Convert knows.
+func Supported(version int) bool {
+	return version >= V1 && version <= Current
+}
+
+// converter rewrites an envelope's payload for the next version up or
+// down.
+type converter func(Envelope) (json.RawMessage, error)
+
+// steps holds the converters between each version and the next, keyed by
+// the lower of the two.
+var steps = map[int]struct{ up, down converter }{
+	V1: {up: v1ToV2, down: v2ToV1},
+}
+
+// Convert returns e with its payload in version.
+func Convert(e Envelope, version int) (Envelope, error) {
+	if !Supported(version) {
+		return e, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	if !Supported(e.SchemaVersion) {
+		return e, fmt.Errorf("%w: %d", ErrUnknownVersion, e.SchemaVersion)
+	}
+	for e.SchemaVersion != version {
+		var payload json.RawMessage
+		var err error
+		from := e.SchemaVersion
+		if from < version {
+			payload, err = steps[from].up(e)
+			e.SchemaVersion++
+		} else {
+			payload, err = steps[from-1].down(e)
+			e.SchemaVersion--
+		}
+		if err != nil {
+			return e, fmt.Errorf("converting %s from v%d to v%d: %w", e.EventType, from, e.SchemaVersion, err)
+		}
+		e.Payload = payload
+	}
+	return e, nil
+}
+
+func v1ToV2(e Envelope) (json.RawMessage, error) {
+	var p PayloadV1
+	err := json.Unmarshal(e.Payload, &p)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(PayloadV2{
+		ChangeID:  p.ChangeID,
+		Tenant:    p.Tenant,
+		CreatedAt: p.CreatedAt,
+		Order: Order{
+			ID:       p.OrderID,
+			Product:  p.Product,
+			Quantity: p.Quantity,
+			Priority: p.Priority,
+		},
+	})
+}
+
+func v2ToV1(e Envelope) (json.RawMessage, error) {
+	var p PayloadV2
+	err := json.Unmarshal(e.Payload, &p)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(PayloadV1{
+		ChangeID:   p.ChangeID,
+		OrderID:    p.Order.ID,
+		ChangeType: e.EventType,
+		Priority:   p.Order.Priority,
+		Product:    p.Order.Product,
+		Quantity:   p.Order.Quantity,
+		Tenant:     p.Tenant,
+		CreatedAt:  p.CreatedAt,
+	})
+}
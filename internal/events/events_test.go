@@ -0,0 +1,117 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro/v2"
+)
+
+func TestConvertRoundTrips(t *testing.T) {
+	e, err := New("priority.changed", Payload{
+		ChangeID:  7,
+		Tenant:    "acme",
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Order:     Order{ID: 3, Product: "ninja", Quantity: 2, Priority: "high"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := Convert(e, V1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p PayloadV1
+	err = json.Unmarshal(v1.Payload, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.SchemaVersion != V1 || p.ChangeType != "priority.changed" || p.OrderID != 3 || p.Quantity != 2 {
+		t.Errorf("v1 = %d %+v, want the flat payload with the change type", v1.SchemaVersion, p)
+	}
+
+	back, err := Convert(v1, Current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.SchemaVersion != Current || !bytes.Equal(back.Payload, e.Payload) {
+		t.Errorf("v1 upgraded back = %s, want %s", back.Payload, e.Payload)
+	}
+
+	for _, version := range []int{0, Current + 1} {
+		_, err = Convert(e, version)
+		if !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("Convert to %d: %v, want ErrUnknownVersion", version, err)
+		}
+	}
+}
+
+func TestEncodeCloudEvents(t *testing.T) {
+	e, err := New("order.cancelled", Payload{
+		ChangeID:  8,
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Order:     Order{ID: 3, Product: "ninja"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, contentType, err := Encode(e, V1, FormatCloudEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ce CloudEvent
+	err = json.Unmarshal(body, &ce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != ContentTypeCloudEvents || ce.SpecVersion != "1.0" || ce.ID != "8" || ce.Type != "order.cancelled" ||
+		ce.Subject != "orders/3" || ce.SchemaVersion != V1 || !ce.Time.Equal(e.Time) {
+		t.Errorf("%s %s, want a CloudEvent for change 8 with v1 data", contentType, body)
+	}
+
+	_, _, err = Encode(e, Current, "xml")
+	if err == nil {
+		t.Error("Encode accepted an unknown format")
+	}
+}
+
+func TestEncodeAvro(t *testing.T) {
+	e, err := New("priority.changed", Payload{
+		ChangeID:  7,
+		Tenant:    "acme",
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Order:     Order{ID: 3, Product: "ninja", Quantity: 2, Priority: "high"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := Convert(e, V1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EncodeAvro(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got avroChange
+	err = avro.Unmarshal(avroSchema, b, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := avroChange{
+		EventType: "priority.changed",
+		ChangeID:  7,
+		Tenant:    "acme",
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Order:     avroOrder{ID: 3, Product: "ninja", Quantity: 2, Priority: "high"},
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.Order != want.Order || got.ChangeID != want.ChangeID ||
+		got.Tenant != want.Tenant || got.EventType != want.EventType {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
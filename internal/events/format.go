@@ -0,0 +1,69 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Output formats.
+const (
+	// FormatEnvelope is the Envelope as JSON.
+	FormatEnvelope = "envelope"
+
+	// FormatCloudEvents is a CloudEvents 1.0 event in structured mode, the
+	// payload as its data.
+	FormatCloudEvents = "cloudevents"
+)
+
+var Formats = []string{FormatEnvelope, FormatCloudEvents}
+
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeCloudEvents = "application/cloudevents+json"
+
+	// CloudEventsSource is the source attribute of every CloudEvent.
+	CloudEventsSource = "/orders"
+)
+
+// CloudEvent is the structured-mode JSON form of a CloudEvents 1.0 event.
+// The schema version the data follows is the schemaversion extension.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time,omitzero"`
+	DataContentType string          `json:"datacontenttype"`
+	SchemaVersion   int             `json:"schemaversion"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// Encode converts e to version and writes it in format, returning the body
+// and the Content-Type it goes out with.
+func Encode(e Envelope, version int, format string) ([]byte, string, error) {
+	e, err := Convert(e, version)
+	if err != nil {
+		return nil, "", err
+	}
+	switch format {
+	case FormatEnvelope, "":
+		b, err := json.Marshal(e)
+		return b, ContentTypeJSON, err
+	case FormatCloudEvents:
+		b, err := json.Marshal(CloudEvent{
+			SpecVersion:     "1.0",
+			ID:              e.ID,
+			Source:          CloudEventsSource,
+			Type:            e.EventType,
+			Subject:         e.Subject,
+			Time:            e.Time.UTC(),
+			DataContentType: ContentTypeJSON,
+			SchemaVersion:   e.SchemaVersion,
+			Data:            e.Payload,
+		})
+		return b, ContentTypeCloudEvents, err
+	}
+	return nil, "", fmt.Errorf("unknown event format %q", format)
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file when the
+// test runs with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.MkdirAll("testdata", 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, got, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed.\n got: %s\nwant: %s", path, got, want)
+	}
+}
+
+// TestGoldenCloudEvents pins the CloudEvents body of each event type in
+// every schema version, one event per line.
+func TestGoldenCloudEvents(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var envelopes []Envelope
+	for _, c := range []struct {
+		eventType string
+		payload   Payload
+	}{
+		{"priority.changed", Payload{
+			ChangeID: 7, Tenant: "acme", CreatedAt: at,
+			Order: Order{ID: 3, Product: "ninja", Quantity: 2, Priority: "high"},
+		}},
+		{"order.cancelled", Payload{
+			ChangeID: 8, Tenant: "acme", CreatedAt: at.Add(time.Minute),
+			Order: Order{ID: 3, Product: "ninja", Quantity: 2, Priority: "high"},
+		}},
+		{"customer.erased", Payload{
+			ChangeID: 9, Tenant: "default", CreatedAt: at.Add(2 * time.Minute),
+			Order: Order{ID: 4, Product: "widget", Quantity: 1, Priority: "low"},
+		}},
+	} {
+		e, err := New(c.eventType, c.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelopes = append(envelopes, e)
+	}
+
+	var out bytes.Buffer
+	for version := V1; version <= Current; version++ {
+		for _, e := range envelopes {
+			body, _, err := Encode(e, version, FormatCloudEvents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out.Write(body)
+			out.WriteByte('\n')
+		}
+	}
+	checkGolden(t, "cloudevents.golden", out.Bytes())
+}
@@ -0,0 +1,56 @@
+package grpcapi
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"test/internal/audit"
+	"test/internal/grpcapi/pb"
+	"test/internal/maintenance"
+	"test/internal/store"
+)
+
+// ProtoOrder is o, owned by tenant, as its protobuf message.
+func ProtoOrder(o store.Order, tenant string) *pb.Order {
+	return &pb.Order{
+		Id:              o.ID,
+		CustomerName:    o.CustomerName,
+		ProductName:     o.ProductName,
+		Quantity:        int32(o.Quantity),
+		ShippingAddress: o.ShippingAddress,
+		Priority:        o.Priority,
+		Tag:             o.Tag,
+		Status:          o.Status,
+		CreatedAt:       timestamppb.New(o.CreatedAt),
+		Tenant:          tenant,
+	}
+}
+
+// ProtoChange is c as its protobuf message.
+func ProtoChange(c maintenance.Change) *pb.PriorityChange {
+	return &pb.PriorityChange{
+		Id:          c.ID,
+		OrderId:     c.OrderID,
+		ChangeType:  c.ChangeType,
+		Priority:    c.Priority,
+		ProductName: c.ProductName,
+		Quantity:    int32(c.Quantity),
+		Tenant:      c.Tenant,
+		Actor:       c.Actor,
+		CreatedAt:   timestamppb.New(c.CreatedAt),
+	}
+}
+
+// ProtoAuditEntry is e as its protobuf message.
+func ProtoAuditEntry(e audit.Entry) *pb.AuditEntry {
+	return &pb.AuditEntry{
+		Id:         e.ID,
+		OrderId:    e.OrderID,
+		ChangeType: e.ChangeType,
+		Priority:   e.Priority,
+		Processed:  e.Processed,
+		CreatedAt:  timestamppb.New(e.CreatedAt),
+		Actor:      e.Actor,
+		Patch:      e.Patch,
+		Tenant:     e.Tenant,
+	}
+}
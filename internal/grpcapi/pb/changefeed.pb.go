@@ -0,0 +1,501 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: changefeed.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StreamChangesRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	AfterId int64                  `protobuf:"varint,1,opt,name=after_id,json=afterId,proto3" json:"after_id,omitempty"`
+	// Only changes of these types; empty means every type.
+	EventTypes []string `protobuf:"bytes,2,rep,name=event_types,json=eventTypes,proto3" json:"event_types,omitempty"`
+	// The events schema version payloads are written in; 0 means the
+	// current one.
+	SchemaVersion int32 `protobuf:"varint,3,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamChangesRequest) Reset() {
+	*x = StreamChangesRequest{}
+	mi := &file_changefeed_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamChangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamChangesRequest) ProtoMessage() {}
+
+func (x *StreamChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_changefeed_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamChangesRequest.ProtoReflect.Descriptor instead.
+func (*StreamChangesRequest) Descriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StreamChangesRequest) GetAfterId() int64 {
+	if x != nil {
+		return x.AfterId
+	}
+	return 0
+}
+
+func (x *StreamChangesRequest) GetEventTypes() []string {
+	if x != nil {
+		return x.EventTypes
+	}
+	return nil
+}
+
+func (x *StreamChangesRequest) GetSchemaVersion() int32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+type StreamChangesResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Item:
+	//
+	//	*StreamChangesResponse_Change
+	//	*StreamChangesResponse_Heartbeat
+	Item          isStreamChangesResponse_Item `protobuf_oneof:"item"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamChangesResponse) Reset() {
+	*x = StreamChangesResponse{}
+	mi := &file_changefeed_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamChangesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamChangesResponse) ProtoMessage() {}
+
+func (x *StreamChangesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_changefeed_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamChangesResponse.ProtoReflect.Descriptor instead.
+func (*StreamChangesResponse) Descriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StreamChangesResponse) GetItem() isStreamChangesResponse_Item {
+	if x != nil {
+		return x.Item
+	}
+	return nil
+}
+
+func (x *StreamChangesResponse) GetChange() *Change {
+	if x != nil {
+		if x, ok := x.Item.(*StreamChangesResponse_Change); ok {
+			return x.Change
+		}
+	}
+	return nil
+}
+
+func (x *StreamChangesResponse) GetHeartbeat() *Heartbeat {
+	if x != nil {
+		if x, ok := x.Item.(*StreamChangesResponse_Heartbeat); ok {
+			return x.Heartbeat
+		}
+	}
+	return nil
+}
+
+type isStreamChangesResponse_Item interface {
+	isStreamChangesResponse_Item()
+}
+
+type StreamChangesResponse_Change struct {
+	Change *Change `protobuf:"bytes,1,opt,name=change,proto3,oneof"`
+}
+
+type StreamChangesResponse_Heartbeat struct {
+	Heartbeat *Heartbeat `protobuf:"bytes,2,opt,name=heartbeat,proto3,oneof"`
+}
+
+func (*StreamChangesResponse_Change) isStreamChangesResponse_Item() {}
+
+func (*StreamChangesResponse_Heartbeat) isStreamChangesResponse_Item() {}
+
+type Change struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	EventType     string                 `protobuf:"bytes,2,opt,name=event_type,json=eventType,proto3" json:"event_type,omitempty"`
+	SchemaVersion int32                  `protobuf:"varint,3,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	// The change's events payload in schema_version, as JSON.
+	Payload []byte `protobuf:"bytes,4,opt,name=payload,proto3" json:"payload,omitempty"`
+	// The change as a typed message, the same in every schema version.
+	Change        *PriorityChange `protobuf:"bytes,5,opt,name=change,proto3" json:"change,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Change) Reset() {
+	*x = Change{}
+	mi := &file_changefeed_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Change) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Change) ProtoMessage() {}
+
+func (x *Change) ProtoReflect() protoreflect.Message {
+	mi := &file_changefeed_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Change.ProtoReflect.Descriptor instead.
+func (*Change) Descriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Change) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Change) GetEventType() string {
+	if x != nil {
+		return x.EventType
+	}
+	return ""
+}
+
+func (x *Change) GetSchemaVersion() int32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *Change) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *Change) GetChange() *PriorityChange {
+	if x != nil {
+		return x.Change
+	}
+	return nil
+}
+
+// Heartbeat says the stream has sent every matching change through
+// position. Consumers that filter by type can resume from it instead of
+// from their last change.
+type Heartbeat struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Position      int64                  `protobuf:"varint,1,opt,name=position,proto3" json:"position,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Heartbeat) Reset() {
+	*x = Heartbeat{}
+	mi := &file_changefeed_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Heartbeat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Heartbeat) ProtoMessage() {}
+
+func (x *Heartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_changefeed_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
+func (*Heartbeat) Descriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Heartbeat) GetPosition() int64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+type GetOrderHistoryRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The tenant owning the order; empty means the default tenant.
+	Tenant        string `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	OrderId       int64  `protobuf:"varint,2,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetOrderHistoryRequest) Reset() {
+	*x = GetOrderHistoryRequest{}
+	mi := &file_changefeed_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetOrderHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetOrderHistoryRequest) ProtoMessage() {}
+
+func (x *GetOrderHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_changefeed_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetOrderHistoryRequest.ProtoReflect.Descriptor instead.
+func (*GetOrderHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetOrderHistoryRequest) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *GetOrderHistoryRequest) GetOrderId() int64 {
+	if x != nil {
+		return x.OrderId
+	}
+	return 0
+}
+
+type OrderHistory struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Order         *Order                 `protobuf:"bytes,1,opt,name=order,proto3" json:"order,omitempty"`
+	Entries       []*AuditEntry          `protobuf:"bytes,2,rep,name=entries,proto3" json:"entries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OrderHistory) Reset() {
+	*x = OrderHistory{}
+	mi := &file_changefeed_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OrderHistory) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OrderHistory) ProtoMessage() {}
+
+func (x *OrderHistory) ProtoReflect() protoreflect.Message {
+	mi := &file_changefeed_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OrderHistory.ProtoReflect.Descriptor instead.
+func (*OrderHistory) Descriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *OrderHistory) GetOrder() *Order {
+	if x != nil {
+		return x.Order
+	}
+	return nil
+}
+
+func (x *OrderHistory) GetEntries() []*AuditEntry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+var File_changefeed_proto protoreflect.FileDescriptor
+
+const file_changefeed_proto_rawDesc = "" +
+	"\n" +
+	"\x10changefeed.proto\x12\torders.v1\x1a\forders.proto\"y\n" +
+	"\x14StreamChangesRequest\x12\x19\n" +
+	"\bafter_id\x18\x01 \x01(\x03R\aafterId\x12\x1f\n" +
+	"\vevent_types\x18\x02 \x03(\tR\n" +
+	"eventTypes\x12%\n" +
+	"\x0eschema_version\x18\x03 \x01(\x05R\rschemaVersion\"\x82\x01\n" +
+	"\x15StreamChangesResponse\x12+\n" +
+	"\x06change\x18\x01 \x01(\v2\x11.orders.v1.ChangeH\x00R\x06change\x124\n" +
+	"\theartbeat\x18\x02 \x01(\v2\x14.orders.v1.HeartbeatH\x00R\theartbeatB\x06\n" +
+	"\x04item\"\xab\x01\n" +
+	"\x06Change\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1d\n" +
+	"\n" +
+	"event_type\x18\x02 \x01(\tR\teventType\x12%\n" +
+	"\x0eschema_version\x18\x03 \x01(\x05R\rschemaVersion\x12\x18\n" +
+	"\apayload\x18\x04 \x01(\fR\apayload\x121\n" +
+	"\x06change\x18\x05 \x01(\v2\x19.orders.v1.PriorityChangeR\x06change\"'\n" +
+	"\tHeartbeat\x12\x1a\n" +
+	"\bposition\x18\x01 \x01(\x03R\bposition\"K\n" +
+	"\x16GetOrderHistoryRequest\x12\x16\n" +
+	"\x06tenant\x18\x01 \x01(\tR\x06tenant\x12\x19\n" +
+	"\border_id\x18\x02 \x01(\x03R\aorderId\"g\n" +
+	"\fOrderHistory\x12&\n" +
+	"\x05order\x18\x01 \x01(\v2\x10.orders.v1.OrderR\x05order\x12/\n" +
+	"\aentries\x18\x02 \x03(\v2\x15.orders.v1.AuditEntryR\aentries2\xb1\x01\n" +
+	"\n" +
+	"ChangeFeed\x12T\n" +
+	"\rStreamChanges\x12\x1f.orders.v1.StreamChangesRequest\x1a .orders.v1.StreamChangesResponse0\x01\x12M\n" +
+	"\x0fGetOrderHistory\x12!.orders.v1.GetOrderHistoryRequest\x1a\x17.orders.v1.OrderHistoryB\x1aZ\x18test/internal/grpcapi/pbb\x06proto3"
+
+var (
+	file_changefeed_proto_rawDescOnce sync.Once
+	file_changefeed_proto_rawDescData []byte
+)
+
+func file_changefeed_proto_rawDescGZIP() []byte {
+	file_changefeed_proto_rawDescOnce.Do(func() {
+		file_changefeed_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_changefeed_proto_rawDesc), len(file_changefeed_proto_rawDesc)))
+	})
+	return file_changefeed_proto_rawDescData
+}
+
+var file_changefeed_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_changefeed_proto_goTypes = []any{
+	(*StreamChangesRequest)(nil),   // 0: orders.v1.StreamChangesRequest
+	(*StreamChangesResponse)(nil),  // 1: orders.v1.StreamChangesResponse
+	(*Change)(nil),                 // 2: orders.v1.Change
+	(*Heartbeat)(nil),              // 3: orders.v1.Heartbeat
+	(*GetOrderHistoryRequest)(nil), // 4: orders.v1.GetOrderHistoryRequest
+	(*OrderHistory)(nil),           // 5: orders.v1.OrderHistory
+	(*PriorityChange)(nil),         // 6: orders.v1.PriorityChange
+	(*Order)(nil),                  // 7: orders.v1.Order
+	(*AuditEntry)(nil),             // 8: orders.v1.AuditEntry
+}
+var file_changefeed_proto_depIdxs = []int32{
+	2, // 0: orders.v1.StreamChangesResponse.change:type_name -> orders.v1.Change
+	3, // 1: orders.v1.StreamChangesResponse.heartbeat:type_name -> orders.v1.Heartbeat
+	6, // 2: orders.v1.Change.change:type_name -> orders.v1.PriorityChange
+	7, // 3: orders.v1.OrderHistory.order:type_name -> orders.v1.Order
+	8, // 4: orders.v1.OrderHistory.entries:type_name -> orders.v1.AuditEntry
+	0, // 5: orders.v1.ChangeFeed.StreamChanges:input_type -> orders.v1.StreamChangesRequest
+	4, // 6: orders.v1.ChangeFeed.GetOrderHistory:input_type -> orders.v1.GetOrderHistoryRequest
+	1, // 7: orders.v1.ChangeFeed.StreamChanges:output_type -> orders.v1.StreamChangesResponse
+	5, // 8: orders.v1.ChangeFeed.GetOrderHistory:output_type -> orders.v1.OrderHistory
+	7, // [7:9] is the sub-list for method output_type
+	5, // [5:7] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_changefeed_proto_init() }
+func file_changefeed_proto_init() {
+	if File_changefeed_proto != nil {
+		return
+	}
+	file_orders_proto_init()
+	file_changefeed_proto_msgTypes[1].OneofWrappers = []any{
+		(*StreamChangesResponse_Change)(nil),
+		(*StreamChangesResponse_Heartbeat)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_changefeed_proto_rawDesc), len(file_changefeed_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_changefeed_proto_goTypes,
+		DependencyIndexes: file_changefeed_proto_depIdxs,
+		MessageInfos:      file_changefeed_proto_msgTypes,
+	}.Build()
+	File_changefeed_proto = out.File
+	file_changefeed_proto_goTypes = nil
+	file_changefeed_proto_depIdxs = nil
+}
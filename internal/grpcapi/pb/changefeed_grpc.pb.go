@@ -0,0 +1,189 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: changefeed.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ChangeFeed_StreamChanges_FullMethodName   = "/orders.v1.ChangeFeed/StreamChanges"
+	ChangeFeed_GetOrderHistory_FullMethodName = "/orders.v1.ChangeFeed/GetOrderHistory"
+)
+
+// ChangeFeedClient is the client API for ChangeFeed service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ChangeFeed streams processed changes to internal consumers, a cheaper
+// alternative to webhook subscriptions.
+type ChangeFeedClient interface {
+	// StreamChanges sends every processed change with an id above after_id,
+	// oldest first, then keeps the stream open and sends changes as the
+	// pollers finish them. A Heartbeat goes out whenever the stream has been
+	// quiet for the server's heartbeat interval.
+	//
+	// To resume after a disconnect, call again with after_id set to the id of
+	// the last change, or the position of the last heartbeat, the consumer
+	// has dealt with.
+	StreamChanges(ctx context.Context, in *StreamChangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamChangesResponse], error)
+	// GetOrderHistory returns an order with its audit trail, oldest change
+	// first.
+	GetOrderHistory(ctx context.Context, in *GetOrderHistoryRequest, opts ...grpc.CallOption) (*OrderHistory, error)
+}
+
+type changeFeedClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChangeFeedClient(cc grpc.ClientConnInterface) ChangeFeedClient {
+	return &changeFeedClient{cc}
+}
+
+func (c *changeFeedClient) StreamChanges(ctx context.Context, in *StreamChangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamChangesResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ChangeFeed_ServiceDesc.Streams[0], ChangeFeed_StreamChanges_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamChangesRequest, StreamChangesResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ChangeFeed_StreamChangesClient = grpc.ServerStreamingClient[StreamChangesResponse]
+
+func (c *changeFeedClient) GetOrderHistory(ctx context.Context, in *GetOrderHistoryRequest, opts ...grpc.CallOption) (*OrderHistory, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OrderHistory)
+	err := c.cc.Invoke(ctx, ChangeFeed_GetOrderHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChangeFeedServer is the server API for ChangeFeed service.
+// All implementations must embed UnimplementedChangeFeedServer
+// for forward compatibility.
+//
+// ChangeFeed streams processed changes to internal consumers, a cheaper
+// alternative to webhook subscriptions.
+type ChangeFeedServer interface {
+	// StreamChanges sends every processed change with an id above after_id,
+	// oldest first, then keeps the stream open and sends changes as the
+	// pollers finish them. A Heartbeat goes out whenever the stream has been
+	// quiet for the server's heartbeat interval.
+	//
+	// To resume after a disconnect, call again with after_id set to the id of
+	// the last change, or the position of the last heartbeat, the consumer
+	// has dealt with.
+	StreamChanges(*StreamChangesRequest, grpc.ServerStreamingServer[StreamChangesResponse]) error
+	// GetOrderHistory returns an order with its audit trail, oldest change
+	// first.
+	GetOrderHistory(context.Context, *GetOrderHistoryRequest) (*OrderHistory, error)
+	mustEmbedUnimplementedChangeFeedServer()
+}
+
+// UnimplementedChangeFeedServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedChangeFeedServer struct{}
+
+func (UnimplementedChangeFeedServer) StreamChanges(*StreamChangesRequest, grpc.ServerStreamingServer[StreamChangesResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamChanges not implemented")
+}
+func (UnimplementedChangeFeedServer) GetOrderHistory(context.Context, *GetOrderHistoryRequest) (*OrderHistory, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrderHistory not implemented")
+}
+func (UnimplementedChangeFeedServer) mustEmbedUnimplementedChangeFeedServer() {}
+func (UnimplementedChangeFeedServer) testEmbeddedByValue()                    {}
+
+// UnsafeChangeFeedServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChangeFeedServer will
+// result in compilation errors.
+type UnsafeChangeFeedServer interface {
+	mustEmbedUnimplementedChangeFeedServer()
+}
+
+func RegisterChangeFeedServer(s grpc.ServiceRegistrar, srv ChangeFeedServer) {
+	// If the following call pancis, it indicates UnimplementedChangeFeedServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ChangeFeed_ServiceDesc, srv)
+}
+
+func _ChangeFeed_StreamChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ChangeFeedServer).StreamChanges(m, &grpc.GenericServerStream[StreamChangesRequest, StreamChangesResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ChangeFeed_StreamChangesServer = grpc.ServerStreamingServer[StreamChangesResponse]
+
+func _ChangeFeed_GetOrderHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrderHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChangeFeedServer).GetOrderHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChangeFeed_GetOrderHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChangeFeedServer).GetOrderHistory(ctx, req.(*GetOrderHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChangeFeed_ServiceDesc is the grpc.ServiceDesc for ChangeFeed service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChangeFeed_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "orders.v1.ChangeFeed",
+	HandlerType: (*ChangeFeedServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetOrderHistory",
+			Handler:    _ChangeFeed_GetOrderHistory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamChanges",
+			Handler:       _ChangeFeed_StreamChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "changefeed.proto",
+}
@@ -0,0 +1,6 @@
+// Package pb holds the protobuf messages and gRPC stubs generated from the
+// .proto files here. Regenerate with go generate, which needs buf,
+// protoc-gen-go and protoc-gen-go-grpc on PATH.
+package pb
+
+//go:generate buf generate
@@ -0,0 +1,263 @@
+// Package grpcapi serves the change feed and order history over gRPC; see
+// pb/changefeed.proto for the contract and pb/orders.proto for the
+// messages.
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"test/internal/audit"
+	"test/internal/events"
+	"test/internal/grpcapi/pb"
+	"test/internal/maintenance"
+	"test/internal/store"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultHeartbeat    = 15 * time.Second
+	defaultBatch        = 500
+
+	// keyHeader is the metadata key callers send their API key in.
+	keyHeader = "x-api-key"
+)
+
+type Config struct {
+	// Keys are the API keys callers may send; empty admits loopback
+	// callers only, like /admin without -admin-keys.
+	Keys []string
+
+	// PollInterval is how often an idle stream looks for newly processed
+	// changes; 0 means 1 second.
+	PollInterval time.Duration
+
+	// Heartbeat is how long a stream may stay quiet before a Heartbeat is
+	// sent; 0 means 15 seconds.
+	Heartbeat time.Duration
+
+	// Batch is how many changes a stream reads per query; 0 means 500.
+	Batch int
+}
+
+type Server struct {
+	pb.UnimplementedChangeFeedServer
+
+	db     *sql.DB
+	orders *store.Store
+	cfg    Config
+}
+
+func New(db *sql.DB, orders *store.Store, cfg Config) *Server {
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.Heartbeat == 0 {
+		cfg.Heartbeat = defaultHeartbeat
+	}
+	if cfg.Batch == 0 {
+		cfg.Batch = defaultBatch
+	}
+	return &Server{db: db, orders: orders, cfg: cfg}
+}
+
+// GRPCServer returns a gRPC server with the feed registered behind key
+// checks. Transport keepalives find dead connections on otherwise idle
+// streams; heartbeats do the same for the consumer.
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			err := s.authenticate(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			err := s.authenticate(ss.Context())
+			if err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    30 * time.Second,
+			Timeout: 10 * time.Second,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}, opts...)
+	srv := grpc.NewServer(opts...)
+	pb.RegisterChangeFeedServer(srv, s)
+	return srv
+}
+
+func (s *Server) authenticate(ctx context.Context) error {
+	if len(s.cfg.Keys) == 0 {
+		p, ok := peer.FromContext(ctx)
+		if ok && isLoopback(p.Addr) {
+			return nil
+		}
+		return status.Error(codes.PermissionDenied, "gRPC is limited to localhost without -admin-keys")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, got := range md.Get(keyHeader) {
+		for _, key := range s.cfg.Keys {
+			if subtle.ConstantTimeCompare([]byte(got), []byte(key)) == 1 {
+				return nil
+			}
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or unknown "+keyHeader)
+}
+
+func isLoopback(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// StreamChanges sends processed changes in id order. It only reads up to
+// the first change still to be processed, so a change still being retried
+// holds the stream back rather than being skipped; dead letters, and
+// changes no poller selects, are skipped.
+func (s *Server) StreamChanges(req *pb.StreamChangesRequest, stream pb.ChangeFeed_StreamChangesServer) error {
+	ctx := stream.Context()
+	version := int(req.SchemaVersion)
+	if version == 0 {
+		version = events.Current
+	}
+	if !events.Supported(version) {
+		return status.Errorf(codes.InvalidArgument, "schema_version must be between %d and %d", events.V1, events.Current)
+	}
+	if req.AfterId < 0 {
+		return status.Error(codes.InvalidArgument, "after_id must not be negative")
+	}
+
+	position := req.AfterId
+	quietSince := time.Now()
+	for {
+		settled, err := maintenance.SettledThrough(ctx, s.db)
+		if err != nil {
+			return s.fail(ctx, err)
+		}
+		var changes []maintenance.Change
+		if settled > position {
+			changes, err = maintenance.ListProcessed(s.db, maintenance.Range{ToID: settled}, position, s.cfg.Batch)
+			if err != nil {
+				return s.fail(ctx, err)
+			}
+		}
+
+		for _, c := range changes {
+			position = c.ID
+			if len(req.EventTypes) > 0 && !slices.Contains(req.EventTypes, c.ChangeType) {
+				continue
+			}
+			msg, err := change(c, version)
+			if err != nil {
+				return s.fail(ctx, err)
+			}
+			err = stream.Send(&pb.StreamChangesResponse{Item: &pb.StreamChangesResponse_Change{Change: msg}})
+			if err != nil {
+				return err
+			}
+			quietSince = time.Now()
+		}
+		full := len(changes) == s.cfg.Batch
+		if !full {
+			position = max(position, settled)
+		}
+
+		if time.Since(quietSince) >= s.cfg.Heartbeat {
+			err = stream.Send(&pb.StreamChangesResponse{Item: &pb.StreamChangesResponse_Heartbeat{
+				Heartbeat: &pb.Heartbeat{Position: position},
+			}})
+			if err != nil {
+				return err
+			}
+			quietSince = time.Now()
+		}
+
+		// A full batch goes straight on to the next.
+		if full {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(s.cfg.PollInterval):
+		}
+	}
+}
+
+func (s *Server) fail(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	log.Printf("Error serving gRPC: %v", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func change(c maintenance.Change, version int) (*pb.Change, error) {
+	env, err := c.Event()
+	if err != nil {
+		return nil, err
+	}
+	env, err = events.Convert(env, version)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Change{
+		Id:            c.ID,
+		EventType:     env.EventType,
+		SchemaVersion: int32(env.SchemaVersion),
+		Payload:       env.Payload,
+		Change:        ProtoChange(c),
+	}, nil
+}
+
+func (s *Server) GetOrderHistory(ctx context.Context, req *pb.GetOrderHistoryRequest) (*pb.OrderHistory, error) {
+	tenant := req.Tenant
+	if tenant == "" {
+		tenant = audit.DefaultTenant
+	}
+	ctx = audit.WithTenant(ctx, tenant)
+
+	o, err := s.orders.GetOrder(ctx, req.OrderId)
+	if errors.Is(err, store.ErrOrderNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no order %d for tenant %s", req.OrderId, tenant)
+	}
+	if err != nil {
+		return nil, s.fail(ctx, err)
+	}
+	entries, err := s.orders.AuditTrail(ctx, req.OrderId)
+	if err != nil {
+		return nil, s.fail(ctx, err)
+	}
+
+	h := &pb.OrderHistory{Order: ProtoOrder(o, tenant)}
+	for _, e := range entries {
+		h.Entries = append(h.Entries, ProtoAuditEntry(e))
+	}
+	return h, nil
+}
@@ -0,0 +1,299 @@
+package grpcapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"test/internal/audit"
+	"test/internal/events"
+	"test/internal/grpcapi/pb"
+	"test/internal/poller"
+	"test/internal/store"
+)
+
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	dsn := "file:" + filepath.Join(tb.TempDir(), "orders.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := store.Open(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	err = store.Migrate(db)
+	if err != nil {
+		tb.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// dial serves s on an in-memory listener and returns a client for it.
+func dial(t *testing.T, s *Server) pb.ChangeFeedClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := s.GRPCServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewChangeFeedClient(conn)
+}
+
+// changeOrder creates an order for product and changes its priority to
+// high, returning the change's id.
+func changeOrder(t *testing.T, ctx context.Context, db *sql.DB, orders *store.Store, product string) int64 {
+	t.Helper()
+	id, err := orders.CreateOrder(ctx, store.Order{
+		CustomerName: "Ada", ProductName: product, Quantity: 2, ShippingAddress: "1 Test Street", Priority: "low",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = orders.ChangePriority(ctx, store.PriorityChange{OrderID: id, Priority: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changeID int64
+	err = db.QueryRow(`SELECT MAX(id) FROM priority_changes WHERE order_id = ?`, id).Scan(&changeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changeID
+}
+
+// runPollers runs a poller filtered to ninja orders alongside an
+// unfiltered rule, so the filtered poller's offset stops at the last ninja
+// change while the rule processes the rest.
+func runPollers(t *testing.T, db *sql.DB) {
+	t.Helper()
+	err := poller.CreateRule(db, poller.Rule{
+		Config:  poller.Config{Name: "everything", Interval: poller.Duration{Duration: 100 * time.Millisecond}},
+		Enabled: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	sup := poller.NewSupervisor(db, []poller.Config{
+		{Name: "ninja", Product: "ninja", Interval: poller.Duration{Duration: 10 * time.Millisecond}},
+	}, nil)
+	go sup.Run(ctx, done)
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestStreamChangesFollowsTheFeedAndResumes(t *testing.T) {
+	db := openTestDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	orders := store.New(db, nil, nil)
+	defer orders.Close()
+	ids := []int64{
+		changeOrder(t, ctx, db, orders, "ninja"),
+		changeOrder(t, ctx, db, orders, "widget"),
+	}
+	runPollers(t, db)
+
+	client := dial(t, New(db, orders, Config{Keys: []string{"feed-key"}, PollInterval: 10 * time.Millisecond, Heartbeat: 50 * time.Millisecond}))
+
+	stream, err := client.StreamChanges(ctx, &pb.StreamChangesRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("stream without a key: %v, want Unauthenticated", err)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "feed-key")
+	stream, err = client.StreamChanges(ctx, &pb.StreamChangesRequest{SchemaVersion: events.V1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range ids {
+		msg, err := stream.Recv()
+		for err == nil && msg.GetHeartbeat() != nil {
+			msg, err = stream.Recv()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := msg.GetChange()
+		var p events.PayloadV1
+		if c == nil || json.Unmarshal(c.Payload, &p) != nil {
+			t.Fatalf("got %v, want change %d", msg, want)
+		}
+		if c.Id != want || c.SchemaVersion != events.V1 || p.ChangeID != want || p.Quantity != 2 {
+			t.Errorf("got change %d v%d %+v, want change %d in v1", c.Id, c.SchemaVersion, p, want)
+		}
+		if typed := c.GetChange(); typed.GetId() != want || typed.GetQuantity() != 2 || typed.GetPriority() != "high" {
+			t.Errorf("typed change = %v, want change %d to high priority", typed, want)
+		}
+	}
+
+	// With nothing left to process the stream idles at the newest change,
+	// past where the filtered poller's offset stopped.
+	msg, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hb := msg.GetHeartbeat(); hb == nil || hb.Position != ids[1] {
+		t.Fatalf("got %v, want a heartbeat at %d", msg, ids[1])
+	}
+	ids = append(ids, changeOrder(t, ctx, db, orders, "widget"))
+	for {
+		msg, err = stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.GetHeartbeat() == nil {
+			break
+		}
+	}
+	if c := msg.GetChange(); c == nil || c.Id != ids[2] {
+		t.Fatalf("got %v, want change %d once it was processed", msg, ids[2])
+	}
+
+	// Resuming from a position skips everything up to it.
+	stream, err = client.StreamChanges(ctx, &pb.StreamChangesRequest{AfterId: ids[1], EventTypes: []string{"priority.changed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := msg.GetChange(); c == nil || c.Id != ids[2] || c.SchemaVersion != events.Current {
+		t.Errorf("resumed stream sent %v, want change %d in the current version", msg, ids[2])
+	}
+}
+
+// TestStreamChangesSkipsUnselectedChanges runs the default pollers, which
+// only pick up ninja orders: changes to other orders are never processed
+// and mustn't hold the stream back.
+func TestStreamChangesSkipsUnselectedChanges(t *testing.T) {
+	db := openTestDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	orders := store.New(db, nil, nil)
+	defer orders.Close()
+	changeOrder(t, ctx, db, orders, "widget")
+	ninja := []int64{
+		changeOrder(t, ctx, db, orders, "ninja"),
+		changeOrder(t, ctx, db, orders, "ninja"),
+	}
+
+	configs := slices.Clone(poller.DefaultConfigs)
+	for i := range configs {
+		configs[i].Interval = poller.Duration{Duration: 10 * time.Millisecond}
+	}
+	pollCtx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go poller.NewSupervisor(db, configs, nil).Run(pollCtx, done)
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	client := dial(t, New(db, orders, Config{Keys: []string{"feed-key"}, PollInterval: 10 * time.Millisecond, Heartbeat: 50 * time.Millisecond}))
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "feed-key")
+	stream, err := client.StreamChanges(ctx, &pb.StreamChangesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() *pb.StreamChangesResponse {
+		t.Helper()
+		msg, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	for _, want := range ninja {
+		msg := next()
+		for msg.GetHeartbeat() != nil {
+			msg = next()
+		}
+		if c := msg.GetChange(); c == nil || c.Id != want {
+			t.Fatalf("got %v, want change %d", msg, want)
+		}
+	}
+
+	// A widget change after them is passed over once the ninja poller has
+	// looked past it.
+	widget := changeOrder(t, ctx, db, orders, "widget")
+	for {
+		msg := next()
+		if msg.GetChange() != nil {
+			t.Fatalf("got %v, want only heartbeats", msg)
+		}
+		if msg.GetHeartbeat().GetPosition() == widget {
+			break
+		}
+	}
+}
+
+func TestGetOrderHistory(t *testing.T) {
+	db := openTestDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	orders := store.New(db, nil, nil)
+	defer orders.Close()
+	id, err := orders.CreateOrder(ctx, store.Order{
+		CustomerName: "Ada", ProductName: "ninja", Quantity: 2, ShippingAddress: "1 Test Street", Priority: "low",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = orders.ChangePriority(ctx, store.PriorityChange{OrderID: id, Priority: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := dial(t, New(db, orders, Config{Keys: []string{"feed-key"}}))
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "feed-key")
+	h, err := client.GetOrderHistory(ctx, &pb.GetOrderHistoryRequest{OrderId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := h.GetOrder()
+	if o.GetId() != id || o.GetPriority() != "high" || o.GetTenant() != audit.DefaultTenant || !o.GetCreatedAt().IsValid() {
+		t.Errorf("order = %v, want order %d at high priority in the default tenant", o, id)
+	}
+	if len(h.Entries) != 1 || h.Entries[0].ChangeType != "priority.changed" || h.Entries[0].Priority != "high" {
+		t.Errorf("entries = %v, want the one priority change", h.Entries)
+	}
+
+	_, err = client.GetOrderHistory(ctx, &pb.GetOrderHistoryRequest{Tenant: "acme", OrderId: id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("another tenant's order: %v, want NotFound", err)
+	}
+}
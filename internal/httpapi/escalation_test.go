@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"test/internal/audit"
+	"test/internal/store"
+)
+
+func TestEscalationIsProcessed(t *testing.T) {
+	app := newTestApp(t)
+
+	order := app.postOrder(store.Order{Priority: "low"})
+	updated := app.escalate(order.ID, "high")
+	if updated.Priority != "high" {
+		t.Fatalf("priority after escalation = %q, want high", updated.Priority)
+	}
+
+	entries := app.awaitProcessed(order.ID)
+	if len(entries) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.ChangeType != audit.PriorityChanged || e.Priority != "high" {
+		t.Errorf("audit entry = %+v, want a priority.changed to high", e)
+	}
+
+	var offset int64
+	err := app.db.QueryRow(`SELECT last_processed_id FROM poller_offsets WHERE poller = 'test'`).Scan(&offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != e.ID {
+		t.Errorf("poller offset = %d, want %d", offset, e.ID)
+	}
+}
+
+func TestEscalationsAreProcessedInOrder(t *testing.T) {
+	app := newTestApp(t)
+
+	order := app.postOrder(store.Order{})
+	for _, p := range []string{"medium", "high", "low"} {
+		app.escalate(order.ID, p)
+	}
+
+	entries := app.awaitProcessed(order.ID)
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Priority)
+	}
+	want := []string{"medium", "high", "low"}
+	if len(got) != len(want) {
+		t.Fatalf("audit store.Priorities = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("audit store.Priorities = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestUnmatchedProductStaysUnprocessed(t *testing.T) {
+	app := newTestApp(t)
+
+	order := app.postOrder(store.Order{ProductName: "shoe"})
+	app.escalate(order.ID, "high")
+	app.settle()
+
+	entries := app.audit(order.ID)
+	if len(entries) != 1 || entries[0].Processed {
+		t.Fatalf("audit = %+v, want one unprocessed entry", entries)
+	}
+}
+
+func TestLegacyFormEscalation(t *testing.T) {
+	app := newTestApp(t)
+
+	resp, body := app.do(http.MethodPost, "/orders", url.Values{
+		"customerName":    {"Form Customer"},
+		"productName":     {"ninja"},
+		"quantity":        {"2"},
+		"shippingAddress": {"2 Form Road"},
+		"priority":        {"low"},
+	})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("legacy create: got %d: %s", resp.StatusCode, body)
+	}
+
+	var id int64
+	err := app.db.QueryRow(`SELECT MAX(id) FROM orders`).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body = app.do(http.MethodPatch, "/orders/priority", url.Values{
+		"id":       {strconv.FormatInt(id, 10)},
+		"priority": {"high"},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("legacy escalate: got %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Deprecation") == "" {
+		t.Error("legacy endpoint response has no Deprecation header")
+	}
+
+	entries := app.awaitProcessed(id)
+	if entries[len(entries)-1].Priority != "high" {
+		t.Errorf("last audit entry = %+v, want priority high", entries[len(entries)-1])
+	}
+}
+
+func TestCancelledOrderRejectsEscalation(t *testing.T) {
+	app := newTestApp(t)
+
+	order := app.postOrder(store.Order{})
+	var cancelled orderResponse
+	app.doJSON(http.MethodPost, "/v1/orders/"+strconv.FormatInt(order.ID, 10)+"/cancel", nil, http.StatusOK, &cancelled)
+	if _, ok := cancelled.Links["priority"]; ok {
+		t.Error("cancelled order still links to the priority action")
+	}
+
+	var p problem
+	app.doJSON(
+		http.MethodPatch,
+		"/v1/orders/"+strconv.FormatInt(order.ID, 10)+"/priority",
+		map[string]string{"priority": "high"},
+		http.StatusConflict,
+		&p,
+	)
+	if p.Code != "order_cancelled" {
+		t.Errorf("problem code = %q, want order_cancelled", p.Code)
+	}
+
+	entries := app.awaitProcessed(order.ID)
+	if len(entries) != 1 || entries[0].ChangeType != audit.OrderCancelled {
+		t.Fatalf("audit = %+v, want only the cancellation", entries)
+	}
+}
@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Fuzz targets drive the real router against a throwaway database. Any input
+// may be rejected, but never with a 500 or a response that leaks the driver.
+
+func newFuzzHandler(f *testing.F) http.Handler {
+	quietLogs(f)
+	return New(openTestDB(f), defaultTestSetup().api, nil).Handler()
+}
+
+func checkClientSafe(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code >= 500 {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(strings.ToLower(rec.Body.String()), "sql") {
+		t.Fatalf("response leaks database details: %s", rec.Body)
+	}
+}
+
+func FuzzCreateOrderJSON(f *testing.F) {
+	f.Add(`{"customerName":"Ada","productName":"ninja","quantity":3,"shippingAddress":"1 Main St","priority":"low"}`)
+	f.Add(`{"customerName":"Ada","productName":"ninja","quantity":-1,"shippingAddress":"","priority":"URGENT"}`)
+	f.Add(`{"quantity":1e30}`)
+	f.Add(`{"customerName":"x","tag":"` + strings.Repeat("t", 100) + `"}`)
+	f.Add(`{"customerName":"'; DROP TABLE orders; --","productName":"ninja","quantity":1,"shippingAddress":"a","priority":"high"}`)
+	f.Add(`[1,2,3]`)
+	f.Add(`{"id":5,"status":"cancelled"}`)
+
+	h := newFuzzHandler(f)
+	f.Fuzz(func(t *testing.T, body string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/orders", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		checkClientSafe(t, rec)
+	})
+}
+
+func FuzzCreateOrderForm(f *testing.F) {
+	f.Add("customerName=Ada&productName=ninja&quantity=2&shippingAddress=1+Main+St&priority=low")
+	f.Add("quantity=99999999999999999999&priority=%00")
+	f.Add("customerName=%ff%fe&productName=&quantity=+7+")
+	f.Add("%zz=1&&&=")
+	f.Add("priority=High&priority=low&quantity=1")
+
+	h := newFuzzHandler(f)
+	f.Fuzz(func(t *testing.T, body string) {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		checkClientSafe(t, rec)
+	})
+}
+
+func FuzzOrderPriority(f *testing.F) {
+	f.Add("1", `{"priority":"high"}`)
+	f.Add("0", `{"priority":""}`)
+	f.Add("-3", `{"priority":"HIGH "}`)
+	f.Add("9223372036854775808", `{"priority":"low"}`)
+	f.Add("1", `{"priority":7}`)
+	f.Add("abc", `{}`)
+
+	h := newFuzzHandler(f)
+
+	// one order to escalate so valid ids reach the store
+	seed := httptest.NewRequest(http.MethodPost, "/v1/orders", strings.NewReader(
+		`{"customerName":"Ada","productName":"ninja","quantity":1,"shippingAddress":"1 Main St","priority":"low"}`,
+	))
+	h.ServeHTTP(httptest.NewRecorder(), seed)
+
+	f.Fuzz(func(t *testing.T, id, body string) {
+		path := "/v1/orders/" + url.PathEscape(id) + "/priority"
+		req := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		checkClientSafe(t, rec)
+
+		form := url.Values{"id": {id}, "priority": {body}}.Encode()
+		req = httptest.NewRequest(http.MethodPatch, "/orders/priority", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		checkClientSafe(t, rec)
+	})
+}
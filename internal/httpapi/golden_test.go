@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"test/internal/store"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file when the
+// test runs with -update. Golden files pin wire formats downstream consumers
+// depend on; a diff here is a breaking change until proven otherwise.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.MkdirAll("testdata", 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, got, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed.\n got: %s\nwant: %s", path, got, want)
+	}
+}
+
+// pinTimestamps makes stored timestamps deterministic for golden output.
+func pinTimestamps(t *testing.T, a *testApp) {
+	t.Helper()
+	for _, stmt := range []string{
+		`UPDATE orders SET created_at = '2024-01-02 03:04:05'`,
+		`UPDATE priority_changes SET created_at = '2024-01-02 03:04:05'`,
+	} {
+		_, err := a.db.Exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGoldenOrderAndAudit(t *testing.T) {
+	app := newTestApp(t, withoutPollers)
+
+	order := app.postOrder(store.Order{
+		CustomerName:    "Ada Lovelace",
+		Quantity:        3,
+		ShippingAddress: "12 Analytical Row",
+		Tag:             "vip",
+	})
+	app.escalate(order.ID, "medium")
+	app.escalate(order.ID, "high")
+	_, err := app.db.Exec(`UPDATE priority_changes SET processed = TRUE WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinTimestamps(t, app)
+
+	_, body := app.do(http.MethodGet, "/v1/orders/1", nil)
+	checkGolden(t, "order.json.golden", body)
+
+	_, body = app.do(http.MethodGet, "/v1/orders/1/audit", nil)
+	checkGolden(t, "audit.json.golden", body)
+
+	app.doJSON(http.MethodPost, "/v1/orders/1/cancel", nil, http.StatusOK, nil)
+	pinTimestamps(t, app)
+
+	_, body = app.do(http.MethodGet, "/v1/orders/1", nil)
+	checkGolden(t, "order_cancelled.json.golden", body)
+
+	_, body = app.do(http.MethodGet, "/v1/orders/1/audit", nil)
+	checkGolden(t, "audit_cancelled.json.golden", body)
+
+	// Undoing the cancellation and the escalation to high.
+	_, body = app.do(http.MethodGet, "/v1/orders/1/audit/1/state", nil)
+	checkGolden(t, "order_state_at.json.golden", body)
+}
+
+func TestGoldenProblems(t *testing.T) {
+	app := newTestApp(t, withoutPollers)
+
+	_, body := app.do(http.MethodGet, "/v1/orders/42", nil)
+	checkGolden(t, "problem_not_found.json.golden", body)
+
+	_, body = app.do(http.MethodPost, "/v1/orders", map[string]any{
+		"customerName": "",
+		"quantity":     0,
+		"priority":     "urgent",
+	})
+	checkGolden(t, "problem_validation.json.golden", body)
+}
@@ -0,0 +1,616 @@
+// Package httpapi serves the order API: the legacy form endpoints, the
+// versioned JSON API under /v1 and the operational endpoints under /admin.
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"test/api"
+	"test/internal/oidc"
+	"test/internal/poller"
+	"test/internal/redact"
+	"test/internal/store"
+)
+
+type Config struct {
+	MinQuantity int
+	MaxQuantity int
+	APIKeys     []string
+	AdminKeys   []string
+	RateLimit   float64
+	RateBurst   int
+	CORSOrigins []string
+
+	// StaticDir holds the page served at /; empty means "static".
+	StaticDir string
+
+	// Cipher, when set, encrypts customer names and shipping addresses
+	// at rest.
+	Cipher *store.FieldCipher
+
+	// LogRedaction controls how customer fields appear in logs; nil
+	// means redact.Default.
+	LogRedaction redact.Policy
+
+	// BackupDir receives snapshots taken through /admin/backup; empty
+	// means "backups".
+	BackupDir string
+
+	// StaleAfter is how long a poller may go without a heartbeat before
+	// /admin/heartbeats reports it stale; 0 means 2 minutes.
+	StaleAfter time.Duration
+
+	// SeparateAdmin takes /admin off Handler; serve AdminHandler on a
+	// listener of its own instead.
+	SeparateAdmin bool
+
+	// AdminUsers, user to password, turns on basic auth for AdminHandler
+	// and signing in to /admin from a browser at /login.
+	AdminUsers map[string]string
+
+	// SessionTTL is how long a /login session lasts; 0 means 12 hours.
+	SessionTTL time.Duration
+
+	// OIDC, when set, lets people sign in at /login through the provider;
+	// only members of one of OIDCAdminGroups get a session.
+	OIDC            *oidc.Provider
+	OIDCAdminGroups []string
+
+	// IPRules restrict route groups to client address ranges, by group;
+	// see ParseIPRules.
+	IPRules map[string]IPRules
+
+	// KeyTenants maps API keys to the tenant whose orders they see and
+	// write. Keys not in it, the legacy form endpoints and /v1 without
+	// APIKeys all act for audit.DefaultTenant.
+	KeyTenants map[string]string
+
+	// Quotas limit tenants and API keys by subject, "tenant:<name>" or
+	// "key:<fingerprint>"; see ParseQuotas.
+	Quotas map[string]Quota
+
+	// CustomerOrders limits the orders each customer may place.
+	CustomerOrders CustomerOrderLimit
+
+	// WebhookTimeout bounds test deliveries to webhook subscriptions; 0
+	// means 10 seconds.
+	WebhookTimeout time.Duration
+}
+
+type Server struct {
+	db     *sql.DB
+	orders *store.Store
+
+	// readDB serves the list and lookup endpoints; it is db unless
+	// SetReadDB gave a separate pool.
+	readDB *sql.DB
+
+	cfg    Config
+	sup    *poller.Supervisor
+	router *router
+}
+
+// New wires the API to its database and to the supervisor the /admin
+// endpoints control.
+func New(db *sql.DB, cfg Config, sup *poller.Supervisor) *Server {
+	if cfg.StaticDir == "" {
+		cfg.StaticDir = "static"
+	}
+	if cfg.BackupDir == "" {
+		cfg.BackupDir = "backups"
+	}
+	if cfg.StaleAfter == 0 {
+		cfg.StaleAfter = 2 * time.Minute
+	}
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = 12 * time.Hour
+	}
+	return &Server{db: db, orders: store.New(db, nil, cfg.Cipher), readDB: db, cfg: cfg, sup: sup}
+}
+
+// SetReadDB routes GET requests for orders, audit trails and the admin
+// listings to read, a replica or read-only connection. Inserts, updates and
+// the poller stay on the writer. Call it before Handler.
+func (s *Server) SetReadDB(read *sql.DB) {
+	if read == nil {
+		read = s.db
+	}
+	s.orders.Close()
+	s.orders = store.New(s.db, read, s.cfg.Cipher)
+	s.readDB = read
+}
+
+// ordersFor returns the store a request should use: GETs may be served from
+// the read pool, anything else goes to the writer so it sees its own writes.
+func (s *Server) ordersFor(r *http.Request) *store.Store {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return s.orders.ReadOnly()
+	}
+	return s.orders
+}
+
+// Close releases the statements prepared for the order endpoints.
+func (s *Server) Close() error {
+	return s.orders.Close()
+}
+
+const (
+	routeOrders        = "orders"
+	routeOrder         = "order"
+	routeOrderAudit    = "order.audit"
+	routeOrderCancel   = "order.cancel"
+	routeOrderPriority = "order.priority"
+)
+
+// Handler builds the routing table with its middleware.
+func (s *Server) Handler() http.Handler {
+	rt := newRouter()
+	rt.Handle("GET /{$}", withCSRFCookie(http.FileServer(http.Dir(s.cfg.StaticDir))))
+
+	var limiter *rateLimiter
+	if s.cfg.RateLimit > 0 {
+		limiter = newRateLimiter(s.cfg.RateLimit, s.cfg.RateBurst)
+	}
+
+	// The form endpoints the static page posts to stay at their original
+	// paths; JSON clients should move to /v1. See csrfProtect for how
+	// posts from the page are told apart from forged ones.
+	legacy := newRouteGroup(rt, "", 0)
+	legacy.successor = "/v1"
+	quotaRate := subjectRateLimit(s.cfg.Quotas, s.cfg.RateBurst)
+	legacy.use(ipFilter(GroupLegacy, s.cfg.IPRules[GroupLegacy]), rateLimit(limiter), quotaRate, csrfProtect)
+	legacy.handle(http.MethodPost, "/orders", s.withQuota(QuotaOrders, s.handleLegacyCreateOrder))
+	legacy.handle(http.MethodPatch, "/orders/priority", s.withQuota(QuotaEscalations, s.handleLegacyChangePriority))
+
+	v1 := newRouteGroup(rt, "/v1", 1)
+	v1.use(ipFilter(GroupV1, s.cfg.IPRules[GroupV1]), cors(s.cfg.CORSOrigins), rateLimit(limiter), requireAPIKey(s.cfg.APIKeys, s.cfg.KeyTenants), quotaRate)
+	v1.handlePreflight()
+	v1.handleNamed(routeOrders, http.MethodPost, "/orders", s.idempotent(s.withQuota(QuotaOrders, s.handleCreateOrderV1)))
+	v1.handle(http.MethodGet, "/orders", s.handleListOrdersV1)
+	v1.handle(http.MethodPatch, "/orders/priority", s.idempotent(s.withQuota(QuotaEscalations, s.handleChangePriorityV1)))
+	v1.handleNamed(routeOrder, http.MethodGet, "/orders/{id}", s.handleGetOrderV1)
+	v1.handleNamed(routeOrderAudit, http.MethodGet, "/orders/{id}/audit", s.handleOrderAuditV1)
+	v1.handle(http.MethodGet, "/orders/{id}/audit/{changeId}/state", s.handleOrderStateAtV1)
+	v1.handleNamed(routeOrderCancel, http.MethodPost, "/orders/{id}/cancel", s.idempotent(s.handleCancelOrderV1))
+	v1.handleNamed(routeOrderPriority, http.MethodPatch, "/orders/{id}/priority", s.idempotent(s.withQuota(QuotaEscalations, s.handleOrderPriorityV1)))
+
+	if !s.cfg.SeparateAdmin {
+		s.adminRoutes(rt, s.sessionAuth(adminOnly(s.cfg.AdminKeys)))
+		s.sessionRoutes(rt)
+	}
+
+	s.router = rt
+	return chain(rt, withRequestID, withTrace, withAuditDB(s.db), logRequests, recoverPanics)
+}
+
+// AdminHandler serves /admin and the pprof profiles under /debug/pprof for
+// a listener of their own; Handler leaves /admin out when
+// Config.SeparateAdmin is set. See adminAuth for who gets in.
+func (s *Server) AdminHandler() http.Handler {
+	rt := newRouter()
+	guard := s.sessionAuth(adminAuth(s.cfg.AdminKeys, s.cfg.AdminUsers))
+	s.adminRoutes(rt, guard)
+	s.sessionRoutes(rt)
+
+	debug := newRouteGroup(rt, "/debug/pprof", 0)
+	debug.use(ipFilter(GroupAdmin, s.cfg.IPRules[GroupAdmin]), guard)
+	debug.handle(http.MethodGet, "/", pprof.Index)
+	debug.handle(http.MethodGet, "/cmdline", pprof.Cmdline)
+	debug.handle(http.MethodGet, "/profile", pprof.Profile)
+	debug.handle(http.MethodGet, "/symbol", pprof.Symbol)
+	debug.handle(http.MethodPost, "/symbol", pprof.Symbol)
+	debug.handle(http.MethodGet, "/trace", pprof.Trace)
+
+	return chain(rt, withRequestID, withTrace, withAuditDB(s.db), logRequests, recoverPanics)
+}
+
+func (s *Server) adminRoutes(rt *router, guard middleware) {
+	admin := newRouteGroup(rt, "/admin", 0)
+	admin.use(ipFilter(GroupAdmin, s.cfg.IPRules[GroupAdmin]), guard)
+	admin.handle(http.MethodGet, "/pollers", s.handleListPollers)
+	admin.handle(http.MethodPost, "/pollers", s.handleCreatePoller)
+	admin.handle(http.MethodGet, "/pollers/{name}", s.handleGetPoller)
+	admin.handle(http.MethodPut, "/pollers/{name}", s.handleUpdatePoller)
+	admin.handle(http.MethodDelete, "/pollers/{name}", s.handleDeletePoller)
+	admin.handle(http.MethodGet, "/dry-run", s.handleGetDryRun)
+	admin.handle(http.MethodPut, "/dry-run", s.handleSetDryRun)
+	admin.handle(http.MethodGet, "/offsets", s.handleListOffsets)
+	admin.handle(http.MethodGet, "/heartbeats", s.handleListHeartbeats)
+	admin.handle(http.MethodGet, "/changes", s.handleListChanges)
+	admin.handle(http.MethodPost, "/changes/{id}/requeue", s.handleRequeueChange)
+	admin.handle(http.MethodGet, "/quarantine", s.handleListQuarantine)
+	admin.handle(http.MethodGet, "/breakers", s.handleListBreakers)
+	admin.handle(http.MethodGet, "/leases", s.handleListLeases)
+	admin.handle(http.MethodGet, "/webhooks/subscriptions", s.handleListWebhookSubscriptions)
+	admin.handle(http.MethodPost, "/webhooks/subscriptions", s.handleCreateWebhookSubscription)
+	admin.handle(http.MethodGet, "/webhooks/subscriptions/{id}", s.handleGetWebhookSubscription)
+	admin.handle(http.MethodPut, "/webhooks/subscriptions/{id}", s.handleUpdateWebhookSubscription)
+	admin.handle(http.MethodDelete, "/webhooks/subscriptions/{id}", s.handleDeleteWebhookSubscription)
+	admin.handle(http.MethodPost, "/webhooks/subscriptions/{id}/test", s.handleTestWebhookSubscription)
+	admin.handle(http.MethodGet, "/webhooks/deliveries", s.handleListWebhookDeliveries)
+	admin.handle(http.MethodGet, "/webhooks/deliveries/{id}", s.handleGetWebhookDelivery)
+	admin.handle(http.MethodPost, "/webhooks/deliveries/{id}/retry", s.handleRetryWebhookDelivery)
+	admin.handle(http.MethodPost, "/retention", s.handleRetention)
+	admin.handle(http.MethodGet, "/retention/runs", s.handleListRetentionRuns)
+	admin.handle(http.MethodGet, "/maintenance/runs", s.handleListMaintenanceRuns)
+	admin.handle(http.MethodPost, "/maintenance/runs", s.handleRunMaintenance)
+	admin.handle(http.MethodPost, "/erasures", s.handleEraseCustomer)
+	admin.handle(http.MethodGet, "/audit", s.handleQueryAudit)
+	admin.handle(http.MethodGet, "/stats", s.handleStats)
+	admin.handle(http.MethodGet, "/audit/verify", s.handleVerifyAudit)
+	admin.handle(http.MethodGet, "/rejected-requests", s.handleListRejectedRequests)
+	admin.handle(http.MethodGet, "/security-events", s.handleListSecurityEvents)
+	admin.handle(http.MethodPost, "/backup", s.handleBackup)
+	admin.handle(http.MethodGet, "/backups", s.handleListBackups)
+	admin.handle(http.MethodGet, "/metrics", expvar.Handler().ServeHTTP)
+}
+
+type link = api.Link
+
+type orderResponse = api.OrderResponse
+
+// orderLinks only offers the state-changing actions an order still allows.
+func (s *Server) orderLinks(o store.Order) map[string]link {
+	id := strconv.FormatInt(o.ID, 10)
+	links := map[string]link{
+		"self":  {Href: s.router.url(routeOrder, "id", id), Method: http.MethodGet},
+		"audit": {Href: s.router.url(routeOrderAudit, "id", id), Method: http.MethodGet},
+	}
+	if o.Status == store.OrderStatusOpen {
+		links["priority"] = link{
+			Href:   s.router.url(routeOrderPriority, "id", id),
+			Method: http.MethodPatch,
+		}
+		links["cancel"] = link{
+			Href:   s.router.url(routeOrderCancel, "id", id),
+			Method: http.MethodPost,
+		}
+	}
+	return links
+}
+
+func (s *Server) writeOrder(w http.ResponseWriter, status int, o store.Order) {
+	writeJSON(w, status, orderResponse{Order: o, Links: s.orderLinks(o)})
+}
+
+// orderFromPath loads the order named by the {id} wildcard, writing a 404 or
+// 500 itself when it can't.
+func (s *Server) orderFromPath(w http.ResponseWriter, r *http.Request) (store.Order, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		writeOrderNotFound(w, r, r.PathValue("id"))
+		return store.Order{}, false
+	}
+
+	o, err := s.ordersFor(r).GetOrder(r.Context(), id)
+	if errors.Is(err, store.ErrOrderNotFound) {
+		writeOrderNotFound(w, r, r.PathValue("id"))
+		return store.Order{}, false
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return store.Order{}, false
+	}
+	return o, true
+}
+
+func writeOrderNotFound(w http.ResponseWriter, r *http.Request, id string) {
+	writeProblem(w, r, newProblem(
+		http.StatusNotFound,
+		"order_not_found",
+		"no order with id "+id,
+	))
+}
+
+func writeOrderCancelled(w http.ResponseWriter, r *http.Request, id int64) {
+	writeProblem(w, r, newProblem(
+		http.StatusConflict,
+		"order_cancelled",
+		"order "+strconv.FormatInt(id, 10)+" is cancelled",
+	))
+}
+
+func (s *Server) handleLegacyCreateOrder(w http.ResponseWriter, r *http.Request) {
+	if !parseRequestForm(w, r) {
+		return
+	}
+
+	order, errs := parseOrderForm(r, s.cfg)
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	_, ok := s.insertOrder(w, r, order)
+	if !ok {
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *Server) handleLegacyChangePriority(w http.ResponseWriter, r *http.Request) {
+	if !parseRequestForm(w, r) {
+		return
+	}
+
+	change, errs := parsePriorityChangeForm(r)
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	if !s.applyPriorityChange(w, r, change) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleCreateOrderV1(w http.ResponseWriter, r *http.Request) {
+	var order store.Order
+	if !decodeJSONBody(w, r, &order) {
+		return
+	}
+
+	var errs validationErrors
+	validateOrder(&errs, &order, s.cfg)
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	id, ok := s.insertOrder(w, r, order)
+	if !ok {
+		return
+	}
+
+	created, err := s.orders.GetOrder(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", s.router.url(routeOrder, "id", strconv.FormatInt(id, 10)))
+	s.writeOrder(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleChangePriorityV1(w http.ResponseWriter, r *http.Request) {
+	var change store.PriorityChange
+	if !decodeJSONBody(w, r, &change) {
+		return
+	}
+
+	var errs validationErrors
+	validatePriorityChange(&errs, &change, "orderId")
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	if !s.applyPriorityChange(w, r, change) {
+		return
+	}
+
+	updated, err := s.orders.GetOrder(r.Context(), change.OrderID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	s.writeOrder(w, http.StatusOK, updated)
+}
+
+// handleOrderPriorityV1 is the per-order form of handleChangePriorityV1: the
+// order comes from the path and the body carries only the new priority.
+func (s *Server) handleOrderPriorityV1(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.orderFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Priority string `json:"priority"`
+	}
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+
+	change := store.PriorityChange{OrderID: o.ID, Priority: body.Priority}
+	var errs validationErrors
+	validatePriorityChange(&errs, &change, "id")
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	if !s.applyPriorityChange(w, r, change) {
+		return
+	}
+
+	updated, err := s.orders.GetOrder(r.Context(), change.OrderID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	s.writeOrder(w, http.StatusOK, updated)
+}
+
+// handleListOrdersV1 pages through the caller's orders by id; the "next"
+// link carries the cursor.
+func (s *Server) handleListOrdersV1(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs validationErrors
+	after := parseIDParam(&errs, q, "after")
+	limit := defaultChangeListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxChangeListLimit {
+			errs.add("limit", "range", "invalid_limit", "limit must be between 1 and 1000")
+		}
+		limit = n
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	orders, err := s.ordersFor(r).ListOrders(r.Context(), after, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	list := api.OrderList{
+		Orders: make([]orderResponse, 0, len(orders)),
+		Links:  map[string]link{"self": {Href: r.URL.RequestURI(), Method: http.MethodGet}},
+	}
+	for _, o := range orders {
+		list.Orders = append(list.Orders, orderResponse{Order: o, Links: s.orderLinks(o)})
+	}
+	if len(orders) == limit {
+		q.Set("after", strconv.FormatInt(orders[len(orders)-1].ID, 10))
+		list.Links["next"] = link{Href: r.URL.Path + "?" + q.Encode(), Method: http.MethodGet}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleGetOrderV1(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.orderFromPath(w, r)
+	if !ok {
+		return
+	}
+	s.writeOrder(w, http.StatusOK, o)
+}
+
+func (s *Server) handleOrderAuditV1(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.orderFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := s.ordersFor(r).AuditTrail(r.Context(), o.ID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, api.AuditTrail{
+		Entries: entries,
+		Links: map[string]link{
+			"self":  {Href: r.URL.Path, Method: http.MethodGet},
+			"order": {Href: s.router.url(routeOrder, "id", strconv.FormatInt(o.ID, 10)), Method: http.MethodGet},
+		},
+	})
+}
+
+// handleOrderStateAtV1 shows an order as it was right after one of the
+// changes in its audit trail.
+func (s *Server) handleOrderStateAtV1(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.orderFromPath(w, r)
+	if !ok {
+		return
+	}
+	changeID, err := strconv.ParseInt(r.PathValue("changeId"), 10, 64)
+	if err != nil || changeID < 1 {
+		writeChangeNotFound(w, r, r.PathValue("changeId"))
+		return
+	}
+
+	at, err := s.ordersFor(r).OrderAt(r.Context(), o.ID, changeID)
+	if errors.Is(err, store.ErrChangeNotFound) {
+		writeChangeNotFound(w, r, r.PathValue("changeId"))
+		return
+	}
+	if errors.Is(err, store.ErrHistoryIncomplete) {
+		writeProblem(w, r, newProblem(
+			http.StatusConflict,
+			"history_incomplete",
+			"order "+strconv.FormatInt(o.ID, 10)+" has changes after "+r.PathValue("changeId")+" recorded without patches",
+		))
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, api.OrderState{
+		ChangeID: changeID,
+		Order:    at,
+		Links: map[string]link{
+			"self":  {Href: r.URL.Path, Method: http.MethodGet},
+			"audit": {Href: s.router.url(routeOrderAudit, "id", strconv.FormatInt(o.ID, 10)), Method: http.MethodGet},
+		},
+	})
+}
+
+func (s *Server) handleCancelOrderV1(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.orderFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.orders.CancelOrder(r.Context(), o.ID)
+	if errors.Is(err, store.ErrOrderCancelled) {
+		writeOrderCancelled(w, r, o.ID)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	log.Printf("Cancelled order #%d", o.ID)
+
+	o.Status = store.OrderStatusCancelled
+	s.writeOrder(w, http.StatusOK, o)
+}
+
+func (s *Server) insertOrder(w http.ResponseWriter, r *http.Request, order store.Order) (int64, bool) {
+	release, ok := s.checkCustomerLimit(w, r, order)
+	if !ok {
+		return 0, false
+	}
+	id, err := s.orders.CreateOrder(r.Context(), order)
+	if err != nil {
+		release()
+		writeInternalError(w, r, err)
+		return 0, false
+	}
+
+	log.Printf(
+		"Inserted order #%d with quantity: %d, customer name: %s, product name: %s, shipping address: %s, priority: %s",
+		id,
+		order.Quantity,
+		s.cfg.LogRedaction.Value("customerName", order.CustomerName),
+		order.ProductName,
+		s.cfg.LogRedaction.Value("shippingAddress", order.ShippingAddress),
+		order.Priority,
+	)
+	return id, true
+}
+
+func (s *Server) applyPriorityChange(w http.ResponseWriter, r *http.Request, change store.PriorityChange) bool {
+	err := s.orders.ChangePriority(r.Context(), change)
+	if errors.Is(err, store.ErrOrderNotFound) {
+		writeOrderNotFound(w, r, strconv.FormatInt(change.OrderID, 10))
+		return false
+	}
+	if errors.Is(err, store.ErrOrderCancelled) {
+		writeOrderCancelled(w, r, change.OrderID)
+		return false
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return false
+	}
+
+	log.Printf(
+		"Updated order #%d priority to %s and logged change",
+		change.OrderID,
+		change.Priority,
+	)
+	return true
+}
@@ -0,0 +1,277 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/poller"
+	"test/internal/store"
+)
+
+const (
+	testPollInterval = 100 * time.Millisecond
+	testAwaitTimeout = 5 * time.Second
+)
+
+// testApp is the whole server, HTTP handlers plus supervised pollers, on a
+// throwaway SQLite database. Everything is torn down by t.Cleanup.
+type testApp struct {
+	t   *testing.T
+	db  *sql.DB
+	sup *poller.Supervisor
+	srv *httptest.Server
+
+	// key, when set, is sent as X-API-Key on every request.
+	key string
+
+	// header is added to every request.
+	header http.Header
+}
+
+// testSetup is what newTestApp starts from: the server's usual quantity
+// limits and one fast poller for ninja orders. Options adjust it.
+type testSetup struct {
+	api     Config
+	pollers []poller.Config
+}
+
+func defaultTestSetup() testSetup {
+	return testSetup{
+		api: Config{
+			MinQuantity: 1,
+			MaxQuantity: 1000,
+			RateBurst:   20,
+		},
+		pollers: []poller.Config{{
+			Name:     "test",
+			Product:  "ninja",
+			Interval: poller.Duration{Duration: testPollInterval},
+			Handler:  "log",
+		}},
+	}
+}
+
+// withoutPollers leaves processed flags exactly as the API wrote them.
+func withoutPollers(s *testSetup) {
+	s.pollers = nil
+}
+
+func newTestApp(t *testing.T, opts ...func(*testSetup)) *testApp {
+	t.Helper()
+
+	setup := defaultTestSetup()
+	for _, opt := range opts {
+		opt(&setup)
+	}
+	err := poller.ValidateAll(setup.pollers)
+	if err != nil {
+		t.Fatalf("invalid test pollers: %v", err)
+	}
+
+	db := openTestDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	sup := poller.NewSupervisor(db, setup.pollers, nil)
+	go sup.Run(ctx, done)
+
+	srv := httptest.NewServer(New(db, setup.api, sup).Handler())
+
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		<-done
+	})
+	return &testApp{t: t, db: db, sup: sup, srv: srv}
+}
+
+// openTestDB returns a migrated database in a temp dir, closed on cleanup.
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	// A file rather than :memory: so the pool's connections share one
+	// database. Immediate transactions plus the busy timeout let HTTP writes
+	// wait for a poll cycle instead of failing a read-to-write lock upgrade.
+	dsn := "file:" + filepath.Join(tb.TempDir(), "orders.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := store.Open(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	err = store.Migrate(db)
+	if err != nil {
+		tb.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// do sends body as JSON, or as a form when it is url.Values, and returns the
+// response with its body already read.
+func (a *testApp) do(method, path string, body any) (*http.Response, []byte) {
+	a.t.Helper()
+
+	var rd io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case url.Values:
+		rd = strings.NewReader(b.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		rd = bytes.NewReader(buf)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, a.srv.URL+path, rd)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if a.key != "" {
+		req.Header.Set("X-API-Key", a.key)
+	}
+	for name, values := range a.header {
+		req.Header[name] = values
+	}
+
+	// Redirects from the legacy form endpoints are part of what tests check.
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return resp, data
+}
+
+// doJSON is do plus a status check and decoding the body into out.
+func (a *testApp) doJSON(method, path string, body any, wantStatus int, out any) {
+	a.t.Helper()
+
+	resp, data := a.do(method, path, body)
+	if resp.StatusCode != wantStatus {
+		a.t.Fatalf("%s %s: got %d, want %d: %s", method, path, resp.StatusCode, wantStatus, data)
+	}
+	if out == nil {
+		return
+	}
+	err := json.Unmarshal(data, out)
+	if err != nil {
+		a.t.Fatalf("%s %s: decoding %s: %v", method, path, data, err)
+	}
+}
+
+// postOrder creates an order through /v1, filling in whatever o leaves empty.
+func (a *testApp) postOrder(o store.Order) orderResponse {
+	a.t.Helper()
+
+	if o.CustomerName == "" {
+		o.CustomerName = "Test Customer"
+	}
+	if o.ProductName == "" {
+		o.ProductName = "ninja"
+	}
+	if o.Quantity == 0 {
+		o.Quantity = 1
+	}
+	if o.ShippingAddress == "" {
+		o.ShippingAddress = "1 Test Street"
+	}
+	if o.Priority == "" {
+		o.Priority = "low"
+	}
+
+	var created orderResponse
+	a.doJSON(http.MethodPost, "/v1/orders", o, http.StatusCreated, &created)
+	return created
+}
+
+func (a *testApp) escalate(id int64, priority string) orderResponse {
+	a.t.Helper()
+
+	var updated orderResponse
+	a.doJSON(
+		http.MethodPatch,
+		fmt.Sprintf("/v1/orders/%d/priority", id),
+		map[string]string{"priority": priority},
+		http.StatusOK,
+		&updated,
+	)
+	return updated
+}
+
+func (a *testApp) audit(id int64) []audit.Entry {
+	a.t.Helper()
+
+	var body struct {
+		Entries []audit.Entry `json:"entries"`
+	}
+	a.doJSON(http.MethodGet, fmt.Sprintf("/v1/orders/%d/audit", id), nil, http.StatusOK, &body)
+	return body.Entries
+}
+
+// awaitProcessed waits until every change recorded for the order is marked
+// processed and returns the final audit trail.
+func (a *testApp) awaitProcessed(id int64) []audit.Entry {
+	a.t.Helper()
+
+	deadline := time.Now().Add(testAwaitTimeout)
+	for {
+		entries := a.audit(id)
+		pending := 0
+		for _, e := range entries {
+			if !e.Processed {
+				pending++
+			}
+		}
+		if len(entries) > 0 && pending == 0 {
+			return entries
+		}
+		if time.Now().After(deadline) {
+			a.t.Fatalf("order %d: %d of %d changes still unprocessed after %s", id, pending, len(entries), testAwaitTimeout)
+		}
+		time.Sleep(testPollInterval / 2)
+	}
+}
+
+// quietLogs silences per-request logging until tb is done.
+func quietLogs(tb testing.TB) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(out) })
+}
+
+// settle waits long enough for every poller to have run a few cycles, for
+// tests asserting that something is NOT processed.
+func (a *testApp) settle() {
+	time.Sleep(4 * testPollInterval)
+}
@@ -0,0 +1,194 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/idempotency"
+	"test/internal/store"
+)
+
+const (
+	// idempotencyKeyTTL is how long a key's response is kept for
+	// replaying; retries come well within it.
+	idempotencyKeyTTL = 24 * time.Hour
+
+	maxIdempotencyKeyLength = 255
+)
+
+// bodyRecorder keeps a copy of the response for storing under an
+// idempotency key.
+type bodyRecorder struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (b *bodyRecorder) Write(p []byte) (int, error) {
+	b.body.Write(p)
+	return b.statusRecorder.Write(p)
+}
+
+// idempotent lets clients retry h safely: the first request with an
+// Idempotency-Key header runs h, and later ones with the same key from the
+// same caller get its response replayed with Idempotent-Replayed: true.
+// Reusing a key for a different request is refused, as is a retry while
+// the first is still running. Replays are recorded in request_audit.
+// Server errors and 429s aren't kept, so
+// their retries run afresh. A success is replayed with the order as it is
+// now, so customer data is never stored with the key and erasures reach
+// replays too.
+func (s *Server) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			h(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(w, r, newProblem(
+				http.StatusBadRequest,
+				"invalid_idempotency_key",
+				"Idempotency-Key must be at most 255 characters",
+			))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFormBodyBytes))
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeProblem(w, r, newProblem(
+				http.StatusRequestEntityTooLarge,
+				"request_too_large",
+				"request body exceeds the allowed size",
+			))
+			return
+		}
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+		hash := hex.EncodeToString(sum[:])
+
+		subject := audit.TenantFrom(r.Context()) + "/" + audit.ActorFrom(r.Context())
+		held, claimed, err := idempotency.Claim(r.Context(), s.db, subject, key, hash, idempotencyKeyTTL)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if !claimed {
+			s.replayIdempotent(w, r, held, hash)
+			return
+		}
+
+		// Unless the response is kept, a panic or an error included, the
+		// key is freed for the retry.
+		kept := false
+		defer func() {
+			if !kept {
+				s.releaseIdempotencyKey(r, subject, key)
+			}
+		}()
+		rec := &bodyRecorder{statusRecorder: statusRecorder{ResponseWriter: w}}
+		h(rec, r)
+		if rec.status == 0 || rec.status >= 500 || rec.status == http.StatusTooManyRequests {
+			return
+		}
+
+		resp := idempotency.Response{
+			Status:      rec.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Location:    w.Header().Get("Location"),
+		}
+		if rec.status < 300 {
+			// Successes are orders, replayed from the id alone.
+			var o orderResponse
+			err = json.Unmarshal(rec.body.Bytes(), &o)
+			if err != nil || o.ID == 0 {
+				return
+			}
+			resp.OrderID = o.ID
+		} else {
+			resp.Body = rec.body.Bytes()
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+		err = idempotency.Save(ctx, s.db, subject, key, resp)
+		if err != nil {
+			log.Printf("Error saving response for idempotency key [req %s]: %v", requestIDFrom(r.Context()), err)
+			return
+		}
+		kept = true
+	}
+}
+
+func (s *Server) releaseIdempotencyKey(r *http.Request, subject, key string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+	err := idempotency.Release(ctx, s.db, subject, key)
+	if err != nil {
+		log.Printf("Error releasing idempotency key [req %s]: %v", requestIDFrom(r.Context()), err)
+	}
+}
+
+func (s *Server) replayIdempotent(w http.ResponseWriter, r *http.Request, held idempotency.Response, hash string) {
+	if held.RequestHash != hash {
+		writeProblem(w, r, newProblem(
+			http.StatusUnprocessableEntity,
+			"idempotency_key_reused",
+			"Idempotency-Key was already used for a different request",
+		))
+		return
+	}
+	if held.Status == 0 {
+		w.Header().Set("Retry-After", "1")
+		writeProblem(w, r, newProblem(
+			http.StatusConflict,
+			"idempotency_key_in_flight",
+			"a request with this Idempotency-Key is still being served",
+		))
+		return
+	}
+
+	auditReplay(r, held.Status)
+	if held.OrderID != 0 {
+		o, err := s.orders.GetOrder(r.Context(), held.OrderID)
+		if errors.Is(err, store.ErrOrderNotFound) {
+			writeOrderNotFound(w, r, strconv.FormatInt(held.OrderID, 10))
+			return
+		}
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		setReplayHeaders(w, held)
+		s.writeOrder(w, held.Status, o)
+		return
+	}
+
+	if held.ContentType != "" {
+		w.Header().Set("Content-Type", held.ContentType)
+	}
+	setReplayHeaders(w, held)
+	w.WriteHeader(held.Status)
+	w.Write(held.Body)
+}
+
+func setReplayHeaders(w http.ResponseWriter, held idempotency.Response) {
+	if held.Location != "" {
+		w.Header().Set("Location", held.Location)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+}
@@ -0,0 +1,91 @@
+package httpapi
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"test/internal/store"
+)
+
+func TestIdempotencyKeysReplayWrites(t *testing.T) {
+	quietLogs(t)
+	app := newTestApp(t, withoutPollers, func(s *testSetup) {
+		s.api.APIKeys = []string{"acme-key", "other-key"}
+	})
+	app.key = "acme-key"
+	app.header = http.Header{"Idempotency-Key": {"order-1"}}
+
+	o := store.Order{
+		CustomerName:    "Test Customer",
+		ProductName:     "ninja",
+		Quantity:        1,
+		ShippingAddress: "1 Test Street",
+		Priority:        "low",
+	}
+	first, firstBody := app.do(http.MethodPost, "/v1/orders", o)
+	again, againBody := app.do(http.MethodPost, "/v1/orders", o)
+	if first.StatusCode != http.StatusCreated || again.StatusCode != http.StatusCreated ||
+		string(againBody) != string(firstBody) || again.Header.Get("Location") != first.Header.Get("Location") {
+		t.Fatalf("retry got %d %s, want the first response %d %s replayed", again.StatusCode, againBody, first.StatusCode, firstBody)
+	}
+	if first.Header.Get("Idempotent-Replayed") != "" || again.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Idempotent-Replayed = %q then %q, want only the retry marked", first.Header.Get("Idempotent-Replayed"), again.Header.Get("Idempotent-Replayed"))
+	}
+	var orders int
+	err := app.db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&orders)
+	if err != nil || orders != 1 {
+		t.Fatalf("%d orders (err %v), want 1", orders, err)
+	}
+	var status int
+	var path string
+	err = app.db.QueryRow(`SELECT status, path FROM request_audit WHERE code = 'idempotent_replay'`).Scan(&status, &path)
+	if err != nil || status != http.StatusCreated || path != "/v1/orders" {
+		t.Errorf("replay audited as %d %s (err %v), want 201 /v1/orders", status, path, err)
+	}
+	var stored []byte
+	err = app.db.QueryRow(`SELECT body FROM idempotency_keys WHERE key = 'order-1'`).Scan(&stored)
+	if err != nil || stored != nil {
+		t.Errorf("stored body %q (err %v), want none for a success", stored, err)
+	}
+
+	// Replays show the order as it is now, erasures included.
+	key := app.key
+	app.key = ""
+	app.doJSON(http.MethodPost, "/admin/erasures", map[string]string{"customerName": o.CustomerName}, http.StatusOK, nil)
+	app.key = key
+	_, erasedBody := app.do(http.MethodPost, "/v1/orders", o)
+	if bytes.Contains(erasedBody, []byte(o.CustomerName)) || !bytes.Contains(erasedBody, []byte(store.ErasedValue)) {
+		t.Errorf("replay after erasure = %s, want the customer erased", erasedBody)
+	}
+
+	o.Quantity = 2
+	resp, body := app.do(http.MethodPost, "/v1/orders", o)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("same key, different order: %d %s, want 422", resp.StatusCode, body)
+	}
+
+	// Keys are per caller, and refused requests are replayed too.
+	app.key = "other-key"
+	o.Quantity = 0
+	resp, _ = app.do(http.MethodPost, "/v1/orders", o)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid order under another caller's key: %d, want 422", resp.StatusCode)
+	}
+	resp, _ = app.do(http.MethodPost, "/v1/orders", o)
+	if resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retried invalid order wasn't replayed")
+	}
+
+	// An in-flight key holds retries off.
+	_, err = app.db.Exec(`UPDATE idempotency_keys SET status = 0 WHERE key = 'order-1'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.key = "acme-key"
+	o.Quantity = 1
+	resp, _ = app.do(http.MethodPost, "/v1/orders", o)
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("retry while in flight: %d, want 409 with Retry-After", resp.StatusCode)
+	}
+}
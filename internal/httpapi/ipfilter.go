@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"test/internal/maintenance"
+)
+
+// Route groups IP rules apply to. /debug/pprof follows admin.
+const (
+	GroupLegacy = "legacy"
+	GroupV1     = "v1"
+	GroupAdmin  = "admin"
+)
+
+var ipRuleGroups = []string{GroupLegacy, GroupV1, GroupAdmin}
+
+// IPRules admit a route group's clients by address. Deny wins; with any
+// Allow prefixes, a client must be in one of them.
+type IPRules struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// ParseIPRules reads the -ip-allow and -ip-deny lists of group:cidr, e.g.
+// "admin:10.0.0.0/8,admin:192.168.1.0/24", into rules by group. A bare
+// address is a /32 or /128.
+func ParseIPRules(allow, deny string) (map[string]IPRules, error) {
+	rules := make(map[string]IPRules)
+	for _, list := range []struct {
+		spec  string
+		allow bool
+	}{{allow, true}, {deny, false}} {
+		for _, item := range strings.Split(list.spec, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			group, cidr, ok := strings.Cut(item, ":")
+			if !ok || !slices.Contains(ipRuleGroups, group) {
+				return nil, fmt.Errorf("ip rule %q: want group:cidr with group one of %s", item, strings.Join(ipRuleGroups, ", "))
+			}
+			prefix, err := parsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("ip rule %q: %w", item, err)
+			}
+			r := rules[group]
+			if list.allow {
+				r.Allow = append(r.Allow, prefix)
+			} else {
+				r.Deny = append(r.Deny, prefix)
+			}
+			rules[group] = r
+		}
+	}
+	return rules, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	return p.Masked(), err
+}
+
+// admits reports whether addr may use the group, and if not, why.
+func (r IPRules) admits(addr netip.Addr) (bool, string) {
+	addr = addr.Unmap().WithZone("")
+	for _, p := range r.Deny {
+		if p.Contains(addr) {
+			return false, "denied by " + p.String()
+		}
+	}
+	if len(r.Allow) == 0 {
+		return true, ""
+	}
+	for _, p := range r.Allow {
+		if p.Contains(addr) {
+			return true, ""
+		}
+	}
+	return false, "not in the allowlist"
+}
+
+// ipFilter applies rules to a route group ahead of its other middleware,
+// authentication included, recording refused clients as security events.
+func ipFilter(group string, rules IPRules) middleware {
+	return func(next http.Handler) http.Handler {
+		if len(rules.Allow) == 0 && len(rules.Deny) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := netip.ParseAddr(clientIP(r))
+			ok, reason := false, "unparseable client address"
+			if err == nil {
+				ok, reason = rules.admits(addr)
+			}
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			securityEvent(r, maintenance.EventIPDenied, r.URL.Path, group+": "+reason)
+			writeProblem(w, r, newProblem(
+				http.StatusForbidden,
+				"ip_denied",
+				"requests from this address are not accepted here",
+			))
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/logging"
+	"test/internal/maintenance"
+	"test/internal/tracing"
+)
+
+type middleware func(http.Handler) http.Handler
+
+// chain applies mws so the first one listed is the outermost.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+type requestIDKey struct{}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID reuses a sane incoming X-Request-ID so IDs from a proxy in
+// front of us line up with our logs, and generates one otherwise.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			id = newReference()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withTrace continues the trace of an incoming traceparent header in a
+// span of its own, or starts one, so the changes a request records carry
+// it to the poller.
+func withTrace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span, err := tracing.Parse(r.Header.Get("traceparent"))
+		if err != nil {
+			span = tracing.New()
+		} else {
+			span = span.Child()
+		}
+		next.ServeHTTP(w, r.WithContext(tracing.WithSpan(r.Context(), span)))
+	})
+}
+
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			writeInternalError(w, r, fmt.Errorf("panic: %v", rec))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		span, _ := tracing.FromContext(r.Context())
+		logging.With(
+			logging.TraceID(span.TraceID),
+			slog.String("http.request.id", requestIDFrom(r.Context())),
+			slog.String("http.request.method", r.Method),
+			slog.String("url.path", r.URL.Path),
+			slog.Int("http.response.status_code", rec.status),
+		).Printf(
+			"[req %s] [trace %s] %s %s %d %s",
+			requestIDFrom(r.Context()),
+			span.TraceID,
+			r.Method,
+			r.URL.Path,
+			rec.status,
+			time.Since(start).Round(time.Microsecond),
+		)
+	})
+}
+
+// requireAPIKey accepts either "Authorization: Bearer <key>" or
+// "X-API-Key: <key>". With no keys configured it lets everything through.
+// A key with an entry in tenants scopes the request to that tenant; the
+// others, and requests without a key, stay in audit.DefaultTenant.
+func requireAPIKey(keys []string, tenants map[string]string) middleware {
+	return func(next http.Handler) http.Handler {
+		if len(keys) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-API-Key")
+			if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				key = bearer
+			}
+
+			for _, k := range keys {
+				if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+					ctx := audit.WithActor(r.Context(), keyActor(k))
+					if t := tenants[k]; t != "" {
+						ctx = audit.WithTenant(ctx, t)
+					}
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+
+			detail := "no API key"
+			if key != "" {
+				detail = "unknown API key " + keyActor(key)
+			}
+			securityEvent(r, maintenance.EventAuthFailed, r.URL.Path, detail)
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="orders"`)
+			writeProblem(w, r, newProblem(
+				http.StatusUnauthorized,
+				"unauthorized",
+				"a valid API key is required",
+			))
+		})
+	}
+}
+
+// keyActor names a key in the audit log by a fingerprint, never the key.
+func keyActor(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:6])
+}
+
+// rateLimiter is a token bucket per client IP.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    perSecond,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > 10*time.Minute {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit is a no-op when l is nil so groups can always list it.
+func rateLimit(l *rateLimiter) middleware {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.allow(clientIP(r), time.Now()) {
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, int(1/l.rate))))
+				writeProblem(w, r, newProblem(
+					http.StatusTooManyRequests,
+					"rate_limited",
+					"too many requests; slow down",
+				))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// cors answers preflight requests itself and decorates actual requests from
+// allowed origins. "*" allows any origin.
+func cors(origins []string) middleware {
+	return func(next http.Handler) http.Handler {
+		if len(origins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			allowed := origin != "" &&
+				(slices.Contains(origins, "*") || slices.Contains(origins, origin))
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+				w.Header().Set("Access-Control-Expose-Headers", "API-Version, Idempotent-Replayed, Location, X-Quota-Limit, X-Quota-Remaining, X-Quota-Reset, X-Request-ID")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				if allowed {
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, X-API-Key, X-Request-ID")
+					w.Header().Set("Access-Control-Max-Age", "600")
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strings"
+
+	"test/internal/audit"
+	"test/internal/maintenance"
+	"test/internal/oidc"
+)
+
+// oidcCookie carries a sign-in's state, nonce, PKCE verifier and target
+// from /login/oidc to the callback. It is SameSite=Lax so the browser
+// sends it on the provider's redirect back.
+const oidcCookie = "oidc_login"
+
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, nonce, verifier := oidc.RandomString(), oidc.RandomString(), oidc.RandomString()
+	next := base64.RawURLEncoding.EncodeToString([]byte(loginTarget(r.URL.Query().Get("next"))))
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    strings.Join([]string{state, nonce, verifier, next}, "."),
+		Path:     "/login",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, s.cfg.OIDC.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/login", MaxAge: -1})
+
+	var parts []string
+	if c, err := r.Cookie(oidcCookie); err == nil {
+		parts = strings.Split(c.Value, ".")
+	}
+	q := r.URL.Query()
+	if len(parts) != 4 || q.Get("state") != parts[0] {
+		s.refuseOIDC(w, r, "state does not match the sign-in it came from")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		s.refuseOIDC(w, r, "provider returned "+e)
+		return
+	}
+
+	id, err := s.cfg.OIDC.Exchange(r.Context(), q.Get("code"), parts[2], parts[1])
+	if err != nil {
+		s.refuseOIDC(w, r, err.Error())
+		return
+	}
+	if !slices.ContainsFunc(id.Groups, func(g string) bool { return slices.Contains(s.cfg.OIDCAdminGroups, g) }) {
+		r = r.WithContext(audit.WithActor(r.Context(), oidcSessionPrefix+id.Name()))
+		s.refuseOIDC(w, r, id.Name()+" is in none of the admin groups")
+		return
+	}
+
+	name := oidcSessionPrefix + id.Name()
+	r, ok := s.startSession(w, r, name, name)
+	if !ok {
+		return
+	}
+
+	// The callback is a navigation the provider started, so the browser
+	// holds SameSite=Strict cookies back from it and from any redirect it
+	// answers with. A page that moves on by itself starts a same-site
+	// navigation that carries the new session.
+	next, _ := base64.RawURLEncoding.DecodeString(parts[3])
+	target := html.EscapeString(loginTarget(string(next)))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<meta http-equiv=\"refresh\" content=\"0;url=%s\">\n<a href=\"%s\">Continue</a>\n", target, target)
+}
+
+func (s *Server) refuseOIDC(w http.ResponseWriter, r *http.Request, reason string) {
+	securityEvent(r, maintenance.EventAuthFailed, r.URL.Path, "oidc: "+reason)
+	writeProblem(w, r, newProblem(
+		http.StatusForbidden,
+		"login_failed",
+		"single sign-on did not let you in; try again from /login",
+	))
+}
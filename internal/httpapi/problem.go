@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"test/api"
+)
+
+type problem = api.Problem
+
+func newProblem(status int, code, detail string) problem {
+	return problem{
+		Type:   "/problems/" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	auditRejection(r, p)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	err := json.NewEncoder(w).Encode(p)
+	if err != nil {
+		log.Printf("Error writing problem response: %v", err)
+	}
+}
+
+// writeInternalError logs err under a fresh reference ID and sends the
+// client only that ID, never the driver's message.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	ref := newReference()
+	log.Printf(
+		"Internal error [ref %s] [req %s] %s %s: %v",
+		ref,
+		requestIDFrom(r.Context()),
+		r.Method,
+		r.URL.Path,
+		err,
+	)
+
+	p := newProblem(
+		http.StatusInternalServerError,
+		"internal_error",
+		"an unexpected error occurred; quote the reference when reporting it",
+	)
+	p.Reference = ref
+	writeProblem(w, r, p)
+}
+
+func newReference() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "unavailable"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,299 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/maintenance"
+	"test/internal/quota"
+	"test/internal/store"
+)
+
+// Quota kinds, as -quotas spells them.
+const (
+	QuotaOrders      = "orders"
+	QuotaEscalations = "escalations"
+	QuotaRate        = "rate"
+)
+
+// Quota caps what one tenant or API key may do, on top of the per-IP
+// RateLimit. Zero fields are unlimited.
+type Quota struct {
+	// OrdersPerDay and EscalationsPerHour count successful order
+	// creations and priority changes in fixed UTC windows, shared by
+	// every instance on the database.
+	OrdersPerDay       int64
+	EscalationsPerHour int64
+
+	// RatePerSecond is a token bucket with Config.RateBurst, kept in
+	// memory like RateLimit.
+	RatePerSecond float64
+}
+
+// quotaWindows is how long each counted kind's window lasts.
+var quotaWindows = map[string]struct {
+	length time.Duration
+	per    string
+}{
+	QuotaOrders:      {24 * time.Hour, "day"},
+	QuotaEscalations: {time.Hour, "hour"},
+}
+
+func (q Quota) limit(kind string) int64 {
+	switch kind {
+	case QuotaOrders:
+		return q.OrdersPerDay
+	case QuotaEscalations:
+		return q.EscalationsPerHour
+	}
+	return 0
+}
+
+// ParseQuotas reads the -quotas list of subject:kind=limit, e.g.
+// "tenant:acme:orders=5000,key:3f2a9c01d4e7:escalations=100". A subject
+// is tenant:<name> or key:<fingerprint>, the actor the audit log shows for
+// the key; kind is orders (per day), escalations (per hour) or rate (per
+// second).
+func ParseQuotas(spec string) (map[string]Quota, error) {
+	quotas := make(map[string]Quota)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		lhs, value, ok := strings.Cut(item, "=")
+		i := strings.LastIndex(lhs, ":")
+		if !ok || i < 0 {
+			return nil, fmt.Errorf("quota %q: want subject:kind=limit", item)
+		}
+		subject, kind := lhs[:i], lhs[i+1:]
+		scope, name, _ := strings.Cut(subject, ":")
+		if (scope != "tenant" && scope != "key") || name == "" {
+			return nil, fmt.Errorf("quota %q: subject must be tenant:<name> or key:<fingerprint>", item)
+		}
+
+		q := quotas[subject]
+		switch kind {
+		case QuotaOrders, QuotaEscalations:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("quota %q: limit must be a positive whole number", item)
+			}
+			if kind == QuotaOrders {
+				q.OrdersPerDay = n
+			} else {
+				q.EscalationsPerHour = n
+			}
+		case QuotaRate:
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate <= 0 {
+				return nil, fmt.Errorf("quota %q: rate must be a positive number", item)
+			}
+			q.RatePerSecond = rate
+		default:
+			return nil, fmt.Errorf("quota %q: kind must be %s, %s or %s", item, QuotaOrders, QuotaEscalations, QuotaRate)
+		}
+		quotas[subject] = q
+	}
+	return quotas, nil
+}
+
+// quotaSubjects are who a request's quotas are kept for: its API key, if
+// it came with one, then its tenant.
+func quotaSubjects(ctx context.Context) []string {
+	var subjects []string
+	if actor := audit.ActorFrom(ctx); strings.HasPrefix(actor, "key:") {
+		subjects = append(subjects, actor)
+	}
+	return append(subjects, "tenant:"+audit.TenantFrom(ctx))
+}
+
+// subjectRateLimit applies the RatePerSecond quotas. It goes after
+// authentication, which decides the subjects.
+func subjectRateLimit(quotas map[string]Quota, burst int) middleware {
+	limiters := make(map[string]*rateLimiter)
+	for subject, q := range quotas {
+		if q.RatePerSecond > 0 {
+			limiters[subject] = newRateLimiter(q.RatePerSecond, burst)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		if len(limiters) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, subject := range quotaSubjects(r.Context()) {
+				l, ok := limiters[subject]
+				if !ok || l.allow(subject, time.Now()) {
+					continue
+				}
+				securityEvent(r, maintenance.EventQuotaExceeded, subject, fmt.Sprintf("rate of %g/s exceeded", l.rate))
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, int(1/l.rate))))
+				writeProblem(w, r, newProblem(
+					http.StatusTooManyRequests,
+					"rate_limited",
+					"too many requests for "+subject+"; slow down",
+				))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type heldQuota struct {
+	subject string
+	start   time.Time
+}
+
+// withQuota counts each request h handles against the kind quotas of its
+// subjects and refuses it once one is used up. Requests h fails give their
+// use back. The X-Quota-* headers describe whichever quota has the least
+// left.
+func (s *Server) withQuota(kind string, h http.HandlerFunc) http.HandlerFunc {
+	window := quotaWindows[kind]
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().UTC()
+		start := now.Truncate(window.length)
+		reset := strconv.Itoa(int(start.Add(window.length).Sub(now).Seconds()) + 1)
+
+		var held []heldQuota
+		release := func() {
+			// The use is given back even if the client hung up.
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+			defer cancel()
+			for _, q := range held {
+				err := quota.Release(ctx, s.db, q.subject, kind, q.start)
+				if err != nil {
+					log.Printf("Error releasing %s quota of %s [req %s]: %v", kind, q.subject, requestIDFrom(r.Context()), err)
+				}
+			}
+		}
+
+		remaining := int64(-1)
+		for _, subject := range quotaSubjects(r.Context()) {
+			limit := s.cfg.Quotas[subject].limit(kind)
+			if limit == 0 {
+				continue
+			}
+			used, ok, err := quota.Reserve(r.Context(), s.db, subject, kind, start, limit)
+			if err != nil {
+				release()
+				writeInternalError(w, r, err)
+				return
+			}
+			if remaining < 0 || limit-used < remaining {
+				remaining = limit - used
+				w.Header().Set("X-Quota-Limit", strconv.FormatInt(limit, 10))
+				w.Header().Set("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+				w.Header().Set("X-Quota-Reset", reset)
+			}
+			if !ok {
+				release()
+				securityEvent(r, maintenance.EventQuotaExceeded, subject, fmt.Sprintf("%s quota of %d per %s used up", kind, limit, window.per))
+				w.Header().Set("Retry-After", reset)
+				writeProblem(w, r, newProblem(
+					http.StatusTooManyRequests,
+					"quota_exceeded",
+					fmt.Sprintf("the %s quota of %s is used up until the window resets", kind, subject),
+				))
+				return
+			}
+			held = append(held, heldQuota{subject: subject, start: start})
+		}
+		if len(held) == 0 {
+			h(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status >= 400 {
+			release()
+		}
+	}
+}
+
+// CustomerOrderLimit caps the orders one customer of a tenant may place in
+// each fixed Window. Past Soft an order still goes through but is logged
+// and recorded as a security event; past Hard it is refused. Zero turns
+// either off.
+type CustomerOrderLimit struct {
+	Window time.Duration
+	Soft   int64
+	Hard   int64
+}
+
+func (l CustomerOrderLimit) enabled() bool {
+	return l.Window > 0 && (l.Soft > 0 || l.Hard > 0)
+}
+
+// customerSubject keys a customer's order count by a hash of their name,
+// so quota_usage holds no personal data and erasures need not touch it.
+func customerSubject(tenant, name string) string {
+	sum := sha256.Sum256([]byte(tenant + "\x00" + name))
+	return "customer:" + hex.EncodeToString(sum[:8])
+}
+
+// checkCustomerLimit counts order against its customer's limit and
+// answers the request itself when the hard limit is reached. The count is
+// given back through the returned release if the order isn't created
+// after all.
+func (s *Server) checkCustomerLimit(w http.ResponseWriter, r *http.Request, order store.Order) (func(), bool) {
+	l := s.cfg.CustomerOrders
+	if !l.enabled() {
+		return func() {}, true
+	}
+	subject := customerSubject(audit.TenantFrom(r.Context()), order.CustomerName)
+	start := time.Now().UTC().Truncate(l.Window)
+	limit := l.Hard
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	used, ok, err := quota.Reserve(r.Context(), s.db, subject, QuotaOrders, start, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return nil, false
+	}
+	if !ok {
+		securityEvent(r, maintenance.EventOrderLimitExceeded, subject, fmt.Sprintf("hard limit of %d orders per %s reached", l.Hard, l.Window))
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(start.Add(l.Window)).Seconds())+1))
+		writeProblem(w, r, newProblem(
+			http.StatusTooManyRequests,
+			"customer_order_limit",
+			fmt.Sprintf("this customer may place at most %d orders per %s", l.Hard, l.Window),
+		))
+		return nil, false
+	}
+	if l.Soft > 0 && used > l.Soft {
+		log.Printf(
+			"WARNING: customer %s placed %d orders this %s window, over the soft limit of %d [req %s]",
+			s.cfg.LogRedaction.Value("customerName", order.CustomerName),
+			used,
+			l.Window,
+			l.Soft,
+			requestIDFrom(r.Context()),
+		)
+		securityEvent(r, maintenance.EventOrderLimitWarned, subject, fmt.Sprintf("order %d of a soft limit of %d per %s", used, l.Soft, l.Window))
+	}
+
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+		err := quota.Release(ctx, s.db, subject, QuotaOrders, start)
+		if err != nil {
+			log.Printf("Error releasing customer order count [req %s]: %v", requestIDFrom(r.Context()), err)
+		}
+	}
+	return release, true
+}
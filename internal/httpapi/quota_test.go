@@ -0,0 +1,146 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"test/internal/store"
+)
+
+func TestQuotasCapTenantsAndKeys(t *testing.T) {
+	quietLogs(t)
+	app := newTestApp(t, withoutPollers, func(s *testSetup) {
+		s.api.APIKeys = []string{"acme-key", "acme-batch-key"}
+		s.api.KeyTenants = map[string]string{"acme-key": "acme", "acme-batch-key": "acme"}
+		s.api.Quotas = map[string]Quota{
+			"tenant:acme":        {OrdersPerDay: 2},
+			keyActor("acme-key"): {EscalationsPerHour: 1},
+		}
+	})
+
+	app.key = "acme-key"
+	order := app.postOrder(store.Order{})
+
+	// A refused order doesn't count.
+	resp, body := app.do(http.MethodPost, "/v1/orders", map[string]any{"quantity": 1})
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid order: %d, want 422: %s", resp.StatusCode, body)
+	}
+
+	o := store.Order{
+		CustomerName:    "Test Customer",
+		ProductName:     "ninja",
+		Quantity:        1,
+		ShippingAddress: "1 Test Street",
+		Priority:        "low",
+	}
+	app.key = "acme-batch-key"
+	resp, body = app.do(http.MethodPost, "/v1/orders", o)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("second order: %d, want 201: %s", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("X-Quota-Remaining"); got != "0" {
+		t.Errorf("X-Quota-Remaining = %q, want 0", got)
+	}
+
+	resp, body = app.do(http.MethodPost, "/v1/orders", o)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("third order: %d, want 429: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Retry-After") == "" || resp.Header.Get("X-Quota-Limit") != "2" {
+		t.Errorf("quota headers %v, want Retry-After and X-Quota-Limit 2", resp.Header)
+	}
+
+	// The escalation quota is acme-key's alone.
+	app.key = "acme-key"
+	app.escalate(order.ID, "high")
+	path := fmt.Sprintf("/v1/orders/%d/priority", order.ID)
+	app.doJSON(http.MethodPatch, path, map[string]string{"priority": "low"}, http.StatusTooManyRequests, nil)
+	app.key = "acme-batch-key"
+	app.doJSON(http.MethodPatch, path, map[string]string{"priority": "low"}, http.StatusOK, nil)
+
+	var events, rejections int
+	err := app.db.QueryRow(`SELECT COUNT(*) FROM security_audit WHERE event = 'quota.exceeded'`).Scan(&events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.db.QueryRow(`SELECT COUNT(*) FROM request_audit WHERE status = 429 AND tenant_id = 'acme'`).Scan(&rejections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != 2 || rejections != 2 {
+		t.Errorf("recorded %d quota.exceeded events and %d rejections, want 2 of each", events, rejections)
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := ParseQuotas("tenant:acme:orders=10, key:3f2a9c01d4e7:escalations=5,tenant:acme:rate=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := quotas["tenant:acme"]; q.OrdersPerDay != 10 || q.RatePerSecond != 0.5 {
+		t.Errorf("tenant:acme = %+v", q)
+	}
+	if q := quotas["key:3f2a9c01d4e7"]; q.EscalationsPerHour != 5 {
+		t.Errorf("key:3f2a9c01d4e7 = %+v", q)
+	}
+
+	for _, spec := range []string{
+		"acme:orders=10",
+		"tenant::orders=10",
+		"tenant:acme:cancels=10",
+		"tenant:acme:orders=0",
+		"tenant:acme:orders",
+		"tenant:acme:rate=-1",
+	} {
+		_, err := ParseQuotas(spec)
+		if err == nil {
+			t.Errorf("ParseQuotas(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestCustomerOrderLimitsWarnThenRefuse(t *testing.T) {
+	quietLogs(t)
+	app := newTestApp(t, withoutPollers, func(s *testSetup) {
+		s.api.CustomerOrders = CustomerOrderLimit{Window: time.Hour, Soft: 1, Hard: 2}
+	})
+
+	app.postOrder(store.Order{CustomerName: "Ada"})
+	app.postOrder(store.Order{CustomerName: "Ada"})
+	resp, body := app.do(http.MethodPost, "/v1/orders", store.Order{
+		CustomerName:    "Ada",
+		ProductName:     "ninja",
+		Quantity:        1,
+		ShippingAddress: "1 Test Street",
+		Priority:        "low",
+	})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("third order from Ada: %d, want 429: %s", resp.StatusCode, body)
+	}
+	app.postOrder(store.Order{CustomerName: "Bob"})
+
+	events := map[string]int{}
+	rows, err := app.db.Query(`SELECT event, detail FROM security_audit WHERE event LIKE 'order_limit.%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event, detail string
+		err = rows.Scan(&event, &detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(detail, "Ada") {
+			t.Errorf("event %s names the customer: %s", event, detail)
+		}
+		events[event]++
+	}
+	if events["order_limit.warned"] != 1 || events["order_limit.exceeded"] != 1 {
+		t.Errorf("events %v, want one warning and one refusal", events)
+	}
+}
@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/maintenance"
+)
+
+type auditDBKey struct{}
+
+// withAuditDB hands writeProblem and the handlers the database that
+// request_audit and security_audit are in.
+func withAuditDB(db *sql.DB) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), auditDBKey{}, db)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// auditRejection records p as the answer to r in request_audit when r is
+// a write, so clients that keep sending bad or unauthorized writes show up
+// after the fact. The actor is the one authentication put in r's context,
+// empty when the request didn't get that far.
+func auditRejection(r *http.Request, p problem) {
+	db, ok := r.Context().Value(auditDBKey{}).(*sql.DB)
+	if !ok || p.Status < 400 {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	reason := p.Detail
+	if len(p.Fields) > 0 {
+		fields := make([]string, len(p.Fields))
+		for i, f := range p.Fields {
+			fields[i] = fmt.Sprintf("%s (%s)", f.Field, f.Code)
+		}
+		reason = strings.TrimSpace(reason + " " + strings.Join(fields, ", "))
+	}
+	recordRequest(r, db, p.Status, p.Code, reason)
+}
+
+// auditReplay records in request_audit that r was answered with the
+// response kept under its Idempotency-Key rather than run again.
+func auditReplay(r *http.Request, status int) {
+	db, ok := r.Context().Value(auditDBKey{}).(*sql.DB)
+	if !ok {
+		return
+	}
+	recordRequest(r, db, status, "idempotent_replay", "response replayed for a retried Idempotency-Key")
+}
+
+func recordRequest(r *http.Request, db *sql.DB, status int, code, reason string) {
+	// The record outlives a client that hung up on the answer.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+	err := maintenance.RecordRejectedRequest(ctx, db, maintenance.RejectedRequest{
+		Actor:     audit.ActorFrom(r.Context()),
+		Tenant:    audit.TenantFrom(r.Context()),
+		ClientIP:  clientIP(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Status:    status,
+		Code:      code,
+		Reason:    reason,
+		RequestID: requestIDFrom(r.Context()),
+	})
+	if err != nil {
+		log.Printf("Error auditing request [req %s]: %v", requestIDFrom(r.Context()), err)
+	}
+}
+
+// handleListRejectedRequests pages through request_audit, filtered by
+// ?actor=, ?tenant=, ?clientIp=, ?status=, ?since= and ?until=.
+func (s *Server) handleListRejectedRequests(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs validationErrors
+	var f maintenance.RejectionFilter
+
+	f.After = parseIDParam(&errs, q, "after")
+	f.Actor = q.Get("actor")
+	f.Tenant = q.Get("tenant")
+	f.ClientIP = q.Get("clientIp")
+	f.Since = parseTimeParam(&errs, q, "since")
+	f.Until = parseTimeParam(&errs, q, "until")
+	if v := q.Get("status"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 400 || n > 599 {
+			errs.add("status", "range", "invalid_status", "status must be between 400 and 599")
+		}
+		f.Status = n
+	}
+
+	limit := defaultChangeListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxChangeListLimit {
+			errs.add("limit", "range", "invalid_limit", "limit must be between 1 and 1000")
+		}
+		limit = n
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	requests, next, err := maintenance.ListRejectedRequests(r.Context(), s.readDB, f, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	links := map[string]link{
+		"self": {Href: r.URL.RequestURI(), Method: http.MethodGet},
+	}
+	if next > 0 {
+		q.Set("after", strconv.FormatInt(next, 10))
+		links["next"] = link{Href: r.URL.Path + "?" + q.Encode(), Method: http.MethodGet}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"requests": requests, "_links": links})
+}
@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"test/internal/maintenance"
+	"test/internal/store"
+)
+
+func TestRejectedWritesAreAudited(t *testing.T) {
+	app := newTestApp(t, withoutPollers)
+
+	resp, _ := app.do(http.MethodPost, "/v1/orders", map[string]any{
+		"customerName": "Test", "productName": "ninja", "quantity": 0, "shippingAddress": "1 Test Way",
+	})
+	if resp.StatusCode < 400 {
+		t.Fatalf("invalid order: got %d", resp.StatusCode)
+	}
+	order := app.postOrder(store.Order{})
+	id := strconv.FormatInt(order.ID, 10)
+	app.doJSON(http.MethodPost, "/v1/orders/"+id+"/cancel", nil, http.StatusOK, nil)
+	app.doJSON(http.MethodPatch, "/v1/orders/"+id+"/priority", map[string]string{"priority": "high"}, http.StatusConflict, nil)
+	// Reads are not audited.
+	app.doJSON(http.MethodGet, "/v1/orders/999", nil, http.StatusNotFound, nil)
+
+	var got struct {
+		Requests []maintenance.RejectedRequest `json:"requests"`
+	}
+	app.doJSON(http.MethodGet, "/admin/rejected-requests", nil, http.StatusOK, &got)
+	if len(got.Requests) != 2 {
+		t.Fatalf("rejected requests = %+v, want the invalid order and the conflict", got.Requests)
+	}
+	invalid, conflict := got.Requests[0], got.Requests[1]
+	if invalid.Method != http.MethodPost || invalid.Path != "/v1/orders" || !strings.Contains(invalid.Reason, "quantity") {
+		t.Errorf("invalid order recorded as %+v", invalid)
+	}
+	if conflict.Status != http.StatusConflict || conflict.Code != "order_cancelled" || conflict.ClientIP == "" || conflict.RequestID == "" {
+		t.Errorf("conflict recorded as %+v", conflict)
+	}
+
+	app.doJSON(http.MethodGet, "/admin/rejected-requests?status=409", nil, http.StatusOK, &got)
+	if len(got.Requests) != 1 || got.Requests[0].ID != conflict.ID {
+		t.Errorf("status=409 = %+v, want only the conflict", got.Requests)
+	}
+}
@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+)
+
+const (
+	maxFormBodyBytes      = 64 << 10 // 64 KiB is plenty for urlencoded forms
+	maxMultipartBodyBytes = 10 << 20 // CSV import uploads
+	maxMultipartMemory    = 2 << 20  // the rest spills to temp files
+)
+
+// parseRequestForm caps the body size before parsing so a single oversized
+// request can't exhaust memory. It writes the error response itself and
+// returns false when the caller should stop.
+func parseRequestForm(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var err error
+	if mediaType == "multipart/form-data" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxMultipartBodyBytes)
+		err = r.ParseMultipartForm(maxMultipartMemory)
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormBodyBytes)
+		err = r.ParseForm()
+	}
+	if err == nil {
+		return true
+	}
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeProblem(w, r, newProblem(
+			http.StatusRequestEntityTooLarge,
+			"request_too_large",
+			"request body exceeds the allowed size",
+		))
+		return false
+	}
+
+	writeProblem(w, r, newProblem(
+		http.StatusBadRequest,
+		"malformed_form",
+		"request body could not be parsed as a form",
+	))
+	return false
+}
+
+// decodeJSONBody applies the same size cap as forms and rejects unknown
+// fields so typos in client payloads surface instead of being dropped.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil {
+		return true
+	}
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeProblem(w, r, newProblem(
+			http.StatusRequestEntityTooLarge,
+			"request_too_large",
+			"request body exceeds the allowed size",
+		))
+		return false
+	}
+
+	writeProblem(w, r, newProblem(
+		http.StatusBadRequest,
+		"malformed_json",
+		"request body is not a valid JSON document for this endpoint",
+	))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// router is a ServeMux that also remembers the path template of every named
+// route, so response links are built from the same table requests are
+// dispatched by.
+type router struct {
+	*http.ServeMux
+	templates map[string]string
+}
+
+func newRouter() *router {
+	return &router{
+		ServeMux:  http.NewServeMux(),
+		templates: make(map[string]string),
+	}
+}
+
+// ServeHTTP dispatches like ServeMux but turns the mux's own plain-text 404
+// and 405 replies into problem responses, keeping its Allow header.
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, pattern := rt.ServeMux.Handler(r)
+	if pattern != "" {
+		rt.ServeMux.ServeHTTP(w, r)
+		return
+	}
+	rt.ServeMux.ServeHTTP(&unmatchedWriter{ResponseWriter: w, r: r}, r)
+}
+
+type unmatchedWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	written bool
+}
+
+func (u *unmatchedWriter) WriteHeader(status int) {
+	if u.written {
+		return
+	}
+	u.written = true
+
+	code := "not_found"
+	detail := "no resource at " + u.r.URL.Path
+	if status == http.StatusMethodNotAllowed {
+		code = "method_not_allowed"
+		detail = "use " + u.Header().Get("Allow")
+	}
+	u.Header().Del("X-Content-Type-Options")
+	writeProblem(u.ResponseWriter, u.r, newProblem(status, code, detail))
+}
+
+func (u *unmatchedWriter) Write(b []byte) (int, error) {
+	if !u.written {
+		u.WriteHeader(http.StatusOK)
+	}
+	// the mux's text body is replaced by the problem document
+	return len(b), nil
+}
+
+// url fills the {wildcards} of the named route from alternating key/value
+// pairs. Asking for an unregistered route is a programming error.
+func (rt *router) url(name string, pairs ...string) string {
+	tmpl, ok := rt.templates[name]
+	if !ok {
+		panic("router: no route named " + name)
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		tmpl = strings.ReplaceAll(tmpl, "{"+pairs[i]+"}", pairs[i+1])
+	}
+	return tmpl
+}
+
+// routeGroup mounts handlers under a common path prefix. Versioned groups
+// tag requests with their version and check it against the Accept header,
+// so a future /v2 group can change payload shapes next to /v1.
+type routeGroup struct {
+	rt      *router
+	prefix  string
+	version int
+
+	// successor, when set, marks every route in the group as deprecated in
+	// favour of the same path under this prefix.
+	successor string
+
+	middlewares []middleware
+}
+
+func newRouteGroup(rt *router, prefix string, version int) *routeGroup {
+	return &routeGroup{rt: rt, prefix: prefix, version: version}
+}
+
+// use appends to the group's middleware stack. It only affects routes
+// registered afterwards.
+func (g *routeGroup) use(mws ...middleware) {
+	g.middlewares = append(g.middlewares, mws...)
+}
+
+// handlePreflight routes every OPTIONS request under the prefix through the
+// group's middleware so a CORS middleware in the stack can answer it.
+func (g *routeGroup) handlePreflight() {
+	g.handle(http.MethodOptions, "/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// handle registers h for method and path under the group prefix. Requests
+// with another method on a known path get a 405 with an Allow header from
+// the mux itself.
+func (g *routeGroup) handle(method, path string, h http.HandlerFunc) {
+	g.rt.Handle(method+" "+g.prefix+path, chain(g.wrap(path, h), g.middlewares...))
+}
+
+// handleNamed registers the route like handle and records its template under
+// name for link generation.
+func (g *routeGroup) handleNamed(name, method, path string, h http.HandlerFunc) {
+	g.rt.templates[name] = g.prefix + path
+	g.handle(method, path, h)
+}
+
+func (g *routeGroup) wrap(path string, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.successor != "" {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set(
+				"Link",
+				fmt.Sprintf(`<%s%s>; rel="successor-version"`, g.successor, path),
+			)
+		}
+
+		if g.version > 0 {
+			requested, ok := requestedVersion(r)
+			if ok && requested != g.version {
+				writeProblem(w, r, newProblem(
+					http.StatusNotAcceptable,
+					"unsupported_version",
+					fmt.Sprintf(
+						"this endpoint serves API version %d, not %d",
+						g.version,
+						requested,
+					),
+				))
+				return
+			}
+			w.Header().Set("API-Version", strconv.Itoa(g.version))
+		}
+
+		ctx := context.WithValue(r.Context(), versionKey{}, g.version)
+		h(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/maintenance"
+)
+
+// securityEvent records an authentication failure or administrative action
+// taken in r in security_audit. It never fails the request: an event that
+// can't be recorded is logged instead.
+func securityEvent(r *http.Request, event, target, detail string) {
+	db, ok := r.Context().Value(auditDBKey{}).(*sql.DB)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+	err := maintenance.RecordSecurityEvent(ctx, db, maintenance.SecurityEvent{
+		Event:     event,
+		Actor:     audit.ActorFrom(r.Context()),
+		ClientIP:  clientIP(r),
+		Target:    target,
+		Detail:    detail,
+		RequestID: requestIDFrom(r.Context()),
+	})
+	if err != nil {
+		log.Printf("Error recording security event %s [req %s]: %v", event, requestIDFrom(r.Context()), err)
+	}
+}
+
+// handleListSecurityEvents pages through security_audit, filtered by
+// ?event=, ?actor=, ?since= and ?until=.
+func (s *Server) handleListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs validationErrors
+	var f maintenance.SecurityFilter
+
+	f.After = parseIDParam(&errs, q, "after")
+	f.Event = q.Get("event")
+	f.Actor = q.Get("actor")
+	f.Since = parseTimeParam(&errs, q, "since")
+	f.Until = parseTimeParam(&errs, q, "until")
+
+	limit := defaultChangeListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxChangeListLimit {
+			errs.add("limit", "range", "invalid_limit", "limit must be between 1 and 1000")
+		}
+		limit = n
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	events, next, err := maintenance.ListSecurityEvents(r.Context(), s.readDB, f, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	links := map[string]link{
+		"self": {Href: r.URL.RequestURI(), Method: http.MethodGet},
+	}
+	if next > 0 {
+		q.Set("after", strconv.FormatInt(next, 10))
+		links["next"] = link{Href: r.URL.Path + "?" + q.Encode(), Method: http.MethodGet}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"events": events, "_links": links})
+}
@@ -0,0 +1,305 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"test/internal/maintenance"
+)
+
+func TestSecurityEventsRecordAuthFailuresAndAdminActions(t *testing.T) {
+	quietLogs(t)
+	app := newTestApp(t, withoutPollers, func(s *testSetup) {
+		s.api.APIKeys = []string{"secret"}
+	})
+
+	app.doJSON(http.MethodPost, "/v1/orders", map[string]any{}, http.StatusUnauthorized, nil)
+	rule := map[string]any{"name": "nightly", "interval": "10s", "handler": "log", "enabled": true}
+	app.doJSON(http.MethodPost, "/admin/pollers", rule, http.StatusCreated, nil)
+	rule["enabled"] = false
+	app.doJSON(http.MethodPut, "/admin/pollers/nightly", rule, http.StatusOK, nil)
+	rule["interval"] = "20s"
+	app.doJSON(http.MethodPut, "/admin/pollers/nightly", rule, http.StatusOK, nil)
+	app.doJSON(http.MethodDelete, "/admin/pollers/nightly", nil, http.StatusNoContent, nil)
+
+	var got struct {
+		Events []maintenance.SecurityEvent `json:"events"`
+	}
+	app.doJSON(http.MethodGet, "/admin/security-events", nil, http.StatusOK, &got)
+	want := []struct{ event, actor, target string }{
+		{maintenance.EventAuthFailed, "", "/v1/orders"},
+		{maintenance.EventPollerCreated, "loopback", "nightly"},
+		{maintenance.EventPollerPaused, "loopback", "nightly"},
+		{maintenance.EventPollerUpdated, "loopback", "nightly"},
+		{maintenance.EventPollerDeleted, "loopback", "nightly"},
+	}
+	if len(got.Events) != len(want) {
+		t.Fatalf("events = %+v, want %d", got.Events, len(want))
+	}
+	for i, w := range want {
+		e := got.Events[i]
+		if e.Event != w.event || e.Actor != w.actor || e.Target != w.target || e.ClientIP == "" {
+			t.Errorf("event %d = %+v, want %s by %q on %s", i, e, w.event, w.actor, w.target)
+		}
+	}
+
+	app.doJSON(http.MethodGet, "/admin/security-events?event=auth.failed", nil, http.StatusOK, &got)
+	if len(got.Events) != 1 || got.Events[0].Detail != "no API key" {
+		t.Errorf("auth.failed events = %+v, want the one without a key", got.Events)
+	}
+}
+
+func TestSeparateAdminHandlerTakesBasicAuth(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	cfg := defaultTestSetup().api
+	cfg.SeparateAdmin = true
+	cfg.AdminUsers = map[string]string{"ops": "hunter2"}
+	srv := New(db, cfg, nil)
+
+	public := httptest.NewServer(srv.Handler())
+	t.Cleanup(public.Close)
+	admin := httptest.NewServer(srv.AdminHandler())
+	t.Cleanup(admin.Close)
+
+	get := func(url, user, password string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, c := range []struct {
+		url, user, password string
+		want                int
+	}{
+		{public.URL + "/admin/leases", "ops", "hunter2", http.StatusNotFound},
+		{admin.URL + "/admin/leases", "", "", http.StatusUnauthorized},
+		{admin.URL + "/admin/leases", "ops", "hunter3", http.StatusUnauthorized},
+		{admin.URL + "/admin/leases", "ops", "hunter2", http.StatusOK},
+		{admin.URL + "/debug/pprof/", "", "", http.StatusUnauthorized},
+		{admin.URL + "/debug/pprof/", "ops", "hunter2", http.StatusOK},
+	} {
+		if got := get(c.url, c.user, c.password); got != c.want {
+			t.Errorf("GET %s as %q: %d, want %d", c.url, c.user, got, c.want)
+		}
+	}
+}
+
+func TestIPRulesRefuseClientsBeforeAuth(t *testing.T) {
+	quietLogs(t)
+	rules, err := ParseIPRules("admin:10.0.0.0/8,v1:127.0.0.0/8", "v1:127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, withoutPollers, func(s *testSetup) {
+		s.api.IPRules = rules
+		s.api.APIKeys = []string{"secret"}
+	})
+
+	// Denied although allowed, and before the missing key is noticed.
+	var p problem
+	app.doJSON(http.MethodGet, "/v1/orders/1", nil, http.StatusForbidden, &p)
+	if p.Code != "ip_denied" {
+		t.Errorf("v1 problem code = %q, want ip_denied", p.Code)
+	}
+	app.doJSON(http.MethodGet, "/admin/leases", nil, http.StatusForbidden, nil)
+
+	// /admin is closed to this client too, so read the table.
+	rows, err := app.db.Query(`SELECT detail FROM security_audit WHERE event = ? ORDER BY id`, maintenance.EventIPDenied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var details []string
+	for rows.Next() {
+		var d string
+		err = rows.Scan(&d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		details = append(details, d)
+	}
+	want := []string{"v1: denied by 127.0.0.1/32", "admin: not in the allowlist"}
+	if !slices.Equal(details, want) {
+		t.Errorf("ip.denied details = %q, want %q", details, want)
+	}
+
+	for _, bad := range []string{"api:10.0.0.0/8", "v1:10.0.0.0/33", "v1"} {
+		_, err := ParseIPRules(bad, "")
+		if err == nil {
+			t.Errorf("ParseIPRules(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLegacyFormPostsNeedTheirCSRFCookie(t *testing.T) {
+	quietLogs(t)
+	app := newTestApp(t, withoutPollers)
+
+	resp, err := http.Get(app.srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var token string
+	for _, c := range resp.Cookies() {
+		if c.Name == csrfCookie {
+			token = c.Value
+		}
+	}
+	if token == "" {
+		t.Fatalf("GET / set no %s cookie", csrfCookie)
+	}
+
+	form := url.Values{
+		"customerName":    {"Form Customer"},
+		"productName":     {"ninja"},
+		"quantity":        {"2"},
+		"shippingAddress": {"2 Form Road"},
+		"priority":        {"low"},
+	}
+	post := func(cookie, header string, extra http.Header) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, app.srv.URL+"/orders", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", app.srv.URL)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookie, Value: cookie})
+		}
+		if header != "" {
+			req.Header.Set(csrfHeader, header)
+		}
+		for k, v := range extra {
+			req.Header[k] = v
+		}
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, c := range []struct {
+		name           string
+		cookie, header string
+		extra          http.Header
+		want           int
+	}{
+		{"no cookie", "", token, nil, http.StatusForbidden},
+		{"no token", token, "", nil, http.StatusForbidden},
+		{"wrong token", token, "forged", nil, http.StatusForbidden},
+		{"matching token", token, token, nil, http.StatusSeeOther},
+		{"API key", "", "", http.Header{"X-Api-Key": {"secret"}}, http.StatusSeeOther},
+	} {
+		if got := post(c.cookie, c.header, c.extra); got != c.want {
+			t.Errorf("%s: POST /orders = %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	form.Set(csrfField, token)
+	if got := post(token, "", nil); got != http.StatusSeeOther {
+		t.Errorf("token in form field: POST /orders = %d, want %d", got, http.StatusSeeOther)
+	}
+
+	var n int
+	err = app.db.QueryRow(`SELECT COUNT(*) FROM security_audit WHERE event = ?`, maintenance.EventCSRFRejected).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("%d csrf.rejected events, want 3", n)
+	}
+}
+
+func TestAdminSessionsSignInAndOut(t *testing.T) {
+	quietLogs(t)
+	app := newTestApp(t, withoutPollers, func(s *testSetup) {
+		s.api.AdminKeys = []string{"admin-secret"}
+		s.api.AdminUsers = map[string]string{"ops": "hunter2"}
+	})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	send := func(method, path string, form url.Values) *http.Response {
+		t.Helper()
+		var resp *http.Response
+		var err error
+		if form == nil {
+			req, _ := http.NewRequest(method, app.srv.URL+path, nil)
+			resp, err = client.Do(req)
+		} else {
+			resp, err = client.PostForm(app.srv.URL+path, form)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := send(http.MethodGet, "/admin/leases", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("before login: %d, want 401", resp.StatusCode)
+	}
+	resp := send(http.MethodPost, "/login", url.Values{"user": {"ops"}, "password": {"hunter3"}, "next": {"/admin/leases"}})
+	if loc := resp.Header.Get("Location"); !strings.HasPrefix(loc, "/login?failed=1") {
+		t.Errorf("bad password redirected to %q, want back to /login", loc)
+	}
+	resp = send(http.MethodPost, "/login", url.Values{"user": {"ops"}, "password": {"hunter2"}, "next": {"https://example.com/"}})
+	if loc := resp.Header.Get("Location"); loc != "/admin/pollers" {
+		t.Errorf("login redirected to %q, want /admin/pollers", loc)
+	}
+	if resp := send(http.MethodGet, "/admin/leases", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("signed in: %d, want 200", resp.StatusCode)
+	}
+
+	send(http.MethodPost, "/logout", url.Values{})
+	if resp := send(http.MethodGet, "/admin/leases", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("after logout: %d, want 401", resp.StatusCode)
+	}
+
+	var events []string
+	rows, err := app.db.Query(`SELECT event || ' ' || actor FROM security_audit WHERE target = 'ops' ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e string
+		err = rows.Scan(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	want := []string{"session.login user:ops", "session.logout user:ops"}
+	if !slices.Equal(events, want) {
+		t.Errorf("session events = %q, want %q", events, want)
+	}
+}
@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"test/internal/audit"
+	"test/internal/maintenance"
+	"test/internal/session"
+)
+
+const sessionCookie = "admin_session"
+
+// sessionAuth lets a signed-in user, from AdminUsers or the OIDC provider,
+// past guard on the strength of their session cookie alone, so /admin can
+// be used from a browser.
+// The cookie is SameSite=Strict: another site can't make the browser send
+// it, which is what keeps session-backed admin writes safe from CSRF.
+func (s *Server) sessionAuth(guard middleware) middleware {
+	if !s.sessionsEnabled() {
+		return guard
+	}
+	return func(next http.Handler) http.Handler {
+		guarded := guard(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor, err := s.sessionActor(r)
+			if err != nil {
+				writeInternalError(w, r, err)
+				return
+			}
+			if actor == "" {
+				guarded.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+		})
+	}
+}
+
+func (s *Server) sessionsEnabled() bool {
+	return len(s.cfg.AdminUsers) > 0 || s.cfg.OIDC != nil
+}
+
+// Sessions from the OIDC provider are stored under the identity's name
+// with this prefix, which keeps them apart from AdminUsers.
+const oidcSessionPrefix = "oidc:"
+
+// sessionActor returns the actor r's session cookie signs in, "user:<name>"
+// or "oidc:<name>", or "". Users taken out of AdminUsers lose their
+// sessions with them, as do OIDC users when OIDC is turned off.
+func (s *Server) sessionActor(r *http.Request) (string, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return "", nil
+	}
+	user, err := session.User(r.Context(), s.db, c.Value)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(user, oidcSessionPrefix) {
+		if s.cfg.OIDC == nil {
+			return "", nil
+		}
+		return user, nil
+	}
+	if _, ok := s.cfg.AdminUsers[user]; !ok {
+		return "", nil
+	}
+	return "user:" + user, nil
+}
+
+// sessionRoutes serves the login page beside wherever /admin is served.
+func (s *Server) sessionRoutes(rt *router) {
+	if !s.sessionsEnabled() {
+		return
+	}
+	login := newRouteGroup(rt, "", 0)
+	login.use(ipFilter(GroupAdmin, s.cfg.IPRules[GroupAdmin]))
+	login.handle(http.MethodGet, "/login", s.handleLoginPage)
+	login.handle(http.MethodPost, "/login", s.handleLogin)
+	login.handle(http.MethodPost, "/logout", s.handleLogout)
+	if s.cfg.OIDC != nil {
+		login.handle(http.MethodGet, "/login/oidc", s.handleOIDCLogin)
+		login.handle(http.MethodGet, "/login/callback", s.handleOIDCCallback)
+	}
+}
+
+// handleLoginPage serves login.html, which offers SSO when its query has
+// sso set. With no AdminUsers SSO is the only way in, so it goes straight
+// there.
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case s.cfg.OIDC != nil && len(s.cfg.AdminUsers) == 0:
+		http.Redirect(w, r, "/login/oidc?next="+url.QueryEscape(q.Get("next")), http.StatusSeeOther)
+		return
+	case s.cfg.OIDC != nil && !q.Has("sso"):
+		q.Set("sso", "1")
+		http.Redirect(w, r, "/login?"+q.Encode(), http.StatusSeeOther)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(s.cfg.StaticDir, "login.html"))
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !parseRequestForm(w, r) {
+		return
+	}
+	user, password := r.PostFormValue("user"), r.PostFormValue("password")
+	next := r.PostFormValue("next")
+	if !checkPassword(s.cfg.AdminUsers, user, password) {
+		securityEvent(r, maintenance.EventAuthFailed, r.URL.Path, "bad credentials for user "+strconv.Quote(user))
+		http.Redirect(w, r, "/login?failed=1&next="+url.QueryEscape(next), http.StatusSeeOther)
+		return
+	}
+
+	r, ok := s.startSession(w, r, user, "user:"+user)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, loginTarget(next), http.StatusSeeOther)
+}
+
+// startSession signs name in, setting the session cookie, and returns r
+// as actor for what follows.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, name, actor string) (*http.Request, bool) {
+	token, err := session.Create(r.Context(), s.db, name, s.cfg.SessionTTL)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return r, false
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(s.cfg.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	r = r.WithContext(audit.WithActor(r.Context(), actor))
+	securityEvent(r, maintenance.EventLogin, name, "")
+	return r, true
+}
+
+// loginTarget is where to go after signing in. Only back into /admin:
+// anything else would make login an open redirect.
+func loginTarget(next string) string {
+	if !strings.HasPrefix(next, "/admin/") {
+		return "/admin/pollers"
+	}
+	return next
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	actor, err := s.sessionActor(r)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if actor != "" {
+		c, _ := r.Cookie(sessionCookie)
+		err = session.End(r.Context(), s.db, c.Value)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		r = r.WithContext(audit.WithActor(r.Context(), actor))
+		securityEvent(r, maintenance.EventLogout, strings.TrimPrefix(actor, "user:"), "")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,56 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"test/internal/store"
+)
+
+func TestTenantsOnlySeeTheirOwnOrders(t *testing.T) {
+	quietLogs(t)
+	app := newTestApp(t, withoutPollers, func(s *testSetup) {
+		s.api.APIKeys = []string{"acme-key", "globex-key", "plain-key"}
+		s.api.KeyTenants = map[string]string{"acme-key": "acme", "globex-key": "globex"}
+	})
+
+	app.key = "acme-key"
+	order := app.postOrder(store.Order{CustomerName: "Ada"})
+	app.escalate(order.ID, "high")
+	if got := len(app.audit(order.ID)); got != 1 {
+		t.Errorf("acme sees %d audit entries of its order, want 1", got)
+	}
+
+	for _, key := range []string{"globex-key", "plain-key"} {
+		app.key = key
+		for _, c := range []struct {
+			method, path string
+			body         any
+		}{
+			{http.MethodGet, fmt.Sprintf("/v1/orders/%d", order.ID), nil},
+			{http.MethodGet, fmt.Sprintf("/v1/orders/%d/audit", order.ID), nil},
+			{http.MethodPatch, fmt.Sprintf("/v1/orders/%d/priority", order.ID), map[string]string{"priority": "low"}},
+			{http.MethodPost, fmt.Sprintf("/v1/orders/%d/cancel", order.ID), nil},
+		} {
+			resp, body := app.do(c.method, c.path, c.body)
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("%s %s with %s: %d, want 404: %s", c.method, c.path, key, resp.StatusCode, body)
+			}
+		}
+	}
+
+	var tenant string
+	err := app.db.QueryRow(`SELECT tenant_id FROM priority_changes WHERE order_id = ? AND priority = 'high'`, order.ID).Scan(&tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant != "acme" {
+		t.Errorf("escalation recorded for tenant %q, want acme", tenant)
+	}
+
+	// Erasing for another tenant leaves acme's customer of the same name.
+	app.key = ""
+	app.doJSON(http.MethodPost, "/admin/erasures", map[string]string{"customerName": "Ada"}, http.StatusNotFound, nil)
+	app.doJSON(http.MethodPost, "/admin/erasures", map[string]string{"customerName": "Ada", "tenant": "acme"}, http.StatusOK, nil)
+}
@@ -0,0 +1,139 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"test/api"
+	"test/internal/store"
+)
+
+// fieldError names the form field exactly as the client sent it so the
+// frontend can highlight the matching input.
+type fieldError = api.FieldError
+
+type validationErrors []fieldError
+
+func (v *validationErrors) add(field, rule, code, msg string) {
+	*v = append(*v, fieldError{Field: field, Rule: rule, Code: code, Message: msg})
+}
+
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs validationErrors) {
+	p := newProblem(
+		http.StatusUnprocessableEntity,
+		"validation_failed",
+		"one or more fields are invalid",
+	)
+	p.Fields = errs
+	writeProblem(w, r, p)
+}
+
+func requireText(errs *validationErrors, field, v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		errs.add(field, "required", "missing_field", field+" is required")
+	}
+	return v
+}
+
+func validatePriority(errs *validationErrors, field, raw string) string {
+	p, ok := store.ParsePriority(raw)
+	if !ok {
+		errs.add(
+			field,
+			"enum",
+			"invalid_priority",
+			"priority must be one of: "+strings.Join(store.Priorities, ", "),
+		)
+	}
+	return p
+}
+
+const maxTagLength = 64
+
+// validateOrder normalizes o in place and reports every rule it breaks.
+// Form and JSON inputs both end up here so the rules can't drift apart.
+func validateOrder(errs *validationErrors, o *store.Order, cfg Config) {
+	o.CustomerName = requireText(errs, "customerName", o.CustomerName)
+	o.ProductName = requireText(errs, "productName", o.ProductName)
+	o.ShippingAddress = requireText(errs, "shippingAddress", o.ShippingAddress)
+
+	if o.Quantity < cfg.MinQuantity || o.Quantity > cfg.MaxQuantity {
+		errs.add(
+			"quantity",
+			"range",
+			"quantity_out_of_range",
+			fmt.Sprintf(
+				"quantity must be between %d and %d",
+				cfg.MinQuantity,
+				cfg.MaxQuantity,
+			),
+		)
+	}
+
+	o.Priority = validatePriority(errs, "priority", o.Priority)
+
+	o.Tag = strings.TrimSpace(o.Tag)
+	if len(o.Tag) > maxTagLength {
+		errs.add(
+			"tag",
+			"max_length",
+			"tag_too_long",
+			fmt.Sprintf("tag must be at most %d characters", maxTagLength),
+		)
+	}
+}
+
+func parseOrderForm(r *http.Request, cfg Config) (store.Order, validationErrors) {
+	var errs validationErrors
+	o := store.Order{
+		CustomerName:    r.FormValue("customerName"),
+		ProductName:     r.FormValue("productName"),
+		ShippingAddress: r.FormValue("shippingAddress"),
+		Priority:        r.FormValue("priority"),
+		Tag:             r.FormValue("tag"),
+	}
+
+	quantity, err := strconv.Atoi(strings.TrimSpace(r.FormValue("quantity")))
+	if err != nil {
+		errs.add(
+			"quantity",
+			"integer",
+			"invalid_quantity",
+			"quantity must be a whole number",
+		)
+		// keep the range check from piling a second error onto the field
+		quantity = cfg.MinQuantity
+	}
+	o.Quantity = quantity
+
+	validateOrder(&errs, &o, cfg)
+	return o, errs
+}
+
+func validatePriorityChange(errs *validationErrors, c *store.PriorityChange, idField string) {
+	if c.OrderID < 1 {
+		errs.add(idField, "integer", "invalid_order_id", idField+" must be a positive order id")
+	}
+
+	if c.Priority == "" {
+		c.Priority = store.DefaultEscalationPriority
+		return
+	}
+	c.Priority = validatePriority(errs, "priority", c.Priority)
+}
+
+func parsePriorityChangeForm(r *http.Request) (store.PriorityChange, validationErrors) {
+	var errs validationErrors
+	c := store.PriorityChange{Priority: r.FormValue("priority")}
+
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
+	if err == nil {
+		c.OrderID = id
+	}
+
+	validatePriorityChange(&errs, &c, "id")
+	return c, errs
+}
@@ -0,0 +1,39 @@
+package httpapi
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const vendorMediaPrefix = "application/vnd.orders.v"
+
+type versionKey struct{}
+
+// apiVersion returns the API version the request was routed to, or 0 for the
+// legacy unversioned endpoints.
+func apiVersion(ctx context.Context) int {
+	v, _ := ctx.Value(versionKey{}).(int)
+	return v
+}
+
+// requestedVersion looks for a vendor media type such as
+// application/vnd.orders.v1+json in the Accept header.
+func requestedVersion(r *http.Request) (int, bool) {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || !strings.HasPrefix(mediaType, vendorMediaPrefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(mediaType, vendorMediaPrefix)
+		rest = strings.TrimSuffix(rest, "+json")
+		v, err := strconv.Atoi(rest)
+		if err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,319 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"test/internal/maintenance"
+	"test/internal/webhook"
+)
+
+type subscriptionRequest struct {
+	URL           string         `json:"url"`
+	Secret        string         `json:"secret"`
+	EventTypes    []string       `json:"eventTypes"`
+	Filter        webhook.Filter `json:"filter"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Format        string         `json:"format"`
+	Enabled       *bool          `json:"enabled"`
+}
+
+// subscriptionView is a subscription as GET shows it, with how its
+// deliveries have gone.
+type subscriptionView struct {
+	webhook.Subscription
+	Stats webhook.Stats `json:"stats"`
+}
+
+func (s *Server) handleListWebhookSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs, err := webhook.ListSubscriptions(r.Context(), s.readDB)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"subscriptions": subs})
+}
+
+// handleCreateWebhookSubscription adds a subscription. Its secret, given
+// or generated, is in this response and no other.
+func (s *Server) handleCreateWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	sub, ok := decodeSubscription(w, r)
+	if !ok {
+		return
+	}
+	if sub.Secret == "" {
+		var err error
+		sub.Secret, err = webhook.NewSecret()
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+	id, err := webhook.CreateSubscription(r.Context(), s.db, sub)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	log.Printf("Created webhook subscription #%d (enabled: %t)", id, sub.Enabled)
+	securityEvent(r, maintenance.EventWebhookCreated, fmt.Sprintf("subscription #%d", id), sub.URL)
+
+	created, err := webhook.GetSubscription(r.Context(), s.db, id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	created.Secret = sub.Secret
+	w.Header().Set("Location", fmt.Sprintf("/admin/webhooks/subscriptions/%d", id))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleGetWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	sub, err := webhook.GetSubscription(r.Context(), s.readDB, id)
+	if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+		writeSubscriptionNotFound(w, r, r.PathValue("id"))
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	stats, err := webhook.SubscriptionStats(r.Context(), s.readDB, id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, subscriptionView{Subscription: sub, Stats: stats})
+}
+
+// handleUpdateWebhookSubscription replaces a subscription. Leaving out the
+// secret keeps the current one; a new one is echoed back once.
+func (s *Server) handleUpdateWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	sub, ok := decodeSubscription(w, r)
+	if !ok {
+		return
+	}
+	sub.ID = id
+	err := webhook.UpdateSubscription(r.Context(), s.db, sub)
+	if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+		writeSubscriptionNotFound(w, r, r.PathValue("id"))
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	log.Printf("Updated webhook subscription #%d (enabled: %t)", id, sub.Enabled)
+	detail := fmt.Sprintf("%s, enabled: %t", sub.URL, sub.Enabled)
+	if sub.Secret != "" {
+		detail += ", secret replaced"
+	}
+	securityEvent(r, maintenance.EventWebhookUpdated, fmt.Sprintf("subscription #%d", id), detail)
+
+	updated, err := webhook.GetSubscription(r.Context(), s.db, id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	updated.Secret = sub.Secret
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleDeleteWebhookSubscription removes a subscription together with
+// its deliveries.
+func (s *Server) handleDeleteWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	err := webhook.DeleteSubscription(r.Context(), s.db, id)
+	if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+		writeSubscriptionNotFound(w, r, r.PathValue("id"))
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	log.Printf("Deleted webhook subscription #%d", id)
+	securityEvent(r, maintenance.EventWebhookDeleted, fmt.Sprintf("subscription #%d", id), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTestWebhookSubscription sends the subscription a signed test event
+// and reports the receiver's answer. A receiver that fails is still a 200
+// here; the attempt says how it failed.
+func (s *Server) handleTestWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	timeout := s.cfg.WebhookTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	a, err := webhook.TestDelivery(r.Context(), s.db, id, timeout)
+	if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+		writeSubscriptionNotFound(w, r, r.PathValue("id"))
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"delivered": a.Error == "", "attempt": a})
+}
+
+// decodeSubscription reads and validates a subscription body. Subscriptions
+// are enabled unless it says otherwise.
+func decodeSubscription(w http.ResponseWriter, r *http.Request) (webhook.Subscription, bool) {
+	var req subscriptionRequest
+	if !decodeJSONBody(w, r, &req) {
+		return webhook.Subscription{}, false
+	}
+	sub := webhook.Subscription{
+		URL:           req.URL,
+		Secret:        req.Secret,
+		EventTypes:    req.EventTypes,
+		Filter:        req.Filter,
+		SchemaVersion: req.SchemaVersion,
+		Format:        req.Format,
+		Enabled:       true,
+	}
+	if req.Enabled != nil {
+		sub.Enabled = *req.Enabled
+	}
+
+	var errs validationErrors
+	if req.URL == "" {
+		errs.add("url", "required", "missing_field", "url is required")
+	} else if err := sub.Normalize(); err != nil {
+		errs.add("subscription", "valid", "invalid_subscription", err.Error())
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return webhook.Subscription{}, false
+	}
+	return sub, true
+}
+
+func subscriptionID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		writeSubscriptionNotFound(w, r, r.PathValue("id"))
+		return 0, false
+	}
+	return id, true
+}
+
+func writeSubscriptionNotFound(w http.ResponseWriter, r *http.Request, id string) {
+	writeProblem(w, r, newProblem(
+		http.StatusNotFound,
+		"subscription_not_found",
+		"no webhook subscription with id "+id,
+	))
+}
+
+// handleListWebhookDeliveries pages through webhook deliveries, filtered
+// by ?status= (pending, delivered or dead) and ?subscription=.
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs validationErrors
+	var f webhook.DeliveryFilter
+
+	f.After = parseIDParam(&errs, q, "after")
+	f.SubscriptionID = parseIDParam(&errs, q, "subscription")
+	f.Status = q.Get("status")
+	switch f.Status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead:
+	default:
+		errs.add("status", "enum", "invalid_status", "status must be one of: pending, delivered, dead")
+	}
+
+	limit := defaultChangeListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxChangeListLimit {
+			errs.add("limit", "range", "invalid_limit", "limit must be between 1 and 1000")
+		}
+		limit = n
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	deliveries, next, err := webhook.ListDeliveries(r.Context(), s.readDB, f, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	links := map[string]link{
+		"self": {Href: r.URL.RequestURI(), Method: http.MethodGet},
+	}
+	if next > 0 {
+		q.Set("after", strconv.FormatInt(next, 10))
+		links["next"] = link{Href: r.URL.Path + "?" + q.Encode(), Method: http.MethodGet}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": deliveries, "_links": links})
+}
+
+// handleGetWebhookDelivery shows a delivery with each attempt's status
+// code, latency and the start of the receiver's answer.
+func (s *Server) handleGetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		writeDeliveryNotFound(w, r, r.PathValue("id"))
+		return
+	}
+	d, err := webhook.GetDelivery(r.Context(), s.readDB, id)
+	if errors.Is(err, webhook.ErrDeliveryNotFound) {
+		writeDeliveryNotFound(w, r, r.PathValue("id"))
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// handleRetryWebhookDelivery sends a dead-lettered delivery again with a
+// fresh set of attempts.
+func (s *Server) handleRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		writeDeliveryNotFound(w, r, r.PathValue("id"))
+		return
+	}
+	err = webhook.RetryDelivery(r.Context(), s.db, id)
+	if errors.Is(err, webhook.ErrDeliveryNotFound) {
+		writeDeliveryNotFound(w, r, r.PathValue("id"))
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	securityEvent(r, maintenance.EventWebhookRetried, fmt.Sprintf("delivery #%d", id), "")
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "status": webhook.StatusPending})
+}
+
+func writeDeliveryNotFound(w http.ResponseWriter, r *http.Request, id string) {
+	writeProblem(w, r, newProblem(
+		http.StatusNotFound,
+		"delivery_not_found",
+		"no webhook delivery with id "+id,
+	))
+}
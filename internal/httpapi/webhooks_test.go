@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"test/internal/events"
+	"test/internal/webhook"
+)
+
+func TestWebhookSubscriptionsAreManagedAndTested(t *testing.T) {
+	quietLogs(t)
+	app := newTestApp(t, withoutPollers)
+
+	var mu sync.Mutex
+	var secret string
+	var got []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := webhook.VerifyRequest(r, []byte(secret)); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		got = append(got, r.Header.Get("X-Event-Type")+" "+r.Header.Get("Content-Type"))
+	}))
+	defer receiver.Close()
+
+	bad := map[string]any{"url": receiver.URL, "eventTypes": []string{"order.shipped"}}
+	app.doJSON(http.MethodPost, "/admin/webhooks/subscriptions", bad, http.StatusUnprocessableEntity, nil)
+	bad = map[string]any{"url": "ftp://example.com/hook"}
+	app.doJSON(http.MethodPost, "/admin/webhooks/subscriptions", bad, http.StatusUnprocessableEntity, nil)
+
+	var created webhook.Subscription
+	body := map[string]any{"url": receiver.URL, "eventTypes": []string{"priority.changed"}}
+	app.doJSON(http.MethodPost, "/admin/webhooks/subscriptions", body, http.StatusCreated, &created)
+	if created.ID == 0 || len(created.Secret) != 64 || !created.Enabled || created.SchemaVersion != events.Current {
+		t.Fatalf("created %+v, want an enabled subscription on the current schema with a generated secret", created)
+	}
+	mu.Lock()
+	secret = created.Secret
+	mu.Unlock()
+	path := fmt.Sprintf("/admin/webhooks/subscriptions/%d", created.ID)
+
+	var tested struct {
+		Delivered bool            `json:"delivered"`
+		Attempt   webhook.Attempt `json:"attempt"`
+	}
+	app.doJSON(http.MethodPost, path+"/test", nil, http.StatusOK, &tested)
+	if !tested.Delivered || tested.Attempt.StatusCode != http.StatusOK {
+		t.Errorf("test delivery = %+v, want it accepted", tested)
+	}
+
+	// A PUT without a secret keeps it, so receivers needn't change.
+	body = map[string]any{"url": receiver.URL, "enabled": false, "format": "cloudevents"}
+	var updated webhook.Subscription
+	app.doJSON(http.MethodPut, path, body, http.StatusOK, &updated)
+	if updated.Enabled || updated.Secret != "" || len(updated.EventTypes) != 0 || updated.Format != events.FormatCloudEvents {
+		t.Errorf("updated %+v, want disabled CloudEvents for every event type and no secret shown", updated)
+	}
+	app.doJSON(http.MethodPost, path+"/test", nil, http.StatusOK, &tested)
+	if !tested.Delivered {
+		t.Errorf("test delivery after update = %+v, want it still signed with the same secret", tested)
+	}
+	mu.Lock()
+	want := []string{
+		webhook.TestEventType + " " + events.ContentTypeJSON,
+		webhook.TestEventType + " " + events.ContentTypeCloudEvents,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("receiver got %q, want %q", got, want)
+	}
+	mu.Unlock()
+
+	_, err := app.db.Exec(`
+        INSERT INTO webhook_deliveries (subscription_id, change_id, event_type, payload, status, attempts)
+        VALUES (?, 1, 'priority.changed', '{}', 'dead', 8), (?, 2, 'priority.changed', '{}', 'pending', 0)
+    `, created.ID, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var view subscriptionView
+	app.doJSON(http.MethodGet, path, nil, http.StatusOK, &view)
+	if view.Secret != "" || view.Stats.Dead != 1 || view.Stats.Pending != 1 || view.Stats.Delivered != 0 {
+		t.Errorf("GET %s = %+v, want stats of one dead and one pending delivery and no secret", path, view)
+	}
+
+	var list struct {
+		Subscriptions []webhook.Subscription `json:"subscriptions"`
+	}
+	app.doJSON(http.MethodGet, "/admin/webhooks/subscriptions", nil, http.StatusOK, &list)
+	if len(list.Subscriptions) != 1 || list.Subscriptions[0].Secret != "" {
+		t.Errorf("list = %+v, want the one subscription without its secret", list.Subscriptions)
+	}
+
+	app.doJSON(http.MethodDelete, path, nil, http.StatusNoContent, nil)
+	app.doJSON(http.MethodGet, path, nil, http.StatusNotFound, nil)
+	app.doJSON(http.MethodPost, path+"/test", nil, http.StatusNotFound, nil)
+	var left int
+	err = app.db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`).Scan(&left)
+	if err != nil || left != 0 {
+		t.Errorf("%d deliveries left after delete (err %v), want 0", left, err)
+	}
+}
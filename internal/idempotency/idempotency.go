@@ -0,0 +1,80 @@
+// Package idempotency keeps the responses to requests sent with an
+// Idempotency-Key, so their retries can be answered without running the
+// request again.
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"test/internal/store"
+)
+
+// Response is what the first request with an idempotency key
+// got, for replaying to its retries. Status is 0 while that request is
+// still in flight. A success keeps only the id of the order it returned,
+// never the order itself; Body is kept for the errors, which carry no
+// customer data.
+type Response struct {
+	RequestHash string
+	Status      int
+	ContentType string
+	Location    string
+	OrderID     int64
+	Body        []byte
+}
+
+// keyColumn is quoted: KEY is reserved in MySQL.
+const keyColumn = "`key`"
+
+// Claim records that a request hashing to hash is being
+// served under subject's key, unless the key is already taken, in which
+// case it returns what the key holds. Every key older than keepFor is
+// cleared out on the way.
+func Claim(ctx context.Context, db *sql.DB, subject, key, hash string, keepFor time.Duration) (Response, bool, error) {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, `
+        DELETE FROM idempotency_keys WHERE created_at < ?
+    `, now.Add(-keepFor).Unix())
+	if err != nil {
+		return Response{}, false, err
+	}
+
+	res, err := db.ExecContext(ctx, `
+        `+store.DialectOf(db).InsertIgnore()+` INTO idempotency_keys (subject, `+keyColumn+`, request_hash, created_at)
+        VALUES (?, ?, ?, ?)
+    `, subject, key, hash, now.Unix())
+	if err != nil {
+		return Response{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return Response{}, true, nil
+	}
+
+	var held Response
+	err = db.QueryRowContext(ctx, `
+        SELECT request_hash, status, content_type, location, order_id, body
+        FROM idempotency_keys WHERE subject = ? AND `+keyColumn+` = ?
+    `, subject, key).Scan(&held.RequestHash, &held.Status, &held.ContentType, &held.Location, &held.OrderID, &held.Body)
+	return held, false, err
+}
+
+// Save stores the response to the request that claimed
+// subject's key.
+func Save(ctx context.Context, db *sql.DB, subject, key string, resp Response) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE idempotency_keys SET status = ?, content_type = ?, location = ?, order_id = ?, body = ?
+        WHERE subject = ? AND `+keyColumn+` = ?
+    `, resp.Status, resp.ContentType, resp.Location, resp.OrderID, resp.Body, subject, key)
+	return err
+}
+
+// Release frees subject's key for a request that failed in
+// a way a retry might not, so the retry runs afresh.
+func Release(ctx context.Context, db *sql.DB, subject, key string) error {
+	_, err := db.ExecContext(ctx, `
+        DELETE FROM idempotency_keys WHERE subject = ? AND `+keyColumn+` = ?
+    `, subject, key)
+	return err
+}
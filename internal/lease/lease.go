@@ -0,0 +1,182 @@
+// Package lease keeps named, time-limited locks in the database, so the
+// replicas of a deployment can agree on which one runs a job, or a poller,
+// without a coordination service next to them. A holder keeps a lease by
+// acquiring it again before its TTL runs out; when the holder dies the
+// lease expires and the next replica to ask gets it.
+package lease
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"test/internal/logdedup"
+	"test/internal/store"
+)
+
+// MinTTL is the shortest lease; expiry is kept to the second.
+const MinTTL = time.Second
+
+// Acquire takes the lease name for holder for ttl, or extends it when
+// holder already has it, and reports whether holder has it now.
+func Acquire(ctx context.Context, db *sql.DB, name, holder string, ttl time.Duration) (bool, error) {
+	if ttl < MinTTL {
+		return false, fmt.Errorf("lease %s: ttl %s is under %s", name, ttl, MinTTL)
+	}
+	d := store.DialectOf(db)
+	if d == store.MySQL {
+		return acquireMySQL(ctx, db, name, holder, ttl)
+	}
+	res, err := db.ExecContext(ctx, `
+        INSERT INTO leases (name, holder, expires_at)
+        VALUES (?, ?, `+d.SecondsFromNow()+`)
+        ON CONFLICT (name) DO UPDATE SET
+            acquired_at = CASE WHEN leases.holder = excluded.holder
+                THEN leases.acquired_at ELSE CURRENT_TIMESTAMP END,
+            holder = excluded.holder,
+            expires_at = excluded.expires_at
+        WHERE leases.holder = excluded.holder
+            OR leases.expires_at < CURRENT_TIMESTAMP
+    `, name, holder, seconds(ttl))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// acquireMySQL is Acquire without an upsert that can leave the clashing row
+// alone: a lease that is there already is taken with an UPDATE of its own.
+func acquireMySQL(ctx context.Context, db *sql.DB, name, holder string, ttl time.Duration) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+        INSERT IGNORE INTO leases (name, holder, expires_at)
+        VALUES (?, ?, `+store.MySQL.SecondsFromNow()+`)
+    `, name, holder, seconds(ttl))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+	// acquired_at is set first, while holder is still the old one.
+	res, err = db.ExecContext(ctx, `
+        UPDATE leases SET
+            acquired_at = CASE WHEN holder = ? THEN acquired_at ELSE CURRENT_TIMESTAMP END,
+            holder = ?,
+            expires_at = `+store.MySQL.SecondsFromNow()+`
+        WHERE name = ? AND (holder = ? OR expires_at < CURRENT_TIMESTAMP)
+    `, holder, holder, seconds(ttl), name, holder)
+	if err != nil {
+		return false, err
+	}
+	n, err = res.RowsAffected()
+	return n > 0, err
+}
+
+// Renew extends a lease holder still has, and reports false without taking
+// it when the lease has passed to someone else in the meantime.
+func Renew(ctx context.Context, db *sql.DB, name, holder string, ttl time.Duration) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+        UPDATE leases SET expires_at = `+store.DialectOf(db).SecondsFromNow()+`
+        WHERE name = ? AND holder = ?
+    `, seconds(ttl), name, holder)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Release gives up holder's lease, if it still has it, so the next replica
+// doesn't have to wait out the TTL.
+func Release(ctx context.Context, db *sql.DB, name, holder string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM leases WHERE name = ? AND holder = ?`, name, holder)
+	return err
+}
+
+func seconds(ttl time.Duration) int64 {
+	return int64(ttl.Seconds())
+}
+
+// Lease is a lease as stored, possibly expired.
+type Lease struct {
+	Name       string    `json:"name"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Expired    bool      `json:"expired"`
+}
+
+func List(ctx context.Context, db *sql.DB) ([]Lease, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT name, holder, acquired_at, expires_at, expires_at < CURRENT_TIMESTAMP
+        FROM leases
+        ORDER BY name
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leases := []Lease{}
+	for rows.Next() {
+		var l Lease
+		err = rows.Scan(&l.Name, &l.Holder, &l.AcquiredAt, &l.ExpiresAt, &l.Expired)
+		if err != nil {
+			return nil, err
+		}
+		leases = append(leases, l)
+	}
+	return leases, rows.Err()
+}
+
+// Elector tracks whether one holder leads under one lease, logging when
+// that changes. Call Lead before each unit of work.
+type Elector struct {
+	db     *sql.DB
+	name   string
+	holder string
+	ttl    time.Duration
+
+	leading bool
+}
+
+func NewElector(db *sql.DB, name, holder string, ttl time.Duration) *Elector {
+	return &Elector{db: db, name: name, holder: holder, ttl: ttl}
+}
+
+// Lead acquires or extends the lease and reports whether the holder leads.
+// An error to reach the database counts as not leading.
+func (e *Elector) Lead(ctx context.Context) bool {
+	ok, err := Acquire(ctx, e.db, e.name, e.holder, e.ttl)
+	if err != nil {
+		if ctx.Err() == nil {
+			logdedup.Printf("Error acquiring lease %s: %v", e.name, err)
+		}
+		ok = false
+	}
+	if ok != e.leading {
+		if ok {
+			log.Printf("%s took lease %s", e.holder, e.name)
+		} else {
+			log.Printf("%s no longer holds lease %s", e.holder, e.name)
+		}
+		e.leading = ok
+	}
+	return ok
+}
+
+// Resign releases the lease if the holder leads.
+func (e *Elector) Resign() {
+	if !e.leading {
+		return
+	}
+	e.leading = false
+	err := Release(context.Background(), e.db, e.name, e.holder)
+	if err != nil {
+		log.Printf("Error releasing lease %s: %v", e.name, err)
+	}
+}
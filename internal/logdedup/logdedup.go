@@ -0,0 +1,108 @@
+// Package logdedup keeps a line that repeats, such as the same error from
+// every poll while the database is down, from flooding the log: the first
+// occurrences are logged as usual, later ones are counted and summarized
+// once per window instead.
+package logdedup
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Limiter logs each distinct line at most Burst times in a row, then a
+// summary of the repeats it suppressed every Window while it keeps coming.
+// A line that stops repeating for Window is forgotten, with a last summary
+// if any repeats are still unreported.
+type Limiter struct {
+	logger *log.Logger
+	burst  int
+	window time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	lines map[string]*line
+}
+
+type line struct {
+	logged     int
+	suppressed int
+	since      time.Time // of the repeats counted in suppressed
+	last       time.Time
+}
+
+// New returns a Limiter writing to logger. A burst under 1 turns it into a
+// plain logger.
+func New(logger *log.Logger, burst int, window time.Duration) *Limiter {
+	return &Limiter{
+		logger: logger,
+		burst:  burst,
+		window: window,
+		now:    time.Now,
+		lines:  make(map[string]*line),
+	}
+}
+
+var std = New(log.Default(), 3, time.Minute)
+
+// Configure sets the burst and window of the package-level Printf. Call it
+// before anything logs.
+func Configure(burst int, window time.Duration) {
+	std = New(log.Default(), burst, window)
+}
+
+// Printf logs through the package-level Limiter; see Configure.
+func Printf(format string, args ...any) {
+	std.Printf(format, args...)
+}
+
+func (l *Limiter) Printf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if l.burst < 1 {
+		l.logger.Print(msg)
+		return
+	}
+
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.forget(now)
+
+	ln, ok := l.lines[msg]
+	if !ok {
+		ln = &line{}
+		l.lines[msg] = ln
+	}
+	ln.last = now
+	if ln.logged < l.burst {
+		ln.logged++
+		ln.since = now
+		l.logger.Print(msg)
+		return
+	}
+	ln.suppressed++
+	if now.Sub(ln.since) >= l.window {
+		l.summarize(msg, ln, now)
+	}
+}
+
+// forget drops the lines that stopped repeating, summarizing what is left
+// of their repeats.
+func (l *Limiter) forget(now time.Time) {
+	for msg, ln := range l.lines {
+		if now.Sub(ln.last) < l.window {
+			continue
+		}
+		if ln.suppressed > 0 {
+			l.summarize(msg, ln, ln.last)
+		}
+		delete(l.lines, msg)
+	}
+}
+
+func (l *Limiter) summarize(msg string, ln *line, until time.Time) {
+	l.logger.Printf("%s (repeated %d more times in %s)", msg, ln.suppressed, until.Sub(ln.since).Round(time.Second))
+	ln.suppressed = 0
+	ln.since = until
+}
@@ -0,0 +1,39 @@
+package logdedup
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiterSummarizesRepeats(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(log.New(&buf, "", 0), 2, time.Minute)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	// Every 5s for 2 minutes, then quiet.
+	for range 25 {
+		l.Printf("Polling error in %s: %v", "p", "database is locked")
+		now = now.Add(5 * time.Second)
+	}
+	l.Printf("Polling error in %s: %v", "other", "database is locked")
+	now = now.Add(2 * time.Minute)
+	l.Printf("recovered")
+
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		"Polling error in p: database is locked",
+		"Polling error in p: database is locked",
+		"Polling error in p: database is locked (repeated 12 more times in 1m0s)",
+		"Polling error in other: database is locked",
+		"Polling error in p: database is locked (repeated 11 more times in 55s)",
+		"recovered",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("logged:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,171 @@
+// Package logging switches the process's log output between the standard
+// text lines and JSON with ECS field names (@timestamp, log.level, message,
+// trace.id, ...), which ELK and Loki ingest without a parsing stage.
+//
+// Code keeps logging with log.Printf; in JSON mode each line becomes the
+// message of a record. Where a line is about a change or a trace, log it
+// through a Logger instead so the JSON record carries those as fields too.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"test/internal/tracing"
+)
+
+var jsonOutput atomic.Bool
+
+type Config struct {
+	// Format is "text", the default, or "json".
+	Format string
+
+	// File, when set, is where logs go instead of stderr. It is rotated
+	// once it would grow past MaxSizeMB or has been written to for
+	// RotateEvery, whichever comes first; zero turns either off. The
+	// rotation settings are ignored without a File.
+	File        string
+	MaxSizeMB   int
+	RotateEvery time.Duration
+	// Keep is how many rotated files to keep, 0 for all of them.
+	Keep int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+func (c Config) Validate() error {
+	if c.Format != "" && c.Format != "text" && c.Format != "json" {
+		return fmt.Errorf("unknown log format %q (text or json)", c.Format)
+	}
+	if c.MaxSizeMB < 0 || c.RotateEvery < 0 || c.Keep < 0 {
+		return errors.New("log rotation size, interval and keep must not be negative")
+	}
+	return nil
+}
+
+// Setup directs the process's logs as cfg says. The returned Closer closes
+// the log file, if any; call it last thing before exiting.
+func Setup(cfg Config) (io.Closer, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	var w io.Writer = os.Stderr
+	var closer io.Closer = io.NopCloser(nil)
+	if cfg.File != "" {
+		f, err := openRotating(cfg)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = f, f
+		log.SetOutput(w)
+	}
+	return closer, setup(w, cfg.Format)
+}
+
+func setup(w io.Writer, format string) error {
+	switch format {
+	case "", "text":
+		return nil
+	case "json":
+		h := slog.NewJSONHandler(w, &slog.HandlerOptions{ReplaceAttr: ecsAttr})
+		slog.SetDefault(slog.New(levelHandler{h}))
+		jsonOutput.Store(true)
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q (text or json)", format)
+}
+
+// ecsAttr renames slog's built-in keys to their ECS fields.
+func ecsAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		a.Key = "@timestamp"
+	case slog.LevelKey:
+		a.Key = "log.level"
+		a.Value = slog.StringValue(strings.ToLower(a.Value.String()))
+	case slog.MessageKey:
+		a.Key = "message"
+	}
+	return a
+}
+
+// levelHandler gives the lines logged through the log package, which all
+// arrive as info, the level their wording implies.
+type levelHandler struct {
+	slog.Handler
+}
+
+func (h levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelInfo {
+		r.Level = levelOf(r.Message)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h.Handler.WithGroup(name)}
+}
+
+func levelOf(msg string) slog.Level {
+	switch {
+	case strings.HasPrefix(msg, "ALERT:"), strings.HasPrefix(msg, "Error"),
+		strings.Contains(msg, " error "), strings.Contains(msg, " failed"):
+		return slog.LevelError
+	case strings.HasPrefix(msg, "Slow "), strings.HasPrefix(msg, "WARNING"):
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// Logger logs lines with fields that JSON output records separately; text
+// output is the line alone, as log.Printf writes it.
+type Logger struct {
+	attrs []slog.Attr
+}
+
+// With returns a Logger adding attrs to every line.
+func With(attrs ...slog.Attr) Logger {
+	return Logger{attrs: attrs}
+}
+
+// FromContext returns a Logger with the trace.id of the span ctx is in.
+func FromContext(ctx context.Context) Logger {
+	s, ok := tracing.FromContext(ctx)
+	if !ok {
+		return Logger{}
+	}
+	return With(TraceID(s.TraceID))
+}
+
+func (l Logger) With(attrs ...slog.Attr) Logger {
+	return Logger{attrs: append(l.attrs[:len(l.attrs):len(l.attrs)], attrs...)}
+}
+
+func (l Logger) Printf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if !jsonOutput.Load() {
+		log.Print(msg)
+		return
+	}
+	slog.Default().LogAttrs(context.Background(), levelOf(msg), msg, l.attrs...)
+}
+
+func TraceID(id string) slog.Attr { return slog.String("trace.id", id) }
+func OrderID(id int64) slog.Attr  { return slog.Int64("order.id", id) }
+func ChangeID(id int64) slog.Attr { return slog.Int64("change.id", id) }
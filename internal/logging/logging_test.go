@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestJSONOutputUsesECSFields(t *testing.T) {
+	prev, flags, out := slog.Default(), log.Flags(), log.Writer()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		log.SetFlags(flags)
+		log.SetOutput(out)
+		jsonOutput.Store(false)
+	})
+
+	var buf bytes.Buffer
+	err := setup(&buf, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Printf("Polling error in %s: %v", "p", "database is locked")
+	With(ChangeID(7), OrderID(3)).With(TraceID("4bf92f3577b34da6a3ce929d0e0e4736")).
+		Printf("ALERT: poller %s quarantined change %d", "p", 7)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var plain, fields map[string]any
+	for i, v := range []*map[string]any{&plain, &fields} {
+		err = json.Unmarshal([]byte(lines[i]), v)
+		if err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+	}
+
+	if plain["message"] != "Polling error in p: database is locked" || plain["log.level"] != "error" || plain["@timestamp"] == nil {
+		t.Errorf("log.Printf line = %s", lines[0])
+	}
+	if fields["change.id"] != 7.0 || fields["order.id"] != 3.0 ||
+		fields["trace.id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || fields["log.level"] != "error" {
+		t.Errorf("Logger line = %s", lines[1])
+	}
+
+	if setup(&buf, "xml") == nil {
+		t.Error("setup accepted an unknown format")
+	}
+}
@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupLayout stamps rotated files; it sorts in time order and is safe in
+// file names.
+const backupLayout = "2006-01-02T15-04-05.000"
+
+// rotatingFile is a log file that moves itself aside, as
+// <name>-<time><ext>, once it would grow past maxSize or has been open for
+// every, and keeps only the newest keep of those.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	every    time.Duration
+	keep     int
+	compress bool
+	now      func() time.Time
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	openedAt time.Time
+
+	// compressing tracks the gzip of the last rotated file, which runs
+	// outside mu so logging doesn't wait on it. It reports errors straight
+	// to stderr: logging them would write to this file.
+	compressing sync.WaitGroup
+}
+
+func openRotating(cfg Config) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:     cfg.File,
+		maxSize:  int64(cfg.MaxSizeMB) << 20,
+		every:    cfg.RotateEvery,
+		keep:     cfg.Keep,
+		compress: cfg.Compress,
+		now:      time.Now,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.openedAt = f, info.Size(), r.now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && (r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize ||
+		r.every > 0 && r.now().Sub(r.openedAt) >= r.every) {
+		err := r.rotate()
+		if err != nil {
+			// Keep logging to the file we have rather than lose lines.
+			fmt.Fprintf(os.Stderr, "Error rotating log file %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	ext := filepath.Ext(r.path)
+	backup := strings.TrimSuffix(r.path, ext) + "-" + r.now().Format(backupLayout) + ext
+	err := r.f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(r.path, backup)
+	if err != nil {
+		// The old file is closed: reopen it so logging goes on.
+		return fmt.Errorf("%w (reopen: %v)", err, r.open())
+	}
+	err = r.open()
+	if err != nil {
+		return err
+	}
+
+	r.compressing.Wait()
+	r.compressing.Add(1)
+	go func() {
+		defer r.compressing.Done()
+		if r.compress {
+			err := gzipFile(backup)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error compressing log file %s: %v\n", backup, err)
+			}
+		}
+		r.prune()
+	}()
+	return nil
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune removes all but the newest keep rotated files; keep 0 keeps all.
+func (r *rotatingFile) prune() {
+	if r.keep <= 0 {
+		return
+	}
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(r.path, ext) + "-"
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, m := range matches {
+		stamp, ok := strings.CutSuffix(strings.TrimSuffix(m, ".gz"), ext)
+		if !ok {
+			continue
+		}
+		_, err := time.Parse(backupLayout, strings.TrimPrefix(stamp, prefix))
+		if err == nil {
+			backups = append(backups, m)
+		}
+	}
+	// The stamp sorts by time, and a file's .gz sorts after it.
+	slices.Sort(backups)
+	for _, b := range backups[:max(0, len(backups)-r.keep)] {
+		err := os.Remove(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing old log file %s: %v\n", b, err)
+		}
+	}
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.compressing.Wait()
+	return r.f.Close()
+}
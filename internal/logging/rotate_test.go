@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileRotatesBySizeAndAgeAndKeepsTheNewest(t *testing.T) {
+	dir := t.TempDir()
+	r, err := openRotating(Config{File: filepath.Join(dir, "server.log"), RotateEvery: time.Hour, Keep: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.maxSize = 100
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	line := strings.Repeat("x", 39) + "\n"
+	for range 7 { // rotates after every second line: three times
+		_, err = io.WriteString(r, line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+	now = now.Add(time.Hour) // and once for age
+	io.WriteString(r, "last\n")
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{
+		"server-2026-01-01T00-00-06.000.log.gz",
+		"server-2026-01-01T01-00-07.000.log.gz",
+		"server.log",
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+
+	current, _ := os.ReadFile(filepath.Join(dir, "server.log"))
+	if string(current) != "last\n" {
+		t.Errorf("server.log = %q, want the last line", current)
+	}
+	f, err := os.Open(filepath.Join(dir, want[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := io.ReadAll(zr)
+	if string(rotated) != line {
+		t.Errorf("%s = %q, want the seventh line", want[1], rotated)
+	}
+}
@@ -0,0 +1,91 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"test/internal/audit"
+)
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	OrderID   int64
+	Tenant    string
+	Actor     string
+	Operation string
+	Since     time.Time
+	Until     time.Time
+	Processed *bool
+
+	// After is the keyset cursor: only entries with a higher id match.
+	After int64
+}
+
+// QueryAudit returns up to limit entries matching f, oldest first, and the
+// cursor for the next page, 0 when this is the last one.
+func QueryAudit(ctx context.Context, db *sql.DB, f AuditFilter, limit int) ([]audit.Entry, int64, error) {
+	query := `
+        SELECT id, order_id, change_type, priority, processed, created_at, actor, patch, tenant_id
+        FROM priority_changes
+        WHERE id > ?
+    `
+	args := []any{f.After}
+	if f.OrderID > 0 {
+		query += ` AND order_id = ?`
+		args = append(args, f.OrderID)
+	}
+	if f.Tenant != "" {
+		query += ` AND tenant_id = ?`
+		args = append(args, f.Tenant)
+	}
+	if f.Actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, f.Actor)
+	}
+	if f.Operation != "" {
+		query += ` AND change_type = ?`
+		args = append(args, f.Operation)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.Since.UTC().Format(time.DateTime))
+	}
+	if !f.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, f.Until.UTC().Format(time.DateTime))
+	}
+	if f.Processed != nil {
+		query += ` AND processed = ?`
+		args = append(args, *f.Processed)
+	}
+	// One extra row tells whether there is a next page.
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []audit.Entry{}
+	for rows.Next() {
+		var e audit.Entry
+		err = rows.Scan(&e.ID, &e.OrderID, &e.ChangeType, &e.Priority, &e.Processed, &e.CreatedAt, &e.Actor, (*[]byte)(&e.Patch), &e.Tenant)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+		return entries, entries[limit-1].ID, nil
+	}
+	return entries, 0, nil
+}
@@ -0,0 +1,168 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"test/internal/lease"
+)
+
+type Backup struct {
+	ID         int64     `json:"id"`
+	Path       string    `json:"path"`
+	SizeBytes  int64     `json:"sizeBytes"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// TakeBackup writes a consistent snapshot of db into dir with SQLite's
+// online backup API, so writers only wait for the page copy, not for the
+// whole run. The attempt is recorded in backups whether it worked or not.
+func TakeBackup(ctx context.Context, db *sql.DB, dir string) (Backup, error) {
+	b := Backup{StartedAt: time.Now().UTC()}
+	b.Path = filepath.Join(dir, "orders-"+b.StartedAt.Format("20060102T150405.000Z")+".db")
+
+	err := snapshot(ctx, db, b.Path)
+	b.FinishedAt = time.Now().UTC()
+	if err != nil {
+		b.Error = err.Error()
+	} else if fi, statErr := os.Stat(b.Path); statErr == nil {
+		b.SizeBytes = fi.Size()
+	}
+
+	result, recErr := db.ExecContext(ctx, `
+        INSERT INTO backups (path, size_bytes, started_at, finished_at, error)
+        VALUES (?, ?, ?, ?, ?)
+    `, b.Path, b.SizeBytes, b.StartedAt, b.FinishedAt, b.Error)
+	if recErr != nil {
+		return b, errors.Join(err, fmt.Errorf("recording backup: %w", recErr))
+	}
+	b.ID, _ = result.LastInsertId()
+	return b, err
+}
+
+// snapshot copies db to path through a temporary file, so path only ever
+// holds a complete backup.
+func snapshot(ctx context.Context, db *sql.DB, path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".partial"
+	defer os.Remove(tmp)
+
+	dest, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	err = destConn.Raw(func(destRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			d, ok := destRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("backup needs a SQLite destination, got %T", destRaw)
+			}
+			s, ok := srcRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("online backup is only supported for SQLite, got %T", srcRaw)
+			}
+
+			bk, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+			_, err = bk.Step(-1)
+			return errors.Join(err, bk.Finish())
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = destConn.Close()
+	if err != nil {
+		return err
+	}
+	err = dest.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ListBackups returns the most recent backup attempts, newest first.
+func ListBackups(db *sql.DB, limit int) ([]Backup, error) {
+	rows, err := db.Query(`
+        SELECT id, path, size_bytes, started_at, finished_at, error
+        FROM backups
+        ORDER BY id DESC
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backups := []Backup{}
+	for rows.Next() {
+		var b Backup
+		err = rows.Scan(&b.ID, &b.Path, &b.SizeBytes, &b.StartedAt, &b.FinishedAt, &b.Error)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, b)
+	}
+	return backups, rows.Err()
+}
+
+// RunBackups takes a backup into dir every interval until ctx is cancelled.
+// With a holder, only the replica holding the "backup" lease takes them.
+func RunBackups(ctx context.Context, db *sql.DB, dir string, interval time.Duration, holder string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var leader *lease.Elector
+	if holder != "" {
+		leader = lease.NewElector(db, "backup", holder, leaseTTL(interval))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if leader != nil && !leader.Lead(ctx) {
+				continue
+			}
+			b, err := TakeBackup(ctx, db, dir)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Scheduled backup failed: %v", err)
+				}
+				continue
+			}
+			log.Printf("Backed up database to %s (%d bytes)", b.Path, b.SizeBytes)
+		}
+	}
+}
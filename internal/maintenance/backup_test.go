@@ -0,0 +1,101 @@
+package maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"test/internal/store"
+)
+
+func TestTakeBackup(t *testing.T) {
+	db := openTestDB(t)
+	ids := chainedChanges(t, db)
+	dir := filepath.Join(t.TempDir(), "backups")
+
+	b, err := TakeBackup(context.Background(), db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(b.Path) != dir || b.SizeBytes == 0 {
+		t.Errorf("backup = %+v, want a non-empty file in %s", b, dir)
+	}
+
+	snap, err := store.Open(b.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	var changes int
+	err = snap.QueryRow(`SELECT COUNT(*) FROM priority_changes`).Scan(&changes)
+	if err != nil || changes != len(ids) {
+		t.Errorf("snapshot has %d changes (err %v), want %d", changes, err, len(ids))
+	}
+
+	backups, err := ListBackups(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].ID != b.ID || backups[0].Path != b.Path ||
+		backups[0].SizeBytes != b.SizeBytes || backups[0].Error != "" {
+		t.Errorf("backups = %+v, want %+v recorded", backups, b)
+	}
+}
+
+func TestTakeBackupRecordsFailures(t *testing.T) {
+	db := openTestDB(t)
+	// A file where the backup directory should be.
+	dir := filepath.Join(t.TempDir(), "backups")
+	err := os.WriteFile(dir, nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = TakeBackup(context.Background(), db, dir)
+	if err == nil {
+		t.Fatal("TakeBackup into a file succeeded")
+	}
+	backups, err := ListBackups(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Error == "" || backups[0].SizeBytes != 0 {
+		t.Errorf("backups = %+v, want the failure recorded", backups)
+	}
+}
+
+func TestRunBackupsTakesScheduledBackups(t *testing.T) {
+	db := openTestDB(t)
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		RunBackups(ctx, db, dir, 20*time.Millisecond, "replica-1")
+	}()
+
+	var backups []Backup
+	deadline := time.Now().Add(5 * time.Second)
+	for len(backups) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		backups, err = ListBackups(db, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	<-done
+
+	if len(backups) < 2 {
+		t.Fatalf("%d backups taken, want scheduled ones", len(backups))
+	}
+	for _, b := range backups {
+		_, err := os.Stat(b.Path)
+		if b.Error != "" || err != nil {
+			t.Errorf("backup %+v: file %v", b, err)
+		}
+	}
+}
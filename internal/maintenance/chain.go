@@ -0,0 +1,169 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"test/internal/audit"
+)
+
+// ChainGap is a run of changes removed after they were chained, detected by
+// the next change's prev_hash no longer matching. Expired is set when
+// retention runs deleted the whole run; any other gap is a deletion to
+// account for.
+type ChainGap struct {
+	FromID  int64 `json:"fromId"`
+	ToID    int64 `json:"toId"`
+	Expired bool  `json:"expired"`
+}
+
+// ChainBreak is a change whose recorded hash no longer follows from its
+// contents and its predecessor: it, or the change before it, was modified.
+type ChainBreak struct {
+	ID     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type ChainReport struct {
+	OK       bool         `json:"ok"`
+	Checked  int64        `json:"checked"`
+	Unsealed int64        `json:"unsealed"`
+	Gaps     []ChainGap   `json:"gaps"`
+	Breaks   []ChainBreak `json:"breaks"`
+}
+
+// VerifyChain walks the change feed in id order and checks every change's
+// hash against its contents and the change before it. The chain is OK only
+// if nothing is broken and every gap lies within what retention runs
+// deleted. A chain only proves the history up to its newest link: changes
+// removed from the very end, or not yet chained (Unsealed), can't be told
+// apart from ones never written.
+func VerifyChain(ctx context.Context, db *sql.DB) (ChainReport, error) {
+	c := newChainChecker()
+
+	expired, err := expiredChanges(ctx, db)
+	if err != nil {
+		return c.report, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, order_id, change_type, priority, created_at, actor, patch, tenant_id, prev_hash, hash
+        FROM priority_changes
+        ORDER BY id ASC
+    `)
+	if err != nil {
+		return c.report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e audit.Entry
+		var prev, hash string
+		err = rows.Scan(&e.ID, &e.OrderID, &e.ChangeType, &e.Priority, &e.CreatedAt, &e.Actor, (*[]byte)(&e.Patch), &e.Tenant, &prev, &hash)
+		if err != nil {
+			return c.report, err
+		}
+		c.add(e, prev, hash)
+	}
+	err = rows.Err()
+	if err != nil {
+		return c.report, err
+	}
+
+	report := c.done()
+	for i, g := range report.Gaps {
+		report.Gaps[i].Expired = covers(expired, g)
+		report.OK = report.OK && report.Gaps[i].Expired
+	}
+	return report, nil
+}
+
+// expiredChanges returns the spans of changes retention runs deleted,
+// merged where they touch, in id order.
+func expiredChanges(ctx context.Context, db *sql.DB) ([]ChainGap, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT from_id, to_id
+        FROM retention_runs
+        WHERE table_name = 'priority_changes' AND deleted > 0 AND from_id > 0
+        ORDER BY from_id
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var spans []ChainGap
+	for rows.Next() {
+		var g ChainGap
+		err = rows.Scan(&g.FromID, &g.ToID)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(spans); n > 0 && g.FromID <= spans[n-1].ToID+1 {
+			spans[n-1].ToID = max(spans[n-1].ToID, g.ToID)
+			continue
+		}
+		spans = append(spans, g)
+	}
+	return spans, rows.Err()
+}
+
+// covers reports whether one of spans contains all of g.
+func covers(spans []ChainGap, g ChainGap) bool {
+	for _, s := range spans {
+		if s.FromID <= g.FromID && g.ToID <= s.ToID {
+			return true
+		}
+	}
+	return false
+}
+
+// chainChecker verifies a chain one change at a time, in id order.
+type chainChecker struct {
+	report   ChainReport
+	lastID   int64
+	lastHash string
+}
+
+func newChainChecker() *chainChecker {
+	return &chainChecker{report: ChainReport{Gaps: []ChainGap{}, Breaks: []ChainBreak{}}}
+}
+
+// anchor makes the chain start after change id with the given hash, for
+// checking a slice of it.
+func (c *chainChecker) anchor(id int64, hash string) {
+	c.lastID, c.lastHash = id, hash
+}
+
+func (c *chainChecker) add(e audit.Entry, prev, hash string) {
+	c.report.Checked++
+	if hash == "" {
+		c.report.Unsealed++
+		return
+	}
+	if c.report.Unsealed > 0 {
+		c.breakAt(e.ID, "chained after unchained changes")
+	}
+
+	if audit.Hash(prev, e) != hash {
+		c.breakAt(e.ID, "contents don't match the recorded hash")
+	}
+	if prev != c.lastHash {
+		if e.ID == c.lastID+1 {
+			c.breakAt(e.ID, fmt.Sprintf("prev_hash doesn't match change %d", c.lastID))
+		} else {
+			c.report.Gaps = append(c.report.Gaps, ChainGap{FromID: c.lastID + 1, ToID: e.ID - 1})
+		}
+	}
+	c.lastID, c.lastHash = e.ID, hash
+}
+
+func (c *chainChecker) breakAt(id int64, reason string) {
+	c.report.Breaks = append(c.report.Breaks, ChainBreak{ID: id, Reason: reason})
+}
+
+func (c *chainChecker) done() ChainReport {
+	c.report.OK = len(c.report.Breaks) == 0
+	return c.report
+}
@@ -0,0 +1,167 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"test/internal/store"
+)
+
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dsn := "file:" + filepath.Join(tb.TempDir(), "orders.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := store.Open(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	err = store.Migrate(db)
+	if err != nil {
+		tb.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// chainedChanges writes four processed, chained changes, the first two
+// three days old, and returns their ids.
+func chainedChanges(t *testing.T, db *sql.DB) []int64 {
+	t.Helper()
+	ctx := context.Background()
+	orders := store.New(db, nil, nil)
+	defer orders.Close()
+
+	for range 4 {
+		id, err := orders.CreateOrder(ctx, store.Order{
+			CustomerName: "Ada", ProductName: "ninja", Quantity: 2, ShippingAddress: "1 Test Street", Priority: "low",
+		})
+		if err == nil {
+			err = orders.ChangePriority(ctx, store.PriorityChange{OrderID: id, Priority: "high"})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []int64
+	rows, err := db.Query(`SELECT id FROM priority_changes ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	// Backdating changes their contents, so the chain is built again.
+	_, err = db.Exec(`UPDATE priority_changes SET processed = TRUE, prev_hash = '', hash = ''`)
+	if err == nil {
+		_, err = db.Exec(`UPDATE priority_changes SET created_at = datetime('now', '-3 days') WHERE id <= ?`, ids[1])
+	}
+	if err == nil {
+		err = store.NewQueries(db).ChainChanges(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestVerifyChain(t *testing.T) {
+	expire := func(t *testing.T, db *sql.DB) {
+		_, err := ApplyPolicy(context.Background(), db, Policy{Table: "priority_changes", KeepFor: 48 * time.Hour, Action: Archive}, t.TempDir(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec := func(query string, arg func(ids []int64) int64) func(*testing.T, *sql.DB, []int64) {
+		return func(t *testing.T, db *sql.DB, ids []int64) {
+			_, err := db.Exec(query, arg(ids))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	third := func(ids []int64) int64 { return ids[2] }
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(*testing.T, *sql.DB, []int64)
+		ok     bool
+		gaps   func(ids []int64) []ChainGap
+		breaks func(ids []int64) []int64
+	}{
+		{
+			name:   "intact",
+			tamper: func(*testing.T, *sql.DB, []int64) {},
+			ok:     true,
+		},
+		{
+			name:   "edited",
+			tamper: exec(`UPDATE priority_changes SET priority = 'low' WHERE id = ?`, third),
+			breaks: func(ids []int64) []int64 { return []int64{ids[2]} },
+		},
+		{
+			name:   "deleted",
+			tamper: exec(`DELETE FROM priority_changes WHERE id = ?`, third),
+			gaps:   func(ids []int64) []ChainGap { return []ChainGap{{FromID: ids[2], ToID: ids[2]}} },
+		},
+		{
+			name:   "retention",
+			tamper: func(t *testing.T, db *sql.DB, _ []int64) { expire(t, db) },
+			ok:     true,
+			gaps:   func(ids []int64) []ChainGap { return []ChainGap{{FromID: ids[0], ToID: ids[1], Expired: true}} },
+		},
+		{
+			name: "deleted after retention",
+			tamper: func(t *testing.T, db *sql.DB, ids []int64) {
+				expire(t, db)
+				exec(`DELETE FROM priority_changes WHERE id = ?`, third)(t, db, ids)
+			},
+			gaps: func(ids []int64) []ChainGap { return []ChainGap{{FromID: ids[0], ToID: ids[2]}} },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := openTestDB(t)
+			ids := chainedChanges(t, db)
+			tc.tamper(t, db, ids)
+
+			report, err := VerifyChain(context.Background(), db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.OK != tc.ok {
+				t.Errorf("OK = %v, want %v: %+v", report.OK, tc.ok, report)
+			}
+			wantGaps := []ChainGap{}
+			if tc.gaps != nil {
+				wantGaps = tc.gaps(ids)
+			}
+			if !slices.Equal(report.Gaps, wantGaps) {
+				t.Errorf("gaps = %+v, want %+v", report.Gaps, wantGaps)
+			}
+			var broken, wantBroken []int64
+			for _, b := range report.Breaks {
+				broken = append(broken, b.ID)
+			}
+			if tc.breaks != nil {
+				wantBroken = tc.breaks(ids)
+			}
+			if !slices.Equal(broken, wantBroken) {
+				t.Errorf("breaks = %+v, want changes %v", report.Breaks, wantBroken)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package maintenance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"test/internal/audit"
+)
+
+// Audit export formats.
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// An export bundle is a directory holding the exported entries, a manifest
+// describing them and a detached Ed25519 signature over the manifest.
+const (
+	ManifestFile  = "manifest.json"
+	SignatureFile = "manifest.json.sig"
+)
+
+// checkpointEvery is how many entries apart the manifest records the chain
+// hash, so an export can be matched against the database or other exports
+// without comparing every row.
+const checkpointEvery = 1000
+
+var csvHeader = []string{"id", "order_id", "change_type", "priority", "processed", "created_at", "actor", "patch", "prev_hash", "hash", "tenant"}
+
+// ExportEntry is an audit entry with its chain hashes, so the chain can be
+// rechecked outside the database.
+type ExportEntry struct {
+	audit.Entry
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+type Checkpoint struct {
+	ID   int64  `json:"id"`
+	Hash string `json:"hash"`
+}
+
+type ExportManifest struct {
+	Format      string       `json:"format"`
+	File        string       `json:"file"`
+	SHA256      string       `json:"sha256"`
+	Rows        int64        `json:"rows"`
+	FirstID     int64        `json:"firstId"`
+	LastID      int64        `json:"lastId"`
+	Checkpoints []Checkpoint `json:"checkpoints"`
+	ExportedAt  time.Time    `json:"exportedAt"`
+}
+
+// ExportAudit writes the chained changes with ids in r to a new bundle in
+// dir, signed with key. Only r's id bounds apply.
+func ExportAudit(ctx context.Context, db *sql.DB, dir, format string, r Range, key ed25519.PrivateKey) (ExportManifest, error) {
+	m := ExportManifest{
+		Format:      format,
+		File:        "audit." + format,
+		Checkpoints: []Checkpoint{},
+		ExportedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	if format != FormatJSONL && format != FormatCSV {
+		return m, fmt.Errorf("export format must be %s or %s, got %q", FormatJSONL, FormatCSV, format)
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return m, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, m.File), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+
+	query := `
+        SELECT id, order_id, change_type, priority, processed, created_at, actor, patch, tenant_id, prev_hash, hash
+        FROM priority_changes
+        WHERE hash != '' AND id >= ?
+    `
+	args := []any{r.FromID}
+	if r.ToID > 0 {
+		query += ` AND id <= ?`
+		args = append(args, r.ToID)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY id`, args...)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+
+	sum := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(f, sum))
+	w, err := newEntryWriter(bw, format)
+	if err != nil {
+		return m, err
+	}
+	var last ExportEntry
+	for rows.Next() {
+		var e ExportEntry
+		err = rows.Scan(&e.ID, &e.OrderID, &e.ChangeType, &e.Priority, &e.Processed, &e.CreatedAt, &e.Actor, (*[]byte)(&e.Patch), &e.Tenant, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return m, err
+		}
+		err = w.write(e)
+		if err != nil {
+			return m, err
+		}
+
+		m.Rows++
+		if m.FirstID == 0 {
+			m.FirstID = e.ID
+		}
+		if m.Rows%checkpointEvery == 0 {
+			m.Checkpoints = append(m.Checkpoints, Checkpoint{ID: e.ID, Hash: e.Hash})
+		}
+		last = e
+	}
+	err = rows.Err()
+	if err != nil {
+		return m, err
+	}
+	m.LastID = last.ID
+	if m.Rows%checkpointEvery != 0 {
+		m.Checkpoints = append(m.Checkpoints, Checkpoint{ID: last.ID, Hash: last.Hash})
+	}
+
+	err = w.flush()
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return m, err
+	}
+	m.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+	manifest = append(manifest, '\n')
+	err = os.WriteFile(filepath.Join(dir, ManifestFile), manifest, 0o644)
+	if err != nil {
+		return m, err
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest)) + "\n"
+	return m, os.WriteFile(filepath.Join(dir, SignatureFile), []byte(sig), 0o644)
+}
+
+type entryWriter struct {
+	jsonl *json.Encoder
+	csv   *csv.Writer
+}
+
+func newEntryWriter(w io.Writer, format string) (*entryWriter, error) {
+	if format == FormatCSV {
+		cw := csv.NewWriter(w)
+		return &entryWriter{csv: cw}, cw.Write(csvHeader)
+	}
+	return &entryWriter{jsonl: json.NewEncoder(w)}, nil
+}
+
+func (w *entryWriter) write(e ExportEntry) error {
+	if w.jsonl != nil {
+		return w.jsonl.Encode(e)
+	}
+	return w.csv.Write([]string{
+		strconv.FormatInt(e.ID, 10),
+		strconv.FormatInt(e.OrderID, 10),
+		e.ChangeType,
+		e.Priority,
+		strconv.FormatBool(e.Processed),
+		e.CreatedAt.UTC().Format(time.RFC3339),
+		e.Actor,
+		string(e.Patch),
+		e.PrevHash,
+		e.Hash,
+		e.Tenant,
+	})
+}
+
+func (w *entryWriter) flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// VerifyBundle checks an export bundle as an external auditor would: the
+// manifest's signature against pub, the entries file against the manifest,
+// and the hash chain through the entries and their checkpoints. The chain
+// is taken to start at the first entry's prev_hash. A bundle that fails
+// the signature or manifest checks returns an error; chain problems are in
+// the report.
+func VerifyBundle(dir string, pub ed25519.PublicKey) (ChainReport, error) {
+	c := newChainChecker()
+
+	manifest, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return c.report, err
+	}
+	encoded, err := os.ReadFile(filepath.Join(dir, SignatureFile))
+	if err != nil {
+		return c.report, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return c.report, fmt.Errorf("%s: %w", SignatureFile, err)
+	}
+	if !ed25519.Verify(pub, manifest, sig) {
+		return c.report, errors.New("manifest signature is not valid for this public key")
+	}
+
+	var m ExportManifest
+	err = json.Unmarshal(manifest, &m)
+	if err != nil {
+		return c.report, fmt.Errorf("%s: %w", ManifestFile, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(m.File)))
+	if err != nil {
+		return c.report, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != m.SHA256 {
+		return c.report, fmt.Errorf("%s doesn't match the digest in the manifest", m.File)
+	}
+
+	entries, err := readEntries(data, m.Format)
+	if err != nil {
+		return c.report, fmt.Errorf("%s: %w", m.File, err)
+	}
+	if int64(len(entries)) != m.Rows {
+		return c.report, fmt.Errorf("%s has %d entries, the manifest %d", m.File, len(entries), m.Rows)
+	}
+	if len(entries) == 0 {
+		return c.done(), nil
+	}
+	if entries[0].ID != m.FirstID || entries[len(entries)-1].ID != m.LastID {
+		return c.report, fmt.Errorf("%s doesn't span changes %d-%d as the manifest says", m.File, m.FirstID, m.LastID)
+	}
+
+	checkpoints := make(map[int64]string, len(m.Checkpoints))
+	for _, cp := range m.Checkpoints {
+		checkpoints[cp.ID] = cp.Hash
+	}
+	c.anchor(entries[0].ID-1, entries[0].PrevHash)
+	for _, e := range entries {
+		c.add(e.Entry, e.PrevHash, e.Hash)
+		if want, ok := checkpoints[e.ID]; ok && want != e.Hash {
+			c.breakAt(e.ID, "hash doesn't match the manifest checkpoint")
+		}
+		delete(checkpoints, e.ID)
+	}
+	for id := range checkpoints {
+		c.breakAt(id, "manifest checkpoint has no entry")
+	}
+	return c.done(), nil
+}
+
+func readEntries(data []byte, format string) ([]ExportEntry, error) {
+	var entries []ExportEntry
+	switch format {
+	case FormatJSONL:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var e ExportEntry
+			err := dec.Decode(&e)
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	case FormatCSV:
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, errors.New("missing CSV header")
+		}
+		for _, rec := range records[1:] {
+			e, err := parseCSVEntry(rec)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+		return entries, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+func parseCSVEntry(rec []string) (ExportEntry, error) {
+	var e ExportEntry
+	// Bundles exported before tenants existed lack the last column.
+	if len(rec) != len(csvHeader) && len(rec) != len(csvHeader)-1 {
+		return e, fmt.Errorf("want %d columns, got %d", len(csvHeader), len(rec))
+	}
+	var err error
+	e.ID, err = strconv.ParseInt(rec[0], 10, 64)
+	if err != nil {
+		return e, err
+	}
+	e.OrderID, err = strconv.ParseInt(rec[1], 10, 64)
+	if err != nil {
+		return e, err
+	}
+	e.ChangeType, e.Priority = rec[2], rec[3]
+	e.Processed, err = strconv.ParseBool(rec[4])
+	if err != nil {
+		return e, err
+	}
+	e.CreatedAt, err = time.Parse(time.RFC3339, rec[5])
+	if err != nil {
+		return e, err
+	}
+	e.Actor = rec[6]
+	if rec[7] != "" {
+		e.Patch = json.RawMessage(rec[7])
+	}
+	e.PrevHash, e.Hash = rec[8], rec[9]
+	if len(rec) > 10 {
+		e.Tenant = rec[10]
+	}
+	return e, nil
+}
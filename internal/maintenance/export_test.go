@@ -0,0 +1,233 @@
+package maintenance
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// exportBundle exports the chained changes of a fresh database in format
+// and returns the bundle directory, the signing key and the change ids.
+func exportBundle(t *testing.T, format string) (string, ed25519.PrivateKey, []int64) {
+	t.Helper()
+	db := openTestDB(t)
+	ids := chainedChanges(t, db)
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "bundle")
+	_, err = ExportAudit(context.Background(), db, dir, format, Range{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, key, ids
+}
+
+func readManifest(t *testing.T, dir string) ExportManifest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m ExportManifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// signManifest writes m and its signature under key, as whoever holds the
+// key could.
+func signManifest(t *testing.T, dir string, m ExportManifest, key ed25519.PrivateKey) {
+	t.Helper()
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest))
+	err = os.WriteFile(filepath.Join(dir, ManifestFile), manifest, 0o644)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, SignatureFile), []byte(sig), 0o644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyBundle(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			dir, key, ids := exportBundle(t, format)
+
+			report, err := VerifyBundle(dir, key.Public().(ed25519.PublicKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.OK || report.Checked != int64(len(ids)) {
+				t.Errorf("report = %+v, want OK with %d changes checked", report, len(ids))
+			}
+			m := readManifest(t, dir)
+			if m.Rows != int64(len(ids)) || m.FirstID != ids[0] || m.LastID != ids[len(ids)-1] {
+				t.Errorf("manifest covers %d changes %d-%d, want %d changes %d-%d",
+					m.Rows, m.FirstID, m.LastID, len(ids), ids[0], ids[len(ids)-1])
+			}
+		})
+	}
+}
+
+func TestVerifyBundleRejectsTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func(t *testing.T, dir string, key ed25519.PrivateKey) ed25519.PublicKey
+	}{
+		{
+			name: "other key",
+			tamper: func(t *testing.T, _ string, _ ed25519.PrivateKey) ed25519.PublicKey {
+				pub, _, err := ed25519.GenerateKey(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return pub
+			},
+		},
+		{
+			name: "manifest edited",
+			tamper: func(t *testing.T, dir string, key ed25519.PrivateKey) ed25519.PublicKey {
+				path := filepath.Join(dir, ManifestFile)
+				data, err := os.ReadFile(path)
+				if err == nil {
+					err = os.WriteFile(path, bytes.Replace(data, []byte(`"rows": `), []byte(`"rows": 1`), 1), 0o644)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				return key.Public().(ed25519.PublicKey)
+			},
+		},
+		{
+			name: "signature missing",
+			tamper: func(t *testing.T, dir string, key ed25519.PrivateKey) ed25519.PublicKey {
+				err := os.Remove(filepath.Join(dir, SignatureFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return key.Public().(ed25519.PublicKey)
+			},
+		},
+		{
+			name: "entries edited",
+			tamper: func(t *testing.T, dir string, key ed25519.PrivateKey) ed25519.PublicKey {
+				path := filepath.Join(dir, readManifest(t, dir).File)
+				data, err := os.ReadFile(path)
+				if err == nil {
+					err = os.WriteFile(path, bytes.Replace(data, []byte("high"), []byte("low"), 1), 0o644)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				return key.Public().(ed25519.PublicKey)
+			},
+		},
+		{
+			name: "last entry dropped and manifest resigned",
+			tamper: func(t *testing.T, dir string, key ed25519.PrivateKey) ed25519.PublicKey {
+				m := readManifest(t, dir)
+				path := filepath.Join(dir, m.File)
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries, err := readEntries(data, m.Format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m.Rows--
+				m.LastID = entries[len(entries)-2].ID
+				writeEntries(t, path, m.Format, entries[:len(entries)-1])
+				data, err = os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum := sha256.Sum256(data)
+				m.SHA256 = hex.EncodeToString(sum[:])
+				signManifest(t, dir, m, key)
+				return key.Public().(ed25519.PublicKey)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, key, _ := exportBundle(t, FormatJSONL)
+			pub := tc.tamper(t, dir, key)
+
+			report, err := VerifyBundle(dir, pub)
+			if err == nil && report.OK {
+				t.Errorf("VerifyBundle accepted the tampered bundle: %+v", report)
+			}
+		})
+	}
+}
+
+// TestVerifyBundleChecksChain resigns a bundle whose entry was edited, as
+// whoever holds the key could: the signature and digest then pass, and the
+// chain has to catch it.
+func TestVerifyBundleChecksChain(t *testing.T) {
+	dir, key, ids := exportBundle(t, FormatJSONL)
+	m := readManifest(t, dir)
+	path := filepath.Join(dir, m.File)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readEntries(data, m.Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[1].Priority = "medium"
+	writeEntries(t, path, m.Format, entries)
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	m.SHA256 = hex.EncodeToString(sum[:])
+	signManifest(t, dir, m, key)
+
+	report, err := VerifyBundle(dir, key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK || len(report.Breaks) == 0 || report.Breaks[0].ID != ids[1] {
+		t.Errorf("report = %+v, want the chain broken at change %d", report, ids[1])
+	}
+}
+
+func writeEntries(t *testing.T, path, format string, entries []ExportEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newEntryWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		err = w.write(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.flush()
+	if err == nil {
+		err = os.WriteFile(path, buf.Bytes(), 0o644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
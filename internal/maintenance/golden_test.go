@@ -0,0 +1,85 @@
+package maintenance
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"test/internal/store"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file when the
+// test runs with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.MkdirAll("testdata", 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, got, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed.\n got: %s\nwant: %s", path, got, want)
+	}
+}
+
+// exportedAt is the one manifest field that changes from run to run.
+var exportedAt = regexp.MustCompile(`"exportedAt": "[^"]*"`)
+
+// TestGoldenExportBundle pins the entries file and the manifest of an
+// export in each format. The signature covers exportedAt, so it isn't
+// pinned.
+func TestGoldenExportBundle(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			db := openTestDB(t)
+			chainedChanges(t, db)
+			_, err := db.Exec(`UPDATE priority_changes SET created_at = '2024-01-02 03:04:05', prev_hash = '', hash = ''`)
+			if err == nil {
+				err = store.NewQueries(db).ChainChanges(context.Background())
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+			dir := filepath.Join(t.TempDir(), "bundle")
+			m, err := ExportAudit(context.Background(), db, dir, format, Range{}, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadFile(filepath.Join(dir, m.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "export."+format+".golden", entries)
+
+			manifest, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest = exportedAt.ReplaceAll(manifest, []byte(`"exportedAt": "2024-01-02T03:04:05Z"`))
+			checkGolden(t, "export."+format+".manifest.golden", manifest)
+		})
+	}
+}
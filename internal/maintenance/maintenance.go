@@ -0,0 +1,272 @@
+// Package maintenance holds the operational queries shared by the server's
+// /admin endpoints and cmd/adminctl, so both see the same definitions of
+// "unprocessed", "dead letter", retention and replay ranges.
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"test/internal/events"
+)
+
+var ErrChangeNotFound = errors.New("change not found")
+
+const (
+	// StateUnprocessed is every change no handler has completed yet.
+	StateUnprocessed = "unprocessed"
+
+	// StateDeadLetter is the changes in change_quarantine: a handler
+	// failed on them until they ran out of attempts. Nothing will pick
+	// them up again until they are requeued.
+	StateDeadLetter = "dead-letter"
+)
+
+type Offset struct {
+	Poller          string    `json:"poller"`
+	LastProcessedID int64     `json:"lastProcessedId"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+type Change struct {
+	ID          int64     `json:"id"`
+	OrderID     int64     `json:"orderId"`
+	ChangeType  string    `json:"changeType"`
+	Priority    string    `json:"priority"`
+	ProductName string    `json:"productName"`
+	Quantity    int       `json:"quantity,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Processed   bool      `json:"processed"`
+	CreatedAt   time.Time `json:"createdAt"`
+
+	Actor string          `json:"actor,omitempty"`
+	Patch json.RawMessage `json:"patch,omitempty"`
+
+	// Attempts counts the times handlers failed on the change. LastError
+	// and NextAttemptAt are set while it waits to be retried; a dead
+	// letter, out of attempts, keeps LastError only.
+	Attempts      int        `json:"attempts,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+}
+
+// Event is c as an events envelope of the current schema version.
+func (c Change) Event() (events.Envelope, error) {
+	return events.New(c.ChangeType, events.Payload{
+		ChangeID:  c.ID,
+		Tenant:    c.Tenant,
+		CreatedAt: c.CreatedAt,
+		Order: events.Order{
+			ID:       c.OrderID,
+			Product:  c.ProductName,
+			Quantity: c.Quantity,
+			Priority: c.Priority,
+		},
+	})
+}
+
+func ListOffsets(db *sql.DB) ([]Offset, error) {
+	rows, err := db.Query(`
+        SELECT poller, last_processed_id, updated_at
+        FROM poller_offsets
+        ORDER BY poller
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offsets := []Offset{}
+	for rows.Next() {
+		var o Offset
+		err = rows.Scan(&o.Poller, &o.LastProcessedID, &o.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, o)
+	}
+	return offsets, rows.Err()
+}
+
+// ListChanges returns up to limit changes in the given state, oldest first.
+func ListChanges(db *sql.DB, state string, limit int) ([]Change, error) {
+	query := `
+        SELECT pc.id, pc.order_id, pc.change_type, pc.priority,
+               o.product_name, o.quantity, o.tenant_id, pc.processed, pc.created_at, pc.actor, pc.patch,
+               pc.attempts, pc.last_error, pc.next_attempt_at
+        FROM priority_changes pc
+        JOIN orders o ON o.id = pc.order_id
+    `
+	switch state {
+	case StateUnprocessed:
+		query += ` WHERE pc.processed = FALSE`
+	case StateDeadLetter:
+		query += ` WHERE pc.id IN (SELECT change_id FROM change_quarantine)`
+	default:
+		return nil, fmt.Errorf("unknown change state %q", state)
+	}
+	query += ` ORDER BY pc.id LIMIT ?`
+
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanChanges(rows)
+}
+
+// Requeue marks a change unprocessed and due at once with its attempts
+// reset, releases it from quarantine, and rewinds every poller offset that
+// has passed it, returning how many offsets moved. Rewound pollers also
+// retry any other unprocessed changes of theirs between the change and
+// their old offset; processed ones are never picked up twice.
+func Requeue(db *sql.DB, id int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        UPDATE priority_changes
+        SET processed = FALSE, processed_at = NULL, attempts = 0, next_attempt_at = NULL
+        WHERE id = ?
+    `, id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrChangeNotFound
+	}
+
+	_, err = tx.Exec(`DELETE FROM change_quarantine WHERE change_id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err = tx.Exec(`
+        UPDATE poller_offsets
+        SET last_processed_id = ?, updated_at = CURRENT_TIMESTAMP
+        WHERE last_processed_id >= ?
+    `, id-1, id)
+	if err != nil {
+		return 0, err
+	}
+	rewound, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return rewound, tx.Commit()
+}
+
+// Retention deletes processed changes older than olderThan. Unprocessed ones
+// are kept regardless of age. With dryRun it only counts what would go. It
+// is the priority_changes purge policy of the retention engine, run once.
+func Retention(db *sql.DB, olderThan time.Duration, dryRun bool) (RetentionResult, error) {
+	p := Policy{Table: "priority_changes", KeepFor: olderThan, Action: Purge}
+	return ApplyPolicy(context.Background(), db, p, "", dryRun)
+}
+
+// Range selects changes by id and creation time. Zero fields are unbounded.
+type Range struct {
+	FromID int64
+	ToID   int64
+	Since  time.Time
+	Until  time.Time
+}
+
+// ListProcessed returns up to limit processed changes in r with ids above
+// afterID, oldest first, for paging through a replay.
+func ListProcessed(db *sql.DB, r Range, afterID int64, limit int) ([]Change, error) {
+	query := `
+        SELECT pc.id, pc.order_id, pc.change_type, pc.priority,
+               o.product_name, o.quantity, o.tenant_id, pc.processed, pc.created_at, pc.actor, pc.patch,
+               pc.attempts, pc.last_error, pc.next_attempt_at
+        FROM priority_changes pc
+        JOIN orders o ON o.id = pc.order_id
+        WHERE pc.processed = TRUE AND pc.id > ?
+    `
+	args := []any{max(afterID, r.FromID-1)}
+	if r.ToID > 0 {
+		query += ` AND pc.id <= ?`
+		args = append(args, r.ToID)
+	}
+	if !r.Since.IsZero() {
+		query += ` AND pc.created_at >= ?`
+		args = append(args, r.Since.UTC().Format(time.DateTime))
+	}
+	if !r.Until.IsZero() {
+		query += ` AND pc.created_at < ?`
+		args = append(args, r.Until.UTC().Format(time.DateTime))
+	}
+	query += ` ORDER BY pc.id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanChanges(rows)
+}
+
+// SettledThrough returns the highest change id below every change still to
+// be processed. Each change up to it is processed or a dead letter, so none
+// of them will be processed later; 0 means nothing is settled yet. With
+// nothing outstanding it is the newest change.
+//
+// A change is still to be processed while it is unprocessed, not
+// quarantined and past some poller's offset. Offsets move past the changes
+// their poller doesn't match, so a change no poller selects stops holding
+// the feed back once every poller has moved past it. The supervisor
+// registers each poller's offset before starting any, so one that hasn't
+// run yet still counts.
+func SettledThrough(ctx context.Context, db *sql.DB) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, `
+        SELECT COALESCE(
+            (SELECT MIN(id) - 1 FROM priority_changes
+             WHERE processed = FALSE
+             AND id > COALESCE((SELECT MIN(last_processed_id) FROM poller_offsets), 0)
+             AND id NOT IN (SELECT change_id FROM change_quarantine)),
+            (SELECT MAX(id) FROM priority_changes),
+            0)
+    `).Scan(&id)
+	return id, err
+}
+
+func scanChanges(rows *sql.Rows) ([]Change, error) {
+	defer rows.Close()
+
+	changes := []Change{}
+	for rows.Next() {
+		var c Change
+		err := rows.Scan(
+			&c.ID,
+			&c.OrderID,
+			&c.ChangeType,
+			&c.Priority,
+			&c.ProductName,
+			&c.Quantity,
+			&c.Tenant,
+			&c.Processed,
+			&c.CreatedAt,
+			&c.Actor,
+			(*[]byte)(&c.Patch),
+			&c.Attempts,
+			&c.LastError,
+			&c.NextAttemptAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
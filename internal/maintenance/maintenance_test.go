@@ -0,0 +1,45 @@
+package maintenance
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestListChangesDeadLetters checks that dead letters are the quarantined
+// changes, not whatever unprocessed change the pollers have moved past.
+func TestListChangesDeadLetters(t *testing.T) {
+	db := openTestDB(t)
+	ids := chainedChanges(t, db)
+	_, err := db.Exec(`UPDATE priority_changes SET processed = FALSE WHERE id >= ?`, ids[2])
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO change_quarantine (change_id, poller, payload, errors) VALUES (?, 'ninja', '{}', '[]')`, ids[2])
+	}
+	if err == nil {
+		_, err = db.Exec(`UPDATE poller_offsets SET last_processed_id = ?`, ids[3])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		state string
+		want  []int64
+	}{
+		{state: StateUnprocessed, want: ids[2:]},
+		{state: StateDeadLetter, want: ids[2:3]},
+	} {
+		t.Run(tc.state, func(t *testing.T) {
+			changes, err := ListChanges(db, tc.state, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for _, c := range changes {
+				got = append(got, c.ID)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("ListChanges(%q) = %v, want %v", tc.state, got, tc.want)
+			}
+		})
+	}
+}
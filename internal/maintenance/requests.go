@@ -0,0 +1,107 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RejectedRequest is a write the API turned down or failed, or answered by
+// replaying an idempotent response, as recorded in request_audit.
+type RejectedRequest struct {
+	ID        int64     `json:"id"`
+	At        time.Time `json:"at"`
+	Actor     string    `json:"actor,omitempty"`
+	Tenant    string    `json:"tenant"`
+	ClientIP  string    `json:"clientIp"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Code      string    `json:"code"`
+	Reason    string    `json:"reason"`
+	RequestID string    `json:"requestId"`
+}
+
+func RecordRejectedRequest(ctx context.Context, db *sql.DB, rr RejectedRequest) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO request_audit (actor, tenant_id, client_ip, method, path, status, code, reason, request_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, rr.Actor, rr.Tenant, rr.ClientIP, rr.Method, rr.Path, rr.Status, rr.Code, rr.Reason, rr.RequestID)
+	return err
+}
+
+// RejectionFilter selects rejected requests. Zero fields match everything.
+type RejectionFilter struct {
+	Actor    string
+	Tenant   string
+	ClientIP string
+	Status   int
+	Since    time.Time
+	Until    time.Time
+
+	// After is the keyset cursor: only requests with a higher id match.
+	After int64
+}
+
+// ListRejectedRequests returns up to limit requests matching f, oldest
+// first, and the cursor for the next page, 0 when this is the last one.
+func ListRejectedRequests(ctx context.Context, db *sql.DB, f RejectionFilter, limit int) ([]RejectedRequest, int64, error) {
+	query := `
+        SELECT id, created_at, actor, tenant_id, client_ip, method, path, status, code, reason, request_id
+        FROM request_audit
+        WHERE id > ?
+    `
+	args := []any{f.After}
+	if f.Actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, f.Actor)
+	}
+	if f.Tenant != "" {
+		query += ` AND tenant_id = ?`
+		args = append(args, f.Tenant)
+	}
+	if f.ClientIP != "" {
+		query += ` AND client_ip = ?`
+		args = append(args, f.ClientIP)
+	}
+	if f.Status > 0 {
+		query += ` AND status = ?`
+		args = append(args, f.Status)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.Since.UTC().Format(time.DateTime))
+	}
+	if !f.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, f.Until.UTC().Format(time.DateTime))
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	requests := []RejectedRequest{}
+	for rows.Next() {
+		var rr RejectedRequest
+		err = rows.Scan(&rr.ID, &rr.At, &rr.Actor, &rr.Tenant, &rr.ClientIP, &rr.Method, &rr.Path, &rr.Status, &rr.Code, &rr.Reason, &rr.RequestID)
+		if err != nil {
+			return nil, 0, err
+		}
+		requests = append(requests, rr)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(requests) > limit {
+		requests = requests[:limit]
+		return requests, requests[limit-1].ID, nil
+	}
+	return requests, 0, nil
+}
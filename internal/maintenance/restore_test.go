@@ -0,0 +1,220 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"test/internal/store"
+)
+
+// openSnapshot snapshots db and opens the copy, as cmd/restore does.
+func openSnapshot(t *testing.T, db *sql.DB) *sql.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	err := snapshot(context.Background(), db, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := store.Open("file:" + path + "?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { snap.Close() })
+	return snap
+}
+
+// archiveChanges returns every change of db, oldest first, as an archive
+// written by adminctl replay holds them.
+func archiveChanges(t *testing.T, db *sql.DB) []Change {
+	t.Helper()
+	rows, err := db.Query(`
+        SELECT pc.id, pc.order_id, pc.change_type, pc.priority,
+               o.product_name, o.quantity, o.tenant_id, pc.processed, pc.created_at, pc.actor, pc.patch,
+               pc.attempts, pc.last_error, pc.next_attempt_at
+        FROM priority_changes pc
+        JOIN orders o ON o.id = pc.order_id
+        ORDER BY pc.id
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := scanChanges(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestRestoreReplaysErasures(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	orders := store.New(db, nil, nil)
+	defer orders.Close()
+	id, err := orders.CreateOrder(ctx, store.Order{
+		CustomerName: "Ada", ProductName: "ninja", Quantity: 2, ShippingAddress: "1 Test Street", Priority: "low",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := openSnapshot(t, db)
+
+	_, err = orders.EraseCustomer(ctx, "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Restore(ctx, snap, archiveChanges(t, db), time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Applied != 1 {
+		t.Errorf("applied %d changes, want the erasure", res.Applied)
+	}
+
+	var name, address string
+	err = snap.QueryRow(`SELECT customer_name, shipping_address FROM orders WHERE id = ?`, id).Scan(&name, &address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != store.ErasedValue || address != store.ErasedValue {
+		t.Errorf("restored order has %q at %q, want both %q", name, address, store.ErasedValue)
+	}
+}
+
+// restoreScenario snapshots a database holding an order with one pending
+// change, then goes on to process it, escalate the order twice more and
+// create a second order. The last escalation is dated an hour ahead. It
+// returns the snapshot, the archive of the database as it ended up and the
+// order and change ids.
+func restoreScenario(t *testing.T) (snap *sql.DB, archive []Change, orderID int64, ids []int64) {
+	t.Helper()
+	ctx := context.Background()
+	db := openTestDB(t)
+	orders := store.New(db, nil, nil)
+	defer orders.Close()
+
+	change := func(orderID int64, priority string) int64 {
+		t.Helper()
+		err := orders.ChangePriority(ctx, store.PriorityChange{OrderID: orderID, Priority: priority})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var id int64
+		err = db.QueryRow(`SELECT MAX(id) FROM priority_changes`).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	order := store.Order{CustomerName: "Ada", ProductName: "ninja", Quantity: 2, ShippingAddress: "1 Test Street", Priority: "low"}
+
+	orderID, err := orders.CreateOrder(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = append(ids, change(orderID, "high"))
+	snap = openSnapshot(t, db)
+
+	ids = append(ids, change(orderID, "medium"), change(orderID, "low"))
+	_, err = db.Exec(`UPDATE priority_changes SET processed = TRUE WHERE id <= ?`, ids[1])
+	if err == nil {
+		_, err = db.Exec(`UPDATE priority_changes SET created_at = datetime('now', '+1 hour') WHERE id = ?`, ids[2])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := orders.CreateOrder(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = append(ids, change(otherID, "high"))
+	return snap, archiveChanges(t, db), orderID, ids
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	snap, archive, orderID, ids := restoreScenario(t)
+
+	res, err := Restore(ctx, snap, archive, time.Now().UTC().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RestoreResult{Applied: 1, Skipped: 1, Marked: 1, LastID: ids[1], Offset: ids[1]}
+	if res != want {
+		t.Errorf("Restore = %+v, want %+v", res, want)
+	}
+
+	var priority string
+	err = snap.QueryRow(`SELECT priority FROM orders WHERE id = ?`, orderID).Scan(&priority)
+	if err != nil || priority != "medium" {
+		t.Errorf("order priority %q (err %v), want the escalation before the cutoff applied", priority, err)
+	}
+	rows, err := snap.Query(`SELECT id, processed FROM priority_changes ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored []int64
+	for rows.Next() {
+		var id int64
+		var processed bool
+		err = rows.Scan(&id, &processed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !processed {
+			t.Errorf("change %d is unprocessed, want the archive's processed flag", id)
+		}
+		restored = append(restored, id)
+	}
+	rows.Close()
+	if len(restored) != 2 || restored[0] != ids[0] || restored[1] != ids[1] {
+		t.Errorf("restored changes %v, want %v", restored, ids[:2])
+	}
+
+	var offset int64
+	err = snap.QueryRow(`SELECT last_processed_id FROM poller_offsets WHERE poller = 'ninja'`).Scan(&offset)
+	if err != nil || offset != ids[1] {
+		t.Errorf("ninja offset %d (err %v), want %d", offset, err, ids[1])
+	}
+}
+
+// TestRestoreOffsetsStopBeforePending checks that offsets stop just before
+// the oldest change still unprocessed, and that ones already past it stay.
+func TestRestoreOffsetsStopBeforePending(t *testing.T) {
+	ctx := context.Background()
+	snap, archive, _, ids := restoreScenario(t)
+	_, err := snap.Exec(`INSERT INTO poller_offsets (poller, last_processed_id) VALUES ('ahead', ?)`, ids[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cutoff past the last escalation replays it, still unprocessed.
+	res, err := Restore(ctx, snap, archive, time.Now().UTC().Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Offset != ids[1] || res.LastID != ids[2] {
+		t.Errorf("Restore = %+v, want offset %d and last id %d", res, ids[1], ids[2])
+	}
+
+	offsets := map[string]int64{}
+	rows, err := snap.Query(`SELECT poller, last_processed_id FROM poller_offsets`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		var id int64
+		err = rows.Scan(&name, &id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets[name] = id
+	}
+	rows.Close()
+	if offsets["ninja"] != ids[1] || offsets["ahead"] != ids[3] {
+		t.Errorf("offsets %v, want ninja at %d and ahead left at %d", offsets, ids[1], ids[3])
+	}
+}
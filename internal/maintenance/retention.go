@@ -0,0 +1,273 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Retention actions: both delete expired rows, Archive first appends them
+// as JSON lines to a file in the archive directory.
+const (
+	Purge   = "purge"
+	Archive = "archive"
+)
+
+// retentionTable says which rows of a table may expire and by which column.
+type retentionTable struct {
+	timeColumn string
+
+	// idColumn orders the rows and numbers them in retention_runs; id
+	// when empty.
+	idColumn string
+
+	// only restricts expiry further; unprocessed changes never expire.
+	only string
+}
+
+func (t retentionTable) id() string {
+	if t.idColumn == "" {
+		return "id"
+	}
+	return t.idColumn
+}
+
+var retentionTables = map[string]retentionTable{
+	"priority_changes": {timeColumn: "created_at", only: "processed = TRUE"},
+	"maintenance_runs": {timeColumn: "started_at"},
+	"backups":          {timeColumn: "started_at"},
+	"retention_runs":   {timeColumn: "ran_at"},
+	"delivered_events": {timeColumn: "delivered_at", idColumn: "change_id"},
+	"change_failures":  {timeColumn: "failed_at"},
+	"request_audit":    {timeColumn: "created_at"},
+	"security_audit":   {timeColumn: "created_at"},
+}
+
+// Policy keeps a table's rows for KeepFor, then archives or purges them.
+type Policy struct {
+	Table   string        `json:"table"`
+	KeepFor time.Duration `json:"keepFor"`
+	Action  string        `json:"action"`
+}
+
+func (p Policy) Validate() error {
+	if _, ok := retentionTables[p.Table]; !ok {
+		return fmt.Errorf("retention: unknown table %q", p.Table)
+	}
+	if p.KeepFor < time.Hour {
+		return fmt.Errorf("retention for %s must keep rows at least 1h, got %s", p.Table, p.KeepFor)
+	}
+	if p.Action != Purge && p.Action != Archive {
+		return fmt.Errorf("retention for %s: action must be %s or %s, got %q", p.Table, Purge, Archive, p.Action)
+	}
+	return nil
+}
+
+// ParsePolicies reads "table=keepFor:action,...", e.g.
+// "priority_changes=720h:archive,maintenance_runs=2160h:purge".
+func ParsePolicies(s string) ([]Policy, error) {
+	var policies []Policy
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		table, rest, ok := strings.Cut(item, "=")
+		keep, action, ok2 := strings.Cut(rest, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("retention %q: want table=keepFor:action", item)
+		}
+		d, err := time.ParseDuration(keep)
+		if err != nil {
+			return nil, fmt.Errorf("retention %q: %w", item, err)
+		}
+		p := Policy{Table: table, KeepFor: d, Action: action}
+		err = p.Validate()
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+type RetentionResult struct {
+	Table       string    `json:"table"`
+	Action      string    `json:"action"`
+	Cutoff      time.Time `json:"cutoff"`
+	Deleted     int64     `json:"deleted"`
+	ArchivePath string    `json:"archivePath,omitempty"`
+	DryRun      bool      `json:"dryRun"`
+
+	// FromID and ToID are the first and last id deleted.
+	FromID int64 `json:"fromId,omitempty"`
+	ToID   int64 `json:"toId,omitempty"`
+}
+
+// ApplyPolicy expires the rows of p.Table older than p.KeepFor. With dryRun
+// it only counts them. A real run is recorded in retention_runs, along with
+// the span of rows deleted and the file they were archived to.
+func ApplyPolicy(ctx context.Context, db *sql.DB, p Policy, archiveDir string, dryRun bool) (RetentionResult, error) {
+	res := RetentionResult{
+		Table:  p.Table,
+		Action: p.Action,
+		Cutoff: time.Now().UTC().Add(-p.KeepFor).Truncate(time.Second),
+		DryRun: dryRun,
+	}
+	err := p.Validate()
+	if err != nil {
+		return res, err
+	}
+
+	t := retentionTables[p.Table]
+	// Timestamps are stored as UTC text, so compare in the same format.
+	where := t.timeColumn + " < ?"
+	if t.only != "" {
+		where += " AND " + t.only
+	}
+	cutoff := res.Cutoff.Format(time.DateTime)
+
+	if dryRun {
+		err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+p.Table+` WHERE `+where, cutoff).Scan(&res.Deleted)
+		return res, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	if p.Action == Archive {
+		res.ArchivePath, err = archiveRows(ctx, tx, p.Table, t.id(), where, cutoff, archiveDir)
+		if err != nil {
+			return res, fmt.Errorf("archiving %s: %w", p.Table, err)
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MIN(`+t.id()+`), 0), COALESCE(MAX(`+t.id()+`), 0) FROM `+p.Table+` WHERE `+where, cutoff).
+		Scan(&res.FromID, &res.ToID)
+	if err != nil {
+		return res, err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM `+p.Table+` WHERE `+where, cutoff)
+	if err != nil {
+		return res, err
+	}
+	res.Deleted, err = result.RowsAffected()
+	if err != nil {
+		return res, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO retention_runs (table_name, action, cutoff, deleted, archive_path, from_id, to_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, res.Table, res.Action, cutoff, res.Deleted, res.ArchivePath, res.FromID, res.ToID)
+	if err != nil {
+		return res, err
+	}
+	return res, tx.Commit()
+}
+
+// archiveRows writes the expiring rows to a new JSONL file, one object per
+// row keyed by column name, and returns its path; empty when there was
+// nothing to archive. The file is synced before the rows are deleted.
+func archiveRows(ctx context.Context, tx *sql.Tx, table, idColumn, where, cutoff, dir string) (string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT * FROM `+table+` WHERE `+where+` ORDER BY `+idColumn, cutoff)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var f *os.File
+	var enc *json.Encoder
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return "", err
+		}
+
+		if f == nil {
+			err = os.MkdirAll(dir, 0o755)
+			if err != nil {
+				return "", err
+			}
+			name := table + "-" + time.Now().UTC().Format("20060102T150405.000Z") + ".jsonl"
+			f, err = os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			enc = json.NewEncoder(f)
+		}
+
+		row := make(map[string]any, len(cols))
+		for i, c := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				vals[i] = string(b)
+			}
+			row[c] = vals[i]
+		}
+		err = enc.Encode(row)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = rows.Err()
+	if err != nil || f == nil {
+		return "", err
+	}
+	return f.Name(), f.Sync()
+}
+
+type RetentionRun struct {
+	ID          int64     `json:"id"`
+	Table       string    `json:"table"`
+	Action      string    `json:"action"`
+	Cutoff      time.Time `json:"cutoff"`
+	Deleted     int64     `json:"deleted"`
+	ArchivePath string    `json:"archivePath,omitempty"`
+	FromID      int64     `json:"fromId,omitempty"`
+	ToID        int64     `json:"toId,omitempty"`
+	RanAt       time.Time `json:"ranAt"`
+}
+
+// ListRetentionRuns returns the most recent retention runs, newest first.
+func ListRetentionRuns(db *sql.DB, limit int) ([]RetentionRun, error) {
+	rows, err := db.Query(`
+        SELECT id, table_name, action, cutoff, deleted, archive_path, from_id, to_id, ran_at
+        FROM retention_runs
+        ORDER BY id DESC
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []RetentionRun{}
+	for rows.Next() {
+		var r RetentionRun
+		err = rows.Scan(&r.ID, &r.Table, &r.Action, &r.Cutoff, &r.Deleted, &r.ArchivePath, &r.FromID, &r.ToID, &r.RanAt)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
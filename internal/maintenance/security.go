@@ -0,0 +1,119 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Security events, kept apart from the business audit trail in
+// priority_changes.
+const (
+	EventAuthFailed         = "auth.failed"
+	EventAdminForbidden     = "admin.forbidden"
+	EventIPDenied           = "ip.denied"
+	EventCSRFRejected       = "csrf.rejected"
+	EventQuotaExceeded      = "quota.exceeded"
+	EventOrderLimitWarned   = "order_limit.warned"
+	EventOrderLimitExceeded = "order_limit.exceeded"
+	EventLogin              = "session.login"
+	EventLogout             = "session.logout"
+	EventPollerCreated      = "poller.created"
+	EventPollerUpdated      = "poller.updated"
+	EventPollerPaused       = "poller.paused"
+	EventPollerResumed      = "poller.resumed"
+	EventPollerDeleted      = "poller.deleted"
+	EventDryRunSet          = "dry_run.set"
+	EventChangeRequeued     = "change.requeued"
+	EventWebhookCreated     = "webhook.created"
+	EventWebhookUpdated     = "webhook.updated"
+	EventWebhookDeleted     = "webhook.deleted"
+	EventWebhookRetried     = "webhook.retried"
+)
+
+// SecurityEvent is an authentication failure or an administrative action,
+// as recorded in security_audit.
+type SecurityEvent struct {
+	ID        int64     `json:"id"`
+	At        time.Time `json:"at"`
+	Event     string    `json:"event"`
+	Actor     string    `json:"actor,omitempty"`
+	ClientIP  string    `json:"clientIp"`
+	Target    string    `json:"target,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	RequestID string    `json:"requestId"`
+}
+
+func RecordSecurityEvent(ctx context.Context, db *sql.DB, e SecurityEvent) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO security_audit (event, actor, client_ip, target, detail, request_id)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, e.Event, e.Actor, e.ClientIP, e.Target, e.Detail, e.RequestID)
+	return err
+}
+
+// SecurityFilter selects security events. Zero fields match everything.
+type SecurityFilter struct {
+	Event string
+	Actor string
+	Since time.Time
+	Until time.Time
+
+	// After is the keyset cursor: only events with a higher id match.
+	After int64
+}
+
+// ListSecurityEvents returns up to limit events matching f, oldest first,
+// and the cursor for the next page, 0 when this is the last one.
+func ListSecurityEvents(ctx context.Context, db *sql.DB, f SecurityFilter, limit int) ([]SecurityEvent, int64, error) {
+	query := `
+        SELECT id, created_at, event, actor, client_ip, target, detail, request_id
+        FROM security_audit
+        WHERE id > ?
+    `
+	args := []any{f.After}
+	if f.Event != "" {
+		query += ` AND event = ?`
+		args = append(args, f.Event)
+	}
+	if f.Actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, f.Actor)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.Since.UTC().Format(time.DateTime))
+	}
+	if !f.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, f.Until.UTC().Format(time.DateTime))
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		err = rows.Scan(&e.ID, &e.At, &e.Event, &e.Actor, &e.ClientIP, &e.Target, &e.Detail, &e.RequestID)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(events) > limit {
+		events = events[:limit]
+		return events, events[limit-1].ID, nil
+	}
+	return events, 0, nil
+}
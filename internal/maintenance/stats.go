@@ -0,0 +1,119 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/store"
+)
+
+// Percentiles are nearest-rank percentiles of a set of durations.
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+type ProductDay struct {
+	Product     string `json:"product"`
+	Day         string `json:"day"`
+	Escalations int64  `json:"escalations"`
+}
+
+// Stats covers the changes created in the window ending now.
+type Stats struct {
+	Since       time.Time    `json:"since"`
+	Escalations []ProductDay `json:"escalations"`
+	Changes     int64        `json:"changes"`
+	Processed   int64        `json:"processed"`
+
+	// AvgProcessingSeconds is the mean time from creation to processing
+	// of the changes processed so far; null when there are none.
+	AvgProcessingSeconds *float64 `json:"avgProcessingSeconds"`
+
+	// ProcessingSeconds are percentiles of the same, from escalation
+	// request to action; null when nothing was processed.
+	ProcessingSeconds *Percentiles `json:"processingSeconds"`
+
+	DeadLetters    int64   `json:"deadLetters"`
+	DeadLetterRate float64 `json:"deadLetterRate"`
+}
+
+// ComputeStats aggregates escalations per product and day, processing time
+// and dead letters over the changes created in the last window.
+func ComputeStats(ctx context.Context, db *sql.DB, window time.Duration) (Stats, error) {
+	d := store.DialectOf(db)
+	st := Stats{
+		Since:       time.Now().UTC().Add(-window).Truncate(time.Second),
+		Escalations: []ProductDay{},
+	}
+	since := st.Since.Format(time.DateTime)
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT o.product_name, `+d.Day("pc.created_at")+` AS day, COUNT(*)
+        FROM priority_changes pc
+        JOIN orders o ON o.id = pc.order_id
+        WHERE pc.created_at >= ? AND pc.change_type = ?
+        GROUP BY o.product_name, day
+        ORDER BY day, o.product_name
+    `, since, audit.PriorityChanged)
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pd ProductDay
+		err = rows.Scan(&pd.Product, &pd.Day, &pd.Escalations)
+		if err != nil {
+			return st, err
+		}
+		st.Escalations = append(st.Escalations, pd)
+	}
+	err = rows.Err()
+	if err != nil {
+		return st, err
+	}
+
+	// Dead letters are the quarantined changes, as in ListChanges.
+	err = db.QueryRowContext(ctx, `
+        SELECT
+            COUNT(*),
+            COALESCE(SUM(processed = TRUE), 0),
+            AVG(`+d.SecondsBetween("created_at", "processed_at")+`),
+            COALESCE(SUM(id IN (SELECT change_id FROM change_quarantine)), 0)
+        FROM priority_changes
+        WHERE created_at >= ?
+    `, since).Scan(&st.Changes, &st.Processed, &st.AvgProcessingSeconds, &st.DeadLetters)
+	if err != nil {
+		return st, err
+	}
+	if st.Changes > 0 {
+		st.DeadLetterRate = float64(st.DeadLetters) / float64(st.Changes)
+	}
+
+	var p50, p95, p99 sql.NullFloat64
+	err = db.QueryRowContext(ctx, `
+        WITH latency AS (
+            SELECT ROUND(`+d.SecondsBetween("created_at", "processed_at")+`, 3) AS s
+            FROM priority_changes
+            WHERE created_at >= ? AND processed = TRUE AND processed_at IS NOT NULL
+        ), ranked AS (
+            SELECT s, ROW_NUMBER() OVER (ORDER BY s) AS n, COUNT(*) OVER () AS total
+            FROM latency
+        )
+        SELECT
+            MIN(CASE WHEN n >= total * 0.50 THEN s END),
+            MIN(CASE WHEN n >= total * 0.95 THEN s END),
+            MIN(CASE WHEN n >= total * 0.99 THEN s END)
+        FROM ranked
+    `, since).Scan(&p50, &p95, &p99)
+	if err != nil {
+		return st, err
+	}
+	if p50.Valid {
+		st.ProcessingSeconds = &Percentiles{P50: p50.Float64, P95: p95.Float64, P99: p99.Float64}
+	}
+	return st, nil
+}
@@ -0,0 +1,100 @@
+package maintenance
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"test/internal/audit"
+)
+
+func TestComputeStats(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.Exec(`
+        INSERT INTO orders (customer_name, product_name, quantity, shipping_address, priority)
+        VALUES ('Ada', 'ninja', 1, '1 Test Street', 'low'), ('Ada', 'widget', 1, '1 Test Street', 'low')
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each change is created the given days before the start of today,
+	// a second into that day, and processed latency seconds later unless
+	// latency is negative.
+	for _, c := range []struct {
+		orderID    int64
+		changeType string
+		daysAgo    int
+		latency    float64
+	}{
+		{1, audit.PriorityChanged, 1, 1},
+		{1, audit.PriorityChanged, 0, 2},
+		{1, audit.PriorityChanged, 0, 3},
+		{2, audit.PriorityChanged, 0, 100},
+		{2, audit.OrderCancelled, 0, -1},
+		{2, audit.PriorityChanged, 0, -1},
+		{1, audit.PriorityChanged, 10, 5}, // outside the window
+	} {
+		created := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -c.daysAgo).Add(time.Second)
+		var processedAt any
+		if c.latency >= 0 {
+			processedAt = created.Add(time.Duration(c.latency * float64(time.Second))).Format(time.DateTime)
+		}
+		_, err = db.Exec(`
+            INSERT INTO priority_changes (order_id, priority, change_type, processed, created_at, processed_at)
+            VALUES (?, 'high', ?, ?, ?, ?)
+        `, c.orderID, c.changeType, processedAt != nil, created.Format(time.DateTime), processedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The last unprocessed escalation ran out of attempts.
+	_, err = db.Exec(`INSERT INTO change_quarantine (change_id, poller, payload, errors) VALUES (6, 'ninja', '{}', '[]')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := ComputeStats(context.Background(), db, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+	wantEscalations := []ProductDay{
+		{Product: "ninja", Day: yesterday, Escalations: 1},
+		{Product: "ninja", Day: today, Escalations: 2},
+		{Product: "widget", Day: today, Escalations: 2},
+	}
+	if !slices.Equal(st.Escalations, wantEscalations) {
+		t.Errorf("escalations = %+v, want %+v", st.Escalations, wantEscalations)
+	}
+	if st.Changes != 6 || st.Processed != 4 {
+		t.Errorf("%d changes, %d processed, want 6 and 4", st.Changes, st.Processed)
+	}
+	if st.AvgProcessingSeconds == nil || math.Abs(*st.AvgProcessingSeconds-26.5) > 0.01 {
+		t.Errorf("average processing = %v, want 26.5s", st.AvgProcessingSeconds)
+	}
+	// Nearest rank over 1, 2, 3 and 100 seconds: the 2nd, 4th and 4th.
+	wantPercentiles := Percentiles{P50: 2, P95: 100, P99: 100}
+	if st.ProcessingSeconds == nil || *st.ProcessingSeconds != wantPercentiles {
+		t.Errorf("processing percentiles = %+v, want %+v", st.ProcessingSeconds, wantPercentiles)
+	}
+	if st.DeadLetters != 1 || math.Abs(st.DeadLetterRate-1.0/6) > 1e-9 {
+		t.Errorf("%d dead letters at rate %v, want 1 at 1/6", st.DeadLetters, st.DeadLetterRate)
+	}
+}
+
+func TestComputeStatsWithoutChanges(t *testing.T) {
+	db := openTestDB(t)
+	st, err := ComputeStats(context.Background(), db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Escalations) != 0 || st.Changes != 0 || st.AvgProcessingSeconds != nil ||
+		st.ProcessingSeconds != nil || st.DeadLetterRate != 0 {
+		t.Errorf("stats = %+v, want them empty", st)
+	}
+}
@@ -0,0 +1,261 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"test/internal/lease"
+	"test/internal/store"
+)
+
+// Database housekeeping tasks, run by the Scheduler off-peak or on demand
+// through /admin/maintenance/runs. Each run is recorded in maintenance_runs.
+const (
+	// TaskOptimize lets SQLite refresh the planner statistics it judges
+	// stale, the equivalent of ANALYZE on the tables that need it. MySQL
+	// analyzes the tables retention deletes from.
+	TaskOptimize = "optimize"
+
+	// TaskVacuum returns the pages freed by retention to the filesystem.
+	// The first run switches the database to incremental auto-vacuum,
+	// which takes one full VACUUM. MySQL rebuilds those tables instead.
+	TaskVacuum = "incremental_vacuum"
+)
+
+// Tasks is every task, in the order the scheduler runs them.
+var Tasks = []string{TaskVacuum, TaskOptimize}
+
+type Run struct {
+	ID         int64     `json:"id"`
+	Task       string    `json:"task"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// RunTask runs task and records the run. A failed task is recorded too; its
+// error is returned alongside the Run.
+func RunTask(ctx context.Context, db *sql.DB, task string) (Run, error) {
+	run := Run{Task: task, StartedAt: time.Now().UTC()}
+
+	var err error
+	mysql := store.DialectOf(db) == store.MySQL
+	switch {
+	case task == TaskOptimize && mysql:
+		_, err = db.ExecContext(ctx, `ANALYZE TABLE `+retainedTables)
+	case task == TaskOptimize:
+		_, err = db.ExecContext(ctx, `PRAGMA optimize`)
+	case task == TaskVacuum && mysql:
+		_, err = db.ExecContext(ctx, `OPTIMIZE TABLE `+retainedTables)
+	case task == TaskVacuum:
+		err = incrementalVacuum(ctx, db)
+	default:
+		return run, fmt.Errorf("unknown maintenance task %q", task)
+	}
+	run.FinishedAt = time.Now().UTC()
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	result, recErr := db.ExecContext(ctx, `
+        INSERT INTO maintenance_runs (task, started_at, finished_at, error)
+        VALUES (?, ?, ?, ?)
+    `, run.Task, run.StartedAt, run.FinishedAt, run.Error)
+	if recErr != nil {
+		return run, fmt.Errorf("recording %s run: %w", task, recErr)
+	}
+	run.ID, _ = result.LastInsertId()
+	return run, err
+}
+
+// retainedTables are the tables retention deletes from, for MySQL's ANALYZE
+// and OPTIMIZE TABLE.
+var retainedTables = strings.Join(slices.Sorted(maps.Keys(retentionTables)), ", ")
+
+// incrementalVacuum needs auto_vacuum=INCREMENTAL, which only takes effect
+// through a VACUUM on the same connection.
+func incrementalVacuum(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var mode int
+	err = conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode)
+	if err != nil {
+		return err
+	}
+	const incremental = 2
+	if mode != incremental {
+		log.Println("Switching the database to incremental auto-vacuum; running a full VACUUM once")
+		_, err = conn.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`)
+		if err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, `VACUUM`)
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, `PRAGMA incremental_vacuum`)
+	return err
+}
+
+// ListRuns returns the most recent maintenance runs, newest first.
+func ListRuns(db *sql.DB, limit int) ([]Run, error) {
+	rows, err := db.Query(`
+        SELECT id, task, started_at, finished_at, error
+        FROM maintenance_runs
+        ORDER BY id DESC
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var r Run
+		err = rows.Scan(&r.ID, &r.Task, &r.StartedAt, &r.FinishedAt, &r.Error)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// Window is a daily period in local time, from Start to End after midnight.
+// It may wrap past midnight. The zero Window is always open.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow reads "HH:MM-HH:MM"; an empty string is the zero Window.
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return Window{}, nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q: want HH:MM-HH:MM", s)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return Window{}, fmt.Errorf("window %q: %w", s, err)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return Window{}, fmt.Errorf("window %q: %w", s, err)
+	}
+	return Window{
+		Start: time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		End:   time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute,
+	}, nil
+}
+
+func (w Window) Contains(t time.Time) bool {
+	if w.Start == w.End {
+		return true
+	}
+	since := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.Start < w.End {
+		return since >= w.Start && since < w.End
+	}
+	return since >= w.Start || since < w.End
+}
+
+type SchedulerConfig struct {
+	Interval time.Duration
+	Window   Window
+
+	// Retention is applied after the tasks on each run, archiving into
+	// ArchiveDir where a policy asks for it.
+	Retention  []Policy
+	ArchiveDir string
+
+	// Holder names this process for the "maintenance" lease, which keeps
+	// scheduled runs to one replica at a time. Empty runs without it.
+	Holder string
+}
+
+// Scheduler runs every task, then every retention policy, on each tick of
+// its interval that falls inside its window.
+type Scheduler struct {
+	db     *sql.DB
+	cfg    SchedulerConfig
+	leader *lease.Elector
+}
+
+func NewScheduler(db *sql.DB, cfg SchedulerConfig) *Scheduler {
+	s := &Scheduler{db: db, cfg: cfg}
+	if cfg.Holder != "" {
+		s.leader = lease.NewElector(db, "maintenance", cfg.Holder, leaseTTL(cfg.Interval))
+	}
+	return s
+}
+
+// leaseTTL outlasts two ticks of a job, so the replica running it keeps
+// it from one tick to the next and another takes over after it misses
+// two.
+func leaseTTL(interval time.Duration) time.Duration {
+	return max(2*interval, lease.MinTTL)
+}
+
+// Run ticks until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !s.cfg.Window.Contains(now) {
+				continue
+			}
+			if s.leader != nil && !s.leader.Lead(ctx) {
+				continue
+			}
+			s.runTasks(ctx)
+			s.applyRetention(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) runTasks(ctx context.Context) {
+	for _, task := range Tasks {
+		run, err := RunTask(ctx, s.db, task)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Maintenance task %s failed: %v", task, err)
+			}
+			continue
+		}
+		log.Printf("Maintenance task %s finished in %s", task, run.FinishedAt.Sub(run.StartedAt))
+	}
+}
+
+func (s *Scheduler) applyRetention(ctx context.Context) {
+	for _, p := range s.cfg.Retention {
+		res, err := ApplyPolicy(ctx, s.db, p, s.cfg.ArchiveDir, false)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Retention for %s failed: %v", p.Table, err)
+			}
+			continue
+		}
+		if res.Deleted > 0 {
+			log.Printf("Retention %sd %d rows from %s older than %s", res.Action, res.Deleted, res.Table, res.Cutoff.Format(time.RFC3339))
+		}
+	}
+}
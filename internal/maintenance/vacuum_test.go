@@ -0,0 +1,136 @@
+package maintenance
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRunTaskRecordsRuns(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	for _, task := range Tasks {
+		run, err := RunTask(ctx, db, task)
+		if err != nil {
+			t.Fatalf("%s: %v", task, err)
+		}
+		if run.ID == 0 || run.FinishedAt.Before(run.StartedAt) {
+			t.Errorf("%s run = %+v, want it recorded with its times", task, run)
+		}
+	}
+	_, err := RunTask(ctx, db, "defragment")
+	if err == nil {
+		t.Error("unknown task ran")
+	}
+
+	runs, err := ListRuns(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != len(Tasks) {
+		t.Fatalf("runs = %+v, want one per task and none for the unknown one", runs)
+	}
+	for i, run := range runs {
+		if want := Tasks[len(Tasks)-1-i]; run.Task != want || run.Error != "" {
+			t.Errorf("run %d = %+v, want %s without error, newest first", i, run, want)
+		}
+	}
+
+	var mode int
+	err = db.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode)
+	if err != nil || mode != 2 {
+		t.Errorf("auto_vacuum = %d (err %v), want incremental", mode, err)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		window  string
+		in, out []time.Time
+		wantErr bool
+	}{
+		{window: "", in: []time.Time{at(0, 0), at(12, 0), at(23, 59)}},
+		{window: "01:00-05:30", in: []time.Time{at(1, 0), at(5, 29)}, out: []time.Time{at(0, 59), at(5, 30), at(12, 0)}},
+		{window: "22:00-02:00", in: []time.Time{at(22, 0), at(23, 59), at(1, 59)}, out: []time.Time{at(2, 0), at(21, 59)}},
+		{window: "02:00", wantErr: true},
+		{window: "2am-4am", wantErr: true},
+		{window: "01:00-25:00", wantErr: true},
+	} {
+		t.Run(cmp.Or(tc.window, "always open"), func(t *testing.T) {
+			w, err := ParseWindow(tc.window)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ParseWindow(%q) = %+v, want an error", tc.window, w)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tm := range tc.in {
+				if !w.Contains(tm) {
+					t.Errorf("%s is outside %q, want inside", tm.Format("15:04"), tc.window)
+				}
+			}
+			for _, tm := range tc.out {
+				if w.Contains(tm) {
+					t.Errorf("%s is inside %q, want outside", tm.Format("15:04"), tc.window)
+				}
+			}
+		})
+	}
+}
+
+// runScheduler runs a scheduler ticking every 10ms in window for a while
+// and returns the runs it recorded.
+func runScheduler(t *testing.T, db *sql.DB, window Window, wait time.Duration) []Run {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewScheduler(db, SchedulerConfig{Interval: 10 * time.Millisecond, Window: window, Holder: "replica-1"}).Run(ctx)
+	}()
+
+	var runs []Run
+	deadline := time.Now().Add(wait)
+	for len(runs) < len(Tasks) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		runs, err = ListRuns(db, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	<-done
+	return runs
+}
+
+func TestSchedulerRunsOnlyInItsWindow(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	since := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	day := 24 * time.Hour
+
+	closed := Window{Start: (since + 2*time.Hour) % day, End: (since + 3*time.Hour) % day}
+	if runs := runScheduler(t, db, closed, 200*time.Millisecond); len(runs) > 0 {
+		t.Fatalf("runs = %+v outside the window, want none", runs)
+	}
+
+	open := Window{Start: (since + day - time.Hour) % day, End: (since + time.Hour) % day}
+	runs := runScheduler(t, db, open, 5*time.Second)
+	if len(runs) < len(Tasks) {
+		t.Fatalf("runs = %+v inside the window, want every task", runs)
+	}
+	for _, run := range runs {
+		if run.Error != "" {
+			t.Errorf("run %+v failed", run)
+		}
+	}
+}
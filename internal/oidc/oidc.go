@@ -0,0 +1,318 @@
+// Package oidc signs people in through an OpenID Connect provider with the
+// authorization code flow and PKCE. It speaks just enough of the protocol
+// for that: discovery, the code exchange, and checking RS256 ID tokens
+// against the keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config names the client registered with the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is where the provider sends people back to, the
+	// service's /login/callback.
+	RedirectURL string
+
+	// Scopes are requested on top of openid; nil means email and
+	// profile. Some providers only include groups for a scope of their own.
+	Scopes []string
+
+	// GroupsClaim is the ID token claim listing the user's groups; empty
+	// means "groups".
+	GroupsClaim string
+}
+
+// Identity is who an ID token says signed in.
+type Identity struct {
+	Subject string
+	Email   string
+	Groups  []string
+}
+
+// Name is how the identity shows up as an actor: the email when the
+// provider shares it, the subject otherwise.
+func (id Identity) Name() string {
+	if id.Email != "" {
+		return id.Email
+	}
+	return id.Subject
+}
+
+// Provider is a discovered OIDC provider.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	authURL  string
+	tokenURL string
+	jwksURL  string
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// keyRefetchInterval keeps a token with an unknown key id from sending us
+// to the provider on every login.
+const keyRefetchInterval = time.Minute
+
+// clockSkew is how far the provider's clock may be off ours.
+const clockSkew = time.Minute
+
+// Discover reads the provider's configuration from its well-known
+// document.
+func Discover(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.Scopes == nil {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	p := &Provider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	err := p.getJSON(ctx, strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if doc.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer is %q, want %q", doc.Issuer, cfg.Issuer)
+	}
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURL == "" {
+		return nil, fmt.Errorf("oidc discovery: %s lacks an authorization, token or jwks endpoint", cfg.Issuer)
+	}
+	p.authURL, p.tokenURL, p.jwksURL = doc.AuthURL, doc.TokenURL, doc.JWKSURL
+	return p, nil
+}
+
+// RandomString returns a fresh value for a state, nonce or PKCE verifier.
+func RandomString() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// AuthCodeURL is where to send someone to sign in. state comes back on the
+// callback, nonce in the ID token, and verifier is presented at Exchange.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + q.Encode()
+}
+
+// Exchange trades a callback's code for the identity in its ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tok)
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc token exchange: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.IDToken == "" {
+		return Identity{}, fmt.Errorf("oidc token exchange: %s %s", resp.Status, tok.Error)
+	}
+	return p.Verify(ctx, tok.IDToken, nonce)
+}
+
+// Verify checks an ID token's signature, issuer, audience, expiry and
+// nonce and returns the identity it carries.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (Identity, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Identity{}, errors.New("oidc: malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc: ID token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return Identity{}, fmt.Errorf("oidc: ID token signed with %q, only RS256 is accepted", header.Alg)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return Identity{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc: ID token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		return Identity{}, errors.New("oidc: ID token signature does not verify")
+	}
+
+	var claims map[string]any
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc: ID token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != p.cfg.Issuer {
+		return Identity{}, fmt.Errorf("oidc: ID token from %q, want %q", iss, p.cfg.Issuer)
+	}
+	if !slices.Contains(stringList(claims["aud"]), p.cfg.ClientID) {
+		return Identity{}, errors.New("oidc: ID token is not for this client")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Unix(int64(exp), 0).Add(clockSkew).Before(time.Now()) {
+		return Identity{}, errors.New("oidc: ID token has expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return Identity{}, errors.New("oidc: ID token nonce does not match")
+	}
+
+	id := Identity{Groups: stringList(claims[p.cfg.GroupsClaim])}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	if id.Subject == "" {
+		return Identity{}, errors.New("oidc: ID token has no subject")
+	}
+	return id, nil
+}
+
+// key returns the provider's signing key kid, fetching the key set again
+// when kid is new to us, as it is after the provider rotates keys.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.fetchedAt) < keyRefetchInterval {
+		return nil, fmt.Errorf("oidc: no signing key %q", kid)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err := p.getJSON(ctx, p.jwksURL, &set)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching signing keys: %w", err)
+	}
+	p.fetchedAt = time.Now()
+	p.keys = map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: no signing key %q", kid)
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func decodeSegment(seg string, dst any) error {
+	buf, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, dst)
+}
+
+// stringList reads a claim that may be one string or a list of them, as
+// aud and many providers' groups claims are.
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider issues ID tokens with claims for whatever code it is
+// handed, after checking the PKCE verifier against the last challenge.
+type fakeProvider struct {
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	claims    map[string]map[string]any
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeProvider{key: key, claims: map[string]map[string]any{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.srv.URL,
+			"authorization_endpoint": f.srv.URL + "/authorize",
+			"token_endpoint":         f.srv.URL + "/token",
+			"jwks_uri":               f.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		claims, ok := f.claims[r.PostFormValue("code")]
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": f.sign(t, "k1", claims)})
+	})
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeProvider) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestExchangeVerifiesTheIDToken(t *testing.T) {
+	f := newFakeProvider(t)
+	ctx := context.Background()
+	p, err := Discover(ctx, Config{Issuer: f.srv.URL, ClientID: "orders", RedirectURL: "http://orders/login/callback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := RandomString()
+	auth := p.AuthCodeURL("state-1", "nonce-1", verifier)
+	if !strings.HasPrefix(auth, f.srv.URL+"/authorize?") || !strings.Contains(auth, "code_challenge_method=S256") {
+		t.Errorf("AuthCodeURL = %s", auth)
+	}
+	_, query, _ := strings.Cut(auth, "?")
+	for _, kv := range strings.Split(query, "&") {
+		if v, ok := strings.CutPrefix(kv, "code_challenge="); ok {
+			f.challenge = v
+		}
+	}
+
+	good := map[string]any{
+		"iss":    f.srv.URL,
+		"aud":    []string{"orders", "other"},
+		"sub":    "u-42",
+		"email":  "ada@example.com",
+		"groups": []string{"ops", "eng"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"nonce":  "nonce-1",
+	}
+	with := func(k string, v any) map[string]any {
+		c := map[string]any{}
+		for k, v := range good {
+			c[k] = v
+		}
+		c[k] = v
+		return c
+	}
+	f.claims["good"] = good
+	f.claims["other-client"] = with("aud", "someone-else")
+	f.claims["expired"] = with("exp", time.Now().Add(-time.Hour).Unix())
+	f.claims["other-issuer"] = with("iss", "https://evil.example.com")
+
+	id, err := p.Exchange(ctx, "good", verifier, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "u-42" || id.Name() != "ada@example.com" || !slices.Equal(id.Groups, []string{"ops", "eng"}) {
+		t.Errorf("identity = %+v", id)
+	}
+
+	for _, c := range []struct{ code, verifier, nonce, want string }{
+		{"good", verifier, "nonce-2", "nonce"},
+		{"good", "wrong-verifier", "nonce-1", "invalid_grant"},
+		{"other-client", verifier, "nonce-1", "not for this client"},
+		{"expired", verifier, "nonce-1", "expired"},
+		{"other-issuer", verifier, "nonce-1", "evil.example.com"},
+	} {
+		_, err := p.Exchange(ctx, c.code, c.verifier, c.nonce)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("Exchange(%s, nonce %s) = %v, want an error about %s", c.code, c.nonce, err, c.want)
+		}
+	}
+
+	forged := f.sign(t, "k2", good)
+	_, err = p.Verify(ctx, forged, "nonce-1")
+	if err == nil || !strings.Contains(err.Error(), `no signing key "k2"`) {
+		t.Errorf("Verify with an unknown key = %v", err)
+	}
+	parts := strings.Split(f.sign(t, "k1", good), ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"root"}`)) + "." + parts[2]
+	_, err = p.Verify(ctx, tampered, "nonce-1")
+	if err == nil || !strings.Contains(err.Error(), "does not verify") {
+		t.Errorf("Verify with altered claims = %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package poller
+
+import (
+	"expvar"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	breakerTrips  = expvar.NewInt("breaker_trips")
+	breakerStates = expvar.NewMap("poller_breakers")
+)
+
+const defaultBreakerCooldown = 30 * time.Second
+
+// breaker stops a poller's deliveries after BreakerFailures handler calls
+// in a row failed, on the assumption that the sink behind them is down and
+// burning attempts on it only pushes changes towards quarantine. While it
+// is open the poller claims nothing. Once the cooldown is over it lets one
+// change through: success closes it, failure opens it for another cooldown.
+// A nil breaker never opens.
+type breaker struct {
+	poller    string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func newBreaker(cfg Config) *breaker {
+	if cfg.BreakerFailures <= 0 {
+		return nil
+	}
+	b := &breaker{
+		poller:    cfg.Name,
+		threshold: cfg.BreakerFailures,
+		cooldown:  cfg.BreakerCooldown.Duration,
+	}
+	if b.cooldown == 0 {
+		b.cooldown = defaultBreakerCooldown
+	}
+	return b
+}
+
+func (b *breaker) state(now time.Time) string {
+	switch {
+	case b.openedAt.IsZero():
+		return "closed"
+	case now.Before(b.openedAt.Add(b.cooldown)):
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// ready reports whether a cycle should claim changes at all.
+func (b *breaker) ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state(time.Now()) != "open"
+}
+
+// allow reports whether a change may go to the handlers now. Half open,
+// only one trial change is let through at a time.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state(time.Now()) {
+	case "closed":
+		return true
+	case "half-open":
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return false
+}
+
+// record counts the outcome of a change allow let through.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil {
+		if !b.openedAt.IsZero() {
+			log.Printf("Poller %s breaker closed", b.poller)
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+	b.openedAt = time.Now()
+	breakerTrips.Add(1)
+	log.Printf("ALERT: poller %s breaker open for %s after %d failures in a row: %v",
+		b.poller, b.cooldown, b.failures, err)
+}
+
+// String makes the breaker an expvar showing its current state.
+func (b *breaker) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strconv.Quote(b.state(time.Now()))
+}
+
+// publish lists the breaker in the metrics while its poller runs.
+func (b *breaker) publish() {
+	if b != nil {
+		breakerStates.Set(b.poller, b)
+	}
+}
+
+func (b *breaker) unpublish() {
+	if b != nil {
+		breakerStates.Delete(b.poller)
+	}
+}
+
+// BreakerState is a running poller's circuit breaker as the admin API shows
+// it.
+type BreakerState struct {
+	Poller              string    `json:"poller"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	OpenedAt            time.Time `json:"openedAt,omitzero"`
+	RetryAt             time.Time `json:"retryAt,omitzero"`
+}
+
+func (b *breaker) snapshot() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerState{
+		Poller:              b.poller,
+		State:               b.state(time.Now()),
+		ConsecutiveFailures: b.failures,
+		OpenedAt:            b.openedAt,
+	}
+	if !b.openedAt.IsZero() {
+		s.RetryAt = b.openedAt.Add(b.cooldown)
+	}
+	return s
+}
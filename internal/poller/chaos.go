@@ -0,0 +1,87 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+var (
+	errChaosHandler = errors.New("chaos: injected handler failure")
+	errChaosCommit  = errors.New("chaos: injected commit failure")
+)
+
+// ChaosConfig sets the probability, from 0 to 1, of each injected fault.
+// It exists for staging and resilience testing only.
+type ChaosConfig struct {
+	HandlerFailure float64
+	SlowQuery      float64
+	SlowQueryDelay time.Duration
+	CommitFailure  float64
+}
+
+func (c ChaosConfig) Validate() error {
+	for _, p := range []float64{c.HandlerFailure, c.SlowQuery, c.CommitFailure} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("chaos probabilities must be between 0 and 1, got %v", p)
+		}
+	}
+	return nil
+}
+
+func (c ChaosConfig) enabled() bool {
+	return c.HandlerFailure > 0 || c.SlowQuery > 0 || c.CommitFailure > 0
+}
+
+// Chaos injects faults into the polling path. A nil *Chaos injects nothing,
+// so call sites don't need to check whether the mode is on.
+type Chaos struct {
+	cfg ChaosConfig
+}
+
+// NewChaos returns nil when cfg injects nothing.
+func NewChaos(cfg ChaosConfig) *Chaos {
+	if !cfg.enabled() {
+		return nil
+	}
+	log.Printf(
+		"CHAOS MODE ENABLED: handler failure %.2f, slow query %.2f (%s), commit failure %.2f",
+		cfg.HandlerFailure,
+		cfg.SlowQuery,
+		cfg.SlowQueryDelay,
+		cfg.CommitFailure,
+	)
+	return &Chaos{cfg: cfg}
+}
+
+func (c *Chaos) handlerFault() error {
+	if c == nil || rand.Float64() >= c.cfg.HandlerFailure {
+		return nil
+	}
+	return errChaosHandler
+}
+
+// slowQuery stalls for the configured delay, as a lock wait or an
+// overloaded database would, unless ctx ends first.
+func (c *Chaos) slowQuery(ctx context.Context) error {
+	if c == nil || rand.Float64() >= c.cfg.SlowQuery {
+		return nil
+	}
+	log.Printf("chaos: delaying query by %s", c.cfg.SlowQueryDelay)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(c.cfg.SlowQueryDelay):
+		return nil
+	}
+}
+
+func (c *Chaos) commitFault() error {
+	if c == nil || rand.Float64() >= c.cfg.CommitFailure {
+		return nil
+	}
+	return errChaosCommit
+}
@@ -0,0 +1,292 @@
+package poller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"test/internal/store"
+)
+
+type Config struct {
+	Name     string   `json:"name"`
+	Product  string   `json:"product"`
+	Priority string   `json:"priority"`
+	Tag      string   `json:"tag"`
+	Interval Duration `json:"interval"`
+
+	// Tenant limits the poller to one tenant's changes; empty drains
+	// every tenant's.
+	Tenant string `json:"tenant,omitempty"`
+
+	// PerTenant runs one instance of the poller per tenant with orders,
+	// as "<name>@<tenant>", each with its own offset, claims, breaker and
+	// heartbeat, so one tenant's backlog or failing changes never hold up
+	// another's. New tenants are picked up with the rules.
+	PerTenant bool `json:"perTenant,omitempty"`
+
+	// Filter adds one whitelisted condition on top of the fields above.
+	Filter *FilterSpec `json:"filter,omitempty"`
+
+	// DryRun logs what the poller would process without running handlers,
+	// marking changes processed or moving the offset.
+	DryRun bool `json:"dryRun"`
+
+	// Handler limits the poller to registered handlers of that name; empty
+	// runs every handler registered for each row's change type.
+	Handler string `json:"handler"`
+
+	// Workers dispatches a cycle's changes on this many goroutines, split
+	// by order so one order's changes still run one at a time and in id
+	// order. 0 or 1 dispatches them one after another.
+	Workers int `json:"workers"`
+
+	// MaxInFlight caps the changes a cycle claims and hands to the
+	// workers; 0 claims everything eligible. A cycle that hits the cap
+	// is followed by the next at once rather than after Interval, so
+	// claims keep pace with what the workers get through and no more.
+	MaxInFlight int `json:"maxInFlight"`
+
+	// Shards splits the poller's changes by order id across instances:
+	// each claims only those with order_id % Shards equal to its shard
+	// and keeps its own offset, under "<name>#<shard>". Shard pins this
+	// instance to one; without it instances take free shards from
+	// poller_shards, leased for Lease like changes, so one that dies
+	// hands its shard over. 0 or 1 runs unsharded.
+	Shards int  `json:"shards"`
+	Shard  *int `json:"shard,omitempty"`
+
+	// Lease is how long a cycle's claim on its changes lasts. A worker
+	// that dies mid-cycle holds them up for this long before another
+	// picks them up, so keep it above the slowest cycle. It must be
+	// longer than Interval.
+	Lease Duration `json:"lease"`
+
+	// MaxAttempts is how many times a change is tried before it is left
+	// unprocessed as a dead letter; retries back off exponentially.
+	MaxAttempts int `json:"maxAttempts"`
+
+	// ProductBatch caps how many changes of each product a cycle claims,
+	// so a product with a huge backlog takes turns with the others rather
+	// than filling every cycle; 0 claims everything eligible.
+	// ProductWeights scales the cap for some products; others get 1.
+	ProductBatch   int            `json:"productBatch"`
+	ProductWeights map[string]int `json:"productWeights,omitempty"`
+
+	// RateLimit is the most changes per second the poller hands to its
+	// handlers, spread evenly, so draining a backlog doesn't flood the
+	// sinks behind them; 0 means no limit. A cycle then claims no more
+	// than it can dispatch in half its lease.
+	RateLimit float64 `json:"rateLimit"`
+
+	// BreakerFailures opens the poller's circuit breaker after that many
+	// handler failures in a row: the poller stops claiming changes for
+	// BreakerCooldown (30s by default), then tries one to see whether the
+	// sink is back. 0 disables the breaker.
+	BreakerFailures int      `json:"breakerFailures"`
+	BreakerCooldown Duration `json:"breakerCooldown"`
+
+	// HandlerRetries calls a failing handler again up to that many times
+	// within the cycle, 100ms apart and doubling, before the change is
+	// requeued with an attempt counted against MaxAttempts.
+	HandlerRetries int `json:"handlerRetries"`
+
+	// HandlerTimeout bounds each handler call through its context; a call
+	// that runs over fails and is retried like any other failure. 0 leaves
+	// handlers unbounded.
+	HandlerTimeout Duration `json:"handlerTimeout"`
+}
+
+const (
+	maxWorkers         = 64
+	defaultLease       = time.Minute
+	defaultMaxAttempts = 10
+)
+
+// DefaultConfigs reproduces the original hardcoded behavior: only ninja
+// orders are picked up.
+var DefaultConfigs = []Config{
+	{
+		Name:     "ninja",
+		Product:  "ninja",
+		Interval: Duration{5 * time.Second},
+		Handler:  "log",
+	},
+}
+
+// Duration reads "5s"-style strings from JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// fileConfig is the JSON file given with -config, see pollers.example.json.
+type fileConfig struct {
+	Pollers []Config `json:"pollers"`
+}
+
+// LoadConfigs returns the validated pollers from the config file at path, or
+// DefaultConfigs when path is empty or the file defines none.
+func LoadConfigs(path string) ([]Config, error) {
+	configs := slices.Clone(DefaultConfigs)
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var fc fileConfig
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&fc)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+		if len(fc.Pollers) > 0 {
+			configs = fc.Pollers
+		}
+	}
+
+	err := ValidateAll(configs)
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// ValidateAll checks and normalizes a set of pollers that must have unique
+// names, such as the ones from a config file.
+func ValidateAll(configs []Config) error {
+	seen := make(map[string]bool)
+	for i := range configs {
+		p := &configs[i]
+		if p.Name == "" {
+			return fmt.Errorf("poller %d: name is required", i)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("poller %s: duplicate name", p.Name)
+		}
+		seen[p.Name] = true
+
+		err := Validate(p)
+		if err != nil {
+			return fmt.Errorf("poller %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// Validate normalizes p in place. Names are checked by the caller since
+// uniqueness depends on where the poller comes from.
+func Validate(p *Config) error {
+	if p.Priority != "" {
+		priority, ok := store.ParsePriority(p.Priority)
+		if !ok {
+			return fmt.Errorf("unknown priority %q", p.Priority)
+		}
+		p.Priority = priority
+	}
+	if p.Interval.Duration == 0 {
+		p.Interval.Duration = 5 * time.Second
+	}
+	if p.Interval.Duration < 100*time.Millisecond {
+		return fmt.Errorf("interval %s is too short", p.Interval)
+	}
+	if p.Lease.Duration == 0 {
+		p.Lease.Duration = defaultLease
+	}
+	if p.Lease.Duration < time.Second {
+		return fmt.Errorf("lease %s is too short", p.Lease)
+	}
+	// The leader and shard leases are renewed once a cycle, so they would
+	// lapse between cycles.
+	if p.Interval.Duration >= p.Lease.Duration {
+		return fmt.Errorf("interval %s must be shorter than lease %s", p.Interval, p.Lease)
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("maxAttempts must be positive, got %d", p.MaxAttempts)
+	}
+	if p.ProductBatch < 0 {
+		return fmt.Errorf("productBatch must not be negative, got %d", p.ProductBatch)
+	}
+	if len(p.ProductWeights) > 0 && p.ProductBatch == 0 {
+		return fmt.Errorf("productWeights need a productBatch")
+	}
+	for product, w := range p.ProductWeights {
+		if w < 1 {
+			return fmt.Errorf("weight for product %q must be at least 1, got %d", product, w)
+		}
+	}
+	if p.RateLimit < 0 {
+		return fmt.Errorf("rateLimit must not be negative, got %g", p.RateLimit)
+	}
+	if p.BreakerFailures < 0 {
+		return fmt.Errorf("breakerFailures must not be negative, got %d", p.BreakerFailures)
+	}
+	if p.BreakerFailures > 0 {
+		if p.BreakerCooldown.Duration == 0 {
+			p.BreakerCooldown.Duration = defaultBreakerCooldown
+		}
+		if p.BreakerCooldown.Duration < time.Second {
+			return fmt.Errorf("breakerCooldown %s is too short", p.BreakerCooldown)
+		}
+	}
+	if p.HandlerRetries < 0 || p.HandlerRetries > maxHandlerRetry {
+		return fmt.Errorf("handlerRetries must be between 0 and %d, got %d", maxHandlerRetry, p.HandlerRetries)
+	}
+	if p.HandlerTimeout.Duration < 0 {
+		return fmt.Errorf("handlerTimeout must not be negative, got %s", p.HandlerTimeout)
+	}
+	if p.Shards < 0 || p.Shards > maxShards {
+		return fmt.Errorf("shards must be between 0 and %d, got %d", maxShards, p.Shards)
+	}
+	if p.Shard != nil && (p.Shards < 2 || *p.Shard < 0 || *p.Shard >= p.Shards) {
+		return fmt.Errorf("shard %d is not one of the %d shards", *p.Shard, p.Shards)
+	}
+	if p.PerTenant && p.Tenant != "" {
+		return fmt.Errorf("perTenant and tenant are mutually exclusive")
+	}
+	if p.PerTenant && p.Shards > 1 {
+		return fmt.Errorf("perTenant pollers cannot be sharded")
+	}
+	if p.MaxInFlight < 0 {
+		return fmt.Errorf("maxInFlight must not be negative, got %d", p.MaxInFlight)
+	}
+	if p.Workers < 0 || p.Workers > maxWorkers {
+		return fmt.Errorf("workers must be between 0 and %d, got %d", maxWorkers, p.Workers)
+	}
+	if p.Handler != "" && !handlers.hasName(p.Handler) {
+		return fmt.Errorf(
+			"unknown handler %q (registered: %s)",
+			p.Handler,
+			strings.Join(handlers.names(), ", "),
+		)
+	}
+	if p.Filter != nil {
+		err := p.Filter.normalize()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package poller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"test/internal/store"
+)
+
+// cycle collects what handlers record during a poll cycle for its
+// completion transaction.
+type cycle struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	delivered []delivery
+}
+
+type delivery struct {
+	changeID int64
+	sink     string
+}
+
+type cycleKey struct{}
+
+// withCycle hands the poll cycle to the handlers it dispatches to.
+func withCycle(ctx context.Context, cy *cycle) context.Context {
+	return context.WithValue(ctx, cycleKey{}, cy)
+}
+
+func cycleFrom(ctx context.Context) *cycle {
+	cy, _ := ctx.Value(cycleKey{}).(*cycle)
+	return cy
+}
+
+func (cy *cycle) recordDeliveries(ctx context.Context, tx *sql.Tx) error {
+	cy.mu.Lock()
+	defer cy.mu.Unlock()
+	for _, d := range cy.delivered {
+		_, err := tx.ExecContext(ctx, `
+            `+store.DialectOf(cy.db).InsertIgnore()+` INTO delivered_events (change_id, sink) VALUES (?, ?)
+        `, d.changeID, d.sink)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deliver emits c to an external sink unless delivered_events shows it
+// already reached that sink. The delivery is recorded in the transaction
+// that completes the poll cycle and marks the change processed, so a
+// requeue, an offset reset or a restart after it can't send the same change
+// to the same sink twice. A cycle that fails to complete sends it again;
+// use Effects.Once inside emit where that matters too. Outside a poll cycle
+// emit simply runs.
+func Deliver(ctx context.Context, c Change, sink string, emit func(ctx context.Context) error) error {
+	cy := cycleFrom(ctx)
+	if cy == nil {
+		return emit(ctx)
+	}
+
+	var one int
+	err := cy.db.QueryRowContext(ctx, `
+        SELECT 1 FROM delivered_events WHERE change_id = ? AND sink = ?
+    `, c.ID, sink).Scan(&one)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = emit(ctx)
+	if err != nil {
+		return err
+	}
+	cy.mu.Lock()
+	cy.delivered = append(cy.delivered, delivery{changeID: c.ID, sink: sink})
+	cy.mu.Unlock()
+	return nil
+}
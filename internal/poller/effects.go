@@ -0,0 +1,84 @@
+package poller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"test/internal/store"
+)
+
+// Effects is a ledger of handler side effects that completed, keyed by the
+// change's processing key and the handler's name. A cycle that dies after
+// a handler succeeded but before its commit redelivers the change; handlers
+// that wrap their external effects in Once skip the ones already done.
+//
+// Records are committed on their own as soon as an effect completes, so
+// they survive a cycle that never does.
+type Effects struct {
+	db      *sql.DB
+	dialect store.Dialect
+}
+
+// OpenEffects opens the ledger at dsn, SQLite or MySQL, creating its table
+// if needed.
+func OpenEffects(dsn string) (*Effects, error) {
+	db, err := store.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS handler_effects (
+            effect_key VARCHAR(255) NOT NULL,
+            handler VARCHAR(255) NOT NULL,
+            done_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (effect_key, handler)
+        )
+    `)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("effects ledger %s: %w", dsn, err)
+	}
+	return &Effects{db: db, dialect: store.DialectOf(db)}, nil
+}
+
+func (e *Effects) Close() error {
+	return e.db.Close()
+}
+
+// Once runs fn unless handler already completed it for key, and records it
+// once fn succeeds. An effect interrupted between fn returning and the
+// record being written runs again, so fn should still pass key on to
+// systems that can deduplicate by it. A nil ledger runs fn every time.
+func (e *Effects) Once(ctx context.Context, key, handler string, fn func(ctx context.Context) error) error {
+	if e == nil {
+		return fn(ctx)
+	}
+
+	var one int
+	err := e.db.QueryRowContext(ctx, `
+        SELECT 1 FROM handler_effects WHERE effect_key = ? AND handler = ?
+    `, key, handler).Scan(&one)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = fn(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = e.db.ExecContext(ctx, `
+        `+e.dialect.InsertIgnore()+` INTO handler_effects (effect_key, handler) VALUES (?, ?)
+    `, key, handler)
+	return err
+}
+
+// processingKey identifies a change for as long as the database keeps it,
+// across redeliveries and pollers.
+func processingKey(id int64) string {
+	return fmt.Sprintf("change-%d", id)
+}
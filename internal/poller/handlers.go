@@ -0,0 +1,150 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// AnyChangeType registers a handler for every change type.
+const AnyChangeType = "*"
+
+// Handler acts on one change. Returning an error leaves the change
+// unprocessed. Delivery is at least once: a change can reach a handler again
+// after it succeeded, with the same Key, so external effects should go
+// through p.Effects().Once.
+type Handler func(ctx context.Context, p *Poller, c Change) error
+
+// BatchHandler acts on all of a cycle's changes for it in one call, such as
+// one bulk request to a sink, after the changes' Handlers succeeded. The
+// changes come in dispatch order, so each order's are in id order.
+// Returning a *BatchError fails only the changes it names; any other error
+// fails them all.
+type BatchHandler func(ctx context.Context, p *Poller, changes []Change) error
+
+// BatchError reports which changes of a batch failed, by change id.
+type BatchError struct {
+	Failed map[int64]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d changes of the batch failed", len(e.Failed))
+}
+
+type registeredHandler struct {
+	name   string
+	handle Handler
+	batch  BatchHandler
+}
+
+// handlerRegistry maps change types to the handlers that act on them. New
+// behaviors are attached by registering another handler in init; pollers
+// pick the handlers for each row by its change type.
+type handlerRegistry struct {
+	mu         sync.RWMutex
+	byType     map[string][]registeredHandler
+	middleware []Middleware
+}
+
+var handlers = &handlerRegistry{byType: make(map[string][]registeredHandler)}
+
+// Register adds h under name for changeType, or for every type with
+// AnyChangeType. Call it from init so the handler exists before configs that
+// name it are validated.
+func Register(changeType, name string, h Handler) {
+	handlers.register(changeType, name, h)
+}
+
+// RegisterBatch is Register for a BatchHandler. Pollers call a change's
+// batch handlers after its other handlers.
+func RegisterBatch(changeType, name string, h BatchHandler) {
+	handlers.add(changeType, registeredHandler{name: name, batch: h})
+}
+
+func (r *handlerRegistry) register(changeType, name string, h Handler) {
+	r.add(changeType, registeredHandler{name: name, handle: h})
+}
+
+func (r *handlerRegistry) add(changeType string, h registeredHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byType[changeType] = append(r.byType[changeType], h)
+}
+
+// lookup returns the per-change handlers for changeType, type-specific ones
+// first, wrapped in their middleware. When name is non-empty only handlers
+// registered under that name are returned.
+func (r *handlerRegistry) lookup(changeType, name string) []registeredHandler {
+	return r.find(changeType, name, false)
+}
+
+// lookupBatch is lookup for batch handlers.
+func (r *handlerRegistry) lookupBatch(changeType, name string) []registeredHandler {
+	return r.find(changeType, name, true)
+}
+
+func (r *handlerRegistry) find(changeType, name string, batch bool) []registeredHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var out []registeredHandler
+	for _, key := range []string{changeType, AnyChangeType} {
+		for _, h := range r.byType[key] {
+			if (h.batch != nil) != batch || (name != "" && h.name != name) {
+				continue
+			}
+			if !batch {
+				h.handle = r.wrap(h.name, h.handle)
+			}
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func (r *handlerRegistry) hasName(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, hs := range r.byType {
+		for _, h := range hs {
+			if h.name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *handlerRegistry) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]bool)
+	var out []string
+	for _, hs := range r.byType {
+		for _, h := range hs {
+			if !seen[h.name] {
+				seen[h.name] = true
+				out = append(out, h.name)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func init() {
+	handlers.register(AnyChangeType, "log", logChange)
+}
+
+func logChange(ctx context.Context, p *Poller, c Change) error {
+	log.Printf(
+		"Polling worker %s processed %s change for %s order #%d",
+		p.Name(),
+		c.ChangeType,
+		c.ProductName,
+		c.OrderID,
+	)
+	return nil
+}
@@ -0,0 +1,176 @@
+package poller
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"test/internal/logdedup"
+)
+
+// InstanceID names this process in poller_heartbeats. It stays the same
+// across restarts on one host, so a restarted worker takes over its old
+// heartbeat rows instead of leaving them to go stale.
+var InstanceID = defaultInstanceID()
+
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "/" + filepath.Base(os.Args[0])
+}
+
+// HeartbeatInterval throttles heartbeat writes for pollers cycling faster;
+// staleness thresholds must leave room for it.
+const HeartbeatInterval = 5 * time.Second
+
+var stalePollers = expvar.NewInt("stale_pollers")
+
+// pollerBacklog and pollerLag are each running poller's changes still to
+// process and the age in seconds of the oldest, by poller name, so the
+// instances of a per-tenant poller report one tenant each.
+var (
+	pollerBacklog = expvar.NewMap("poller_backlog")
+	pollerLag     = expvar.NewMap("poller_lag_seconds")
+)
+
+// beat records a completed cycle, along with the poller's offset.
+func (p *Poller) beat(ctx context.Context) error {
+	if time.Since(p.lastBeat) < HeartbeatInterval {
+		return nil
+	}
+	_, err := p.db.ExecContext(ctx, `
+        INSERT INTO poller_heartbeats (instance, poller, last_cycle_at, last_processed_id)
+        VALUES (?, ?, CURRENT_TIMESTAMP,
+                COALESCE((SELECT last_processed_id FROM poller_offsets WHERE poller = ?), 0))
+        `+p.dialect.OnConflictUpdate("instance, poller", `
+            last_cycle_at = `+p.dialect.Inserted("last_cycle_at")+`,
+            last_processed_id = `+p.dialect.Inserted("last_processed_id"))+`
+    `, InstanceID, p.cfg.Name, p.offsetName())
+	if err != nil {
+		return err
+	}
+	p.lastBeat = time.Now()
+	return p.publishLag(ctx)
+}
+
+// publishLag counts the changes past the poller's offset it has yet to
+// process, leaving out dead letters, for pollerBacklog and pollerLag.
+func (p *Poller) publishLag(ctx context.Context) error {
+	filter, args := p.filter()
+	var backlog int64
+	var lag sql.NullFloat64
+	err := p.db.QueryRowContext(ctx, `
+        SELECT COUNT(*), `+p.dialect.SecondsBetween("MIN(pc.created_at)", "CURRENT_TIMESTAMP")+`
+        FROM priority_changes pc
+        JOIN orders o ON pc.order_id = o.id
+        WHERE pc.id > COALESCE((SELECT last_processed_id FROM poller_offsets WHERE poller = ?), 0)
+        AND pc.processed = FALSE
+        AND pc.attempts < ?
+        `+filter,
+		append([]any{p.offsetName(), p.maxAttempts()}, args...)...,
+	).Scan(&backlog, &lag)
+	if err != nil {
+		return err
+	}
+
+	n := new(expvar.Int)
+	n.Set(backlog)
+	pollerBacklog.Set(p.cfg.Name, n)
+	secs := new(expvar.Int)
+	secs.Set(int64(lag.Float64))
+	pollerLag.Set(p.cfg.Name, secs)
+	return nil
+}
+
+func (p *Poller) unpublishLag() {
+	pollerBacklog.Delete(p.cfg.Name)
+	pollerLag.Delete(p.cfg.Name)
+}
+
+// clearBeat removes the heartbeat of a poller stopped on purpose, so only
+// pollers that stop without saying so are reported stale.
+func (p *Poller) clearBeat() {
+	_, err := p.db.Exec(`DELETE FROM poller_heartbeats WHERE instance = ? AND poller = ?`, InstanceID, p.cfg.Name)
+	if err != nil {
+		log.Printf("Error clearing heartbeat of %s: %v", p.cfg.Name, err)
+	}
+}
+
+type Heartbeat struct {
+	Instance        string    `json:"instance"`
+	Poller          string    `json:"poller"`
+	LastCycleAt     time.Time `json:"lastCycleAt"`
+	LastProcessedID int64     `json:"lastProcessedId"`
+	Stale           bool      `json:"stale"`
+}
+
+// ListHeartbeats returns every running poller's heartbeat, flagging those
+// with no completed cycle within staleAfter.
+func ListHeartbeats(ctx context.Context, db *sql.DB, staleAfter time.Duration) ([]Heartbeat, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT instance, poller, last_cycle_at, last_processed_id
+        FROM poller_heartbeats
+        ORDER BY instance, poller
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	heartbeats := []Heartbeat{}
+	for rows.Next() {
+		var h Heartbeat
+		err = rows.Scan(&h.Instance, &h.Poller, &h.LastCycleAt, &h.LastProcessedID)
+		if err != nil {
+			return nil, err
+		}
+		h.Stale = time.Since(h.LastCycleAt) > staleAfter
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, rows.Err()
+}
+
+// WatchHeartbeats checks every poller's heartbeat, across all instances
+// sharing the database, until ctx is cancelled. Stale pollers are logged
+// and counted in the stale_pollers metric.
+func WatchHeartbeats(ctx context.Context, db *sql.DB, staleAfter time.Duration) {
+	ticker := time.NewTicker(staleAfter / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		heartbeats, err := ListHeartbeats(ctx, db, staleAfter)
+		if err != nil {
+			if ctx.Err() == nil {
+				logdedup.Printf("Error checking poller heartbeats: %v", err)
+			}
+			continue
+		}
+		var stale int64
+		for _, h := range heartbeats {
+			if !h.Stale {
+				continue
+			}
+			stale++
+			log.Printf(
+				"ALERT: poller %s on %s has not completed a cycle since %s (offset %d)",
+				h.Poller,
+				h.Instance,
+				h.LastCycleAt.Format(time.RFC3339),
+				h.LastProcessedID,
+			)
+		}
+		stalePollers.Set(stale)
+	}
+}
@@ -0,0 +1,118 @@
+package poller
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// histogram is an expvar of observations counted into buckets by upper
+// bound, cumulatively as Prometheus does, with their count and sum. Each
+// bucket keeps the trace of its latest observation that had one as an
+// exemplar, so a spike in a bucket leads to a cycle to look at.
+type histogram struct {
+	bounds []float64
+
+	mu        sync.Mutex
+	counts    []int64
+	count     int64
+	sum       float64
+	exemplars map[string]exemplar
+}
+
+type exemplar struct {
+	TraceID   string    `json:"traceId"`
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{
+		bounds:    bounds,
+		counts:    make([]int64, len(bounds)),
+		exemplars: make(map[string]exemplar),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.observeTrace(v, "")
+}
+
+// observeTrace records v, with traceID as the exemplar of its bucket
+// unless it is empty.
+func (h *histogram) observeTrace(v float64, traceID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+
+	if traceID != "" {
+		bucket := "+Inf"
+		if i, _ := slices.BinarySearch(h.bounds, v); i < len(h.bounds) {
+			bucket = formatBound(h.bounds[i])
+		}
+		h.exemplars[bucket] = exemplar{TraceID: traceID, Value: v, Timestamp: time.Now()}
+	}
+}
+
+func formatBound(b float64) string {
+	return strconv.FormatFloat(b, 'g', -1, 64)
+}
+
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var b strings.Builder
+	b.WriteString(`{"buckets": {`)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(&b, "%q: %d, ", formatBound(bound), h.counts[i])
+	}
+	fmt.Fprintf(&b, `"+Inf": %d}, "count": %d, "sum": %g`, h.count, h.count, h.sum)
+	if len(h.exemplars) > 0 {
+		ex, _ := json.Marshal(h.exemplars)
+		fmt.Fprintf(&b, `, "exemplars": %s`, ex)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// processingLatency is the time from a change being recorded to a cycle
+// committing it as processed, in seconds: how long an escalation waits
+// for its handlers to act on it.
+var processingLatency = newHistogram(0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600)
+
+// cycleDuration is how long each cycle took, from its claim to its commit.
+var cycleDuration = newHistogram(0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60)
+
+// handlerLatency is the time each handler call took, by handler name.
+var handlerLatency = expvar.NewMap("handler_latency_seconds")
+
+var handlerBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var handlerLatencyMu sync.Mutex
+
+func observeHandler(name string, seconds float64, traceID string) {
+	handlerLatencyMu.Lock()
+	h, ok := handlerLatency.Get(name).(*histogram)
+	if !ok {
+		h = newHistogram(handlerBounds...)
+		handlerLatency.Set(name, h)
+	}
+	handlerLatencyMu.Unlock()
+	h.observeTrace(seconds, traceID)
+}
+
+func init() {
+	expvar.Publish("processing_latency_seconds", processingLatency)
+	expvar.Publish("cycle_duration_seconds", cycleDuration)
+}
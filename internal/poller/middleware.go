@@ -0,0 +1,157 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps the handler registered under name. Middleware added with
+// Use applies to every Handler, inside the built-in chain: failure logging,
+// retries, timing, the timeout and panic recovery, in that order from the
+// outside, so a panic or a timeout counts as a failed call like any other
+// error. Batch handlers aren't wrapped.
+type Middleware func(name string, next Handler) Handler
+
+// Use adds mws to every handler's chain, the first one listed outermost.
+// Call it from init, like Register.
+func Use(mws ...Middleware) {
+	handlers.mu.Lock()
+	defer handlers.mu.Unlock()
+	handlers.middleware = append(handlers.middleware, mws...)
+}
+
+// wrap applies the built-in chain and the Use middleware to h.
+func (r *handlerRegistry) wrap(name string, h Handler) Handler {
+	mws := append([]Middleware{logHandler, retryHandler, timeHandler, timeoutHandler, recoverHandler}, r.middleware...)
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](name, h)
+	}
+	return h
+}
+
+func recoverHandler(name string, next Handler) Handler {
+	return func(ctx context.Context, p *Poller, c Change) (err error) {
+		defer func() {
+			rec := recover()
+			if rec != nil {
+				log.Printf("Handler %s panicked on change %d: %v\n%s", name, c.ID, rec, debug.Stack())
+				err = fmt.Errorf("panic: %v", rec)
+			}
+		}()
+		return next(ctx, p, c)
+	}
+}
+
+func logHandler(name string, next Handler) Handler {
+	return func(ctx context.Context, p *Poller, c Change) error {
+		start := time.Now()
+		err := next(ctx, p, c)
+		if err != nil {
+			changeLog(ctx, c).Printf(
+				"Handler %s failed in %s on %s change %d for %s order #%d, attempt %d, after %s (trace %s): %v",
+				name,
+				p.Name(),
+				c.ChangeType,
+				c.ID,
+				c.ProductName,
+				c.OrderID,
+				c.Attempts+1,
+				time.Since(start).Round(time.Microsecond),
+				TraceID(ctx),
+				err,
+			)
+		}
+		return err
+	}
+}
+
+// Retries within a cycle back off from handlerRetryBase, doubling.
+const (
+	handlerRetryBase = 100 * time.Millisecond
+	maxHandlerRetry  = 10
+)
+
+// retryHandler calls a failing handler again up to the poller's
+// HandlerRetries times before the change fails for the cycle. The retries
+// spend the cycle's lease, unlike the attempts requeue counts.
+func retryHandler(name string, next Handler) Handler {
+	return func(ctx context.Context, p *Poller, c Change) error {
+		err := next(ctx, p, c)
+		delay := handlerRetryBase
+		for range p.cfg.HandlerRetries {
+			if err == nil {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+			delay *= 2
+			err = next(ctx, p, c)
+		}
+		return err
+	}
+}
+
+// timeoutHandler gives each call of a handler the poller's HandlerTimeout.
+// A handler that doesn't return once its context is done is left running
+// and the call fails anyway, so it can't hold up the cycle; its change is
+// retried like any other failure, possibly while the abandoned call is
+// still going.
+func timeoutHandler(name string, next Handler) Handler {
+	return func(ctx context.Context, p *Poller, c Change) error {
+		timeout := p.cfg.HandlerTimeout.Duration
+		if timeout <= 0 {
+			return next(ctx, p, c)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- next(ctx, p, c)
+		}()
+		select {
+		case err := <-done:
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("timed out after %s: %w", timeout, err)
+			}
+			return err
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				log.Printf("Handler %s still running on change %d after its %s timeout; abandoning it", name, c.ID, timeout)
+				return fmt.Errorf("timed out after %s: %w", timeout, ctx.Err())
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+var (
+	handlerCalls    = expvar.NewMap("handler_calls")
+	handlerErrors   = expvar.NewMap("handler_errors")
+	handlerDuration = expvar.NewMap("handler_duration_seconds")
+)
+
+// timeHandler counts every call of a handler, retries included, its
+// failures and the time spent in it.
+func timeHandler(name string, next Handler) Handler {
+	return func(ctx context.Context, p *Poller, c Change) error {
+		start := time.Now()
+		err := next(ctx, p, c)
+		elapsed := time.Since(start).Seconds()
+		handlerDuration.AddFloat(name, elapsed)
+		observeHandler(name, elapsed, TraceID(ctx))
+		handlerCalls.Add(name, 1)
+		if err != nil {
+			handlerErrors.Add(name, 1)
+		}
+		return err
+	}
+}
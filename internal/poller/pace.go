@@ -0,0 +1,55 @@
+package poller
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pacer spaces out calls to wait so they run at most perSecond times a
+// second, shared by a poller's workers and carried across its cycles. A
+// nil pacer never waits.
+type pacer struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newPacer(perSecond float64) *pacer {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &pacer{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the caller's turn, or until ctx is done. Turns are
+// handed out in the order callers arrive; an idle pacer doesn't save up
+// turns for a burst.
+func (pc *pacer) wait(ctx context.Context) error {
+	if pc == nil {
+		return nil
+	}
+
+	pc.mu.Lock()
+	now := time.Now()
+	at := pc.next
+	if at.Before(now) {
+		at = now
+	}
+	pc.next = at.Add(pc.interval)
+	pc.mu.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
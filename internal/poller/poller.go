@@ -0,0 +1,959 @@
+// Package poller drains the change feed in priority_changes: pollers with
+// their filters and offsets, the handler registry they dispatch to, the
+// runtime rules in poller_rules, and the supervisor that keeps them running.
+package poller
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/lease"
+	"test/internal/logdedup"
+	"test/internal/logging"
+	"test/internal/store"
+	"test/internal/tracing"
+)
+
+// Change is one unprocessed change as a poller's handler sees it.
+type Change struct {
+	ID          int64
+	OrderID     int64
+	ChangeType  string
+	Priority    string
+	ProductName string
+
+	// Tenant owns the order. Handlers run scoped to it, see
+	// audit.WithTenant.
+	Tenant string
+
+	// Attempts is how many times handlers failed on the change before.
+	Attempts int
+
+	CreatedAt time.Time
+
+	// Traceparent is the W3C trace context the change was recorded in, if
+	// any; its handlers run in a child span of it.
+	Traceparent string
+
+	// Key is the change's processing key, the same on every redelivery.
+	// Handlers pass it to Effects.Once or to external systems that can
+	// deduplicate by it.
+	Key string
+}
+
+// Poller drains the changes matching its Config, claiming a batch per
+// cycle and advancing its own offset in poller_offsets.
+type Poller struct {
+	cfg     Config
+	db      *sql.DB
+	dialect store.Dialect
+
+	// globalDryRun is the process-wide switch; cfg.DryRun is per poller.
+	globalDryRun *atomic.Bool
+
+	chaos   *Chaos
+	effects *Effects
+	pace    *pacer
+	breaker *breaker
+
+	// instance is InstanceID, unless a test runs several instances in one
+	// process. shard is the shard the poller works on when sharded.
+	instance string
+	shard    int
+
+	// leader holds the poller's lease, keeping it to one process at a
+	// time; sharded pollers coordinate by shard instead.
+	leader *lease.Elector
+
+	mu    sync.Mutex
+	stmts *pollStmts
+
+	lastBeat time.Time
+}
+
+// pollStmts are the statements of a poll cycle, prepared on the first cycle
+// and reused by every later one.
+type pollStmts struct {
+	ensureOffset  *sql.Stmt
+	readOffset    *sql.Stmt
+	claim         *sql.Stmt
+	progress      *sql.Stmt
+	advanceOffset *sql.Stmt
+}
+
+// New returns a poller; globalDryRun may be nil when nothing can switch it
+// to dry-run mode at runtime.
+func New(db *sql.DB, cfg Config, globalDryRun *atomic.Bool) *Poller {
+	return &Poller{
+		cfg:          cfg,
+		db:           db,
+		dialect:      store.DialectOf(db),
+		globalDryRun: globalDryRun,
+		pace:         newPacer(cfg.RateLimit),
+		breaker:      newBreaker(cfg),
+		instance:     InstanceID,
+		shard:        noShard,
+	}
+}
+
+func (p *Poller) Name() string {
+	return p.cfg.Name
+}
+
+// DB is the database the poller claims from, for handlers that queue work
+// of their own there.
+func (p *Poller) DB() *sql.DB {
+	return p.db
+}
+
+// Effects returns the side-effect ledger shared by the supervisor's
+// pollers, nil when none is configured.
+func (p *Poller) Effects() *Effects {
+	return p.effects
+}
+
+func (p *Poller) dryRun() bool {
+	return p.cfg.DryRun || (p.globalDryRun != nil && p.globalDryRun.Load())
+}
+
+// logDryRun reports what a real cycle would have done with changes.
+func (p *Poller) logDryRun(changes []Change) {
+	for _, c := range changes {
+		var names []string
+		for _, h := range handlers.lookup(c.ChangeType, p.cfg.Handler) {
+			names = append(names, h.name)
+		}
+		for _, h := range handlers.lookupBatch(c.ChangeType, p.cfg.Handler) {
+			names = append(names, h.name)
+		}
+		log.Printf(
+			"[dry-run] Poller %s would process %s change %d for %s order #%d with handlers [%s]",
+			p.cfg.Name,
+			c.ChangeType,
+			c.ID,
+			c.ProductName,
+			c.OrderID,
+			strings.Join(names, ", "),
+		)
+	}
+}
+
+// dispatch runs every handler registered for the row's change type. A row
+// whose type has no handlers counts as handled.
+func (p *Poller) dispatch(ctx context.Context, c Change) error {
+	ctx = tracing.WithSpan(ctx, changeSpan(ctx, c))
+	ctx = audit.WithTenant(ctx, c.Tenant)
+	err := p.chaos.handlerFault()
+	if err != nil {
+		changeLog(ctx, c).Printf("Handler error in %s for change %d: %v", p.cfg.Name, c.ID, err)
+		return err
+	}
+
+	for _, h := range handlers.lookup(c.ChangeType, p.cfg.Handler) {
+		err := h.handle(ctx, p, c)
+		if err != nil {
+			return fmt.Errorf("%s handler: %w", h.name, err)
+		}
+	}
+	return nil
+}
+
+// schedule returns the indexes of changes, which are in id order, in the
+// order to dispatch them: orders with the most urgent change first, each
+// order's changes together and in id order, so an escalation doesn't wait
+// behind a flood of less urgent changes but never overtakes an earlier
+// change to its own order. Ties keep id order.
+func schedule(changes []Change) []int {
+	rank := make(map[int64]int)
+	first := make(map[int64]int)
+	for i, c := range changes {
+		r, ok := rank[c.OrderID]
+		if !ok {
+			first[c.OrderID] = i
+			r = -1
+		}
+		rank[c.OrderID] = max(r, store.PriorityRank(c.Priority))
+	}
+
+	order := make([]int, len(changes))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		oa, ob := changes[a].OrderID, changes[b].OrderID
+		if oa == ob {
+			return 0
+		}
+		if c := cmp.Compare(rank[ob], rank[oa]); c != 0 {
+			return c
+		}
+		return cmp.Compare(first[oa], first[ob])
+	})
+	return order
+}
+
+// failure is a change whose handlers returned err.
+type failure struct {
+	Change
+	err error
+}
+
+// dispatchAll dispatches changes in the order schedule gives and splits
+// them into the ones handled, the ones that failed and the ones skipped
+// because the breaker was open, all in id order. Once one of an order's
+// changes is skipped the rest of that order's are too.
+// With Workers above 1 the changes are partitioned by order id: each
+// partition runs on its own goroutine in that order, so two changes for the
+// same order are never in flight together and keep their order.
+func (p *Poller) dispatchAll(ctx context.Context, changes []Change) (handled []Change, failed []failure, skipped []Change) {
+	errs := make([]error, len(changes))
+	held := make([]bool, len(changes))
+	run := func(i int, heldOrders map[int64]bool) {
+		c := changes[i]
+		if heldOrders[c.OrderID] || !p.breaker.allow() {
+			heldOrders[c.OrderID] = true
+			held[i] = true
+			return
+		}
+		errs[i] = p.pace.wait(ctx)
+		if errs[i] == nil {
+			errs[i] = p.dispatch(ctx, c)
+			p.breaker.record(errs[i])
+		}
+	}
+
+	order := schedule(changes)
+	workers := min(p.cfg.Workers, len(changes))
+	if workers <= 1 {
+		heldOrders := make(map[int64]bool)
+		for _, i := range order {
+			run(i, heldOrders)
+		}
+	} else {
+		parts := make([][]int, workers)
+		for _, i := range order {
+			n := changes[i].OrderID % int64(workers)
+			parts[n] = append(parts[n], i)
+		}
+		var wg sync.WaitGroup
+		for _, part := range parts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				heldOrders := make(map[int64]bool)
+				for _, i := range part {
+					run(i, heldOrders)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	p.dispatchBatches(ctx, changes, order, errs, held)
+
+	for i, c := range changes {
+		switch {
+		case held[i]:
+			skipped = append(skipped, c)
+		case errs[i] == nil:
+			handled = append(handled, c)
+		default:
+			failed = append(failed, failure{Change: c, err: errs[i]})
+		}
+	}
+	return handled, failed, skipped
+}
+
+// dispatchBatches hands each batch handler, in one call, the changes that
+// went through their other handlers, and records per change what it
+// returned in errs. A batch the breaker holds back is skipped whole.
+func (p *Poller) dispatchBatches(ctx context.Context, changes []Change, order []int, errs []error, held []bool) {
+	type batch struct {
+		h   registeredHandler
+		idx []int
+	}
+	var batches []*batch
+	byName := make(map[string]*batch)
+	for _, i := range order {
+		if held[i] || errs[i] != nil {
+			continue
+		}
+		for _, h := range handlers.lookupBatch(changes[i].ChangeType, p.cfg.Handler) {
+			b, ok := byName[h.name]
+			if !ok {
+				b = &batch{h: h}
+				byName[h.name] = b
+				batches = append(batches, b)
+			}
+			b.idx = append(b.idx, i)
+		}
+	}
+
+	for _, b := range batches {
+		// An earlier batch handler may have failed some of them.
+		b.idx = slices.DeleteFunc(b.idx, func(i int) bool { return errs[i] != nil })
+		if len(b.idx) == 0 {
+			continue
+		}
+		if !p.breaker.allow() {
+			for _, i := range b.idx {
+				held[i] = true
+			}
+			continue
+		}
+
+		var err error
+		batchChanges := make([]Change, len(b.idx))
+		for j, i := range b.idx {
+			batchChanges[j] = changes[i]
+			if err == nil {
+				err = p.pace.wait(ctx)
+			}
+		}
+		if err == nil {
+			err = b.h.batch(ctx, p, batchChanges)
+		}
+
+		// A batch with some changes failed still reached the sink.
+		var be *BatchError
+		partial := errors.As(err, &be)
+		if partial {
+			p.breaker.record(nil)
+		} else {
+			p.breaker.record(err)
+		}
+		for _, i := range b.idx {
+			e := err
+			if partial {
+				e = be.Failed[changes[i].ID]
+			}
+			if e != nil {
+				errs[i] = fmt.Errorf("%s handler: %w", b.h.name, e)
+				changeLog(ctx, changes[i]).Printf("Handler error in %s for change %d: %v", p.cfg.Name, changes[i].ID, errs[i])
+			}
+		}
+	}
+}
+
+// prepare returns the poller's statements, preparing them on first use.
+func (p *Poller) prepare(ctx context.Context) (*pollStmts, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stmts != nil {
+		return p.stmts, nil
+	}
+
+	filter, _ := p.filter()
+	var st pollStmts
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&st.ensureOffset, p.dialect.InsertIgnore() + ` INTO poller_offsets (poller) VALUES (?)`},
+		{&st.readOffset, `SELECT last_processed_id FROM poller_offsets WHERE poller = ?`},
+		{&st.claim, p.claimQuery(filter)},
+		// The offset can move up to the first matching change not yet
+		// processed: one leased by another worker, waiting for a retry or
+		// newer than the claim. When there is none, to the newest change,
+		// matching or not, so the offset shows how far the feed is settled
+		// for this poller. Dead letters, out of attempts, don't hold it back.
+		{&st.progress, `
+			SELECT MIN(CASE WHEN pc.processed = FALSE AND pc.attempts < ? THEN pc.id END),
+				(SELECT MAX(id) FROM priority_changes)
+			FROM priority_changes pc
+			JOIN orders o ON pc.order_id = o.id
+			WHERE pc.id > ?
+			` + filter},
+		{&st.advanceOffset, `
+			UPDATE poller_offsets
+			SET last_processed_id = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE poller = ?`},
+	} {
+		stmt, err := p.db.PrepareContext(ctx, s.query)
+		if err != nil {
+			st.close()
+			return nil, err
+		}
+		*s.stmt = stmt
+	}
+
+	p.stmts = &st
+	return p.stmts, nil
+}
+
+func (st *pollStmts) close() {
+	for _, stmt := range []*sql.Stmt{st.ensureOffset, st.readOffset, st.claim, st.progress, st.advanceOffset} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// Close releases the poller's prepared statements. Run calls it on return.
+func (p *Poller) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stmts != nil {
+		p.stmts.close()
+		p.stmts = nil
+	}
+}
+
+// Run polls until ctx is cancelled. A cycle interrupted by cancellation
+// leaves its changes unprocessed, claimed until the lease runs out, and the
+// offset where it was. Each completed cycle is recorded in
+// poller_heartbeats.
+func (p *Poller) Run(ctx context.Context) {
+	defer p.Close()
+	defer p.clearBeat()
+	defer p.unpublishLag()
+	p.breaker.publish()
+	defer p.breaker.unpublish()
+	defer p.releaseShard()
+	defer func() {
+		if p.leader != nil {
+			p.leader.Resign()
+		}
+	}()
+
+	for {
+		full, err := p.pollOnce(ctx)
+		if err == nil {
+			err = p.beat(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			logdedup.Printf("Polling error in %s: %v", p.cfg.Name, err)
+		}
+
+		// A full cycle goes straight on to the next: the claim limit is
+		// what keeps claims in step with the workers, not the interval.
+		wait := p.cfg.Interval.Duration
+		if full && err == nil {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// filter renders the poller's configured filters as extra WHERE conditions.
+func (p *Poller) filter() (string, []any) {
+	var conds []string
+	var args []any
+	if p.cfg.Product != "" {
+		conds = append(conds, "o.product_name = ?")
+		args = append(args, p.cfg.Product)
+	}
+	if p.cfg.Priority != "" {
+		conds = append(conds, "pc.priority = ?")
+		args = append(args, p.cfg.Priority)
+	}
+	if p.cfg.Tag != "" {
+		conds = append(conds, "o.tag = ?")
+		args = append(args, p.cfg.Tag)
+	}
+	if p.cfg.Tenant != "" {
+		conds = append(conds, "pc.tenant_id = ?")
+		args = append(args, p.cfg.Tenant)
+	}
+	if p.cfg.Filter != nil {
+		conds = append(conds, filterTemplates[p.cfg.Filter.Template].sql)
+		args = append(args, p.cfg.Filter.Args...)
+	}
+	if p.cfg.Shards > 1 {
+		conds = append(conds, "pc.order_id % ? = ?")
+		args = append(args, p.cfg.Shards, p.shard)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "AND " + strings.Join(conds, " AND "), args
+}
+
+// productProcessed counts the changes processed per product, across all
+// pollers of the process.
+var productProcessed = expvar.NewMap("product_changes_processed")
+
+// errDryRunCycle makes RetryTx roll back a dry-run claim instead of
+// committing it.
+var errDryRunCycle = errors.New("dry-run cycle")
+
+// claimed is what a cycle's claim step hands to its completion step.
+type claimed struct {
+	lastID  int64
+	changes []Change
+
+	// broken are claimed rows that can't be turned into a Change; they
+	// fail without reaching the handlers.
+	broken []failure
+
+	// full is set when the claim stopped at the cycle's limit, so more
+	// changes are likely waiting.
+	full bool
+}
+
+// PollOnce runs one cycle: a transaction claiming the unprocessed changes
+// with a lease, the handlers, outside any transaction, and a transaction
+// marking the handled changes processed and moving the offset. Either
+// transaction is replayed from the start on a lock or serialization
+// conflict. Changes whose cycle never completes are claimed again once
+// their lease runs out, or at once by the same poller after a restart.
+// While the poller's breaker is open a cycle claims nothing.
+func (p *Poller) PollOnce(ctx context.Context) error {
+	_, err := p.pollOnce(ctx)
+	return err
+}
+
+// pollOnce is PollOnce, also reporting whether the cycle claimed as many
+// changes as it may.
+func (p *Poller) pollOnce(ctx context.Context) (full bool, err error) {
+	if !p.breaker.ready() || !p.lead(ctx) {
+		return false, nil
+	}
+
+	st, err := p.prepare(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// Each cycle gets a trace that the cycle latency histogram keeps as an
+	// exemplar and that batch handlers run in.
+	span := tracing.New()
+	ctx = tracing.WithSpan(ctx, span)
+	start := time.Now()
+
+	var cl claimed
+	err = store.RetryTx(ctx, p.db, "poll:"+p.cfg.Name, func(tx *sql.Tx) error {
+		var err error
+		cl, err = p.claim(ctx, tx, st)
+		return err
+	})
+	if errors.Is(err, errDryRunCycle) {
+		return false, nil
+	}
+	if err != nil || len(cl.changes)+len(cl.broken) == 0 {
+		return false, err
+	}
+
+	cy := &cycle{db: p.db}
+	handled, failed, skipped := p.dispatchAll(withCycle(ctx, cy), cl.changes)
+	failed = append(failed, cl.broken...)
+	var quarantined []failure
+	err = store.RetryTx(ctx, p.db, "complete:"+p.cfg.Name, func(tx *sql.Tx) error {
+		var err error
+		quarantined, err = p.complete(ctx, tx, st, cl, cy, handled, failed, skipped)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	p.observeCycle(span.TraceID, now.Sub(start), len(handled)+len(failed))
+	for _, c := range handled {
+		productProcessed.Add(c.ProductName, 1)
+		processingLatency.observe(now.Sub(c.CreatedAt).Seconds())
+	}
+	p.alertQuarantined(quarantined)
+	return cl.full, nil
+}
+
+// observeCycle records a cycle's duration and logs one that took over half
+// the lease, by when its changes were at risk of being claimed again.
+func (p *Poller) observeCycle(traceID string, took time.Duration, changes int) {
+	cycleDuration.observeTrace(took.Seconds(), traceID)
+	if took > p.leaseDuration()/2 {
+		logging.With(logging.TraceID(traceID)).Printf("Slow cycle in %s: %d changes took %s (trace %s)",
+			p.cfg.Name, changes, took.Round(time.Millisecond), traceID)
+	}
+}
+
+// claimLimit is how many changes a cycle may claim, -1 for no limit: at
+// most MaxInFlight, and for a rate-limited poller what it can dispatch in
+// half the lease.
+func (p *Poller) claimLimit(lease time.Duration) int {
+	limit := -1
+	if p.cfg.MaxInFlight > 0 {
+		limit = p.cfg.MaxInFlight
+	}
+	if p.cfg.RateLimit > 0 {
+		byRate := max(1, int(p.cfg.RateLimit*lease.Seconds()/2))
+		if limit < 0 || byRate < limit {
+			limit = byRate
+		}
+	}
+	return limit
+}
+
+// lead reports whether this process may run the poller's cycle now. A
+// second process started with the same unsharded poller idles until the
+// first stops or its lease runs out.
+func (p *Poller) lead(ctx context.Context) bool {
+	if p.cfg.Shards > 1 {
+		return true
+	}
+	if p.leader == nil {
+		p.leader = lease.NewElector(p.db, "poller:"+p.cfg.Name, p.claimer(), p.leaseDuration())
+	}
+	return p.leader.Lead(ctx)
+}
+
+// claimQuery picks the cycle's batch: each product contributes its oldest
+// eligible changes, up to its share of ProductBatch when that is set.
+// processed = FALSE is spelled to match idx_priority_changes_unprocessed.
+//
+// In SQLite the claim leases the batch as it reads it, so the cycle holds
+// the write lock from its first read and no other writer can claim the
+// same rows in between. RETURNING doesn't keep the order. MySQL has no
+// UPDATE ... RETURNING and locks rows rather than the database: the claim
+// locks the batch with FOR UPDATE, skipping rows another poller's claim
+// has locked, checks them again as they are now and leaves the lease to
+// leaseClaimed.
+func (p *Poller) claimQuery(filter string) string {
+	eligible := `
+				SELECT pc.id, o.product_name AS product,
+					ROW_NUMBER() OVER (PARTITION BY o.product_name ORDER BY pc.id) AS n
+				FROM priority_changes pc
+				JOIN orders o ON pc.order_id = o.id
+				WHERE pc.id > ?
+				AND pc.processed = FALSE
+				AND (pc.claimed_until IS NULL
+					OR pc.claimed_until < CURRENT_TIMESTAMP
+					OR pc.claimed_by = ?)
+				AND (pc.next_attempt_at IS NULL
+					OR pc.next_attempt_at <= CURRENT_TIMESTAMP)
+				AND pc.attempts < ?
+				` + filter
+	if p.dialect == store.MySQL {
+		return `
+			SELECT pc.id, pc.order_id, pc.change_type, pc.priority, pc.attempts, pc.created_at, pc.traceparent, pc.tenant_id,
+				(SELECT product_name FROM orders WHERE orders.id = pc.order_id)
+			FROM priority_changes pc
+			WHERE pc.id IN (
+				SELECT id FROM (
+					SELECT id FROM (` + eligible + `
+					) eligible
+					WHERE ? = 0
+					OR n <= ? * COALESCE(JSON_EXTRACT(?, CONCAT('$.', JSON_QUOTE(product))), 1)
+					ORDER BY id
+					LIMIT ?
+				) batch
+			)
+			AND pc.processed = FALSE
+			AND (pc.claimed_until IS NULL
+				OR pc.claimed_until < CURRENT_TIMESTAMP
+				OR pc.claimed_by = ?)
+			ORDER BY pc.id
+			FOR UPDATE SKIP LOCKED`
+	}
+	return `
+			UPDATE priority_changes
+			SET claimed_by = ?, claimed_until = ` + p.dialect.SecondsFromNow() + `
+			WHERE id IN (
+				SELECT id FROM (` + eligible + `
+				)
+				WHERE ? = 0
+				OR n <= ? * COALESCE((SELECT value FROM json_each(?) WHERE key = product), 1)
+				ORDER BY id
+				LIMIT ?
+			)
+			RETURNING id, order_id, change_type, priority, attempts, created_at, traceparent, tenant_id,
+				(SELECT product_name FROM orders WHERE orders.id = priority_changes.order_id)`
+}
+
+// leaseDuration is how long a claim holds the claimed changes.
+func (p *Poller) leaseDuration() time.Duration {
+	if p.cfg.Lease.Duration == 0 {
+		return defaultLease
+	}
+	return p.cfg.Lease.Duration
+}
+
+// claimer identifies this poller in claimed_by. A poller name runs in one
+// process at a time, so claims under it left by a crashed run are this
+// poller's to take back.
+func (p *Poller) claimer() string {
+	return p.instance + "/" + p.cfg.Name
+}
+
+func (p *Poller) claim(ctx context.Context, tx *sql.Tx, st *pollStmts) (claimed, error) {
+	var cl claimed
+	lease := p.leaseDuration()
+	seconds := int64(lease.Seconds())
+	ok, err := p.takeShard(ctx, tx, seconds)
+	if err != nil || !ok {
+		return cl, err
+	}
+
+	_, err = tx.StmtContext(ctx, st.ensureOffset).ExecContext(ctx, p.offsetName())
+	if err != nil {
+		return cl, err
+	}
+
+	err = tx.StmtContext(ctx, st.readOffset).QueryRowContext(ctx, p.offsetName()).Scan(&cl.lastID)
+	if err != nil {
+		return cl, err
+	}
+
+	err = p.chaos.slowQuery(ctx)
+	if err != nil {
+		return cl, err
+	}
+
+	_, args := p.filter()
+	weights, err := json.Marshal(p.cfg.ProductWeights)
+	if err != nil {
+		return cl, err
+	}
+	claimArgs := append([]any{cl.lastID, p.claimer(), p.maxAttempts()}, args...)
+	limit := p.claimLimit(lease)
+	batch := int64(limit)
+	if limit < 0 {
+		batch = p.dialect.NoLimit()
+	}
+	claimArgs = append(claimArgs, p.cfg.ProductBatch, p.cfg.ProductBatch, string(weights), batch)
+	if p.dialect == store.MySQL {
+		claimArgs = append(claimArgs, p.claimer())
+	} else {
+		claimArgs = append([]any{p.claimer(), seconds}, claimArgs...)
+	}
+	rows, err := tx.StmtContext(ctx, st.claim).QueryContext(ctx, claimArgs...)
+	if err != nil {
+		return cl, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Change
+		var product sql.NullString
+		err := rows.Scan(&c.ID, &c.OrderID, &c.ChangeType, &c.Priority, &c.Attempts, &c.CreatedAt, &c.Traceparent, &c.Tenant, &product)
+		if err != nil {
+			log.Printf("Scan error: %v", err)
+			continue
+		}
+		if !product.Valid {
+			cl.broken = append(cl.broken, failure{Change: c, err: fmt.Errorf("order #%d not found", c.OrderID)})
+			continue
+		}
+		c.ProductName = product.String
+		c.Key = processingKey(c.ID)
+		cl.changes = append(cl.changes, c)
+	}
+	err = rows.Err()
+	if err != nil {
+		return cl, err
+	}
+	rows.Close()
+	slices.SortFunc(cl.changes, func(a, b Change) int { return cmp.Compare(a.ID, b.ID) })
+	if p.dialect == store.MySQL {
+		err = p.leaseClaimed(ctx, tx, cl, seconds)
+		if err != nil {
+			return cl, err
+		}
+	}
+	cl.full = limit > 0 && len(cl.changes)+len(cl.broken) == limit
+
+	// A cycle with nothing to claim still moves the offset past changes
+	// other pollers have settled since, which no completion would.
+	if len(cl.changes)+len(cl.broken) == 0 && !p.dryRun() {
+		return cl, p.advance(ctx, tx, st, cl.lastID)
+	}
+
+	// Dry runs don't call handlers, and the rollback discards the claim
+	// and the offset row inserted above.
+	if p.dryRun() {
+		p.logDryRun(cl.changes)
+		return cl, errDryRunCycle
+	}
+	return cl, nil
+}
+
+// complete records the cycle's outcome and returns the failed changes it
+// quarantined.
+func (p *Poller) complete(ctx context.Context, tx *sql.Tx, st *pollStmts, cl claimed, cy *cycle, handled []Change, failed []failure, skipped []Change) ([]failure, error) {
+	err := cy.recordDeliveries(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// A change whose lease ran out and was claimed by someone else is
+	// theirs now, so only rows still claimed by this poller are touched.
+	n, err := updateClaimed(ctx, tx, p.claimer(), `
+		SET processed = TRUE, processed_at = CURRENT_TIMESTAMP,
+			claimed_by = NULL, claimed_until = NULL,
+			last_error = '', next_attempt_at = NULL`, handled)
+	if err != nil {
+		return nil, fmt.Errorf("marking changes processed: %w", err)
+	}
+	if lost := int64(len(handled)) - n; lost > 0 {
+		log.Printf("Poller %s lost the lease on %d handled changes to another worker", p.cfg.Name, lost)
+	}
+	quarantined, err := p.requeue(ctx, tx, failed)
+	if err != nil {
+		return nil, fmt.Errorf("requeueing failed changes: %w", err)
+	}
+	_, err = updateClaimed(ctx, tx, p.claimer(), `SET claimed_by = NULL, claimed_until = NULL`, skipped)
+	if err != nil {
+		return nil, fmt.Errorf("releasing skipped changes: %w", err)
+	}
+
+	err = p.advance(ctx, tx, st, cl.lastID)
+	if err != nil {
+		return nil, err
+	}
+	return quarantined, p.chaos.commitFault()
+}
+
+// advance moves the offset from lastID as far as the progress statement
+// allows. It stops short of the first change still to be done, so nothing
+// is left behind it.
+func (p *Poller) advance(ctx context.Context, tx *sql.Tx, st *pollStmts, lastID int64) error {
+	_, args := p.filter()
+	var pending, last sql.NullInt64
+	err := tx.StmtContext(ctx, st.progress).QueryRowContext(ctx,
+		append([]any{p.maxAttempts(), lastID}, args...)...).Scan(&pending, &last)
+	if err != nil {
+		return err
+	}
+	maxID := last.Int64
+	if pending.Valid {
+		maxID = pending.Int64 - 1
+	}
+	if maxID <= lastID {
+		return nil
+	}
+	_, err = tx.StmtContext(ctx, st.advanceOffset).ExecContext(ctx, maxID, p.offsetName())
+	return err
+}
+
+// Retries back off exponentially from retryBase, up to retryMax apart.
+const (
+	retryBase = 30 * time.Second
+	retryMax  = time.Hour
+)
+
+// retryBackoff is how long a change waits after its attempts-th failure.
+func retryBackoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return retryMax
+	}
+	return min(retryBase<<(attempts-1), retryMax)
+}
+
+func (p *Poller) maxAttempts() int {
+	if p.cfg.MaxAttempts == 0 {
+		return defaultMaxAttempts
+	}
+	return p.cfg.MaxAttempts
+}
+
+// requeue releases failed changes with their error, one more attempt and
+// the time of the next one. They stay unprocessed, and the offset stays
+// below them, until they run out of attempts: those become dead letters,
+// are quarantined and returned.
+func (p *Poller) requeue(ctx context.Context, tx *sql.Tx, failed []failure) ([]failure, error) {
+	var quarantined []failure
+	for _, f := range failed {
+		attempts := f.Attempts + 1
+		var next any
+		if attempts < p.maxAttempts() {
+			next = int64(retryBackoff(attempts).Seconds())
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE priority_changes
+			SET claimed_by = NULL, claimed_until = NULL,
+				attempts = ?, last_error = ?, next_attempt_at = `+p.dialect.SecondsFromNow()+`
+			WHERE claimed_by = ? AND id = ?`,
+			attempts, f.err.Error(), next, p.claimer(), f.ID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			// lease lost; the change is another worker's now
+			continue
+		}
+
+		err = p.recordFailure(ctx, tx, f, attempts)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			err = p.quarantine(ctx, tx, f.ID)
+			if err != nil {
+				return nil, err
+			}
+			quarantined = append(quarantined, f)
+		}
+	}
+	return quarantined, nil
+}
+
+// updateBatch is how many ids go into one UPDATE, well below SQLite's
+// limit on bound parameters.
+const updateBatch = 500
+
+// leaseClaimed leases the changes a MySQL claim locked, broken ones
+// included, to the poller for seconds.
+func (p *Poller) leaseClaimed(ctx context.Context, tx *sql.Tx, cl claimed, seconds int64) error {
+	ids := make([]any, 0, len(cl.changes)+len(cl.broken))
+	for _, c := range cl.changes {
+		ids = append(ids, c.ID)
+	}
+	for _, f := range cl.broken {
+		ids = append(ids, f.ID)
+	}
+	for len(ids) > 0 {
+		n := min(len(ids), updateBatch)
+		_, err := tx.ExecContext(ctx, `
+			UPDATE priority_changes
+			SET claimed_by = ?, claimed_until = `+p.dialect.SecondsFromNow()+`
+			WHERE id IN (?`+strings.Repeat(", ?", n-1)+`)`,
+			append([]any{p.claimer(), seconds}, ids[:n]...)...)
+		if err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// updateClaimed applies set to those of changes still claimed by claimer,
+// with one UPDATE per updateBatch ids, and returns how many rows changed.
+func updateClaimed(ctx context.Context, tx *sql.Tx, claimer, set string, changes []Change) (int64, error) {
+	var total int64
+	for len(changes) > 0 {
+		n := min(len(changes), updateBatch)
+		args := []any{claimer}
+		for _, c := range changes[:n] {
+			args = append(args, c.ID)
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE priority_changes `+set+`
+			WHERE claimed_by = ? AND id IN (?`+strings.Repeat(", ?", n-1)+`)`, args...)
+		if err != nil {
+			return total, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += affected
+		changes = changes[n:]
+	}
+	return total, nil
+}
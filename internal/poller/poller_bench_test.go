@@ -0,0 +1,174 @@
+package poller
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"test/internal/store"
+)
+
+// The benchmarks only cover SQLite, the one backend the poller supports.
+// ns/op is a full poll cycle, commit included; changes/s is the throughput
+// across all pollers taking part.
+
+var benchProducts = []string{"ninja", "shoe", "hat", "umbrella"}
+
+func init() {
+	Register(AnyChangeType, "bench-noop", func(context.Context, *Poller, Change) error {
+		return nil
+	})
+}
+
+// quietLogs silences per-change logging until tb is done.
+func quietLogs(tb testing.TB) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(out) })
+}
+
+// openTestDB returns a migrated database in a temp dir, closed on cleanup.
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	dsn := "file:" + filepath.Join(tb.TempDir(), "orders.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := store.Open(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	err = store.Migrate(db)
+	if err != nil {
+		tb.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// seedBacklog queues n unprocessed changes spread evenly over benchProducts.
+func seedBacklog(tb testing.TB, db *sql.DB, n int) {
+	tb.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for i := range n {
+		res, err := tx.Exec(`
+            INSERT INTO orders (customer_name, product_name, quantity, shipping_address, priority)
+            VALUES ('Bench', ?, 1, '1 Bench Way', 'low')
+        `, benchProducts[i%len(benchProducts)])
+		if err != nil {
+			tb.Fatal(err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		_, err = tx.Exec(`INSERT INTO priority_changes (order_id, priority) VALUES (?, 'high')`, id)
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func benchPoller(db *sql.DB, name, product string) *Poller {
+	return New(db, Config{
+		Name:     name,
+		Product:  product,
+		Interval: Duration{time.Second},
+		Handler:  "bench-noop",
+	}, nil)
+}
+
+func reportThroughput(b *testing.B, perOp int) {
+	b.ReportMetric(float64(perOp*b.N)/b.Elapsed().Seconds(), "changes/s")
+}
+
+// BenchmarkPollOnce measures one poller draining backlogs of growing size in
+// a single cycle.
+func BenchmarkPollOnce(b *testing.B) {
+	quietLogs(b)
+	for _, backlog := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("backlog=%d", backlog), func(b *testing.B) {
+			db := openTestDB(b)
+			p := benchPoller(db, "bench", "")
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for range b.N {
+				b.StopTimer()
+				seedBacklog(b, db, backlog)
+				b.StartTimer()
+
+				err := p.PollOnce(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportThroughput(b, backlog)
+		})
+	}
+}
+
+// BenchmarkPollers compares one poller taking every product with one poller
+// per product running their cycles concurrently over the same backlog.
+func BenchmarkPollers(b *testing.B) {
+	quietLogs(b)
+	const backlog = 400
+
+	cases := []struct {
+		name     string
+		products []string
+	}{
+		{"single", []string{""}},
+		{"per-product", benchProducts},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			db := openTestDB(b)
+			var pollers []*Poller
+			for i, product := range tc.products {
+				pollers = append(pollers, benchPoller(db, fmt.Sprintf("bench-%d", i), product))
+			}
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for range b.N {
+				b.StopTimer()
+				seedBacklog(b, db, backlog)
+				b.StartTimer()
+
+				var wg sync.WaitGroup
+				errs := make(chan error, len(pollers))
+				for _, p := range pollers {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						errs <- p.PollOnce(ctx)
+					}()
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			reportThroughput(b, backlog)
+		})
+	}
+}
@@ -0,0 +1,1077 @@
+package poller
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test/internal/tracing"
+)
+
+// orderTracker records the changes each order's handler calls saw, and
+// whether two calls for one order ever overlapped.
+type orderTracker struct {
+	mu          sync.Mutex
+	inFlight    map[int64]bool
+	seen        map[int64][]int64
+	overlaps    int
+	running     int
+	maxParallel int
+	fail        map[int64]bool
+}
+
+var tracker *orderTracker
+
+func init() {
+	Register(AnyChangeType, "order-tracker", func(ctx context.Context, p *Poller, c Change) error {
+		return tracker.handle(c)
+	})
+}
+
+// batchCalls records the changes of each batch-tracker call; the changes
+// in batchFail fail.
+var (
+	batchCalls [][]int64
+	batchFail  map[int64]bool
+)
+
+func init() {
+	RegisterBatch(AnyChangeType, "batch-tracker", func(ctx context.Context, p *Poller, changes []Change) error {
+		var ids []int64
+		failed := make(map[int64]error)
+		for _, c := range changes {
+			ids = append(ids, c.ID)
+			if batchFail[c.ID] {
+				failed[c.ID] = errors.New("batch-tracker: failing on purpose")
+			}
+		}
+		batchCalls = append(batchCalls, ids)
+		if len(failed) > 0 {
+			return &BatchError{Failed: failed}
+		}
+		return nil
+	})
+}
+
+// flakyCalls counts calls of the flaky handler, which panics on the first
+// and fails on the second.
+var flakyCalls int
+
+func init() {
+	Register(AnyChangeType, "flaky", func(ctx context.Context, p *Poller, c Change) error {
+		flakyCalls++
+		switch flakyCalls {
+		case 1:
+			panic("flaky: first call")
+		case 2:
+			return errors.New("flaky: second call")
+		}
+		return nil
+	})
+}
+
+// stuck is closed to release the stuck handler, which ignores its context.
+var stuck chan struct{}
+
+func init() {
+	Register(AnyChangeType, "stuck", func(ctx context.Context, p *Poller, c Change) error {
+		<-stuck
+		return nil
+	})
+}
+
+// traced records the span each change's handler ran in, by change id.
+var traced = make(map[int64]tracing.Span)
+
+func init() {
+	Register(AnyChangeType, "traced", func(ctx context.Context, p *Poller, c Change) error {
+		traced[c.ID], _ = tracing.FromContext(ctx)
+		return nil
+	})
+}
+
+// sent and effects count, by change id, what the deliver-tracker handler
+// sent to its sink and the effects the effect-tracker handler carried out.
+var (
+	sent    map[int64]int
+	effects map[int64]int
+)
+
+func init() {
+	Register(AnyChangeType, "deliver-tracker", func(ctx context.Context, p *Poller, c Change) error {
+		return Deliver(ctx, c, "test-sink", func(ctx context.Context) error {
+			sent[c.ID]++
+			return nil
+		})
+	})
+	Register(AnyChangeType, "effect-tracker", func(ctx context.Context, p *Poller, c Change) error {
+		return p.Effects().Once(ctx, c.Key, "effect-tracker", func(ctx context.Context) error {
+			effects[c.ID]++
+			return nil
+		})
+	})
+}
+
+func newOrderTracker(tb testing.TB) *orderTracker {
+	t := &orderTracker{
+		inFlight: make(map[int64]bool),
+		seen:     make(map[int64][]int64),
+		fail:     make(map[int64]bool),
+	}
+	tracker = t
+	tb.Cleanup(func() { tracker = nil })
+	return t
+}
+
+func (t *orderTracker) handle(c Change) error {
+	t.mu.Lock()
+	if t.inFlight[c.OrderID] {
+		t.overlaps++
+	}
+	t.inFlight[c.OrderID] = true
+	t.running++
+	t.maxParallel = max(t.maxParallel, t.running)
+	t.mu.Unlock()
+
+	// Long enough for other workers to start on their own orders.
+	time.Sleep(2 * time.Millisecond)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight[c.OrderID] = false
+	t.running--
+	t.seen[c.OrderID] = append(t.seen[c.OrderID], c.ID)
+	if t.fail[c.ID] {
+		return errors.New("tracker: failing on purpose")
+	}
+	return nil
+}
+
+// seedInterleaved queues perOrder changes for each of orders orders, in
+// round-robin so consecutive change ids belong to different orders.
+func seedInterleaved(tb testing.TB, db *sql.DB, orders, perOrder int) {
+	tb.Helper()
+
+	for range orders {
+		_, err := db.Exec(`
+            INSERT INTO orders (customer_name, product_name, quantity, shipping_address, priority)
+            VALUES ('Test', 'ninja', 1, '1 Test Way', 'low')
+        `)
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for range perOrder {
+		for id := 1; id <= orders; id++ {
+			_, err := db.Exec(`INSERT INTO priority_changes (order_id, priority) VALUES (?, 'high')`, id)
+			if err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+}
+
+func unprocessed(tb testing.TB, db *sql.DB) []int64 {
+	tb.Helper()
+
+	rows, err := db.Query(`SELECT id FROM priority_changes WHERE processed = FALSE ORDER BY id`)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestWorkersKeepPerOrderOrdering(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	const orders, perOrder = 5, 10
+	seedInterleaved(t, db, orders, perOrder)
+
+	p := New(db, Config{Name: "workers", Handler: "order-tracker", Workers: 4}, nil)
+	err := p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.overlaps > 0 {
+		t.Errorf("%d handler calls overlapped another for the same order", tr.overlaps)
+	}
+	if tr.maxParallel < 2 {
+		t.Errorf("at most %d changes were in flight at once; want parallel dispatch", tr.maxParallel)
+	}
+	if len(tr.seen) != orders {
+		t.Fatalf("handled changes for %d orders, want %d", len(tr.seen), orders)
+	}
+	for order, ids := range tr.seen {
+		if len(ids) != perOrder {
+			t.Errorf("order %d: handled %d changes, want %d", order, len(ids), perOrder)
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] <= ids[i-1] {
+				t.Errorf("order %d: changes handled out of order: %v", order, ids)
+				break
+			}
+		}
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("changes left unprocessed: %v", left)
+	}
+}
+
+func TestFailedChangesAreRetriedLater(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 4, 3)
+	tr.fail[6] = true
+
+	p := New(db, Config{Name: "workers", Handler: "order-tracker", Workers: 3}, nil)
+	ctx := context.Background()
+	err := p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	left := unprocessed(t, db)
+	if len(left) != 1 || left[0] != 6 {
+		t.Errorf("unprocessed changes = %v, want [6]", left)
+	}
+	var lastError string
+	var due bool
+	err = db.QueryRow(`
+        SELECT last_error, next_attempt_at > CURRENT_TIMESTAMP
+        FROM priority_changes WHERE id = 6
+    `).Scan(&lastError, &due)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastError != "order-tracker handler: tracker: failing on purpose" || !due {
+		t.Errorf("change 6: last_error = %q, retry pending = %t", lastError, due)
+	}
+	if offset := pollerOffset(t, db, "workers"); offset != 5 {
+		t.Errorf("offset = %d, want 5, short of the failed change", offset)
+	}
+
+	// Not due yet.
+	err = p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tr.seen[2]); n != 3 {
+		t.Errorf("order 2 handled %d times before the retry was due, want 3", n)
+	}
+
+	tr.fail[6] = false
+	_, err = db.Exec(`UPDATE priority_changes SET next_attempt_at = datetime('now', '-1 second') WHERE id = 6`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("changes left unprocessed after the retry: %v", left)
+	}
+	if offset := pollerOffset(t, db, "workers"); offset != 12 {
+		t.Errorf("offset = %d, want 12", offset)
+	}
+}
+
+func pollerOffset(tb testing.TB, db *sql.DB, name string) int64 {
+	tb.Helper()
+
+	var offset int64
+	err := db.QueryRow(`SELECT last_processed_id FROM poller_offsets WHERE poller = ?`, name).Scan(&offset)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return offset
+}
+
+func TestLeasedChangesWaitForTheLease(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 2, 3)
+
+	// Another worker died holding changes 2 and 3.
+	_, err := db.Exec(`
+        UPDATE priority_changes
+        SET claimed_by = 'elsewhere/poller', claimed_until = datetime('now', '+1 hour')
+        WHERE id IN (2, 3)
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(db, Config{Name: "leases", Handler: "order-tracker"}, nil)
+	ctx := context.Background()
+	err = p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := unprocessed(t, db)
+	if len(left) != 2 || left[0] != 2 || left[1] != 3 {
+		t.Fatalf("unprocessed changes = %v, want [2 3]", left)
+	}
+	if offset := pollerOffset(t, db, "leases"); offset != 1 {
+		t.Errorf("offset = %d, want 1 so the leased changes aren't skipped", offset)
+	}
+
+	_, err = db.Exec(`UPDATE priority_changes SET claimed_until = datetime('now', '-1 second') WHERE id IN (2, 3)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("changes left unprocessed after the lease expired: %v", left)
+	}
+	if got := len(tr.seen[1]) + len(tr.seen[2]); got != 6 {
+		t.Errorf("handled %d changes, want 6", got)
+	}
+}
+
+func TestPollerTakesBackItsOwnClaims(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	newOrderTracker(t)
+	seedInterleaved(t, db, 1, 2)
+
+	p := New(db, Config{Name: "restarted", Handler: "order-tracker"}, nil)
+	_, err := db.Exec(`
+        UPDATE priority_changes
+        SET claimed_by = ?, claimed_until = datetime('now', '+1 hour')
+    `, p.claimer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("changes left unprocessed: %v", left)
+	}
+}
+
+func TestChangesOutOfAttemptsAreQuarantined(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 1, 3)
+	tr.fail[2] = true
+
+	p := New(db, Config{Name: "attempts", Handler: "order-tracker", MaxAttempts: 2}, nil)
+	ctx := context.Background()
+	for range 2 {
+		err := p.PollOnce(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(`UPDATE priority_changes SET next_attempt_at = datetime('now', '-1 second') WHERE next_attempt_at IS NOT NULL`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts int
+	var retry sql.NullTime
+	err = db.QueryRow(`SELECT attempts, next_attempt_at FROM priority_changes WHERE id = 2`).Scan(&attempts, &retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || retry.Valid {
+		t.Errorf("change 2: attempts = %d, next attempt %v; want 2 and none", attempts, retry)
+	}
+	if n := len(tr.seen[1]); n != 4 {
+		t.Errorf("handler calls = %d, want 4: three changes and one retry", n)
+	}
+	if offset := pollerOffset(t, db, "attempts"); offset != 3 {
+		t.Errorf("offset = %d, want 3, past the dead letter", offset)
+	}
+	entries, err := ListQuarantine(ctx, db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ChangeID != 2 || entries[0].Poller != "attempts" {
+		t.Fatalf("quarantine = %+v, want change 2 from poller attempts", entries)
+	}
+	var payload struct {
+		ID       int64 `json:"id"`
+		OrderID  int64 `json:"order_id"`
+		Attempts int   `json:"attempts"`
+	}
+	err = json.Unmarshal(entries[0].Payload, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != 2 || payload.OrderID != 1 || payload.Attempts != 2 {
+		t.Errorf("payload = %s", entries[0].Payload)
+	}
+	if len(entries[0].Errors) != 2 {
+		t.Errorf("errors = %q, want both attempts", entries[0].Errors)
+	}
+}
+
+func TestDeliverSendsEachChangeOnceAcrossReplays(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	seedInterleaved(t, db, 2, 2)
+	sent = make(map[int64]int)
+	t.Cleanup(func() { sent = nil })
+
+	p := New(db, Config{Name: "deliver", Handler: "deliver-tracker"}, nil)
+	ctx := context.Background()
+	err := p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay the feed, as an offset reset and a requeue of everything would.
+	_, err = db.Exec(`UPDATE priority_changes SET processed = FALSE`)
+	if err == nil {
+		_, err = db.Exec(`UPDATE poller_offsets SET last_processed_id = 0 WHERE poller = 'deliver'`)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("changes left unprocessed after the replay: %v", left)
+	}
+	for id := int64(1); id <= 4; id++ {
+		if sent[id] != 1 {
+			t.Errorf("change %d sent %d times, want once", id, sent[id])
+		}
+	}
+}
+
+func TestEffectsOnceSkipsDoneEffectsOnRedelivery(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	seedInterleaved(t, db, 2, 2)
+	effects = make(map[int64]int)
+	t.Cleanup(func() { effects = nil })
+	ledger, err := OpenEffects(filepath.Join(t.TempDir(), "effects.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+
+	// The handlers succeed but the cycle fails to commit, so every change
+	// is delivered again.
+	p := New(db, Config{Name: "effects", Handler: "effect-tracker"}, nil)
+	p.effects = ledger
+	p.chaos = &Chaos{cfg: ChaosConfig{CommitFailure: 1}}
+	ctx := context.Background()
+	err = p.PollOnce(ctx)
+	if !errors.Is(err, errChaosCommit) {
+		t.Fatalf("PollOnce = %v, want the injected commit failure", err)
+	}
+	if left := unprocessed(t, db); len(left) != 4 {
+		t.Fatalf("unprocessed changes = %v, want all 4 redelivered", left)
+	}
+
+	p.chaos = nil
+	err = p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("changes left unprocessed after the redelivery: %v", left)
+	}
+	for id := int64(1); id <= 4; id++ {
+		if effects[id] != 1 {
+			t.Errorf("change %d's effect ran %d times, want once", id, effects[id])
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	} {
+		if got := retryBackoff(tc.attempts); got != tc.want {
+			t.Errorf("retryBackoff(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestScheduleRunsUrgentOrdersFirst(t *testing.T) {
+	changes := []Change{
+		{ID: 1, OrderID: 1, Priority: "low"},
+		{ID: 2, OrderID: 2, Priority: "low"},
+		{ID: 3, OrderID: 3, Priority: "high"},
+		{ID: 4, OrderID: 1, Priority: "high"},
+		{ID: 5, OrderID: 2, Priority: "medium"},
+		{ID: 6, OrderID: 4, Priority: "low"},
+	}
+	var got []int64
+	for _, i := range schedule(changes) {
+		got = append(got, changes[i].ID)
+	}
+	// Order 1's escalation pulls its earlier change along with it.
+	want := []int64{1, 4, 3, 2, 5, 6}
+	if !slices.Equal(got, want) {
+		t.Errorf("schedule = %v, want %v", got, want)
+	}
+}
+
+func TestProductBatchSharesEachCycle(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	// Order 1 is a busy product with a backlog, orders 2 and 3 quieter ones.
+	for _, product := range []string{"ninja", "pirate", "robot"} {
+		_, err := db.Exec(`
+            INSERT INTO orders (customer_name, product_name, quantity, shipping_address, priority)
+            VALUES ('Test', ?, 1, '1 Test Way', 'low')
+        `, product)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, order := range []int{1, 1, 1, 1, 1, 1, 2, 2, 2, 3} {
+		_, err := db.Exec(`INSERT INTO priority_changes (order_id, priority) VALUES (?, 'high')`, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := New(db, Config{
+		Name:           "fair",
+		Handler:        "order-tracker",
+		ProductBatch:   1,
+		ProductWeights: map[string]int{"ninja": 2},
+	}, nil)
+	err := p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int64]int{}
+	for order, ids := range tr.seen {
+		seen[order] = len(ids)
+	}
+	want := map[int64]int{1: 2, 2: 1, 3: 1}
+	if !maps.Equal(seen, want) {
+		t.Errorf("first cycle handled %v changes per order, want %v", seen, want)
+	}
+
+	for range 3 {
+		err = p.PollOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("changes %v still unprocessed", left)
+	}
+	if got := pollerOffset(t, db, "fair"); got != 10 {
+		t.Errorf("offset = %d, want 10", got)
+	}
+}
+
+func TestRateLimitPacesHandlersAndClaims(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 3, 5)
+
+	// 20 a second with a 1s lease claims 10 changes a cycle.
+	p := New(db, Config{
+		Name:      "paced",
+		Handler:   "order-tracker",
+		Workers:   3,
+		Lease:     Duration{time.Second},
+		RateLimit: 20,
+	}, nil)
+	start := time.Now()
+	err := p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("10 changes at 20/s took %s", elapsed)
+	}
+	var n int
+	for _, ids := range tr.seen {
+		n += len(ids)
+	}
+	if n != 10 {
+		t.Errorf("first cycle handled %d changes, want 10", n)
+	}
+	if left := unprocessed(t, db); len(left) != 5 {
+		t.Errorf("unprocessed = %v, want the last 5", left)
+	}
+}
+
+func TestBreakerStopsClaimingUntilCooldown(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 3, 2)
+	for id := int64(1); id <= 6; id++ {
+		tr.fail[id] = true
+	}
+
+	p := New(db, Config{
+		Name:            "breaker",
+		Handler:         "order-tracker",
+		BreakerFailures: 2,
+		BreakerCooldown: Duration{time.Minute},
+	}, nil)
+	err := p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Order 1's two changes trip the breaker; the others are released
+	// without an attempt.
+	if got := tr.seen[1]; !slices.Equal(got, []int64{1, 4}) || len(tr.seen) != 1 {
+		t.Fatalf("handlers saw %v, want only order 1's changes", tr.seen)
+	}
+	if s := p.breaker.snapshot(); s.State != "open" || s.ConsecutiveFailures != 2 {
+		t.Errorf("breaker = %+v, want open after 2 failures", s)
+	}
+	var claimed, attempted int
+	err = db.QueryRow(`
+        SELECT COUNT(claimed_by), COUNT(*) FILTER (WHERE attempts > 0) FROM priority_changes
+    `).Scan(&claimed, &attempted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed != 0 || attempted != 2 {
+		t.Errorf("%d changes left claimed and %d attempted, want 0 and 2", claimed, attempted)
+	}
+
+	err = p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.seen) != 1 {
+		t.Errorf("open breaker let changes through: %v", tr.seen)
+	}
+
+	// Past the cooldown a trial change goes through and closes it again.
+	clear(tr.fail)
+	p.breaker.openedAt = time.Now().Add(-time.Hour)
+	err = p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := p.breaker.snapshot(); s.State != "closed" {
+		t.Errorf("breaker = %+v after a successful trial, want closed", s)
+	}
+	if left := unprocessed(t, db); !slices.Equal(left, []int64{1, 4}) {
+		t.Errorf("unprocessed = %v, want only the changes backing off", left)
+	}
+}
+
+func TestBatchHandlerGetsTheWholeCycle(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	seedInterleaved(t, db, 2, 3)
+	batchCalls, batchFail = nil, map[int64]bool{3: true}
+	t.Cleanup(func() { batchCalls, batchFail = nil, nil })
+
+	p := New(db, Config{Name: "batch", Handler: "batch-tracker"}, nil)
+	err := p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]int64{{1, 3, 5, 2, 4, 6}}
+	if !slices.EqualFunc(batchCalls, want, slices.Equal) {
+		t.Errorf("batch calls = %v, want %v", batchCalls, want)
+	}
+	if left := unprocessed(t, db); !slices.Equal(left, []int64{3}) {
+		t.Errorf("unprocessed = %v, want only the change the batch failed", left)
+	}
+	var lastError string
+	err = db.QueryRow(`SELECT last_error FROM priority_changes WHERE id = 3`).Scan(&lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastError != "batch-tracker handler: batch-tracker: failing on purpose" {
+		t.Errorf("last_error = %q", lastError)
+	}
+}
+
+func TestHandlerMiddlewareRecoversRetriesAndCounts(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	seedInterleaved(t, db, 1, 1)
+	flakyCalls = 0
+	calls := expvarInt(handlerCalls, "flaky")
+	errs := expvarInt(handlerErrors, "flaky")
+
+	p := New(db, Config{Name: "flaky", Handler: "flaky", HandlerRetries: 2}, nil)
+	err := p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("unprocessed = %v; the third call should have handled it", left)
+	}
+	if got := expvarInt(handlerCalls, "flaky") - calls; got != 3 {
+		t.Errorf("handler_calls grew by %d, want 3", got)
+	}
+	if got := expvarInt(handlerErrors, "flaky") - errs; got != 2 {
+		t.Errorf("handler_errors grew by %d, want 2", got)
+	}
+}
+
+func expvarInt(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestHandlerTimeoutFailsStuckCalls(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	seedInterleaved(t, db, 1, 1)
+	stuck = make(chan struct{})
+	t.Cleanup(func() { close(stuck) })
+
+	p := New(db, Config{Name: "stuck", Handler: "stuck", HandlerTimeout: Duration{50 * time.Millisecond}}, nil)
+	start := time.Now()
+	err := p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cycle took %s despite the timeout", elapsed)
+	}
+	var attempts int
+	var lastError string
+	err = db.QueryRow(`SELECT attempts, last_error FROM priority_changes WHERE id = 1`).Scan(&attempts, &lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 || !strings.Contains(lastError, "timed out after 50ms") {
+		t.Errorf("attempts = %d, last_error = %q; want a timed out attempt", attempts, lastError)
+	}
+}
+
+func TestMaxInFlightBoundsEachClaim(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 2, 5)
+
+	p := New(db, Config{Name: "bounded", Handler: "order-tracker", Workers: 2, MaxInFlight: 4}, nil)
+	for i, want := range []bool{true, true, false} {
+		full, err := p.pollOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if full != want {
+			t.Errorf("cycle %d full = %t, want %t", i+1, full, want)
+		}
+		if got := len(unprocessed(t, db)); got != max(0, 10-4*(i+1)) {
+			t.Errorf("after cycle %d, %d changes unprocessed", i+1, got)
+		}
+	}
+	if tr.overlaps > 0 {
+		t.Errorf("%d handler calls overlapped another for the same order", tr.overlaps)
+	}
+}
+
+func TestShardedInstancesSplitTheOrders(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 4, 2)
+
+	cfg := Config{Name: "sharded", Handler: "order-tracker", Shards: 2}
+	var instances []*Poller
+	for _, name := range []string{"a", "b", "c"} {
+		p := New(db, cfg, nil)
+		p.instance = name
+		instances = append(instances, p)
+	}
+	for _, p := range instances {
+		err := p.PollOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := []int{instances[0].shard, instances[1].shard, instances[2].shard}; !slices.Equal(got, []int{0, 1, noShard}) {
+		t.Errorf("shards = %v, want the third instance left without one", got)
+	}
+	for order, ids := range tr.seen {
+		if len(ids) != 2 {
+			t.Errorf("order %d handled %v, want each of its changes once", order, ids)
+		}
+	}
+	if left := unprocessed(t, db); len(left) > 0 {
+		t.Errorf("changes %v still unprocessed", left)
+	}
+	// Each shard's offset moves past the other's changes too, to the newest.
+	for _, name := range []string{"sharded#0", "sharded#1"} {
+		if got := pollerOffset(t, db, name); got != 8 {
+			t.Errorf("%s offset = %d, want 8", name, got)
+		}
+	}
+
+	// Once a holder goes, its shard is free for the one left over.
+	instances[0].releaseShard()
+	err := instances[2].PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instances[2].shard != 0 {
+		t.Errorf("spare instance has shard %d after the release, want 0", instances[2].shard)
+	}
+}
+
+func TestOnlyOneProcessRunsAPoller(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 1, 1)
+
+	cfg := Config{Name: "single", Handler: "order-tracker"}
+	first, second := New(db, cfg, nil), New(db, cfg, nil)
+	first.instance, second.instance = "first", "second"
+	err := first.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedInterleaved(t, db, 1, 1)
+	err = second.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(unprocessed(t, db)); n != 1 {
+		t.Fatalf("%d changes unprocessed; the second process should have idled", n)
+	}
+
+	first.leader.Resign()
+	err = second.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(unprocessed(t, db)); n != 0 {
+		t.Errorf("%d changes unprocessed after the first process stepped down", n)
+	}
+	if got := tr.seen[1]; !slices.Equal(got, []int64{1, 2}) {
+		t.Errorf("handlers saw %v, want each change once", got)
+	}
+}
+
+// TestValidateKeepsLeasesAcrossCycles checks that the interval stays under
+// the lease each cycle renews, so a leader doesn't lose it between cycles.
+func TestValidateKeepsLeasesAcrossCycles(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		interval, lease time.Duration
+		wantErr         bool
+	}{
+		{name: "defaults"},
+		{name: "interval under lease", interval: 30 * time.Second, lease: time.Minute},
+		{name: "interval of default lease", interval: time.Minute, wantErr: true},
+		{name: "interval over lease", interval: 2 * time.Minute, lease: time.Minute, wantErr: true},
+		{name: "longer lease", interval: 2 * time.Minute, lease: 5 * time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{Name: "leased", Interval: Duration{tc.interval}, Lease: Duration{tc.lease}}
+			err := Validate(&cfg)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate error = %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestHistogramCountsCumulatively(t *testing.T) {
+	h := newHistogram(1, 10)
+	for _, v := range []float64{0.5, 1, 5, 60} {
+		h.observe(v)
+	}
+	var got struct {
+		Buckets map[string]int64 `json:"buckets"`
+		Count   int64            `json:"count"`
+		Sum     float64          `json:"sum"`
+	}
+	err := json.Unmarshal([]byte(h.String()), &got)
+	if err != nil {
+		t.Fatalf("%s: %v", h, err)
+	}
+	want := map[string]int64{"1": 2, "10": 3, "+Inf": 4}
+	if !maps.Equal(got.Buckets, want) || got.Count != 4 || got.Sum != 66.5 {
+		t.Errorf("histogram = %s, want buckets %v, count 4, sum 66.5", h, want)
+	}
+}
+
+func TestHistogramKeepsLatestTraceAsExemplar(t *testing.T) {
+	h := newHistogram(1, 10)
+	h.observeTrace(0.5, "a")
+	h.observeTrace(2, "b")
+	h.observeTrace(3, "c")
+	h.observe(4)
+	h.observeTrace(60, "d")
+
+	var got struct {
+		Exemplars map[string]exemplar `json:"exemplars"`
+	}
+	err := json.Unmarshal([]byte(h.String()), &got)
+	if err != nil {
+		t.Fatalf("%s: %v", h, err)
+	}
+	want := map[string]string{"1": "a", "10": "c", "+Inf": "d"}
+	traces := make(map[string]string)
+	for bucket, ex := range got.Exemplars {
+		traces[bucket] = ex.TraceID
+	}
+	if !maps.Equal(traces, want) {
+		t.Errorf("exemplar traces = %v, want %v", traces, want)
+	}
+}
+
+func TestHandlersContinueTheTraceOfTheirChange(t *testing.T) {
+	db := openTestDB(t)
+	seedInterleaved(t, db, 1, 2)
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	_, err := db.Exec(`UPDATE priority_changes SET traceparent = ? WHERE id = 1`, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(db, Config{Name: "traced", Handler: "traced"}, nil)
+	err = p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := traced[1]
+	if got.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || got.SpanID == "00f067aa0ba902b7" {
+		t.Errorf("change 1 handled in span %+v, want a child of %s", got, parent)
+	}
+	if untraced := traced[2]; untraced.TraceID == "" || untraced.TraceID == got.TraceID {
+		t.Errorf("change 2 handled in span %+v, want one in the cycle's own trace", untraced)
+	}
+}
+
+func TestTenantPollerOnlyDrainsItsTenant(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 2, 2)
+	_, err := db.Exec(`UPDATE orders SET tenant_id = 'acme' WHERE id = 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`UPDATE priority_changes SET tenant_id = 'acme' WHERE order_id = 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(db, Config{Name: "acme", Tenant: "acme", Handler: "order-tracker"}, nil)
+	err = p.PollOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(maps.Keys(tr.seen)); !slices.Equal(got, []int64{2}) {
+		t.Errorf("handled orders %v, want only acme's order 2", got)
+	}
+	if got := unprocessed(t, db); !slices.Equal(got, []int64{1, 3}) {
+		t.Errorf("unprocessed changes %v, want the default tenant's 1 and 3", got)
+	}
+}
+
+func TestPerTenantPollersKeepTheirOwnOffsets(t *testing.T) {
+	quietLogs(t)
+	db := openTestDB(t)
+	tr := newOrderTracker(t)
+	seedInterleaved(t, db, 2, 2)
+	_, err := db.Exec(`UPDATE orders SET tenant_id = 'acme' WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`UPDATE priority_changes SET tenant_id = 'acme' WHERE order_id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{Name: "split", PerTenant: true, Handler: "order-tracker"}
+	err = Validate(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewSupervisor(db, []Config{cfg}, nil).desired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(maps.Keys(want)); !slices.Equal(got, []string{"split@acme", "split@default"}) {
+		t.Fatalf("instances %v, want one per tenant", got)
+	}
+
+	// acme's first change keeps failing; the default tenant's go through
+	// regardless and its offset moves past them.
+	tr.fail[1] = true
+	ctx := context.Background()
+	for _, name := range []string{"split@acme", "split@default"} {
+		p := New(db, want[name], nil)
+		err = p.PollOnce(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.publishLag(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Close()
+	}
+	if offset := pollerOffset(t, db, "split@default"); offset != 4 {
+		t.Errorf("default offset = %d, want 4", offset)
+	}
+	if offset := pollerOffset(t, db, "split@acme"); offset != 0 {
+		t.Errorf("acme offset = %d, want 0, short of the failed change", offset)
+	}
+	if n := expvarInt(pollerBacklog, "split@acme"); n != 1 {
+		t.Errorf("acme backlog = %d, want 1", n)
+	}
+	if n := expvarInt(pollerBacklog, "split@default"); n != 0 {
+		t.Errorf("default backlog = %d, want 0", n)
+	}
+}
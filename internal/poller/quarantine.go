@@ -0,0 +1,107 @@
+package poller
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"time"
+)
+
+var quarantinedChanges = expvar.NewInt("quarantined_changes")
+
+// quarantineErrors is how many of a change's most recent failures its
+// quarantine entry keeps.
+const quarantineErrors = 3
+
+// Quarantined is a change that ran out of attempts, captured as its row
+// stood at the time with its last errors, newest first. The change itself
+// stays in priority_changes, where the audit chain needs it, as a dead
+// letter the pollers no longer wait for.
+type Quarantined struct {
+	ChangeID      int64           `json:"changeId"`
+	Poller        string          `json:"poller"`
+	Payload       json.RawMessage `json:"payload"`
+	Errors        []string        `json:"errors"`
+	QuarantinedAt time.Time       `json:"quarantinedAt"`
+}
+
+// recordFailure keeps a failed attempt's error for the quarantine.
+func (p *Poller) recordFailure(ctx context.Context, tx *sql.Tx, f failure, attempt int) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO change_failures (change_id, poller, attempt, error)
+        VALUES (?, ?, ?, ?)
+    `, f.ID, p.cfg.Name, attempt, f.err.Error())
+	return err
+}
+
+// quarantine copies the change row and its last errors to
+// change_quarantine, replacing an entry left from before a requeue.
+func (p *Poller) quarantine(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, `
+        `+p.dialect.InsertReplace()+` INTO change_quarantine (change_id, poller, payload, errors)
+        SELECT pc.id, ?,
+            json_object(
+                'id', pc.id,
+                'order_id', pc.order_id,
+                'change_type', pc.change_type,
+                'priority', pc.priority,
+                'processed', pc.processed,
+                'created_at', pc.created_at,
+                'actor', pc.actor,
+                'patch', pc.patch,
+                'prev_hash', pc.prev_hash,
+                'hash', pc.hash,
+                'attempts', pc.attempts,
+                'last_error', pc.last_error
+            ),
+            (SELECT `+p.dialect.JSONArrayAgg("error")+` FROM (
+                SELECT error FROM change_failures
+                WHERE change_id = pc.id
+                ORDER BY id DESC
+                LIMIT ?
+            ) recent)
+        FROM priority_changes pc
+        WHERE pc.id = ?
+    `, p.cfg.Name, quarantineErrors, id)
+	return err
+}
+
+// alertQuarantined reports changes quarantined by a committed cycle.
+func (p *Poller) alertQuarantined(failed []failure) {
+	for _, f := range failed {
+		quarantinedChanges.Add(1)
+		changeLog(context.Background(), f.Change).Printf("ALERT: poller %s quarantined change %d for order #%d after %d attempts: %v",
+			p.cfg.Name, f.ID, f.OrderID, p.maxAttempts(), f.err)
+	}
+}
+
+// ListQuarantine returns up to limit quarantined changes, newest first.
+func ListQuarantine(ctx context.Context, db *sql.DB, limit int) ([]Quarantined, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT change_id, poller, payload, errors, quarantined_at
+        FROM change_quarantine
+        ORDER BY quarantined_at DESC, change_id DESC
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Quarantined{}
+	for rows.Next() {
+		var q Quarantined
+		var errs string
+		err = rows.Scan(&q.ChangeID, &q.Poller, (*[]byte)(&q.Payload), &errs, &q.QuarantinedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(errs), &q.Errors)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, q)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,359 @@
+package poller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"test/internal/store"
+)
+
+var (
+	ErrRuleNotFound = errors.New("poller rule not found")
+	ErrRuleExists   = errors.New("poller rule already exists")
+)
+
+// filterTemplate is one of the SQL fragments a poller rule may add to the
+// polling query. Rules only ever pick a template by name and supply bound
+// arguments, so no caller-provided SQL reaches the database.
+type filterTemplate struct {
+	sql  string
+	args []string // "string", "int" or "priority", one per placeholder
+}
+
+var filterTemplates = map[string]filterTemplate{
+	"min_quantity": {sql: "o.quantity >= ?", args: []string{"int"}},
+	"max_quantity": {sql: "o.quantity <= ?", args: []string{"int"}},
+	"quantity_between": {
+		sql:  "o.quantity BETWEEN ? AND ?",
+		args: []string{"int", "int"},
+	},
+	"change_type":   {sql: "pc.change_type = ?", args: []string{"string"}},
+	"order_status":  {sql: "o.status = ?", args: []string{"string"}},
+	"product_not":   {sql: "o.product_name <> ?", args: []string{"string"}},
+	"priority_from": {sql: "o.priority = ?", args: []string{"priority"}},
+}
+
+// FilterSpec picks a filter template by name and supplies its arguments.
+type FilterSpec struct {
+	Template string `json:"template"`
+	Args     []any  `json:"args"`
+}
+
+// normalize checks the spec against its template and converts the JSON
+// arguments to the types the template binds.
+func (f *FilterSpec) normalize() error {
+	tmpl, ok := filterTemplates[f.Template]
+	if !ok {
+		return fmt.Errorf("unknown filter template %q", f.Template)
+	}
+	if len(f.Args) != len(tmpl.args) {
+		return fmt.Errorf(
+			"filter template %s takes %d args, got %d",
+			f.Template,
+			len(tmpl.args),
+			len(f.Args),
+		)
+	}
+
+	for i, kind := range tmpl.args {
+		switch kind {
+		case "int":
+			switch n := f.Args[i].(type) {
+			case int64:
+			case float64:
+				if n != math.Trunc(n) {
+					return fmt.Errorf("filter arg %d must be a whole number", i)
+				}
+				f.Args[i] = int64(n)
+			default:
+				return fmt.Errorf("filter arg %d must be a number", i)
+			}
+		case "string", "priority":
+			s, ok := f.Args[i].(string)
+			if !ok || s == "" {
+				return fmt.Errorf("filter arg %d must be a non-empty string", i)
+			}
+			if kind == "priority" {
+				p, ok := store.ParsePriority(s)
+				if !ok {
+					return fmt.Errorf("filter arg %d: unknown priority %q", i, s)
+				}
+				s = p
+			}
+			f.Args[i] = s
+		}
+	}
+	return nil
+}
+
+// Rule is a poller defined at runtime through /admin/pollers and stored in
+// poller_rules.
+type Rule struct {
+	Config
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+}
+
+func ListRules(db *sql.DB) ([]Rule, error) {
+	rows, err := db.Query(`
+        SELECT name, product, priority, tag, tenant, filter, interval_ms, handler,
+               workers, lease_ms, max_attempts, product_batch, product_weights,
+               rate_limit, breaker_failures, breaker_cooldown_ms, handler_retries,
+               handler_timeout_ms, max_in_flight, shards, shard, per_tenant, dry_run, enabled,
+               created_at, updated_at
+        FROM poller_rules
+        ORDER BY name
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func GetRule(db *sql.DB, name string) (Rule, error) {
+	row := db.QueryRow(`
+        SELECT name, product, priority, tag, tenant, filter, interval_ms, handler,
+               workers, lease_ms, max_attempts, product_batch, product_weights,
+               rate_limit, breaker_failures, breaker_cooldown_ms, handler_retries,
+               handler_timeout_ms, max_in_flight, shards, shard, per_tenant, dry_run, enabled,
+               created_at, updated_at
+        FROM poller_rules
+        WHERE name = ?
+    `, name)
+	rule, err := scanRule(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rule, ErrRuleNotFound
+	}
+	return rule, err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRule(row rowScanner) (Rule, error) {
+	var rule Rule
+	var filter, weights string
+	var intervalMS, leaseMS, breakerCooldownMS, handlerTimeoutMS int64
+	var shard sql.NullInt64
+	err := row.Scan(
+		&rule.Name,
+		&rule.Product,
+		&rule.Priority,
+		&rule.Tag,
+		&rule.Tenant,
+		&filter,
+		&intervalMS,
+		&rule.Handler,
+		&rule.Workers,
+		&leaseMS,
+		&rule.MaxAttempts,
+		&rule.ProductBatch,
+		&weights,
+		&rule.RateLimit,
+		&rule.BreakerFailures,
+		&breakerCooldownMS,
+		&rule.HandlerRetries,
+		&handlerTimeoutMS,
+		&rule.MaxInFlight,
+		&rule.Shards,
+		&shard,
+		&rule.PerTenant,
+		&rule.DryRun,
+		&rule.Enabled,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+	if err != nil {
+		return rule, err
+	}
+
+	rule.Interval.Duration = time.Duration(intervalMS) * time.Millisecond
+	rule.Lease.Duration = time.Duration(leaseMS) * time.Millisecond
+	rule.BreakerCooldown.Duration = time.Duration(breakerCooldownMS) * time.Millisecond
+	rule.HandlerTimeout.Duration = time.Duration(handlerTimeoutMS) * time.Millisecond
+	if shard.Valid {
+		n := int(shard.Int64)
+		rule.Shard = &n
+	}
+	if rule.Lease.Duration == 0 {
+		// rules stored before leases existed
+		rule.Lease.Duration = defaultLease
+	}
+	if rule.MaxAttempts == 0 {
+		rule.MaxAttempts = defaultMaxAttempts
+	}
+	if weights != "" {
+		err = json.Unmarshal([]byte(weights), &rule.ProductWeights)
+		if err != nil {
+			return rule, fmt.Errorf("rule %s: stored product weights: %w", rule.Name, err)
+		}
+	}
+	if filter != "" {
+		rule.Filter = &FilterSpec{}
+		err = json.Unmarshal([]byte(filter), rule.Filter)
+		if err != nil {
+			return rule, fmt.Errorf("rule %s: stored filter: %w", rule.Name, err)
+		}
+		err = rule.Filter.normalize()
+		if err != nil {
+			return rule, fmt.Errorf("rule %s: stored filter: %w", rule.Name, err)
+		}
+	}
+	return rule, nil
+}
+
+func encodeFilter(f *FilterSpec) (string, error) {
+	if f == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(f)
+	return string(b), err
+}
+
+func encodeWeights(w map[string]int) (string, error) {
+	if len(w) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(w)
+	return string(b), err
+}
+
+func CreateRule(db *sql.DB, rule Rule) error {
+	filter, err := encodeFilter(rule.Filter)
+	if err != nil {
+		return err
+	}
+	weights, err := encodeWeights(rule.ProductWeights)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+        INSERT INTO poller_rules (
+            name, product, priority, tag, tenant, filter, interval_ms, handler,
+            workers, lease_ms, max_attempts, product_batch, product_weights,
+            rate_limit, breaker_failures, breaker_cooldown_ms, handler_retries,
+            handler_timeout_ms, max_in_flight, shards, shard, per_tenant, dry_run, enabled
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `,
+		rule.Name,
+		rule.Product,
+		rule.Priority,
+		rule.Tag,
+		rule.Tenant,
+		filter,
+		rule.Interval.Milliseconds(),
+		rule.Handler,
+		rule.Workers,
+		rule.Lease.Milliseconds(),
+		rule.MaxAttempts,
+		rule.ProductBatch,
+		weights,
+		rule.RateLimit,
+		rule.BreakerFailures,
+		rule.BreakerCooldown.Milliseconds(),
+		rule.HandlerRetries,
+		rule.HandlerTimeout.Milliseconds(),
+		rule.MaxInFlight,
+		rule.Shards,
+		rule.Shard,
+		rule.PerTenant,
+		rule.DryRun,
+		rule.Enabled,
+	)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrRuleExists
+	}
+	return err
+}
+
+func UpdateRule(db *sql.DB, rule Rule) error {
+	filter, err := encodeFilter(rule.Filter)
+	if err != nil {
+		return err
+	}
+	weights, err := encodeWeights(rule.ProductWeights)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(`
+        UPDATE poller_rules
+        SET product = ?, priority = ?, tag = ?, tenant = ?, filter = ?, interval_ms = ?,
+            handler = ?, workers = ?, lease_ms = ?, max_attempts = ?,
+            product_batch = ?, product_weights = ?, rate_limit = ?,
+            breaker_failures = ?, breaker_cooldown_ms = ?, handler_retries = ?,
+            handler_timeout_ms = ?, max_in_flight = ?, shards = ?, shard = ?,
+            per_tenant = ?, dry_run = ?, enabled = ?,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE name = ?
+    `,
+		rule.Product,
+		rule.Priority,
+		rule.Tag,
+		rule.Tenant,
+		filter,
+		rule.Interval.Milliseconds(),
+		rule.Handler,
+		rule.Workers,
+		rule.Lease.Milliseconds(),
+		rule.MaxAttempts,
+		rule.ProductBatch,
+		weights,
+		rule.RateLimit,
+		rule.BreakerFailures,
+		rule.BreakerCooldown.Milliseconds(),
+		rule.HandlerRetries,
+		rule.HandlerTimeout.Milliseconds(),
+		rule.MaxInFlight,
+		rule.Shards,
+		rule.Shard,
+		rule.PerTenant,
+		rule.DryRun,
+		rule.Enabled,
+		rule.Name,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+func DeleteRule(db *sql.DB, name string) error {
+	result, err := db.Exec(`DELETE FROM poller_rules WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package poller
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"test/internal/store"
+)
+
+const maxShards = 1024
+
+// noShard is Poller.shard while a sharded poller holds none.
+const noShard = -1
+
+// offsetName is the poller_offsets row of the poller's current shard, or
+// of the poller when it isn't sharded.
+func (p *Poller) offsetName() string {
+	if p.cfg.Shards <= 1 {
+		return p.cfg.Name
+	}
+	return fmt.Sprintf("%s#%d", p.cfg.Name, p.shard)
+}
+
+// registerOffsets makes sure the poller has its poller_offsets rows, one
+// per shard when it is sharded, before any cycle runs. Other pollers move
+// their offsets past the changes they don't match, so a poller without a
+// row yet wouldn't hold back how far the feed counts as settled.
+func (p *Poller) registerOffsets(ctx context.Context) error {
+	names := []string{p.cfg.Name}
+	if p.cfg.Shards > 1 {
+		names = names[:0]
+		for shard := range p.cfg.Shards {
+			if p.cfg.Shard == nil || *p.cfg.Shard == shard {
+				names = append(names, fmt.Sprintf("%s#%d", p.cfg.Name, shard))
+			}
+		}
+	}
+	for _, name := range names {
+		_, err := p.db.ExecContext(ctx, p.dialect.InsertIgnore()+` INTO poller_offsets (poller) VALUES (?)`, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeShard settles which shard the cycle works on and reports whether it
+// has one. A pinned shard is always there; otherwise the poller renews its
+// lease on the shard it holds in poller_shards or takes the first free or
+// expired one, so instances sharing the poller spread over the shards and
+// the shard of one that dies is picked up once its lease runs out.
+func (p *Poller) takeShard(ctx context.Context, tx *sql.Tx, seconds int64) (bool, error) {
+	if p.cfg.Shards <= 1 {
+		return true, nil
+	}
+	if p.cfg.Shard != nil {
+		p.shard = *p.cfg.Shard
+		return true, nil
+	}
+
+	candidates := make([]int, 0, p.cfg.Shards)
+	if p.shard != noShard {
+		candidates = append(candidates, p.shard)
+	}
+	for shard := range p.cfg.Shards {
+		if shard != p.shard {
+			candidates = append(candidates, shard)
+		}
+	}
+	for _, shard := range candidates {
+		ok, err := p.leaseShard(ctx, tx, shard, seconds)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			if shard != p.shard {
+				log.Printf("Poller %s took shard %d of %d", p.cfg.Name, shard, p.cfg.Shards)
+			}
+			p.shard = shard
+			return true, nil
+		}
+	}
+
+	if p.shard != noShard {
+		log.Printf("Poller %s lost shard %d and found no free one", p.cfg.Name, p.shard)
+		p.shard = noShard
+	}
+	return false, nil
+}
+
+// leaseShard takes shard for seconds when it is free, expired or the
+// poller's own. MySQL has no upsert that leaves a clashing row alone, so
+// a row that is there already is taken with an UPDATE of its own.
+func (p *Poller) leaseShard(ctx context.Context, tx *sql.Tx, shard int, seconds int64) (bool, error) {
+	if p.dialect == store.MySQL {
+		res, err := tx.ExecContext(ctx, `
+            INSERT IGNORE INTO poller_shards (poller, shard, instance, expires_at)
+            VALUES (?, ?, ?, `+p.dialect.SecondsFromNow()+`)
+        `, p.cfg.Name, shard, p.claimer(), seconds)
+		if err != nil {
+			return false, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return n > 0, err
+		}
+		res, err = tx.ExecContext(ctx, `
+            UPDATE poller_shards SET instance = ?, expires_at = `+p.dialect.SecondsFromNow()+`
+            WHERE poller = ? AND shard = ?
+                AND (instance = ? OR expires_at < CURRENT_TIMESTAMP)
+        `, p.claimer(), seconds, p.cfg.Name, shard, p.claimer())
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n > 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+        INSERT INTO poller_shards (poller, shard, instance, expires_at)
+        VALUES (?, ?, ?, `+p.dialect.SecondsFromNow()+`)
+        ON CONFLICT (poller, shard) DO UPDATE SET
+            instance = excluded.instance,
+            expires_at = excluded.expires_at
+        WHERE poller_shards.instance = excluded.instance
+            OR poller_shards.expires_at < CURRENT_TIMESTAMP
+    `, p.cfg.Name, shard, p.claimer(), seconds)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// releaseShard frees the poller's shard for other instances when it stops.
+func (p *Poller) releaseShard() {
+	if p.cfg.Shards <= 1 || p.cfg.Shard != nil {
+		return
+	}
+	_, err := p.db.Exec(`DELETE FROM poller_shards WHERE poller = ? AND instance = ?`, p.cfg.Name, p.claimer())
+	if err != nil {
+		log.Printf("Error releasing the shard of %s: %v", p.cfg.Name, err)
+	}
+	p.shard = noShard
+}
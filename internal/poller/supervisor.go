@@ -0,0 +1,330 @@
+package poller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"test/internal/logdedup"
+)
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+
+	// ruleRefreshInterval bounds how stale the running set can get when
+	// rules are edited by another instance sharing the database.
+	ruleRefreshInterval = 30 * time.Second
+)
+
+// Supervisor runs the configured pollers plus the enabled rules from
+// poller_rules, each in its own goroutine, and restarts one that panics,
+// with growing delays, without touching the others. Rule changes are picked
+// up on reload or every ruleRefreshInterval.
+type Supervisor struct {
+	db      *sql.DB
+	static  []Config
+	reloads chan struct{}
+
+	// dryRun switches every poller to dry-run mode while set.
+	dryRun atomic.Bool
+
+	chaos   *Chaos
+	effects *Effects
+
+	mu      sync.Mutex
+	running map[string]*runningPoller
+}
+
+type runningPoller struct {
+	cfg    Config
+	poller *Poller
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSupervisor takes the pollers from config, which always run, and an
+// optional fault injector shared by every poller.
+func NewSupervisor(db *sql.DB, static []Config, chaos *Chaos) *Supervisor {
+	return &Supervisor{
+		db:      db,
+		static:  static,
+		chaos:   chaos,
+		reloads: make(chan struct{}, 1),
+		running: make(map[string]*runningPoller),
+	}
+}
+
+// SetEffects gives pollers started from now on the ledger handlers record
+// their side effects in. Call it before Run.
+func (s *Supervisor) SetEffects(e *Effects) {
+	s.effects = e
+}
+
+// SetDryRun flips the process-wide dry-run switch. Pollers pick it up on
+// their next cycle.
+func (s *Supervisor) SetDryRun(on bool) {
+	s.dryRun.Store(on)
+}
+
+func (s *Supervisor) DryRun() bool {
+	return s.dryRun.Load()
+}
+
+// Static returns the pollers that come from config rather than rules.
+func (s *Supervisor) Static() []Config {
+	return s.static
+}
+
+// Run blocks until ctx is cancelled and every poller has returned, then
+// closes done.
+func (s *Supervisor) Run(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(ruleRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		err := s.sync(ctx)
+		if err != nil {
+			logdedup.Printf("Error loading poller rules: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			s.stopAll()
+			log.Println("Polling workers stopped")
+			return
+		case <-s.reloads:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reload asks the supervisor to re-read poller_rules now.
+func (s *Supervisor) Reload() {
+	select {
+	case s.reloads <- struct{}{}:
+	default:
+	}
+}
+
+// IsRunning reports whether a poller with that name is currently active,
+// counting a per-tenant poller as running while any of its tenants is.
+func (s *Supervisor) IsRunning(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.running[name]
+	if ok {
+		return true
+	}
+	for running := range s.running {
+		if strings.HasPrefix(running, name+"@") {
+			return true
+		}
+	}
+	return false
+}
+
+// Breakers returns the circuit breakers of the running pollers that have
+// one, by poller name.
+func (s *Supervisor) Breakers() []BreakerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := []BreakerState{}
+	for _, rp := range s.running {
+		if b := rp.poller.breaker; b != nil {
+			states = append(states, b.snapshot())
+		}
+	}
+	slices.SortFunc(states, func(a, b BreakerState) int {
+		return strings.Compare(a.Poller, b.Poller)
+	})
+	return states
+}
+
+func (s *Supervisor) IsStatic(name string) bool {
+	for _, p := range s.static {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Supervisor) desired() (map[string]Config, error) {
+	want := make(map[string]Config)
+	var perTenant []Config
+	for _, p := range s.static {
+		want[p.Name] = p
+	}
+
+	rules, err := ListRules(s.db)
+	if err == nil {
+		for _, r := range rules {
+			if !r.Enabled {
+				continue
+			}
+			if _, clash := want[r.Name]; clash {
+				log.Printf("Ignoring poller rule %s: name taken by config", r.Name)
+				continue
+			}
+			want[r.Name] = r.Config
+		}
+	}
+
+	for name, p := range want {
+		if p.PerTenant {
+			delete(want, name)
+			perTenant = append(perTenant, p)
+		}
+	}
+	if len(perTenant) == 0 {
+		return want, err
+	}
+	tenants, terr := listTenants(s.db)
+	if terr != nil {
+		return want, errors.Join(err, terr)
+	}
+	for _, p := range perTenant {
+		for _, tenant := range tenants {
+			instance := p
+			instance.Name = p.Name + "@" + tenant
+			instance.Tenant = tenant
+			instance.PerTenant = false
+			want[instance.Name] = instance
+		}
+	}
+	return want, err
+}
+
+// listTenants returns every tenant with orders, which per-tenant pollers
+// run one instance for.
+func listTenants(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT DISTINCT tenant_id FROM orders ORDER BY tenant_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var t string
+		err = rows.Scan(&t)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// sync starts, stops and restarts pollers until the running set matches the
+// config plus enabled rules. If the rules can't be read the static pollers
+// still run and rule-based ones are left as they are.
+func (s *Supervisor) sync(ctx context.Context) error {
+	want, err := s.desired()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, rp := range s.running {
+		cfg, keep := want[name]
+		if err != nil && !s.IsStatic(name) {
+			keep, cfg = true, rp.cfg
+		}
+		if keep && reflect.DeepEqual(cfg, rp.cfg) {
+			continue
+		}
+		rp.cancel()
+		<-rp.done
+		delete(s.running, name)
+		log.Printf("Poller %s stopped", name)
+	}
+
+	// Every new poller gets its offsets before any of them starts, so none
+	// settles the feed past changes another has yet to process.
+	start := make(map[string]*Poller)
+	for name, cfg := range want {
+		if _, ok := s.running[name]; ok {
+			continue
+		}
+		p := New(s.db, cfg, &s.dryRun)
+		if !cfg.DryRun {
+			rerr := p.registerOffsets(ctx)
+			if rerr != nil {
+				log.Printf("Poller %s: registering offsets: %v", name, rerr)
+			}
+		}
+		start[name] = p
+	}
+
+	for name, p := range start {
+		cfg := want[name]
+		pctx, cancel := context.WithCancel(ctx)
+		p.chaos = s.chaos
+		p.effects = s.effects
+		rp := &runningPoller{cfg: cfg, poller: p, cancel: cancel, done: make(chan struct{})}
+		s.running[name] = rp
+		go func() {
+			defer close(rp.done)
+			s.supervise(pctx, p)
+		}()
+	}
+	return err
+}
+
+func (s *Supervisor) stopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, rp := range s.running {
+		rp.cancel()
+		<-rp.done
+		delete(s.running, name)
+	}
+}
+
+func (s *Supervisor) supervise(ctx context.Context, p *Poller) {
+	delay := minRestartDelay
+	for {
+		start := time.Now()
+		err := runGuarded(ctx, p)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// a poller that ran fine for a while gets a fresh backoff
+		if time.Since(start) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+		log.Printf("Poller %s crashed, restarting in %s: %v", p.cfg.Name, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+func runGuarded(ctx context.Context, p *Poller) (err error) {
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			err = fmt.Errorf("panic: %v\n%s", rec, debug.Stack())
+		}
+	}()
+	log.Printf("Poller %s started", p.cfg.Name)
+	p.Run(ctx)
+	return nil
+}
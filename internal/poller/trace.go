@@ -0,0 +1,42 @@
+package poller
+
+import (
+	"context"
+
+	"test/internal/logging"
+	"test/internal/tracing"
+)
+
+// changeSpan is the span a change's handlers run in: a child of the span
+// that recorded the change, so its trace runs from the request through to
+// the handling, or of the cycle's span for a change recorded outside one.
+func changeSpan(ctx context.Context, c Change) tracing.Span {
+	parent, err := tracing.Parse(c.Traceparent)
+	if err != nil {
+		parent, _ = tracing.FromContext(ctx)
+	}
+	return parent.Child()
+}
+
+// TraceID returns the trace id of the span ctx is in, empty outside one.
+// For a handler that is the trace of the request that recorded its change,
+// or of its poll cycle; handlers log it, or pass tracing.Traceparent(ctx)
+// on to the systems they call, so a change can be followed from the
+// metrics' exemplars.
+func TraceID(ctx context.Context) string {
+	s, _ := tracing.FromContext(ctx)
+	return s.TraceID
+}
+
+// changeLog logs lines about c with its ids and trace as fields: the trace
+// of the span ctx is in, or else the one c was recorded in.
+func changeLog(ctx context.Context, c Change) logging.Logger {
+	l := logging.With(logging.ChangeID(c.ID), logging.OrderID(c.OrderID))
+	if s, ok := tracing.FromContext(ctx); ok {
+		return l.With(logging.TraceID(s.TraceID))
+	}
+	if s, err := tracing.Parse(c.Traceparent); err == nil {
+		return l.With(logging.TraceID(s.TraceID))
+	}
+	return l
+}
@@ -0,0 +1,100 @@
+// Package quota counts the uses of per-tenant and per-key quotas in fixed
+// windows, in the database so every replica draws on the same count.
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"test/internal/store"
+)
+
+// Reserve counts one use of subject's kind quota in the fixed window
+// starting at start, unless limit uses are already counted there. It
+// returns the uses counted, limit when the quota is used up. Windows
+// before start are cleared out on the way.
+func Reserve(ctx context.Context, db *sql.DB, subject, kind string, start time.Time, limit int64) (int64, bool, error) {
+	_, err := db.ExecContext(ctx, `
+        DELETE FROM quota_usage WHERE subject = ? AND kind = ? AND window_start < ?
+    `, subject, kind, start.Unix())
+	if err != nil {
+		return 0, false, err
+	}
+
+	if store.DialectOf(db) == store.MySQL {
+		return reserveMySQL(ctx, db, subject, kind, start, limit)
+	}
+
+	// The update's WHERE leaves a full window alone, and then nothing is
+	// returned.
+	var used int64
+	err = db.QueryRowContext(ctx, `
+        INSERT INTO quota_usage (subject, kind, window_start, used) VALUES (?, ?, ?, 1)
+        ON CONFLICT (subject, kind, window_start) DO UPDATE SET used = used + 1
+        WHERE used < ?
+        RETURNING used
+    `, subject, kind, start.Unix(), limit).Scan(&used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return limit, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return used, true, nil
+}
+
+// reserveMySQL is Reserve without an upsert that can leave the
+// row alone or return it: the count is taken under the lock of the UPDATE.
+func reserveMySQL(ctx context.Context, db *sql.DB, subject, kind string, start time.Time, limit int64) (int64, bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+        INSERT IGNORE INTO quota_usage (subject, kind, window_start, used) VALUES (?, ?, ?, 1)
+    `, subject, kind, start.Unix())
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	if n > 0 {
+		return 1, true, tx.Commit()
+	}
+
+	res, err = tx.ExecContext(ctx, `
+        UPDATE quota_usage SET used = used + 1
+        WHERE subject = ? AND kind = ? AND window_start = ? AND used < ?
+    `, subject, kind, start.Unix(), limit)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err = res.RowsAffected()
+	if err != nil || n == 0 {
+		return limit, false, err
+	}
+	var used int64
+	err = tx.QueryRowContext(ctx, `
+        SELECT used FROM quota_usage WHERE subject = ? AND kind = ? AND window_start = ?
+    `, subject, kind, start.Unix()).Scan(&used)
+	if err != nil {
+		return 0, false, err
+	}
+	return used, true, tx.Commit()
+}
+
+// Release gives back a use Reserve counted, for a request that
+// failed after all.
+func Release(ctx context.Context, db *sql.DB, subject, kind string, start time.Time) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE quota_usage SET used = used - 1
+        WHERE subject = ? AND kind = ? AND window_start = ? AND used > 0
+    `, subject, kind, start.Unix())
+	return err
+}
@@ -0,0 +1,85 @@
+package redact
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestPolicyValue(t *testing.T) {
+	p := Policy{"keep": Keep, "mask": Mask, "hash": Hash, "drop": Drop}
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		field  string
+		value  string
+		want   string
+	}{
+		{name: "keep", policy: p, field: "keep", value: "Ada", want: "Ada"},
+		{name: "unlisted field is kept", policy: p, field: "quantity", value: "2", want: "2"},
+		{name: "mask", policy: p, field: "mask", value: "Ada", want: "A***"},
+		{name: "mask keeps a whole first rune", policy: p, field: "mask", value: "Émile", want: "É***"},
+		{name: "mask of empty", policy: p, field: "mask", value: "", want: ""},
+		{name: "hash", policy: p, field: "hash", value: "Ada", want: "sha256:99a563ab2f6e"},
+		{name: "hash of empty", policy: p, field: "hash", value: "", want: "sha256:e3b0c44298fc"},
+		{name: "drop", policy: p, field: "drop", value: "1 Test Street", want: "[redacted]"},
+		{name: "nil policy masks names", field: "customerName", value: "Ada", want: "A***"},
+		{name: "nil policy drops addresses", field: "shippingAddress", value: "1 Test Street", want: "[redacted]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.Value(tc.field, tc.value); got != tc.want {
+				t.Errorf("Value(%q, %q) = %q, want %q", tc.field, tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		flag    string
+		want    Policy
+		wantErr bool
+	}{
+		{name: "empty is the default", want: Default},
+		{
+			name: "adds fields",
+			flag: "tag=hash",
+			want: Policy{"customerName": Mask, "shippingAddress": Drop, "tag": Hash},
+		},
+		{
+			name: "overrides the default",
+			flag: " customerName = keep , shippingAddress=hash,",
+			want: Policy{"customerName": Keep, "shippingAddress": Hash},
+		},
+		{name: "missing mode", flag: "customerName", wantErr: true},
+		{name: "unknown mode", flag: "customerName=encrypt", wantErr: true},
+		{name: "empty mode", flag: "customerName=", wantErr: true},
+		{name: "one bad pair of several", flag: "tag=hash,customerName:keep", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.flag)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Parse(%q) = %v, want an error", tc.flag, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tc.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tc.flag, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseLeavesDefaultAlone(t *testing.T) {
+	_, err := Parse("customerName=keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Default["customerName"] != Mask {
+		t.Errorf("Default customerName = %q after Parse, want %q", Default["customerName"], Mask)
+	}
+}
@@ -0,0 +1,138 @@
+// Package schemaregistry registers Avro schemas with a Confluent-compatible
+// schema registry and frames messages in its wire format, so consumers
+// using the registry's deserializers can read them. It speaks just enough
+// of the REST API for a producer: setting a subject's compatibility and
+// registering a schema under it.
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Subject name strategies, named as the registry's serializers name them.
+const (
+	// TopicName is "<topic>-value", the registry's default: one schema
+	// lineage per topic.
+	TopicName = "topic"
+
+	// RecordName is the record's full name, shared by every topic the
+	// record goes to.
+	RecordName = "record"
+
+	// TopicRecordName is "<topic>-<record>", for topics carrying several
+	// record types.
+	TopicRecordName = "topic-record"
+)
+
+var Strategies = []string{TopicName, RecordName, TopicRecordName}
+
+// Compatibility levels the registry accepts.
+var Compatibilities = []string{
+	"BACKWARD", "BACKWARD_TRANSITIVE",
+	"FORWARD", "FORWARD_TRANSITIVE",
+	"FULL", "FULL_TRANSITIVE",
+	"NONE",
+}
+
+// Subject names the subject a value schema is registered under.
+func Subject(strategy, topic, record string) (string, error) {
+	switch strategy {
+	case TopicName:
+		return topic + "-value", nil
+	case RecordName:
+		return record, nil
+	case TopicRecordName:
+		return topic + "-" + record, nil
+	}
+	return "", fmt.Errorf("unknown subject strategy %q (want %s)", strategy, strings.Join(Strategies, ", "))
+}
+
+type Client struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// New returns a client for the registry at baseURL. Credentials in the URL
+// are sent as basic auth.
+func New(baseURL string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("schema registry URL %q must be http or https", baseURL)
+	}
+	return &Client{base: u, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// SetCompatibility sets subject's compatibility level, which the registry
+// checks every later schema against.
+func (c *Client) SetCompatibility(ctx context.Context, subject, level string) error {
+	if !slices.Contains(Compatibilities, level) {
+		return fmt.Errorf("unknown compatibility %q (want %s)", level, strings.Join(Compatibilities, ", "))
+	}
+	return c.do(ctx, http.MethodPut, "/config/"+url.PathEscape(subject), map[string]string{"compatibility": level}, nil)
+}
+
+// Register registers schema under subject and returns its id. Registering
+// a schema the subject already has returns the existing id; one that
+// breaks the subject's compatibility is refused.
+func (c *Client) Register(ctx context.Context, subject, schema string) (int, error) {
+	var resp struct {
+		ID int `json:"id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", map[string]string{"schema": schema}, &resp)
+	return resp.ID, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, dst any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := c.base.JoinPath(path)
+	u.User = nil
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if c.base.User != nil {
+		password, _ := c.base.User.Password()
+		req.SetBasicAuth(c.base.User.Username(), password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("schema registry: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var problem struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&problem)
+		return fmt.Errorf("schema registry: %s %s: %s %s", method, path, resp.Status, problem.Message)
+	}
+	if dst == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// Frame prefixes body with the wire format's header: a zero magic byte and
+// the schema id, big-endian.
+func Frame(id int, body []byte) []byte {
+	b := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(b[1:], uint32(id))
+	return append(b, body...)
+}
@@ -0,0 +1,86 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterUnderConfiguredSubject(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		calls = append(calls, r.Method+" "+r.URL.EscapedPath()+" "+user+":"+password)
+		switch {
+		case r.URL.Path == "/config/orders.events.OrderChange" && body["compatibility"] == "FULL":
+			w.Write([]byte(`{"compatibility":"FULL"}`))
+		case r.URL.Path == "/subjects/orders.events.OrderChange/versions" && body["schema"] == `"string"`:
+			w.Write([]byte(`{"id":42}`))
+		default:
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error_code":409,"message":"incompatible schema"}`))
+		}
+	}))
+	defer srv.Close()
+
+	subject, err := Subject(RecordName, "order-changes", "orders.events.OrderChange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(strings.Replace(srv.URL, "http://", "http://svc:pw@", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	err = c.SetCompatibility(ctx, subject, "FULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.Register(ctx, subject, `"string"`)
+	if err != nil || id != 42 {
+		t.Fatalf("Register = %d, %v; want id 42", id, err)
+	}
+	want := []string{
+		"PUT /config/orders.events.OrderChange svc:pw",
+		"POST /subjects/orders.events.OrderChange/versions svc:pw",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("registry saw %q, want %q", calls, want)
+	}
+
+	_, err = c.Register(ctx, subject, `"long"`)
+	if err == nil || !strings.Contains(err.Error(), "incompatible schema") {
+		t.Errorf("refused schema: %v, want the registry's message", err)
+	}
+	err = c.SetCompatibility(ctx, subject, "SIDEWAYS")
+	if err == nil {
+		t.Error("SetCompatibility accepted an unknown level")
+	}
+}
+
+func TestSubjectAndFrame(t *testing.T) {
+	for strategy, want := range map[string]string{
+		TopicName:       "order-changes-value",
+		RecordName:      "orders.events.OrderChange",
+		TopicRecordName: "order-changes-orders.events.OrderChange",
+	} {
+		got, err := Subject(strategy, "order-changes", "orders.events.OrderChange")
+		if err != nil || got != want {
+			t.Errorf("Subject(%s) = %q, %v; want %q", strategy, got, err, want)
+		}
+	}
+	_, err := Subject("topic-name", "order-changes", "orders.events.OrderChange")
+	if err == nil {
+		t.Error("Subject accepted an unknown strategy")
+	}
+
+	got := Frame(258, []byte{0xaa})
+	if string(got) != "\x00\x00\x00\x01\x02\xaa" {
+		t.Errorf("Frame = %x, want magic byte, id 258 and the body", got)
+	}
+}
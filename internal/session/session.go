@@ -0,0 +1,66 @@
+// Package session keeps the sign-ins of admin users, so a browser holds a
+// cookie rather than their credentials.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"test/internal/store"
+)
+
+// Sessions are stored by the hash of their token, so the sessions table
+// alone isn't enough to sign in as anyone.
+func sessionHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create signs user in for ttl and returns the token for their
+// cookie. Sessions that have already expired are cleared out on the way.
+func Create(ctx context.Context, db *sql.DB, user string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	_, err = db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.ExecContext(ctx, `
+        INSERT INTO sessions (token_hash, username, expires_at)
+        VALUES (?, ?, `+store.DialectOf(db).SecondsFromNow()+`)
+    `, sessionHash(token), user, int64(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// User returns who token signs in, or "" when it is unknown or has
+// expired.
+func User(ctx context.Context, db *sql.DB, token string) (string, error) {
+	var user string
+	err := db.QueryRowContext(ctx, `
+        SELECT username FROM sessions
+        WHERE token_hash = ? AND expires_at >= CURRENT_TIMESTAMP
+    `, sessionHash(token)).Scan(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return user, err
+}
+
+// End signs token out; an unknown token is not an error.
+func End(ctx context.Context, db *sql.DB, token string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?`, sessionHash(token))
+	return err
+}
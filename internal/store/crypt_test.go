@@ -0,0 +1,100 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+type staticKey []byte
+
+func (k staticKey) Key(context.Context) ([]byte, error) { return k, nil }
+
+func newTestCipher(t *testing.T, fill byte) *FieldCipher {
+	t.Helper()
+	c, err := NewFieldCipher(context.Background(), staticKey(bytes.Repeat([]byte{fill}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestFieldCipher(t *testing.T) {
+	c := newTestCipher(t, 1)
+	sealed, err := c.seal("1 Test Street")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, encryptedPrefix) || strings.Contains(sealed, "Test Street") {
+		t.Fatalf("seal = %q, want ciphertext behind %q", sealed, encryptedPrefix)
+	}
+	again, err := c.seal("1 Test Street")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("sealing the same value twice gave the same ciphertext")
+	}
+	tampered := []byte(sealed)
+	i := len(encryptedPrefix) + 20
+	if tampered[i] == 'A' {
+		tampered[i] = 'B'
+	} else {
+		tampered[i] = 'A'
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cipher  *FieldCipher
+		stored  string
+		want    string
+		wantErr bool
+	}{
+		{name: "round trip", cipher: c, stored: sealed, want: "1 Test Street"},
+		{name: "plaintext", cipher: c, stored: "1 Test Street", want: "1 Test Street"},
+		{name: "plaintext without key", stored: "1 Test Street", want: "1 Test Street"},
+		{name: "encrypted without key", stored: sealed, wantErr: true},
+		{name: "rotated key", cipher: newTestCipher(t, 2), stored: sealed, wantErr: true},
+		{name: "truncated", cipher: c, stored: encryptedPrefix + "AAAA", wantErr: true},
+		{name: "tampered", cipher: c, stored: string(tampered), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.cipher.open(tc.stored)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("open = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("open = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewFieldCipherChecksKey(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "32 bytes", value: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+		{name: "16 bytes", value: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16)), wantErr: true},
+		{name: "not base64", value: "not base64!", wantErr: true},
+		{name: "unset", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TEST_FIELD_KEY", tc.value)
+			_, err := NewFieldCipher(context.Background(), EnvKey("TEST_FIELD_KEY"))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewFieldCipher error = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Dialect is the SQL a database Open returned speaks. Queries are written
+// for SQLite; where MySQL spells something differently, the query asks its
+// Dialect for the fragment.
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	MySQL
+)
+
+// DialectOf tells db's dialect from its driver.
+func DialectOf(db *sql.DB) Dialect {
+	if _, ok := db.Driver().(*mysql.MySQLDriver); ok {
+		return MySQL
+	}
+	return SQLite
+}
+
+func (d Dialect) String() string {
+	if d == MySQL {
+		return "mysql"
+	}
+	return "sqlite"
+}
+
+// SecondsFromNow is the current UTC time plus the number of seconds bound
+// to its one parameter; NULL when the parameter is.
+func (d Dialect) SecondsFromNow() string {
+	if d == MySQL {
+		return "TIMESTAMPADD(SECOND, ?, UTC_TIMESTAMP())"
+	}
+	return "datetime('now', ? || ' seconds')"
+}
+
+// SecondsBetween is the time from one timestamp expression to another in
+// seconds, with a fraction.
+func (d Dialect) SecondsBetween(from, to string) string {
+	if d == MySQL {
+		return fmt.Sprintf("TIMESTAMPDIFF(MICROSECOND, %s, %s) / 1000000", from, to)
+	}
+	return fmt.Sprintf("(julianday(%s) - julianday(%s)) * 86400", to, from)
+}
+
+// Day is the date of a timestamp expression as YYYY-MM-DD text.
+func (d Dialect) Day(expr string) string {
+	if d == MySQL {
+		return "DATE_FORMAT(" + expr + ", '%Y-%m-%d')"
+	}
+	return "date(" + expr + ")"
+}
+
+// InsertIgnore starts an INSERT that skips rows clashing with a unique key.
+func (d Dialect) InsertIgnore() string {
+	if d == MySQL {
+		return "INSERT IGNORE"
+	}
+	return "INSERT OR IGNORE"
+}
+
+// InsertReplace starts an INSERT that replaces rows clashing with a unique
+// key.
+func (d Dialect) InsertReplace() string {
+	if d == MySQL {
+		return "REPLACE"
+	}
+	return "INSERT OR REPLACE"
+}
+
+// OnConflictUpdate ends an INSERT into a table whose unique key is keys,
+// updating the clashing row instead. set assigns columns as in an UPDATE,
+// naming the values the INSERT carried as Inserted(column).
+func (d Dialect) OnConflictUpdate(keys, set string) string {
+	if d == MySQL {
+		return "ON DUPLICATE KEY UPDATE " + set
+	}
+	return "ON CONFLICT (" + keys + ") DO UPDATE SET " + set
+}
+
+// Inserted names the value an INSERT carried for column, in the set clause
+// of OnConflictUpdate.
+func (d Dialect) Inserted(column string) string {
+	if d == MySQL {
+		return "VALUES(" + column + ")"
+	}
+	return "excluded." + column
+}
+
+// JSONArrayAgg aggregates expr into a JSON array, [] over no rows.
+func (d Dialect) JSONArrayAgg(expr string) string {
+	if d == MySQL {
+		return "COALESCE(JSON_ARRAYAGG(" + expr + "), JSON_ARRAY())"
+	}
+	return "json_group_array(" + expr + ")"
+}
+
+// JSONArrayHas is true when the JSON array in column holds the string
+// bound to its one parameter. An empty column holds nothing.
+func (d Dialect) JSONArrayHas(column string) string {
+	if d == MySQL {
+		return "JSON_CONTAINS(NULLIF(" + column + ", ''), JSON_QUOTE(?))"
+	}
+	return "EXISTS (SELECT 1 FROM json_each(" + column + ") WHERE value = ?)"
+}
+
+// NoLimit is the LIMIT that returns every row: MySQL has no -1.
+func (d Dialect) NoLimit() int64 {
+	if d == MySQL {
+		return 1<<63 - 1
+	}
+	return -1
+}
@@ -0,0 +1,809 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+type migration struct {
+	version int
+	name    string
+	stmts   []string
+}
+
+// migrations are applied in order and never edited once released; schema
+// changes go into a new entry at the end.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS orders (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                customer_name TEXT NOT NULL,
+                product_name TEXT NOT NULL,
+                quantity INTEGER NOT NULL,
+                shipping_address TEXT NOT NULL,
+                priority TEXT NOT NULL,
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+            )`,
+			`CREATE TABLE IF NOT EXISTS priority_changes (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                order_id INTEGER NOT NULL,
+                priority TEXT NOT NULL,
+                processed BOOLEAN DEFAULT FALSE,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY(order_id) REFERENCES orders(id)
+            )`,
+			`CREATE TABLE IF NOT EXISTS polling_state (
+                id INTEGER PRIMARY KEY CHECK (id = 1),
+                last_processed_id INTEGER NOT NULL DEFAULT 0
+            )`,
+			`INSERT OR IGNORE INTO polling_state (id, last_processed_id)
+             VALUES (1, 0)`,
+		},
+	},
+	{
+		// SQLite can't add a CHECK to an existing table, so both tables are
+		// rebuilt. Rows written before validation existed are normalized;
+		// anything still unrecognized becomes 'medium'.
+		version: 2,
+		name:    "priority check constraint",
+		stmts: []string{
+			`CREATE TABLE orders_new (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                customer_name TEXT NOT NULL,
+                product_name TEXT NOT NULL,
+                quantity INTEGER NOT NULL,
+                shipping_address TEXT NOT NULL,
+                priority TEXT NOT NULL
+                    CHECK (priority IN ('low', 'medium', 'high')),
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+            )`,
+			`INSERT INTO orders_new (
+                id, customer_name, product_name, quantity,
+                shipping_address, priority, created_at
+            )
+            SELECT
+                id, customer_name, product_name, quantity, shipping_address,
+                CASE
+                    WHEN lower(trim(priority)) IN ('low', 'medium', 'high')
+                    THEN lower(trim(priority))
+                    ELSE 'medium'
+                END,
+                created_at
+            FROM orders`,
+			`DROP TABLE orders`,
+			`ALTER TABLE orders_new RENAME TO orders`,
+			`CREATE TABLE priority_changes_new (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                order_id INTEGER NOT NULL,
+                priority TEXT NOT NULL
+                    CHECK (priority IN ('low', 'medium', 'high')),
+                processed BOOLEAN DEFAULT FALSE,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY(order_id) REFERENCES orders(id)
+            )`,
+			`INSERT INTO priority_changes_new (
+                id, order_id, priority, processed, created_at
+            )
+            SELECT
+                id, order_id,
+                CASE
+                    WHEN lower(trim(priority)) IN ('low', 'medium', 'high')
+                    THEN lower(trim(priority))
+                    ELSE 'medium'
+                END,
+                processed, created_at
+            FROM priority_changes`,
+			`DROP TABLE priority_changes`,
+			`ALTER TABLE priority_changes_new RENAME TO priority_changes`,
+		},
+	},
+	{
+		version: 3,
+		name:    "order status",
+		stmts: []string{
+			`ALTER TABLE orders ADD COLUMN status TEXT NOT NULL DEFAULT 'open'
+                CHECK (status IN ('open', 'cancelled'))`,
+		},
+	},
+	{
+		version: 4,
+		name:    "order tags",
+		stmts: []string{
+			`ALTER TABLE orders ADD COLUMN tag TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		// Each configured poller keeps its own offset. The single global
+		// offset carries over to the original ninja poller.
+		version: 5,
+		name:    "per-poller offsets",
+		stmts: []string{
+			`CREATE TABLE poller_offsets (
+                poller TEXT PRIMARY KEY,
+                last_processed_id INTEGER NOT NULL DEFAULT 0,
+                updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+			`INSERT INTO poller_offsets (poller, last_processed_id)
+             SELECT 'ninja', last_processed_id FROM polling_state WHERE id = 1`,
+			`DROP TABLE polling_state`,
+		},
+	},
+	{
+		version: 6,
+		name:    "change types",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN change_type TEXT NOT NULL
+                DEFAULT 'priority.changed'`,
+		},
+	},
+	{
+		version: 7,
+		name:    "poller rules",
+		stmts: []string{
+			`CREATE TABLE poller_rules (
+                name TEXT PRIMARY KEY,
+                product TEXT NOT NULL DEFAULT '',
+                priority TEXT NOT NULL DEFAULT '',
+                tag TEXT NOT NULL DEFAULT '',
+                filter TEXT NOT NULL DEFAULT '',
+                interval_ms INTEGER NOT NULL CHECK (interval_ms >= 100),
+                handler TEXT NOT NULL DEFAULT '',
+                enabled BOOLEAN NOT NULL DEFAULT TRUE,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+		},
+	},
+	{
+		version: 8,
+		name:    "poller rule dry run",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN dry_run BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+	{
+		// The audit trail outlives nothing it describes: deleting an order
+		// that has changes is refused. Rows already orphaned can't satisfy
+		// the constraint and no poller could join them, so they are not
+		// carried over. The AUTOINCREMENT counter is, so ids never go
+		// backwards behind the poller offsets.
+		version: 9,
+		name:    "restrict order deletes",
+		stmts: []string{
+			`CREATE TABLE priority_changes_new (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                order_id INTEGER NOT NULL
+                    REFERENCES orders(id) ON DELETE RESTRICT,
+                priority TEXT NOT NULL
+                    CHECK (priority IN ('low', 'medium', 'high')),
+                processed BOOLEAN DEFAULT FALSE,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                change_type TEXT NOT NULL DEFAULT 'priority.changed'
+            )`,
+			`INSERT INTO priority_changes_new (
+                id, order_id, priority, processed, created_at, change_type
+            )
+            SELECT id, order_id, priority, processed, created_at, change_type
+            FROM priority_changes
+            WHERE order_id IN (SELECT id FROM orders)`,
+			`DELETE FROM sqlite_sequence WHERE name = 'priority_changes_new'`,
+			`INSERT INTO sqlite_sequence (name, seq)
+             SELECT 'priority_changes_new', seq FROM sqlite_sequence
+             WHERE name = 'priority_changes'`,
+			`DROP TABLE priority_changes`,
+			`ALTER TABLE priority_changes_new RENAME TO priority_changes`,
+		},
+	},
+	{
+		// processed, id serves the poll query's range scan; order_id the
+		// audit trail and the foreign key check on order deletes;
+		// product_name the per-product pollers' join; created_at the
+		// retention and replay windows.
+		version: 10,
+		name:    "polling and audit indexes",
+		stmts: []string{
+			`CREATE INDEX idx_priority_changes_processed_id ON priority_changes (processed, id)`,
+			`CREATE INDEX idx_priority_changes_order_id ON priority_changes (order_id)`,
+			`CREATE INDEX idx_priority_changes_created_at ON priority_changes (created_at)`,
+			`CREATE INDEX idx_orders_product_name ON orders (product_name)`,
+			`CREATE INDEX idx_orders_created_at ON orders (created_at)`,
+		},
+	},
+	{
+		// Only the unprocessed tail of priority_changes is ever polled, so
+		// a partial index keeps poll lookups the size of the backlog rather
+		// than of the whole history. Queries must keep the literal
+		// processed = FALSE for SQLite to pick it.
+		version: 11,
+		name:    "partial index on unprocessed changes",
+		stmts: []string{
+			`CREATE INDEX idx_priority_changes_unprocessed ON priority_changes (id)
+             WHERE processed = FALSE`,
+			`DROP INDEX idx_priority_changes_processed_id`,
+		},
+	},
+	{
+		version: 12,
+		name:    "maintenance runs",
+		stmts: []string{
+			`CREATE TABLE maintenance_runs (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                task TEXT NOT NULL,
+                started_at TIMESTAMP NOT NULL,
+                finished_at TIMESTAMP NOT NULL,
+                error TEXT NOT NULL DEFAULT ''
+            )`,
+		},
+	},
+	{
+		version: 13,
+		name:    "backups",
+		stmts: []string{
+			`CREATE TABLE backups (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                path TEXT NOT NULL,
+                size_bytes INTEGER NOT NULL DEFAULT 0,
+                started_at TIMESTAMP NOT NULL,
+                finished_at TIMESTAMP NOT NULL,
+                error TEXT NOT NULL DEFAULT ''
+            )`,
+		},
+	},
+	{
+		version: 14,
+		name:    "retention runs",
+		stmts: []string{
+			`CREATE TABLE retention_runs (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                table_name TEXT NOT NULL,
+                action TEXT NOT NULL,
+                cutoff TIMESTAMP NOT NULL,
+                deleted INTEGER NOT NULL,
+                archive_path TEXT NOT NULL DEFAULT '',
+                ran_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+		},
+	},
+	{
+		// Each change carries the hash of the one before it, making the
+		// feed a tamper-evident chain; see ChainChanges. Existing rows are
+		// chained by the first write after the upgrade.
+		version: 15,
+		name:    "audit hash chain",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN prev_hash TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE priority_changes ADD COLUMN hash TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 16,
+		name:    "change patches",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN patch TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 17,
+		name:    "change actors",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN actor TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		// processed_at times processing for /admin/stats. Its queries range
+		// over created_at and group or filter by change type, so the
+		// created_at index gains change_type; retention and replay still
+		// use it through the prefix.
+		version: 18,
+		name:    "processing times",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN processed_at TIMESTAMP`,
+			`CREATE INDEX idx_priority_changes_created_at_type ON priority_changes (created_at, change_type)`,
+			`DROP INDEX idx_priority_changes_created_at`,
+		},
+	},
+	{
+		version: 19,
+		name:    "poller heartbeats",
+		stmts: []string{
+			`CREATE TABLE poller_heartbeats (
+                instance TEXT NOT NULL,
+                poller TEXT NOT NULL,
+                last_cycle_at TIMESTAMP NOT NULL,
+                last_processed_id INTEGER NOT NULL DEFAULT 0,
+                PRIMARY KEY (instance, poller)
+            )`,
+		},
+	},
+	{
+		version: 20,
+		name:    "delivered events",
+		stmts: []string{
+			`CREATE TABLE delivered_events (
+                change_id INTEGER NOT NULL,
+                sink TEXT NOT NULL,
+                delivered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                PRIMARY KEY (change_id, sink)
+            )`,
+		},
+	},
+	{
+		version: 21,
+		name:    "poller rule workers",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN workers INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 22,
+		name:    "change claims",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN claimed_by TEXT`,
+			`ALTER TABLE priority_changes ADD COLUMN claimed_until TIMESTAMP`,
+			`ALTER TABLE poller_rules ADD COLUMN lease_ms INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 23,
+		name:    "change retries",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN last_error TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE priority_changes ADD COLUMN next_attempt_at TIMESTAMP`,
+		},
+	},
+	{
+		version: 24,
+		name:    "change attempts",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE poller_rules ADD COLUMN max_attempts INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 25,
+		name:    "change failures and quarantine",
+		stmts: []string{
+			`CREATE TABLE change_failures (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                change_id INTEGER NOT NULL,
+                poller TEXT NOT NULL,
+                attempt INTEGER NOT NULL,
+                error TEXT NOT NULL,
+                failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+			`CREATE INDEX idx_change_failures_change_id ON change_failures(change_id)`,
+			`CREATE TABLE change_quarantine (
+                change_id INTEGER PRIMARY KEY,
+                poller TEXT NOT NULL,
+                payload TEXT NOT NULL,
+                errors TEXT NOT NULL,
+                quarantined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+		},
+	},
+	{
+		version: 26,
+		name:    "poller rule product batches",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN product_batch INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE poller_rules ADD COLUMN product_weights TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 27,
+		name:    "poller rule rate limits",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN rate_limit REAL NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 28,
+		name:    "poller rule circuit breakers",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN breaker_failures INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE poller_rules ADD COLUMN breaker_cooldown_ms INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 29,
+		name:    "poller rule handler retries",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN handler_retries INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 30,
+		name:    "poller rule handler timeouts",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN handler_timeout_ms INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 31,
+		name:    "poller rule in-flight limits",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN max_in_flight INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 32,
+		name:    "poller shards",
+		stmts: []string{
+			`CREATE TABLE poller_shards (
+                poller TEXT NOT NULL,
+                shard INTEGER NOT NULL,
+                instance TEXT NOT NULL,
+                expires_at TIMESTAMP NOT NULL,
+                PRIMARY KEY (poller, shard)
+            )`,
+			`ALTER TABLE poller_rules ADD COLUMN shards INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE poller_rules ADD COLUMN shard INTEGER`,
+		},
+	},
+	{
+		version: 33,
+		name:    "leases",
+		stmts: []string{
+			`CREATE TABLE leases (
+                name TEXT PRIMARY KEY,
+                holder TEXT NOT NULL,
+                acquired_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                expires_at TIMESTAMP NOT NULL
+            )`,
+		},
+	},
+	{
+		version: 34,
+		name:    "change traceparent",
+		stmts: []string{
+			`ALTER TABLE priority_changes ADD COLUMN traceparent TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 35,
+		name:    "request audit",
+		stmts: []string{
+			`CREATE TABLE request_audit (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                actor TEXT NOT NULL,
+                client_ip TEXT NOT NULL,
+                method TEXT NOT NULL,
+                path TEXT NOT NULL,
+                status INTEGER NOT NULL,
+                code TEXT NOT NULL,
+                reason TEXT NOT NULL,
+                request_id TEXT NOT NULL
+            )`,
+			`CREATE INDEX idx_request_audit_created_at ON request_audit (created_at)`,
+		},
+	},
+	{
+		version: 36,
+		name:    "security audit",
+		stmts: []string{
+			`CREATE TABLE security_audit (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                event TEXT NOT NULL,
+                actor TEXT NOT NULL,
+                client_ip TEXT NOT NULL,
+                target TEXT NOT NULL,
+                detail TEXT NOT NULL,
+                request_id TEXT NOT NULL
+            )`,
+			`CREATE INDEX idx_security_audit_created_at ON security_audit (created_at)`,
+		},
+	},
+	{
+		version: 37,
+		name:    "sessions",
+		stmts: []string{
+			`CREATE TABLE sessions (
+                token_hash TEXT PRIMARY KEY,
+                username TEXT NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                expires_at TIMESTAMP NOT NULL
+            )`,
+			`CREATE INDEX idx_sessions_expires_at ON sessions (expires_at)`,
+		},
+	},
+	{
+		version: 38,
+		name:    "tenants",
+		stmts: []string{
+			`ALTER TABLE orders ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'`,
+			`ALTER TABLE priority_changes ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'`,
+			`ALTER TABLE request_audit ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'`,
+			`ALTER TABLE poller_rules ADD COLUMN tenant TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 39,
+		name:    "per-tenant pollers",
+		stmts: []string{
+			`ALTER TABLE poller_rules ADD COLUMN per_tenant BOOLEAN NOT NULL DEFAULT FALSE`,
+			`CREATE INDEX idx_orders_tenant_id ON orders (tenant_id)`,
+		},
+	},
+	{
+		version: 40,
+		name:    "quota usage",
+		stmts: []string{
+			`CREATE TABLE quota_usage (
+                subject TEXT NOT NULL,
+                kind TEXT NOT NULL,
+                window_start INTEGER NOT NULL,
+                used INTEGER NOT NULL,
+                PRIMARY KEY (subject, kind, window_start)
+            )`,
+		},
+	},
+	{
+		version: 41,
+		name:    "webhooks",
+		stmts: []string{
+			`CREATE TABLE webhook_subscriptions (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                url TEXT NOT NULL,
+                secret TEXT NOT NULL,
+                enabled BOOLEAN NOT NULL DEFAULT TRUE,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+			`CREATE TABLE webhook_deliveries (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                subscription_id INTEGER NOT NULL
+                    REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+                change_id INTEGER NOT NULL,
+                event_type TEXT NOT NULL,
+                payload TEXT NOT NULL,
+                status TEXT NOT NULL DEFAULT 'pending',
+                attempts INTEGER NOT NULL DEFAULT 0,
+                next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                claimed_until TIMESTAMP,
+                last_error TEXT NOT NULL DEFAULT '',
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                delivered_at TIMESTAMP,
+                UNIQUE (subscription_id, change_id)
+            )`,
+			`CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at)`,
+			`CREATE TABLE webhook_attempts (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                delivery_id INTEGER NOT NULL
+                    REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+                attempt INTEGER NOT NULL,
+                attempted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                status_code INTEGER NOT NULL DEFAULT 0,
+                latency_ms INTEGER NOT NULL,
+                response TEXT NOT NULL DEFAULT '',
+                error TEXT NOT NULL DEFAULT ''
+            )`,
+			`CREATE INDEX idx_webhook_attempts_delivery_id ON webhook_attempts (delivery_id)`,
+		},
+	},
+	{
+		version: 42,
+		name:    "webhook subscription event types",
+		stmts: []string{
+			// A JSON array of change types; empty means every type.
+			`ALTER TABLE webhook_subscriptions ADD COLUMN event_types TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 43,
+		name:    "webhook subscription filters",
+		stmts: []string{
+			// A JSON webhook.Filter; empty matches everything.
+			`ALTER TABLE webhook_subscriptions ADD COLUMN filter TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 44,
+		name:    "webhook subscription schema versions",
+		stmts: []string{
+			// Subscriptions made before the events envelope stay on v1.
+			`ALTER TABLE webhook_subscriptions ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 1`,
+		},
+	},
+	{
+		version: 45,
+		name:    "webhook formats",
+		stmts: []string{
+			`ALTER TABLE webhook_subscriptions ADD COLUMN format TEXT NOT NULL DEFAULT 'envelope'`,
+			`ALTER TABLE webhook_deliveries ADD COLUMN content_type TEXT NOT NULL DEFAULT 'application/json'`,
+		},
+	},
+	{
+		version: 46,
+		name:    "idempotency keys",
+		stmts: []string{
+			// status is 0 while the first request with the key is in
+			// flight; created_at is Unix seconds.
+			`CREATE TABLE idempotency_keys (
+                subject TEXT NOT NULL,
+                key TEXT NOT NULL,
+                request_hash TEXT NOT NULL,
+                status INTEGER NOT NULL DEFAULT 0,
+                content_type TEXT NOT NULL DEFAULT '',
+                location TEXT NOT NULL DEFAULT '',
+                body BLOB,
+                created_at INTEGER NOT NULL,
+                PRIMARY KEY (subject, key)
+            )`,
+			`CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys (created_at)`,
+		},
+	},
+	{
+		// Successful responses are replayed from the order they name
+		// rather than a stored copy, which would keep the customer's name
+		// and address in the clear and out of reach of erasures. The
+		// copies already stored are dropped; their keys only guard
+		// against duplicates for a day anyway.
+		version: 47,
+		name:    "idempotency keys by order",
+		stmts: []string{
+			`ALTER TABLE idempotency_keys ADD COLUMN order_id INTEGER NOT NULL DEFAULT 0`,
+			`DELETE FROM idempotency_keys WHERE status BETWEEN 200 AND 299`,
+		},
+	},
+	{
+		// The span of rows each retention run deleted, so chain
+		// verification can tell its gaps from deletions nobody accounts
+		// for. Earlier runs have none and leave their gaps unaccounted.
+		version: 48,
+		name:    "retention run ranges",
+		stmts: []string{
+			`ALTER TABLE retention_runs ADD COLUMN from_id INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE retention_runs ADD COLUMN to_id INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+}
+
+// SchemaVersion is the schema this binary's queries are written against: the
+// last entry in migrations.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// CheckSchema fails unless the database is at exactly SchemaVersion, so a
+// process never runs its queries against a schema it wasn't built for.
+func CheckSchema(db *sql.DB) error {
+	var current int
+	err := db.QueryRow(`
+        SELECT COALESCE(MAX(version), 0) FROM schema_migrations
+    `).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("reading schema version (has the database been migrated?): %w", err)
+	}
+
+	want := SchemaVersion()
+	switch {
+	case current < want:
+		return fmt.Errorf("database schema is at version %d, this binary needs %d; migrate it first", current, want)
+	case current > want:
+		return fmt.Errorf("database schema is at version %d, newer than the %d this binary supports; upgrade the binary", current, want)
+	}
+	return nil
+}
+
+// Migrate brings the schema up to the latest version, applying each pending
+// migration in its own transaction.
+//
+// Some migrations rebuild tables that other tables reference, which SQLite
+// only allows with foreign key enforcement off. The pragma is a no-op inside
+// a transaction and is per connection, so the migrations run on one pinned
+// connection with enforcement off and each is checked with
+// foreign_key_check before it commits. MySQL runs mysqlMigrations instead,
+// with enforcement on throughout.
+func Migrate(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = conn.QueryRowContext(ctx, `
+        SELECT COALESCE(MAX(version), 0) FROM schema_migrations
+    `).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current >= SchemaVersion() {
+		return nil
+	}
+
+	d := DialectOf(db)
+	pending := migrations
+	if d == MySQL {
+		pending = mysqlMigrations
+	} else {
+		_, err = conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	}
+
+	for _, m := range pending {
+		if m.version <= current {
+			continue
+		}
+
+		err = applyMigration(ctx, conn, d, m)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("Applied migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, d Dialect, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.stmts {
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d == SQLite {
+		err = checkForeignKeys(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO schema_migrations (version, name) VALUES (?, ?)
+    `, m.version, m.name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkForeignKeys fails if any row references a parent that doesn't exist,
+// standing in for the enforcement Migrate turns off.
+func checkForeignKeys(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		err = rows.Scan(&table, &rowid, &parent, &fkid)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("foreign key violation: %s row %d references a missing %s row", table, rowid.Int64, parent)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,257 @@
+package store
+
+// mysqlMigrations are migrations for MySQL and MariaDB. There were no MySQL
+// databases before version 48, so the first entry creates that schema in
+// one go; a schema change after it needs an entry here as well as in
+// migrations, at the same version.
+//
+// MySQL commits each DDL statement on its own, so a migration that fails
+// part way can't be rolled back: every statement is written to be run again
+// when Migrate is retried.
+var mysqlMigrations = []migration{
+	{
+		version: 48,
+		name:    "mysql baseline",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS orders (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                customer_name TEXT NOT NULL,
+                product_name VARCHAR(255) NOT NULL,
+                quantity INT NOT NULL,
+                shipping_address TEXT NOT NULL,
+                priority VARCHAR(16) NOT NULL
+                    CHECK (priority IN ('low', 'medium', 'high')),
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                status VARCHAR(16) NOT NULL DEFAULT 'open'
+                    CHECK (status IN ('open', 'cancelled')),
+                tag VARCHAR(64) NOT NULL DEFAULT '',
+                tenant_id VARCHAR(255) NOT NULL DEFAULT 'default',
+                INDEX idx_orders_product_name (product_name),
+                INDEX idx_orders_created_at (created_at),
+                INDEX idx_orders_tenant_id (tenant_id)
+            )`,
+			`CREATE TABLE IF NOT EXISTS priority_changes (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                order_id BIGINT NOT NULL,
+                priority VARCHAR(16) NOT NULL
+                    CHECK (priority IN ('low', 'medium', 'high')),
+                processed BOOLEAN DEFAULT FALSE,
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                change_type VARCHAR(64) NOT NULL DEFAULT 'priority.changed',
+                prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+                hash VARCHAR(64) NOT NULL DEFAULT '',
+                patch TEXT NOT NULL DEFAULT (''),
+                actor VARCHAR(255) NOT NULL DEFAULT '',
+                processed_at DATETIME,
+                claimed_by VARCHAR(255),
+                claimed_until DATETIME,
+                last_error TEXT NOT NULL DEFAULT (''),
+                next_attempt_at DATETIME,
+                attempts INT NOT NULL DEFAULT 0,
+                traceparent VARCHAR(64) NOT NULL DEFAULT '',
+                tenant_id VARCHAR(255) NOT NULL DEFAULT 'default',
+                FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE RESTRICT,
+                INDEX idx_priority_changes_order_id (order_id),
+                INDEX idx_priority_changes_unprocessed (processed, id),
+                INDEX idx_priority_changes_created_at_type (created_at, change_type)
+            )`,
+			`CREATE TABLE IF NOT EXISTS poller_offsets (
+                poller VARCHAR(255) PRIMARY KEY,
+                last_processed_id BIGINT NOT NULL DEFAULT 0,
+                updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+            )`,
+			`CREATE TABLE IF NOT EXISTS poller_rules (
+                name VARCHAR(255) PRIMARY KEY,
+                product VARCHAR(255) NOT NULL DEFAULT '',
+                priority VARCHAR(16) NOT NULL DEFAULT '',
+                tag VARCHAR(64) NOT NULL DEFAULT '',
+                filter TEXT NOT NULL DEFAULT (''),
+                interval_ms BIGINT NOT NULL CHECK (interval_ms >= 100),
+                handler VARCHAR(255) NOT NULL DEFAULT '',
+                enabled BOOLEAN NOT NULL DEFAULT TRUE,
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+                workers INT NOT NULL DEFAULT 0,
+                lease_ms BIGINT NOT NULL DEFAULT 0,
+                max_attempts INT NOT NULL DEFAULT 0,
+                product_batch INT NOT NULL DEFAULT 0,
+                product_weights TEXT NOT NULL DEFAULT (''),
+                rate_limit DOUBLE NOT NULL DEFAULT 0,
+                breaker_failures INT NOT NULL DEFAULT 0,
+                breaker_cooldown_ms BIGINT NOT NULL DEFAULT 0,
+                handler_retries INT NOT NULL DEFAULT 0,
+                handler_timeout_ms BIGINT NOT NULL DEFAULT 0,
+                max_in_flight INT NOT NULL DEFAULT 0,
+                shards INT NOT NULL DEFAULT 0,
+                shard INT,
+                tenant VARCHAR(255) NOT NULL DEFAULT '',
+                per_tenant BOOLEAN NOT NULL DEFAULT FALSE
+            )`,
+			`CREATE TABLE IF NOT EXISTS maintenance_runs (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                task VARCHAR(64) NOT NULL,
+                started_at DATETIME NOT NULL,
+                finished_at DATETIME NOT NULL,
+                error TEXT NOT NULL DEFAULT ('')
+            )`,
+			`CREATE TABLE IF NOT EXISTS backups (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                path TEXT NOT NULL,
+                size_bytes BIGINT NOT NULL DEFAULT 0,
+                started_at DATETIME NOT NULL,
+                finished_at DATETIME NOT NULL,
+                error TEXT NOT NULL DEFAULT ('')
+            )`,
+			`CREATE TABLE IF NOT EXISTS retention_runs (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                table_name VARCHAR(64) NOT NULL,
+                action VARCHAR(16) NOT NULL,
+                cutoff DATETIME NOT NULL,
+                deleted BIGINT NOT NULL,
+                archive_path TEXT NOT NULL DEFAULT (''),
+                ran_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                from_id BIGINT NOT NULL DEFAULT 0,
+                to_id BIGINT NOT NULL DEFAULT 0
+            )`,
+			`CREATE TABLE IF NOT EXISTS poller_heartbeats (
+                instance VARCHAR(255) NOT NULL,
+                poller VARCHAR(255) NOT NULL,
+                last_cycle_at DATETIME NOT NULL,
+                last_processed_id BIGINT NOT NULL DEFAULT 0,
+                PRIMARY KEY (instance, poller)
+            )`,
+			`CREATE TABLE IF NOT EXISTS delivered_events (
+                change_id BIGINT NOT NULL,
+                sink VARCHAR(255) NOT NULL,
+                delivered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                PRIMARY KEY (change_id, sink)
+            )`,
+			`CREATE TABLE IF NOT EXISTS change_failures (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                change_id BIGINT NOT NULL,
+                poller VARCHAR(255) NOT NULL,
+                attempt INT NOT NULL,
+                error TEXT NOT NULL,
+                failed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                INDEX idx_change_failures_change_id (change_id)
+            )`,
+			`CREATE TABLE IF NOT EXISTS change_quarantine (
+                change_id BIGINT PRIMARY KEY,
+                poller VARCHAR(255) NOT NULL,
+                payload TEXT NOT NULL,
+                errors TEXT NOT NULL,
+                quarantined_at DATETIME DEFAULT CURRENT_TIMESTAMP
+            )`,
+			`CREATE TABLE IF NOT EXISTS poller_shards (
+                poller VARCHAR(255) NOT NULL,
+                shard INT NOT NULL,
+                instance VARCHAR(255) NOT NULL,
+                expires_at DATETIME NOT NULL,
+                PRIMARY KEY (poller, shard)
+            )`,
+			`CREATE TABLE IF NOT EXISTS leases (
+                name VARCHAR(255) PRIMARY KEY,
+                holder VARCHAR(255) NOT NULL,
+                acquired_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                expires_at DATETIME NOT NULL
+            )`,
+			`CREATE TABLE IF NOT EXISTS request_audit (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                actor VARCHAR(255) NOT NULL,
+                client_ip VARCHAR(64) NOT NULL,
+                method VARCHAR(16) NOT NULL,
+                path TEXT NOT NULL,
+                status INT NOT NULL,
+                code VARCHAR(64) NOT NULL,
+                reason TEXT NOT NULL,
+                request_id VARCHAR(64) NOT NULL,
+                tenant_id VARCHAR(255) NOT NULL DEFAULT 'default',
+                INDEX idx_request_audit_created_at (created_at)
+            )`,
+			`CREATE TABLE IF NOT EXISTS security_audit (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                event VARCHAR(64) NOT NULL,
+                actor VARCHAR(255) NOT NULL,
+                client_ip VARCHAR(64) NOT NULL,
+                target TEXT NOT NULL,
+                detail TEXT NOT NULL,
+                request_id VARCHAR(64) NOT NULL,
+                INDEX idx_security_audit_created_at (created_at)
+            )`,
+			`CREATE TABLE IF NOT EXISTS sessions (
+                token_hash VARCHAR(64) PRIMARY KEY,
+                username VARCHAR(255) NOT NULL,
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                expires_at DATETIME NOT NULL,
+                INDEX idx_sessions_expires_at (expires_at)
+            )`,
+			`CREATE TABLE IF NOT EXISTS quota_usage (
+                subject VARCHAR(255) NOT NULL,
+                kind VARCHAR(64) NOT NULL,
+                window_start BIGINT NOT NULL,
+                used BIGINT NOT NULL,
+                PRIMARY KEY (subject, kind, window_start)
+            )`,
+			`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                url TEXT NOT NULL,
+                secret TEXT NOT NULL,
+                enabled BOOLEAN NOT NULL DEFAULT TRUE,
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                event_types TEXT NOT NULL DEFAULT (''),
+                filter TEXT NOT NULL DEFAULT (''),
+                schema_version INT NOT NULL DEFAULT 1,
+                format VARCHAR(32) NOT NULL DEFAULT 'envelope'
+            )`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                subscription_id BIGINT NOT NULL,
+                change_id BIGINT NOT NULL,
+                event_type VARCHAR(64) NOT NULL,
+                payload MEDIUMTEXT NOT NULL,
+                status VARCHAR(16) NOT NULL DEFAULT 'pending',
+                attempts INT NOT NULL DEFAULT 0,
+                next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                claimed_until DATETIME,
+                last_error TEXT NOT NULL DEFAULT (''),
+                created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                delivered_at DATETIME,
+                content_type VARCHAR(255) NOT NULL DEFAULT 'application/json',
+                UNIQUE (subscription_id, change_id),
+                FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+                INDEX idx_webhook_deliveries_due (status, next_attempt_at)
+            )`,
+			`CREATE TABLE IF NOT EXISTS webhook_attempts (
+                id BIGINT PRIMARY KEY AUTO_INCREMENT,
+                delivery_id BIGINT NOT NULL,
+                attempt INT NOT NULL,
+                attempted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+                status_code INT NOT NULL DEFAULT 0,
+                latency_ms BIGINT NOT NULL,
+                response TEXT NOT NULL DEFAULT (''),
+                error TEXT NOT NULL DEFAULT (''),
+                FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+                INDEX idx_webhook_attempts_delivery_id (delivery_id)
+            )`,
+			// status is 0 while the first request with the key is in
+			// flight; created_at is Unix seconds.
+			"CREATE TABLE IF NOT EXISTS idempotency_keys (" + `
+                subject VARCHAR(255) NOT NULL,
+                ` + "`key`" + ` VARCHAR(255) NOT NULL,
+                request_hash VARCHAR(64) NOT NULL,
+                status INT NOT NULL DEFAULT 0,
+                content_type VARCHAR(255) NOT NULL DEFAULT '',
+                location TEXT NOT NULL DEFAULT (''),
+                body MEDIUMBLOB,
+                created_at BIGINT NOT NULL,
+                order_id BIGINT NOT NULL DEFAULT 0,
+                PRIMARY KEY (subject, ` + "`key`" + `),
+                INDEX idx_idempotency_keys_created_at (created_at)
+            )`,
+		},
+	},
+}
@@ -0,0 +1,288 @@
+// Package store owns the schema and the order records: migrations, order
+// creation, priority changes and cancellation.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"test/api"
+	"test/internal/audit"
+)
+
+var (
+	ErrOrderNotFound  = errors.New("order not found")
+	ErrOrderCancelled = errors.New("order is cancelled")
+	ErrChangeNotFound = errors.New("change not found")
+
+	// ErrHistoryIncomplete is returned for states that can't be rebuilt
+	// because a later change was recorded without a patch.
+	ErrHistoryIncomplete = errors.New("change history has no patches that far back")
+)
+
+const (
+	OrderStatusOpen      = api.OrderStatusOpen
+	OrderStatusCancelled = api.OrderStatusCancelled
+)
+
+// Order is the order as the API shows it.
+type Order = api.Order
+
+// Store runs the order operations through Queries that keep their prepared
+// statements, so hot paths like order creation don't re-prepare per call.
+type Store struct {
+	db     *sql.DB
+	q      *Queries
+	cipher *FieldCipher
+
+	// ro serves ReadOnly; it is the Store itself when there is no
+	// separate read pool.
+	ro *Store
+}
+
+// New returns a Store writing to db. Reads through ReadOnly go to read, a
+// replica or read-only connection, or to db as well when read is nil. With
+// a cipher, customer names and addresses are stored encrypted.
+func New(db, read *sql.DB, cipher *FieldCipher) *Store {
+	s := &Store{db: db, q: NewCachedQueries(db), cipher: cipher}
+	s.ro = s
+	if read != nil && read != db {
+		s.ro = &Store{db: read, q: NewCachedQueries(read), cipher: cipher}
+		s.ro.ro = s.ro
+	}
+	return s
+}
+
+// ReadOnly returns the Store for lookups that may lag behind the writer.
+// Writes through it fail when the read pool is a replica or opened
+// read-only.
+func (s *Store) ReadOnly() *Store {
+	return s.ro
+}
+
+// Close releases the prepared statements; it doesn't close the databases.
+func (s *Store) Close() error {
+	err := s.q.Close()
+	if s.ro != s {
+		err = errors.Join(err, s.ro.Close())
+	}
+	return err
+}
+
+func (s *Store) CreateOrder(ctx context.Context, o Order) (int64, error) {
+	name, err := s.cipher.seal(o.CustomerName)
+	if err != nil {
+		return 0, err
+	}
+	address, err := s.cipher.seal(o.ShippingAddress)
+	if err != nil {
+		return 0, err
+	}
+	return s.q.InsertOrder(ctx, InsertOrderParams{
+		CustomerName:    name,
+		ProductName:     o.ProductName,
+		Quantity:        o.Quantity,
+		ShippingAddress: address,
+		Priority:        o.Priority,
+		Tag:             o.Tag,
+	})
+}
+
+func (s *Store) GetOrder(ctx context.Context, id int64) (Order, error) {
+	o, err := s.q.GetOrder(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return o, ErrOrderNotFound
+	}
+	if err != nil {
+		return o, err
+	}
+
+	o.CustomerName, err = s.cipher.open(o.CustomerName)
+	if err != nil {
+		return o, fmt.Errorf("order %d customer name: %w", id, err)
+	}
+	o.ShippingAddress, err = s.cipher.open(o.ShippingAddress)
+	if err != nil {
+		return o, fmt.Errorf("order %d shipping address: %w", id, err)
+	}
+	return o, nil
+}
+
+// ListOrders returns up to limit orders with ids above after, oldest first.
+func (s *Store) ListOrders(ctx context.Context, after int64, limit int) ([]Order, error) {
+	orders, err := s.q.ListOrders(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		o := &orders[i]
+		o.CustomerName, err = s.cipher.open(o.CustomerName)
+		if err != nil {
+			return nil, fmt.Errorf("order %d customer name: %w", o.ID, err)
+		}
+		o.ShippingAddress, err = s.cipher.open(o.ShippingAddress)
+		if err != nil {
+			return nil, fmt.Errorf("order %d shipping address: %w", o.ID, err)
+		}
+	}
+	return orders, nil
+}
+
+// PriorityChange is a request to move an order to a new priority.
+type PriorityChange = api.PriorityChange
+
+// ChangePriority updates the order and records the change for the poller in
+// one transaction.
+func (s *Store) ChangePriority(ctx context.Context, c PriorityChange) error {
+	return RetryTx(ctx, s.db, "change_priority", func(tx *sql.Tx) error {
+		q := s.q.WithTx(tx)
+		o, err := openOrder(ctx, q, c.OrderID)
+		if err != nil {
+			return err
+		}
+
+		err = q.UpdateOrderPriority(ctx, c.OrderID, c.Priority)
+		if err != nil {
+			return err
+		}
+
+		patch := audit.Replace("/priority", o.Priority, c.Priority)
+		return q.InsertChange(ctx, c.OrderID, c.Priority, audit.PriorityChanged, patch)
+	})
+}
+
+// openOrder returns the order as stored, failing unless it is open.
+func openOrder(ctx context.Context, q *Queries, id int64) (Order, error) {
+	o, err := q.GetOrder(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return o, ErrOrderNotFound
+	}
+	if err != nil {
+		return o, err
+	}
+	if o.Status != OrderStatusOpen {
+		return o, ErrOrderCancelled
+	}
+	return o, nil
+}
+
+func (s *Store) CancelOrder(ctx context.Context, id int64) error {
+	return RetryTx(ctx, s.db, "cancel_order", func(tx *sql.Tx) error {
+		q := s.q.WithTx(tx)
+		o, err := openOrder(ctx, q, id)
+		if err != nil {
+			return err
+		}
+
+		err = q.UpdateOrderStatus(ctx, id, OrderStatusCancelled)
+		if err != nil {
+			return err
+		}
+
+		// Cancellations flow through the same change feed so registered
+		// handlers can react to them.
+		patch := audit.Replace("/status", o.Status, OrderStatusCancelled)
+		return q.InsertChangeAtCurrentPriority(ctx, id, audit.OrderCancelled, patch)
+	})
+}
+
+// ErasedValue replaces personal data erased by EraseCustomer.
+const ErasedValue = "[erased]"
+
+// EraseCustomer anonymizes the name and shipping address of every order
+// placed under name and returns their ids. The orders, their priorities and
+// their change history stay; each erasure is itself recorded in the change
+// feed so handlers can erase downstream copies. Names may be encrypted with
+// a random nonce, so they are compared after decryption rather than in SQL.
+func (s *Store) EraseCustomer(ctx context.Context, name string) ([]int64, error) {
+	var erased []int64
+	err := RetryTx(ctx, s.db, "erase_customer", func(tx *sql.Tx) error {
+		erased = erased[:0]
+		q := s.q.WithTx(tx)
+		customers, err := q.ListOrderCustomers(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range customers {
+			stored, err := s.cipher.open(c.CustomerName)
+			if err != nil {
+				return fmt.Errorf("order %d customer name: %w", c.OrderID, err)
+			}
+			if stored != name {
+				continue
+			}
+
+			err = q.EraseOrderPII(ctx, c.OrderID)
+			if err != nil {
+				return err
+			}
+			// The patch must not carry what was erased, so it has no
+			// test ops and can't be undone.
+			patch := []audit.PatchOp{
+				{Op: "replace", Path: "/customerName", Value: ErasedValue},
+				{Op: "replace", Path: "/shippingAddress", Value: ErasedValue},
+			}
+			err = q.InsertChangeAtCurrentPriority(ctx, c.OrderID, audit.CustomerErased, patch)
+			if err != nil {
+				return err
+			}
+			erased = append(erased, c.OrderID)
+		}
+		return nil
+	})
+	return erased, err
+}
+
+// AuditTrail returns the recorded changes for an order, oldest first.
+func (s *Store) AuditTrail(ctx context.Context, orderID int64) ([]audit.Entry, error) {
+	return s.q.ListOrderChanges(ctx, orderID)
+}
+
+// OrderAt rebuilds an order as it was right after the given change of its
+// audit trail, by undoing the patches of every later change on the current
+// order, newest first. Erased personal data stays erased.
+func (s *Store) OrderAt(ctx context.Context, orderID, changeID int64) (Order, error) {
+	o, err := s.GetOrder(ctx, orderID)
+	if err != nil {
+		return o, err
+	}
+	entries, err := s.q.ListOrderChanges(ctx, orderID)
+	if err != nil {
+		return o, err
+	}
+	i := slices.IndexFunc(entries, func(e audit.Entry) bool { return e.ID == changeID })
+	if i < 0 {
+		return o, ErrChangeNotFound
+	}
+
+	b, err := json.Marshal(o)
+	if err != nil {
+		return o, err
+	}
+	var doc map[string]any
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return o, err
+	}
+	for _, e := range slices.Backward(entries[i+1:]) {
+		if len(e.Patch) == 0 {
+			return o, ErrHistoryIncomplete
+		}
+		err = audit.Undo(doc, e.Patch)
+		if err != nil {
+			return o, fmt.Errorf("change %d patch: %w", e.ID, err)
+		}
+	}
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return o, err
+	}
+	var at Order
+	return at, json.Unmarshal(b, &at)
+}
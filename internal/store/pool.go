@@ -0,0 +1,58 @@
+package store
+
+import (
+	"database/sql"
+	"expvar"
+	"fmt"
+	"time"
+)
+
+// PoolConfig sizes the database/sql connection pool. SQLite allows one
+// writer at a time, so a small MaxOpenConns avoids piling connections up on
+// the write lock; zero values keep the database/sql defaults.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func (c PoolConfig) Validate() error {
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		return fmt.Errorf("pool sizes must not be negative, got max open %d, max idle %d", c.MaxOpenConns, c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime < 0 || c.ConnMaxIdleTime < 0 {
+		return fmt.Errorf("connection lifetimes must not be negative")
+	}
+	return nil
+}
+
+// Apply configures db's pool. database/sql's default of two idle
+// connections is kept unless MaxIdleConns is set.
+func (c PoolConfig) Apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+}
+
+// PublishPoolStats exports db's pool statistics as the expvar db_pool, read
+// on every scrape of /debug/vars or /admin/metrics.
+func PublishPoolStats(db *sql.DB) {
+	expvar.Publish("db_pool", expvar.Func(func() any {
+		s := db.Stats()
+		return map[string]any{
+			"max_open":             s.MaxOpenConnections,
+			"open":                 s.OpenConnections,
+			"in_use":               s.InUse,
+			"idle":                 s.Idle,
+			"wait_count":           s.WaitCount,
+			"wait_duration_ms":     s.WaitDuration.Milliseconds(),
+			"max_idle_closed":      s.MaxIdleClosed,
+			"max_idle_time_closed": s.MaxIdleTimeClosed,
+			"max_lifetime_closed":  s.MaxLifetimeClosed,
+		}
+	}))
+}
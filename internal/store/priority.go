@@ -0,0 +1,30 @@
+package store
+
+import (
+	"slices"
+	"strings"
+)
+
+// Priorities is the single source of truth for accepted priority values.
+// The CHECK constraint added in migration 2 mirrors this list; extending it
+// needs a new migration as well.
+var Priorities = []string{"low", "medium", "high"}
+
+const DefaultEscalationPriority = "high"
+
+// ParsePriority normalizes s to one of Priorities.
+func ParsePriority(s string) (string, bool) {
+	p := strings.ToLower(strings.TrimSpace(s))
+	for _, allowed := range Priorities {
+		if p == allowed {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// PriorityRank orders priorities by urgency, following Priorities: the
+// higher the rank, the more urgent. Unknown values rank below all.
+func PriorityRank(p string) int {
+	return slices.Index(Priorities, p)
+}
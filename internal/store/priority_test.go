@@ -0,0 +1,32 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func FuzzParsePriority(f *testing.F) {
+	for _, p := range Priorities {
+		f.Add(p)
+	}
+	f.Add(" HIGH\t")
+	f.Add("lowest")
+	f.Add("İ")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		p, ok := ParsePriority(s)
+		if !ok {
+			if p != "" {
+				t.Fatalf("ParsePriority(%q) rejected but returned %q", s, p)
+			}
+			return
+		}
+		if !slices.Contains(Priorities, p) {
+			t.Fatalf("ParsePriority(%q) = %q, not a known priority", s, p)
+		}
+		again, ok := ParsePriority(p)
+		if !ok || again != p {
+			t.Fatalf("ParsePriority is not idempotent on %q", p)
+		}
+	})
+}
@@ -0,0 +1,427 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"test/internal/audit"
+	"test/internal/tracing"
+)
+
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so the same Queries run
+// inside or outside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Queries is the typed layer over the order and change-feed SQL. Every
+// statement and the Go types it scans into live here, next to each other,
+// so a schema change only has to be followed in one file.
+type Queries struct {
+	db DBTX
+	tx *sql.Tx
+
+	// stmts is shared with every WithTx copy; nil means statements are
+	// sent unprepared.
+	stmts *stmtCache
+}
+
+func NewQueries(db DBTX) *Queries {
+	return &Queries{db: db}
+}
+
+// NewCachedQueries returns Queries that prepare each statement on db the
+// first time it runs and reuse it from then on, including inside WithTx.
+func NewCachedQueries(db *sql.DB) *Queries {
+	return &Queries{db: db, stmts: &stmtCache{db: db, stmts: map[string]*sql.Stmt{}}}
+}
+
+func (q *Queries) WithTx(tx *sql.Tx) *Queries {
+	return &Queries{db: tx, tx: tx, stmts: q.stmts}
+}
+
+// Close releases the cached statements.
+func (q *Queries) Close() error {
+	if q.stmts == nil {
+		return nil
+	}
+	return q.stmts.close()
+}
+
+type stmtCache struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+func (c *stmtCache) get(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stmt, ok := c.stmts[query]
+	if ok {
+		return stmt, nil
+	}
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (c *stmtCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for query, stmt := range c.stmts {
+		errs = append(errs, stmt.Close())
+		delete(c.stmts, query)
+	}
+	return errors.Join(errs...)
+}
+
+// stmt returns the cached statement for query, bound to the transaction
+// when there is one, or nil when q doesn't cache.
+func (q *Queries) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	if q.stmts == nil {
+		return nil, nil
+	}
+	stmt, err := q.stmts.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if q.tx != nil {
+		return q.tx.StmtContext(ctx, stmt), nil
+	}
+	return stmt, nil
+}
+
+func (q *Queries) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := q.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return q.db.ExecContext(ctx, query, args...)
+}
+
+func (q *Queries) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := q.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return q.db.QueryContext(ctx, query, args...)
+}
+
+// queryRow mirrors QueryRowContext, which reports errors through Scan; a
+// failed prepare is returned the same way.
+func (q *Queries) queryRow(ctx context.Context, query string, args ...any) rowScanner {
+	stmt, err := q.stmt(ctx, query)
+	if err != nil {
+		return errRow{err}
+	}
+	if stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return q.db.QueryRowContext(ctx, query, args...)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
+
+const insertOrder = `
+INSERT INTO orders (
+    customer_name,
+    product_name,
+    quantity,
+    shipping_address,
+    priority,
+    tag,
+    tenant_id
+) VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+
+type InsertOrderParams struct {
+	CustomerName    string
+	ProductName     string
+	Quantity        int
+	ShippingAddress string
+	Priority        string
+	Tag             string
+}
+
+// InsertOrder records the order for the tenant ctx is scoped to, see
+// audit.WithTenant; so do the other order queries read and update only
+// that tenant's orders.
+func (q *Queries) InsertOrder(ctx context.Context, arg InsertOrderParams) (int64, error) {
+	result, err := q.exec(ctx, insertOrder,
+		arg.CustomerName,
+		arg.ProductName,
+		arg.Quantity,
+		arg.ShippingAddress,
+		arg.Priority,
+		arg.Tag,
+		audit.TenantFrom(ctx),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+const getOrder = `
+SELECT id, customer_name, product_name, quantity,
+       shipping_address, priority, tag, status, created_at
+FROM orders
+WHERE id = ? AND tenant_id = ?
+`
+
+func (q *Queries) GetOrder(ctx context.Context, id int64) (Order, error) {
+	var o Order
+	err := q.queryRow(ctx, getOrder, id, audit.TenantFrom(ctx)).Scan(
+		&o.ID,
+		&o.CustomerName,
+		&o.ProductName,
+		&o.Quantity,
+		&o.ShippingAddress,
+		&o.Priority,
+		&o.Tag,
+		&o.Status,
+		&o.CreatedAt,
+	)
+	return o, err
+}
+
+const listOrders = `
+SELECT id, customer_name, product_name, quantity,
+       shipping_address, priority, tag, status, created_at
+FROM orders
+WHERE id > ? AND tenant_id = ?
+ORDER BY id ASC
+LIMIT ?
+`
+
+func (q *Queries) ListOrders(ctx context.Context, after int64, limit int) ([]Order, error) {
+	rows, err := q.query(ctx, listOrders, after, audit.TenantFrom(ctx), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		var o Order
+		err = rows.Scan(
+			&o.ID,
+			&o.CustomerName,
+			&o.ProductName,
+			&o.Quantity,
+			&o.ShippingAddress,
+			&o.Priority,
+			&o.Tag,
+			&o.Status,
+			&o.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+const updateOrderPriority = `UPDATE orders SET priority = ? WHERE id = ? AND tenant_id = ?`
+
+func (q *Queries) UpdateOrderPriority(ctx context.Context, id int64, priority string) error {
+	_, err := q.exec(ctx, updateOrderPriority, priority, id, audit.TenantFrom(ctx))
+	return err
+}
+
+const updateOrderStatus = `UPDATE orders SET status = ? WHERE id = ? AND tenant_id = ?`
+
+func (q *Queries) UpdateOrderStatus(ctx context.Context, id int64, status string) error {
+	_, err := q.exec(ctx, updateOrderStatus, status, id, audit.TenantFrom(ctx))
+	return err
+}
+
+const insertChange = `
+INSERT INTO priority_changes (order_id, priority, change_type, patch, actor, traceparent, tenant_id)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertChange records a change and chains it into the audit log. Its
+// actor is taken from ctx, see audit.WithActor, and so is the trace the
+// poller continues when it handles it, see tracing.WithSpan.
+func (q *Queries) InsertChange(ctx context.Context, orderID int64, priority, changeType string, patch []audit.PatchOp) error {
+	p, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = q.exec(ctx, insertChange, orderID, priority, changeType, string(p), audit.ActorFrom(ctx), tracing.Traceparent(ctx), audit.TenantFrom(ctx))
+	if err != nil {
+		return err
+	}
+	return q.ChainChanges(ctx)
+}
+
+// insertChangeAtCurrentPriority records a change carrying whatever priority
+// the order has when the statement runs.
+const insertChangeAtCurrentPriority = `
+INSERT INTO priority_changes (order_id, priority, change_type, patch, actor, traceparent, tenant_id)
+SELECT id, priority, ?, ?, ?, ?, tenant_id FROM orders WHERE id = ? AND tenant_id = ?
+`
+
+func (q *Queries) InsertChangeAtCurrentPriority(ctx context.Context, orderID int64, changeType string, patch []audit.PatchOp) error {
+	p, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = q.exec(ctx, insertChangeAtCurrentPriority, changeType, string(p), audit.ActorFrom(ctx), tracing.Traceparent(ctx), orderID, audit.TenantFrom(ctx))
+	if err != nil {
+		return err
+	}
+	return q.ChainChanges(ctx)
+}
+
+// The unchained changes are always the newest, so walking back from the
+// end to the last chained one only visits the tail.
+const lastChainedChange = `
+SELECT id, hash FROM priority_changes
+WHERE hash != ''
+ORDER BY id DESC
+LIMIT 1
+`
+
+const listUnchainedChanges = `
+SELECT id, order_id, change_type, priority, created_at, patch, actor, tenant_id
+FROM priority_changes
+WHERE id > ?
+ORDER BY id ASC
+`
+
+const chainChange = `
+UPDATE priority_changes SET prev_hash = ?, hash = ?
+WHERE id = ? AND hash = ''
+`
+
+// ChainChanges hashes every change not yet in the audit chain onto its
+// end; see audit.Hash. The hashes only depend on the rows, so writers
+// chaining the same tail concurrently write the same values. Changes
+// inserted without the typed queries are picked up by the next call.
+func (q *Queries) ChainChanges(ctx context.Context) error {
+	var lastID int64
+	var prev string
+	err := q.queryRow(ctx, lastChainedChange).Scan(&lastID, &prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	rows, err := q.query(ctx, listUnchainedChanges, lastID)
+	if err != nil {
+		return err
+	}
+	var tail []audit.Entry
+	for rows.Next() {
+		var e audit.Entry
+		err = rows.Scan(&e.ID, &e.OrderID, &e.ChangeType, &e.Priority, &e.CreatedAt, (*[]byte)(&e.Patch), &e.Actor, &e.Tenant)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tail = append(tail, e)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range tail {
+		hash := audit.Hash(prev, e)
+		_, err = q.exec(ctx, chainChange, prev, hash, e.ID)
+		if err != nil {
+			return err
+		}
+		prev = hash
+	}
+	return nil
+}
+
+const listOrderCustomers = `SELECT id, customer_name FROM orders WHERE customer_name != ? AND tenant_id = ?`
+
+type OrderCustomer struct {
+	OrderID      int64
+	CustomerName string
+}
+
+// ListOrderCustomers returns every order's customer name as stored, which
+// may be encrypted, skipping orders already erased.
+func (q *Queries) ListOrderCustomers(ctx context.Context) ([]OrderCustomer, error) {
+	rows, err := q.query(ctx, listOrderCustomers, ErasedValue, audit.TenantFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var customers []OrderCustomer
+	for rows.Next() {
+		var c OrderCustomer
+		err = rows.Scan(&c.OrderID, &c.CustomerName)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, c)
+	}
+	return customers, rows.Err()
+}
+
+const eraseOrderPII = `UPDATE orders SET customer_name = ?, shipping_address = ? WHERE id = ? AND tenant_id = ?`
+
+func (q *Queries) EraseOrderPII(ctx context.Context, id int64) error {
+	_, err := q.exec(ctx, eraseOrderPII, ErasedValue, ErasedValue, id, audit.TenantFrom(ctx))
+	return err
+}
+
+const listOrderChanges = `
+SELECT id, order_id, change_type, priority, processed, created_at, patch, actor
+FROM priority_changes
+WHERE order_id = ? AND tenant_id = ?
+ORDER BY id ASC
+`
+
+func (q *Queries) ListOrderChanges(ctx context.Context, orderID int64) ([]audit.Entry, error) {
+	rows, err := q.query(ctx, listOrderChanges, orderID, audit.TenantFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []audit.Entry{}
+	for rows.Next() {
+		var e audit.Entry
+		err = rows.Scan(&e.ID, &e.OrderID, &e.ChangeType, &e.Priority, &e.Processed, &e.CreatedAt, (*[]byte)(&e.Patch), &e.Actor)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+)
+
+// txRetries counts transactions replayed after a conflict, by the name given
+// to RetryTx. It is published alongside db_pool.
+var txRetries = expvar.NewMap("tx_retries")
+
+const (
+	maxTxAttempts = 5
+	txRetryBase   = 10 * time.Millisecond
+
+	// MySQL error numbers for a transaction rolled back to break a deadlock
+	// and for a lock wait that timed out.
+	mysqlLockDeadlock    = 1213
+	mysqlLockWaitTimeout = 1205
+)
+
+// RetryTx runs fn in a transaction and commits it, starting over when the
+// database reports a conflict the transaction can simply be replayed after:
+// SQLite's busy and locked errors, and MySQL's deadlocks and lock wait
+// timeouts. fn must be safe to run more than once; any other error is
+// returned as is.
+func RetryTx(ctx context.Context, db *sql.DB, name string, fn func(tx *sql.Tx) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = runTx(ctx, db, fn)
+		if err == nil || !retryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+		txRetries.Add(name, 1)
+
+		backoff := txRetryBase << (attempt - 1)
+		backoff += rand.N(backoff)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func runTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func retryable(err error) bool {
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code == sqlite3.ErrBusy || liteErr.Code == sqlite3.ErrLocked
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == mysqlLockDeadlock || myErr.Number == mysqlLockWaitTimeout
+	}
+	return false
+}
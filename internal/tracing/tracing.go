@@ -0,0 +1,103 @@
+// Package tracing carries W3C trace context (traceparent) through a
+// request and into the changes it records, so the poller can continue the
+// trace when it handles them later.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Span identifies one span of a trace, as in a traceparent header.
+type Span struct {
+	TraceID string // 32 lowercase hex digits
+	SpanID  string // 16 lowercase hex digits
+	Sampled bool
+}
+
+// New starts a trace.
+func New() Span {
+	return Span{TraceID: randomHex(16), SpanID: randomHex(8), Sampled: true}
+}
+
+// Child returns a new span in s's trace.
+func (s Span) Child() Span {
+	return Span{TraceID: s.TraceID, SpanID: randomHex(8), Sampled: s.Sampled}
+}
+
+func (s Span) String() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// Parse reads a version 00 traceparent. Later versions are read for the
+// fields version 00 has, as the spec asks.
+func Parse(traceparent string) (Span, error) {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 {
+		return Span{}, errors.New("traceparent needs version, trace id, span id and flags")
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return Span{}, fmt.Errorf("bad traceparent version %q", version)
+	}
+	if !isHex(traceID, 32) || strings.Trim(traceID, "0") == "" {
+		return Span{}, fmt.Errorf("bad trace id %q", traceID)
+	}
+	if !isHex(spanID, 16) || strings.Trim(spanID, "0") == "" {
+		return Span{}, fmt.Errorf("bad span id %q", spanID)
+	}
+	if !isHex(flags, 2) {
+		return Span{}, fmt.Errorf("bad trace flags %q", flags)
+	}
+	b, _ := hex.DecodeString(flags)
+	return Span{TraceID: traceID, SpanID: spanID, Sampled: b[0]&1 == 1}, nil
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type spanKey struct{}
+
+// WithSpan returns ctx in span s.
+func WithSpan(ctx context.Context, s Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// FromContext returns the span ctx is in, if any.
+func FromContext(ctx context.Context) (Span, bool) {
+	s, ok := ctx.Value(spanKey{}).(Span)
+	return s, ok
+}
+
+// Traceparent returns the traceparent of the span ctx is in, or "" when
+// there is none.
+func Traceparent(ctx context.Context) string {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return s.String()
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	s, err := Parse(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.SpanID != "00f067aa0ba902b7" || !s.Sampled {
+		t.Errorf("Parse(%q) = %+v", valid, s)
+	}
+	if s.String() != valid {
+		t.Errorf("String() = %q, want %q", s.String(), valid)
+	}
+
+	child := s.Child()
+	if child.TraceID != s.TraceID || child.SpanID == s.SpanID {
+		t.Errorf("Child() = %+v, want a new span in trace %s", child, s.TraceID)
+	}
+
+	_, err = Parse("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	if err != nil {
+		t.Errorf("later version: %v", err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := Parse(bad)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
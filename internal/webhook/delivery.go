@@ -0,0 +1,264 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"test/internal/events"
+	"test/internal/poller"
+	"test/internal/store"
+)
+
+// Delivery states. A pending delivery is retried with backoff until it
+// succeeds or runs out of attempts and is dead-lettered.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+func init() {
+	poller.Register(poller.AnyChangeType, "webhook", enqueue)
+}
+
+// enqueue queues c for every enabled subscription that wants its type and
+// whose filter it matches, in the schema version and format the
+// subscription asked for.
+// The dispatcher sends it later, so a slow or failing receiver never holds
+// up the poller; a change handled again is not queued twice.
+func enqueue(ctx context.Context, p *poller.Poller, c poller.Change) error {
+	payload := events.Payload{
+		ChangeID:  c.ID,
+		Tenant:    c.Tenant,
+		CreatedAt: c.CreatedAt,
+		Order: events.Order{
+			ID:       c.OrderID,
+			Product:  c.ProductName,
+			Priority: c.Priority,
+		},
+	}
+	err := p.DB().QueryRowContext(ctx, `SELECT quantity FROM orders WHERE id = ?`, c.OrderID).Scan(&payload.Order.Quantity)
+	if err != nil {
+		return fmt.Errorf("order #%d: %w", c.OrderID, err)
+	}
+	event, err := events.New(c.ChangeType, payload)
+	if err != nil {
+		return err
+	}
+
+	dialect := store.DialectOf(p.DB())
+	rows, err := p.DB().QueryContext(ctx, `
+        SELECT id, filter, schema_version, format FROM webhook_subscriptions
+        WHERE enabled
+        AND (event_types = '' OR `+dialect.JSONArrayHas("event_types")+`)
+    `, c.ChangeType)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type body struct {
+		data        []byte
+		contentType string
+	}
+	bodies := map[int64]body{}
+	for rows.Next() {
+		var id int64
+		var filter, format string
+		var version int
+		err = rows.Scan(&id, &filter, &version, &format)
+		if err != nil {
+			return err
+		}
+		f, err := decodeFilter(filter)
+		if err != nil {
+			return fmt.Errorf("subscription %d: stored filter: %w", id, err)
+		}
+		if !f.Match(payload) {
+			continue
+		}
+		var b body
+		b.data, b.contentType, err = events.Encode(event, version, format)
+		if err != nil {
+			return fmt.Errorf("subscription %d: %w", id, err)
+		}
+		bodies[id] = b
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	rows.Close()
+
+	for id, b := range bodies {
+		_, err = p.DB().ExecContext(ctx, `
+            `+dialect.InsertIgnore()+` INTO webhook_deliveries (subscription_id, change_id, event_type, payload, content_type)
+            VALUES (?, ?, ?, ?, ?)
+        `, id, c.ID, c.ChangeType, string(b.data), b.contentType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Delivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int64      `json:"subscriptionId"`
+	ChangeID       int64      `json:"changeId"`
+	EventType      string     `json:"eventType"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+
+	// AttemptLog is filled in by GetDelivery only.
+	AttemptLog []Attempt `json:"attemptLog,omitempty"`
+}
+
+// Attempt is one try at sending a delivery, as the receiver answered it.
+type Attempt struct {
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	LatencyMS  int64     `json:"latencyMs"`
+	Response   string    `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// DeliveryFilter selects deliveries. Zero fields match everything.
+type DeliveryFilter struct {
+	Status         string
+	SubscriptionID int64
+
+	// After is the keyset cursor: only deliveries with a higher id match.
+	After int64
+}
+
+const deliveryColumns = `
+    id, subscription_id, change_id, event_type, status, attempts,
+    next_attempt_at, last_error, created_at, delivered_at
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDelivery(row rowScanner) (Delivery, error) {
+	var d Delivery
+	var next, delivered sql.NullTime
+	err := row.Scan(
+		&d.ID, &d.SubscriptionID, &d.ChangeID, &d.EventType, &d.Status, &d.Attempts,
+		&next, &d.LastError, &d.CreatedAt, &delivered,
+	)
+	if next.Valid && d.Status == StatusPending {
+		d.NextAttemptAt = &next.Time
+	}
+	if delivered.Valid {
+		d.DeliveredAt = &delivered.Time
+	}
+	return d, err
+}
+
+// ListDeliveries returns up to limit deliveries matching f, oldest first,
+// and the cursor for the next page, 0 when this is the last one.
+func ListDeliveries(ctx context.Context, db *sql.DB, f DeliveryFilter, limit int) ([]Delivery, int64, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE id > ?`
+	args := []any{f.After}
+	if f.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, f.Status)
+	}
+	if f.SubscriptionID != 0 {
+		query += ` AND subscription_id = ?`
+		args = append(args, f.SubscriptionID)
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		return deliveries, deliveries[limit-1].ID, nil
+	}
+	return deliveries, 0, nil
+}
+
+// GetDelivery returns a delivery with every attempt made at it.
+func GetDelivery(ctx context.Context, db *sql.DB, id int64) (Delivery, error) {
+	d, err := scanDelivery(db.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return d, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+        SELECT attempt, attempted_at, status_code, latency_ms, response, error
+        FROM webhook_attempts
+        WHERE delivery_id = ?
+        ORDER BY id
+    `, id)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	d.AttemptLog = []Attempt{}
+	for rows.Next() {
+		var a Attempt
+		err = rows.Scan(&a.Attempt, &a.At, &a.StatusCode, &a.LatencyMS, &a.Response, &a.Error)
+		if err != nil {
+			return d, err
+		}
+		d.AttemptLog = append(d.AttemptLog, a)
+	}
+	return d, rows.Err()
+}
+
+// RetryDelivery puts a dead-lettered or pending delivery back in line to
+// be sent now, with a fresh set of attempts. Delivered ones are left
+// alone and reported as not found.
+func RetryDelivery(ctx context.Context, db *sql.DB, id int64) error {
+	res, err := db.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP,
+            claimed_until = NULL
+        WHERE id = ? AND status != 'delivered'
+    `, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"test/internal/logdedup"
+	"test/internal/store"
+)
+
+const (
+	defaultDispatchInterval = time.Second
+	defaultDispatchBatch    = 50
+	defaultMaxAttempts      = 8
+	defaultTimeout          = 10 * time.Second
+
+	// Backoff after the nth failed attempt is firstBackoff * 2^(n-1), up
+	// to maxBackoff.
+	firstBackoff = 10 * time.Second
+	maxBackoff   = time.Hour
+
+	// responseSnippet is how much of a receiver's answer each attempt
+	// keeps.
+	responseSnippet = 512
+)
+
+// deliveryOutcomes counts attempts by outcome: delivered, failed or dead.
+var deliveryOutcomes = expvar.NewMap("webhook_deliveries")
+
+// DispatchConfig tunes a Dispatcher. Zero fields take the defaults above.
+type DispatchConfig struct {
+	Interval    time.Duration
+	Batch       int
+	MaxAttempts int
+	Timeout     time.Duration
+}
+
+// Dispatcher sends queued deliveries to their subscriptions, recording
+// every attempt, and retries failures with exponential backoff until
+// MaxAttempts, when the delivery is dead-lettered. Deliveries are leased
+// while they are sent, so several processes can dispatch from one
+// database.
+type Dispatcher struct {
+	db      *sql.DB
+	dialect store.Dialect
+	cfg     DispatchConfig
+	http    *http.Client
+}
+
+func NewDispatcher(db *sql.DB, cfg DispatchConfig) *Dispatcher {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultDispatchInterval
+	}
+	if cfg.Batch == 0 {
+		cfg.Batch = defaultDispatchBatch
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &Dispatcher{db: db, dialect: store.DialectOf(db), cfg: cfg, http: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// Run dispatches until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		n, err := d.DispatchOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			logdedup.Printf("Error dispatching webhooks: %v", err)
+		}
+
+		// A full batch goes straight on to the next.
+		wait := d.cfg.Interval
+		if n == d.cfg.Batch && err == nil {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+type claimedDelivery struct {
+	id          int64
+	changeID    int64
+	event       string
+	payload     string
+	contentType string
+	attempts    int
+	url         string
+	secret      string
+}
+
+// DispatchOnce sends the deliveries that are due and returns how many it
+// claimed.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	var claimed []claimedDelivery
+	lease := time.Duration(d.cfg.Batch) * d.cfg.Timeout
+	err := store.RetryTx(ctx, d.db, "webhook:claim", func(tx *sql.Tx) error {
+		var err error
+		claimed, err = d.claim(ctx, tx, int64(lease.Seconds()))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range claimed {
+		err = d.send(ctx, c)
+		if err != nil {
+			return len(claimed), err
+		}
+	}
+	return len(claimed), nil
+}
+
+const dueDeliveries = `
+    SELECT d.id FROM webhook_deliveries d
+    JOIN webhook_subscriptions s ON s.id = d.subscription_id
+    WHERE d.status = 'pending'
+    AND d.next_attempt_at <= CURRENT_TIMESTAMP
+    AND (d.claimed_until IS NULL OR d.claimed_until < CURRENT_TIMESTAMP)
+    AND s.enabled
+    ORDER BY d.id
+    LIMIT ?`
+
+// claim leases up to a batch of due deliveries for seconds. MySQL has no
+// UPDATE ... RETURNING: there the deliveries are locked with FOR UPDATE,
+// skipping those another dispatcher has locked, and leased after.
+func (d *Dispatcher) claim(ctx context.Context, tx *sql.Tx, seconds int64) ([]claimedDelivery, error) {
+	var rows *sql.Rows
+	var err error
+	if d.dialect == store.MySQL {
+		rows, err = tx.QueryContext(ctx, `
+            SELECT d.id, d.change_id, d.event_type, d.payload, d.content_type, d.attempts, s.url, s.secret
+            FROM webhook_deliveries d
+            JOIN webhook_subscriptions s ON s.id = d.subscription_id
+            WHERE d.id IN (SELECT id FROM (`+dueDeliveries+`) due)
+            AND (d.claimed_until IS NULL OR d.claimed_until < CURRENT_TIMESTAMP)
+            ORDER BY d.id
+            FOR UPDATE SKIP LOCKED
+        `, d.cfg.Batch)
+	} else {
+		rows, err = tx.QueryContext(ctx, `
+            UPDATE webhook_deliveries
+            SET claimed_until = `+d.dialect.SecondsFromNow()+`
+            WHERE id IN (`+dueDeliveries+`)
+            RETURNING id, change_id, event_type, payload, content_type, attempts,
+                (SELECT url FROM webhook_subscriptions WHERE id = subscription_id),
+                (SELECT secret FROM webhook_subscriptions WHERE id = subscription_id)
+        `, seconds, d.cfg.Batch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var claimed []claimedDelivery
+	ids := []any{seconds}
+	for rows.Next() {
+		var c claimedDelivery
+		err = rows.Scan(&c.id, &c.changeID, &c.event, &c.payload, &c.contentType, &c.attempts, &c.url, &c.secret)
+		if err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, c)
+		ids = append(ids, c.id)
+	}
+	err = rows.Err()
+	if err != nil || d.dialect != store.MySQL || len(claimed) == 0 {
+		return claimed, err
+	}
+	rows.Close()
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE webhook_deliveries SET claimed_until = `+d.dialect.SecondsFromNow()+`
+        WHERE id IN (?`+strings.Repeat(", ?", len(claimed)-1)+`)
+    `, ids...)
+	return claimed, err
+}
+
+// send makes one attempt at c and records how it went.
+func (d *Dispatcher) send(ctx context.Context, c claimedDelivery) error {
+	a := post(ctx, d.http, c.url, []byte(c.secret), []byte(c.payload), http.Header{
+		"Content-Type":       {c.contentType},
+		"X-Webhook-Delivery": {strconv.FormatInt(c.id, 10)},
+		"X-Change-ID":        {strconv.FormatInt(c.changeID, 10)},
+		"X-Event-Type":       {c.event},
+	})
+	a.Attempt = c.attempts + 1
+	if ctx.Err() != nil {
+		// Shutting down: the lease runs out and the attempt is made again.
+		return ctx.Err()
+	}
+
+	outcome := "delivered"
+	if a.Error != "" {
+		outcome = "failed"
+		if a.Attempt >= d.cfg.MaxAttempts {
+			outcome = "dead"
+		}
+	}
+	deliveryOutcomes.Add(outcome, 1)
+	return d.record(ctx, c.id, a, outcome)
+}
+
+// post signs body with secret and POSTs it to target with header added,
+// as JSON unless header says otherwise. A transport error or any status
+// other than 2xx fails the attempt.
+func post(ctx context.Context, client *http.Client, target string, secret, body []byte, header http.Header) Attempt {
+	var a Attempt
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		for k, vs := range header {
+			req.Header.Del(k)
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		SignRequest(req, secret, body)
+
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err == nil {
+			snippet, _ := io.ReadAll(io.LimitReader(resp.Body, responseSnippet))
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			a.StatusCode = resp.StatusCode
+			a.Response = string(snippet)
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("receiver returned %s", resp.Status)
+			}
+		}
+	}
+	a.At = start.UTC()
+	a.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+func (d *Dispatcher) record(ctx context.Context, id int64, a Attempt, outcome string) error {
+	return store.RetryTx(ctx, d.db, "webhook:record", func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO webhook_attempts (delivery_id, attempt, status_code, latency_ms, response, error)
+            VALUES (?, ?, ?, ?, ?, ?)
+        `, id, a.Attempt, a.StatusCode, a.LatencyMS, a.Response, a.Error)
+		if err != nil {
+			return err
+		}
+
+		switch outcome {
+		case "delivered":
+			_, err = tx.ExecContext(ctx, `
+                UPDATE webhook_deliveries
+                SET status = 'delivered', attempts = ?, last_error = '',
+                    delivered_at = CURRENT_TIMESTAMP, claimed_until = NULL
+                WHERE id = ?
+            `, a.Attempt, id)
+		case "dead":
+			_, err = tx.ExecContext(ctx, `
+                UPDATE webhook_deliveries
+                SET status = 'dead', attempts = ?, last_error = ?, claimed_until = NULL
+                WHERE id = ?
+            `, a.Attempt, a.Error, id)
+		default:
+			_, err = tx.ExecContext(ctx, `
+                UPDATE webhook_deliveries
+                SET attempts = ?, last_error = ?, claimed_until = NULL,
+                    next_attempt_at = `+d.dialect.SecondsFromNow()+`
+                WHERE id = ?
+            `, a.Attempt, a.Error, int64(backoff(a.Attempt).Seconds()), id)
+		}
+		return err
+	})
+}
+
+// backoff is how long to wait after the nth failed attempt.
+func backoff(n int) time.Duration {
+	if n > 10 {
+		return maxBackoff
+	}
+	return min(firstBackoff<<(n-1), maxBackoff)
+}
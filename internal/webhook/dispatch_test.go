@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"test/internal/poller"
+	"test/internal/store"
+)
+
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	dsn := "file:" + filepath.Join(tb.TempDir(), "orders.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := store.Open(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	err = store.Migrate(db)
+	if err != nil {
+		tb.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestFailedDeliveriesAreRetriedThenDeadLettered(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	// flaky fails twice before accepting; down never does.
+	var mu sync.Mutex
+	calls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[r.URL.Path]++
+		if _, err := VerifyRequest(r, []byte("secret")); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/down" || calls[r.URL.Path] <= 2 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	for _, path := range []string{"/flaky", "/down"} {
+		_, err := db.Exec(`INSERT INTO webhook_subscriptions (url, secret) VALUES (?, 'secret')`, srv.URL+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not sent priority changes at all.
+	_, err := db.Exec(`INSERT INTO webhook_subscriptions (url, secret, event_types) VALUES (?, 'secret', '["order.cancelled"]')`, srv.URL+"/cancellations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders := store.New(db, nil, nil)
+	defer orders.Close()
+	id, err := orders.CreateOrder(ctx, store.Order{
+		CustomerName: "Ada", ProductName: "ninja", Quantity: 1, ShippingAddress: "1 Test Street", Priority: "low",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = orders.ChangePriority(ctx, store.PriorityChange{OrderID: id, Priority: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := poller.Config{Name: "hooks", Handler: "webhook"}
+	err = poller.Validate(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := poller.New(db, cfg, nil)
+	defer p.Close()
+	err = p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(db, DispatchConfig{MaxAttempts: 3})
+	for range 3 {
+		n, err := d.DispatchOnce(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Fatalf("dispatched %d deliveries, want both", n)
+		}
+		// skip the backoff
+		_, err = db.Exec(`UPDATE webhook_deliveries SET next_attempt_at = datetime('now', '-1 second')`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	delivered, _, err := ListDeliveries(ctx, db, DeliveryFilter{Status: StatusDelivered}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead, _, err := ListDeliveries(ctx, db, DeliveryFilter{Status: StatusDead}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 || len(dead) != 1 {
+		t.Fatalf("%d delivered and %d dead, want one of each", len(delivered), len(dead))
+	}
+
+	got, err := GetDelivery(ctx, db, delivered[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []int
+	for _, a := range got.AttemptLog {
+		codes = append(codes, a.StatusCode)
+	}
+	if len(codes) != 3 || codes[0] != 503 || codes[2] != 200 || got.AttemptLog[0].Response != "try later\n" {
+		t.Errorf("attempts %+v, want 503, 503, 200 with the receiver's answer", got.AttemptLog)
+	}
+
+	err = RetryDelivery(ctx, db, dead[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.DispatchOnce(ctx)
+	if err != nil || n != 1 {
+		t.Errorf("after the retry dispatched %d deliveries (%v), want the dead one again", n, err)
+	}
+	if err := RetryDelivery(ctx, db, delivered[0].ID); err != ErrDeliveryNotFound {
+		t.Errorf("retrying a delivered delivery: %v, want ErrDeliveryNotFound", err)
+	}
+}
+
+func TestFiltersNarrowWhatIsQueued(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	subs := map[string]Filter{
+		"bulk":   {Products: []string{"ninja"}, MinQuantity: 5},
+		"urgent": {Priorities: []string{"high"}},
+		"other":  {Products: []string{"pirate"}},
+	}
+	ids := map[int64]string{}
+	for name, f := range subs {
+		s := Subscription{URL: "http://example.com/" + name, Secret: "secret", Filter: f, Enabled: true}
+		err := s.Normalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := CreateSubscription(ctx, db, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = name
+	}
+
+	orders := store.New(db, nil, nil)
+	defer orders.Close()
+	for _, quantity := range []int{1, 10} {
+		id, err := orders.CreateOrder(ctx, store.Order{
+			CustomerName: "Ada", ProductName: "ninja", Quantity: quantity, ShippingAddress: "1 Test Street", Priority: "low",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		priority := "medium"
+		if quantity == 1 {
+			priority = "high"
+		}
+		err = orders.ChangePriority(ctx, store.PriorityChange{OrderID: id, Priority: priority})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := poller.Config{Name: "hooks", Handler: "webhook"}
+	err := poller.Validate(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := poller.New(db, cfg, nil)
+	defer p.Close()
+	err = p.PollOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, _, err := ListDeliveries(ctx, db, DeliveryFilter{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, d := range deliveries {
+		got[ids[d.SubscriptionID]]++
+	}
+	if len(deliveries) != 2 || got["bulk"] != 1 || got["urgent"] != 1 {
+		t.Errorf("queued %v, want one change each for bulk and urgent", got)
+	}
+
+	bad := Subscription{URL: "http://example.com/", Filter: Filter{Priorities: []string{"urgent"}}}
+	if err := bad.Normalize(); err == nil {
+		t.Error("Normalize accepted an unknown priority")
+	}
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"id":1}`)
+	at := time.Unix(1700000000, 0)
+	header := Sign(secret, body, at)
+
+	for _, c := range []struct {
+		name   string
+		secret []byte
+		body   string
+		header string
+		now    time.Time
+		want   error
+	}{
+		{"valid", secret, `{"id":1}`, header, at.Add(time.Minute), nil},
+		{"rotated", secret, `{"id":1}`, header + ",v1=00ff", at, nil},
+		{"tampered body", secret, `{"id":2}`, header, at, ErrBadSignature},
+		{"other secret", []byte("other"), `{"id":1}`, header, at, ErrBadSignature},
+		{"replayed later", secret, `{"id":1}`, header, at.Add(time.Hour), ErrStale},
+		{"missing", secret, `{"id":1}`, "", at, ErrNoSignature},
+		{"garbled", secret, `{"id":1}`, "v1=abc", at, ErrBadSignature},
+	} {
+		err := Verify(c.secret, []byte(c.body), c.header, DefaultTolerance, c.now)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: Verify = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+// A receiver checks the signature before trusting anything in the body.
+func ExampleVerifyRequest() {
+	secret := []byte("the subscription's secret")
+
+	receiver := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := VerifyRequest(r, secret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		fmt.Printf("accepted %s\n", body)
+	})
+
+	body := `{"changeType":"priority.changed"}`
+	req := httptest.NewRequest(http.MethodPost, "/hooks/orders", strings.NewReader(body))
+	SignRequest(req, secret, []byte(body))
+	receiver.ServeHTTP(httptest.NewRecorder(), req)
+
+	forged := httptest.NewRequest(http.MethodPost, "/hooks/orders", strings.NewReader(body))
+	forged.Header.Set(SignatureHeader, Sign([]byte("guess"), []byte(body), time.Now()))
+	rec := httptest.NewRecorder()
+	receiver.ServeHTTP(rec, forged)
+	msg, _ := io.ReadAll(rec.Body)
+	fmt.Printf("%d %s", rec.Code, msg)
+	// Output:
+	// accepted {"changeType":"priority.changed"}
+	// 401 webhook: signature does not match
+}
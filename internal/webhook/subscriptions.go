@@ -0,0 +1,348 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"test/internal/audit"
+	"test/internal/events"
+	"test/internal/store"
+)
+
+var ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+
+// EventTypes are the change types a subscription may ask for.
+var EventTypes = []string{audit.PriorityChanged, audit.OrderCancelled, audit.CustomerErased}
+
+// Subscription is a receiver of change webhooks. Its Secret is only ever
+// shown when it is created or replaced; see package doc for how bodies are
+// signed with it.
+type Subscription struct {
+	ID     int64  `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+
+	// EventTypes limits the subscription to those change types; empty
+	// means every one.
+	EventTypes []string `json:"eventTypes"`
+	Filter     Filter   `json:"filter"`
+
+	// SchemaVersion and Format are how bodies are written; see package
+	// events. Format is events.FormatEnvelope unless set.
+	SchemaVersion int    `json:"schemaVersion"`
+	Format        string `json:"format"`
+
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+}
+
+// Filter narrows a subscription to the changes it cares about. It is
+// checked before anything is queued, so filtered changes cost the
+// receiver nothing. Zero fields match everything.
+type Filter struct {
+	Products    []string `json:"products,omitempty"`
+	Priorities  []string `json:"priorities,omitempty"`
+	MinQuantity int      `json:"minQuantity,omitempty"`
+}
+
+// Match reports whether p gets past f.
+func (f Filter) Match(p events.Payload) bool {
+	if len(f.Products) > 0 && !slices.Contains(f.Products, p.Order.Product) {
+		return false
+	}
+	if len(f.Priorities) > 0 && !slices.Contains(f.Priorities, p.Order.Priority) {
+		return false
+	}
+	return p.Order.Quantity >= f.MinQuantity
+}
+
+func (f Filter) validate() error {
+	for _, p := range f.Priorities {
+		if !slices.Contains(store.Priorities, p) {
+			return fmt.Errorf("unknown priority %q in filter (known: %s)", p, strings.Join(store.Priorities, ", "))
+		}
+	}
+	if f.MinQuantity < 0 {
+		return fmt.Errorf("filter minQuantity must not be negative")
+	}
+	return nil
+}
+
+func (f Filter) encode() (string, error) {
+	if len(f.Products) == 0 && len(f.Priorities) == 0 && f.MinQuantity == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(f)
+	return string(b), err
+}
+
+func decodeFilter(s string) (Filter, error) {
+	var f Filter
+	if s == "" {
+		return f, nil
+	}
+	err := json.Unmarshal([]byte(s), &f)
+	return f, err
+}
+
+// Normalize checks s before it is stored. A subscription with no schema
+// version gets the current one, and the envelope format.
+func (s *Subscription) Normalize() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http(s) URL")
+	}
+	for _, t := range s.EventTypes {
+		if !slices.Contains(EventTypes, t) {
+			return fmt.Errorf("unknown event type %q (known: %s)", t, strings.Join(EventTypes, ", "))
+		}
+	}
+	if s.EventTypes == nil {
+		s.EventTypes = []string{}
+	}
+	if s.SchemaVersion == 0 {
+		s.SchemaVersion = events.Current
+	}
+	if !events.Supported(s.SchemaVersion) {
+		return fmt.Errorf("schemaVersion must be between %d and %d", events.V1, events.Current)
+	}
+	if s.Format == "" {
+		s.Format = events.FormatEnvelope
+	}
+	if !slices.Contains(events.Formats, s.Format) {
+		return fmt.Errorf("format must be one of: %s", strings.Join(events.Formats, ", "))
+	}
+	return s.Filter.validate()
+}
+
+// NewSecret returns a random secret for a subscription created without
+// one.
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func encodeEventTypes(types []string) (string, error) {
+	if len(types) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(types)
+	return string(b), err
+}
+
+const subscriptionColumns = `id, url, event_types, filter, schema_version, format, enabled, created_at, updated_at`
+
+func scanSubscription(row rowScanner) (Subscription, error) {
+	var s Subscription
+	var types, filter string
+	err := row.Scan(&s.ID, &s.URL, &types, &filter, &s.SchemaVersion, &s.Format, &s.Enabled, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return s, err
+	}
+	s.Filter, err = decodeFilter(filter)
+	if err != nil {
+		return s, fmt.Errorf("subscription %d: stored filter: %w", s.ID, err)
+	}
+	s.EventTypes = []string{}
+	if types != "" {
+		err = json.Unmarshal([]byte(types), &s.EventTypes)
+		if err != nil {
+			return s, fmt.Errorf("subscription %d: stored event types: %w", s.ID, err)
+		}
+	}
+	return s, nil
+}
+
+func ListSubscriptions(ctx context.Context, db *sql.DB) ([]Subscription, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []Subscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// GetSubscription returns a subscription without its secret.
+func GetSubscription(ctx context.Context, db *sql.DB, id int64) (Subscription, error) {
+	s, err := scanSubscription(db.QueryRowContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, ErrSubscriptionNotFound
+	}
+	return s, err
+}
+
+// CreateSubscription stores s, which Normalize has checked, and returns
+// its id.
+func CreateSubscription(ctx context.Context, db *sql.DB, s Subscription) (int64, error) {
+	types, err := encodeEventTypes(s.EventTypes)
+	if err != nil {
+		return 0, err
+	}
+	filter, err := s.Filter.encode()
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.ExecContext(ctx, `
+        INSERT INTO webhook_subscriptions (url, secret, event_types, filter, schema_version, format, enabled)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, s.URL, s.Secret, types, filter, s.SchemaVersion, s.Format, s.Enabled)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateSubscription replaces the subscription with s.ID. An empty Secret
+// keeps the current one.
+func UpdateSubscription(ctx context.Context, db *sql.DB, s Subscription) error {
+	types, err := encodeEventTypes(s.EventTypes)
+	if err != nil {
+		return err
+	}
+	filter, err := s.Filter.encode()
+	if err != nil {
+		return err
+	}
+	res, err := db.ExecContext(ctx, `
+        UPDATE webhook_subscriptions
+        SET url = ?, secret = COALESCE(NULLIF(?, ''), secret), event_types = ?, filter = ?,
+            schema_version = ?, format = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, s.URL, s.Secret, types, filter, s.SchemaVersion, s.Format, s.Enabled, s.ID)
+	if err != nil {
+		return err
+	}
+	return expectOne(res, ErrSubscriptionNotFound)
+}
+
+// DeleteSubscription removes a subscription along with its deliveries
+// and their attempts.
+func DeleteSubscription(ctx context.Context, db *sql.DB, id int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectOne(res, ErrSubscriptionNotFound)
+}
+
+func expectOne(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// Stats sums up a subscription's deliveries.
+type Stats struct {
+	Pending         int64      `json:"pending"`
+	Delivered       int64      `json:"delivered"`
+	Dead            int64      `json:"dead"`
+	Attempts        int64      `json:"attempts"`
+	FailedAttempts  int64      `json:"failedAttempts"`
+	AvgLatencyMS    float64    `json:"avgLatencyMs"`
+	LastDeliveredAt *time.Time `json:"lastDeliveredAt,omitempty"`
+}
+
+func SubscriptionStats(ctx context.Context, db *sql.DB, id int64) (Stats, error) {
+	var st Stats
+	var last sql.NullString
+	err := db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(status = 'pending'), 0),
+               COALESCE(SUM(status = 'delivered'), 0),
+               COALESCE(SUM(status = 'dead'), 0),
+               MAX(delivered_at)
+        FROM webhook_deliveries
+        WHERE subscription_id = ?
+    `, id).Scan(&st.Pending, &st.Delivered, &st.Dead, &last)
+	if err != nil {
+		return st, err
+	}
+	if last.Valid {
+		t, err := time.Parse(time.DateTime, last.String)
+		if err == nil {
+			st.LastDeliveredAt = &t
+		}
+	}
+
+	err = db.QueryRowContext(ctx, `
+        SELECT COUNT(*),
+               COALESCE(SUM(a.error != ''), 0),
+               COALESCE(AVG(a.latency_ms), 0)
+        FROM webhook_attempts a
+        JOIN webhook_deliveries d ON d.id = a.delivery_id
+        WHERE d.subscription_id = ?
+    `, id).Scan(&st.Attempts, &st.FailedAttempts, &st.AvgLatencyMS)
+	return st, err
+}
+
+// TestEventType marks the body TestDelivery sends.
+const TestEventType = "webhook.test"
+
+// TestDelivery sends the subscription a signed test event right away,
+// whether or not it is enabled, and returns how the receiver answered.
+// Nothing is queued or recorded.
+func TestDelivery(ctx context.Context, db *sql.DB, id int64, timeout time.Duration) (Attempt, error) {
+	var target, secret, format string
+	var version int
+	err := db.QueryRowContext(ctx, `
+        SELECT url, secret, schema_version, format FROM webhook_subscriptions WHERE id = ?
+    `, id).Scan(&target, &secret, &version, &format)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attempt{}, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return Attempt{}, err
+	}
+
+	now := time.Now().UTC()
+	payload, err := json.Marshal(map[string]any{"subscriptionId": id, "sentAt": now})
+	if err != nil {
+		return Attempt{}, err
+	}
+	// The test payload is the same in every version.
+	body, contentType, err := events.Encode(events.Envelope{
+		SchemaVersion: version,
+		EventType:     TestEventType,
+		Payload:       payload,
+		ID:            fmt.Sprintf("test-%d-%d", id, now.UnixNano()),
+		Time:          now,
+	}, version, format)
+	if err != nil {
+		return Attempt{}, err
+	}
+	client := &http.Client{Timeout: timeout}
+	a := post(ctx, client, target, []byte(secret), body, http.Header{
+		"Content-Type": {contentType},
+		"X-Event-Type": {TestEventType},
+	})
+	a.Attempt = 1
+	return a, nil
+}